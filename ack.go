@@ -0,0 +1,190 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"log/slog"
+	"time"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+)
+
+// maxAcknowledgmentResends is the number of times we resend
+// values with an unanswered acknowledgment request before giving up.
+const maxAcknowledgmentResends = 3
+
+// PendingAcknowledgment is an acknowledgment request
+// which has not been answered by the neighbour yet.
+//
+// 3.3. Acknowledgments and Acknowledgment Requests
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.3
+type PendingAcknowledgment struct {
+	Opaque   uint16
+	Values   []proto.Value // The values which are sent along with the acknowledgment request
+	Interval time.Duration // The time after which the request is resent
+	Resent   int
+	Expires  time.Time
+}
+
+// sendReliableValues sends values along with an acknowledgment request
+// and resends them with an exponential back-off until they have been
+// acknowledged by the neighbour. Opaque values are unique per neighbour.
+// It must be called with the speaker lock held.
+func (n *Neighbour) sendReliableValues(vs []proto.Value, maxDelay time.Duration) {
+	if vs = n.intf.filterOutput(vs, n); len(vs) == 0 {
+		return
+	}
+
+	n.supersedePendingAcknowledgments(vs)
+
+	n.ackOpaque++
+
+	ack := &PendingAcknowledgment{
+		Opaque:   n.ackOpaque,
+		Values:   n.intf.withNextHops(vs),
+		Interval: n.intf.speaker.config().AcknowledgmentTimeout,
+	}
+
+	n.sendAcknowledgmentRequest(ack, maxDelay)
+}
+
+func (n *Neighbour) sendAcknowledgmentRequest(ack *PendingAcknowledgment, maxDelay time.Duration) {
+	ack.Expires = time.Now().Add(ack.Interval)
+
+	n.PendingAcknowledgments.Insert(ack)
+
+	vs := []proto.Value{
+		&proto.AcknowledgmentRequest{
+			Opaque:   ack.Opaque,
+			Interval: ack.Interval,
+		},
+	}
+
+	n.queue.SendBundle(append(vs, ack.Values...), queue.PriorityUrgent, maxDelay)
+}
+
+// supersedePendingAcknowledgments removes the Updates for the prefixes
+// announced by vs from the values awaiting an acknowledgment. Resending
+// them would override the newer Updates at the neighbour. Pending
+// acknowledgments which are left without any Update are dropped.
+// It must be called with the speaker lock held.
+func (n *Neighbour) supersedePendingAcknowledgments(vs []proto.Value) {
+	pfxs := map[proto.Prefix]any{}
+	for _, v := range vs {
+		if upd, ok := v.(*proto.Update); ok {
+			pfxs[upd.Prefix] = nil
+		}
+	}
+
+	if len(pfxs) == 0 || n.PendingAcknowledgments.Len() == 0 {
+		return
+	}
+
+	superseded := []*PendingAcknowledgment{}
+
+	n.PendingAcknowledgments.Foreach(func(ack *PendingAcknowledgment) error { //nolint:errcheck
+		kept := []proto.Value{}
+		hasUpdates := false
+
+		for _, v := range ack.Values {
+			if upd, ok := v.(*proto.Update); ok {
+				if _, ok := pfxs[upd.Prefix]; ok {
+					continue
+				}
+
+				hasUpdates = true
+			}
+
+			kept = append(kept, v)
+		}
+
+		if !hasUpdates {
+			superseded = append(superseded, ack)
+		} else {
+			ack.Values = kept
+		}
+
+		return nil
+	})
+
+	for _, ack := range superseded {
+		n.PendingAcknowledgments.Remove(ack)
+
+		n.logger.Debug("Dropped superseded acknowledgment request", slog.Any("opaque", ack.Opaque))
+	}
+}
+
+func (n *Neighbour) onAcknowledgment(a *proto.Acknowledgment) {
+	ack, ok := n.PendingAcknowledgments.Lookup(a.Opaque)
+	if !ok {
+		n.logger.Debug("Ignoring unexpected acknowledgment", slog.Any("opaque", a.Opaque))
+		return
+	}
+
+	n.PendingAcknowledgments.Remove(ack)
+
+	n.logger.Debug("Received acknowledgment",
+		slog.Any("opaque", a.Opaque),
+		slog.Int("resent", ack.Resent))
+}
+
+// resendAcknowledgmentRequests resends values whose acknowledgment
+// request has not been answered in time and reports a failure after
+// a number of attempts.
+func (n *Neighbour) resendAcknowledgmentRequests(now time.Time) {
+	expired := []*PendingAcknowledgment{}
+
+	n.PendingAcknowledgments.Foreach(func(ack *PendingAcknowledgment) error { //nolint:errcheck
+		if now.After(ack.Expires) {
+			expired = append(expired, ack)
+		}
+		return nil
+	})
+
+	for _, ack := range expired {
+		if ack.Resent >= maxAcknowledgmentResends {
+			n.PendingAcknowledgments.Remove(ack)
+
+			n.logger.Warn("Neighbour did not acknowledge values",
+				slog.Any("opaque", ack.Opaque),
+				slog.Int("num_values", len(ack.Values)))
+
+			if h, ok := n.intf.speaker.config().Handler.(AcknowledgmentHandler); ok {
+				h.AcknowledgmentFailed(n, ack)
+			}
+
+			continue
+		}
+
+		ack.Resent++
+		ack.Interval *= 2
+
+		n.sendAcknowledgmentRequest(ack, n.intf.speaker.config().UrgentTimeout)
+	}
+}
+
+// sendReliableValues sends values reliably to all neighbours of the interface.
+func (i *Interface) sendReliableValues(vs []proto.Value, maxDelay time.Duration) {
+	i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+		n.sendReliableValues(vs, maxDelay)
+		return nil
+	})
+}
+
+// resendAcknowledgmentRequests resends unacknowledged values
+// to the neighbours of all interfaces.
+func (s *Speaker) resendAcknowledgmentRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		return i.Neighbours.Foreach(func(n *Neighbour) error {
+			n.resendAcknowledgmentRequests(now)
+			return nil
+		})
+	})
+}
@@ -0,0 +1,36 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"cunicu.li/go-babel/internal/table"
+)
+
+type PendingAcknowledgmentTable table.Table[uint16, *PendingAcknowledgment]
+
+func NewPendingAcknowledgmentTable() PendingAcknowledgmentTable {
+	return PendingAcknowledgmentTable(table.New[uint16, *PendingAcknowledgment]())
+}
+
+func (t *PendingAcknowledgmentTable) Lookup(opaque uint16) (*PendingAcknowledgment, bool) {
+	return (*table.Table[uint16, *PendingAcknowledgment])(t).Lookup(opaque)
+}
+
+func (t *PendingAcknowledgmentTable) Insert(ack *PendingAcknowledgment) {
+	(*table.Table[uint16, *PendingAcknowledgment])(t).Insert(ack.Opaque, ack)
+}
+
+func (t *PendingAcknowledgmentTable) Remove(ack *PendingAcknowledgment) {
+	(*table.Table[uint16, *PendingAcknowledgment])(t).Remove(ack.Opaque)
+}
+
+func (t *PendingAcknowledgmentTable) Foreach(cb func(*PendingAcknowledgment) error) error {
+	return (*table.Table[uint16, *PendingAcknowledgment])(t).ForEach(func(k uint16, v *PendingAcknowledgment) error {
+		return cb(v)
+	})
+}
+
+func (t *PendingAcknowledgmentTable) Len() int {
+	return (*table.Table[uint16, *PendingAcknowledgment])(t).Len()
+}
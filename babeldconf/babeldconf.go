@@ -0,0 +1,673 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package babeldconf parses configuration files of babeld
+// to ease the migration to go-babel.
+//
+// See: babeld(8) section "Configuration file format"
+// https://www.irif.fr/~jch/software/babel/babeld.html
+package babeldconf
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/proto"
+)
+
+var (
+	ErrUnsupported     = errors.New("unsupported directive")
+	ErrUnknown         = errors.New("unknown directive")
+	ErrMissingArgument = errors.New("missing argument")
+	ErrInvalidArgument = errors.New("invalid argument")
+	ErrUnterminated    = errors.New("unterminated quoted string")
+)
+
+// Error is returned for invalid lines of a configuration file.
+type Error struct {
+	Line int
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Config is the result of parsing a babeld configuration file.
+type Config struct {
+	// Speaker is the configuration of the speaker including
+	// the settings of all interfaces.
+	// Its InterfaceFilter selects the configured interfaces while
+	// its InputFilter and OutputFilter are built from the "in" and "out" rules.
+	Speaker *babel.SpeakerConfig
+
+	// InterfaceNames lists the configured interfaces in order of their appearance.
+	InterfaceNames []string
+
+	// Input, Output and Redistribute are the filter rules of
+	// "in", "out" and "redistribute" statements in order of their appearance.
+	Input        []Rule
+	Output       []Rule
+	Redistribute []Rule
+
+	// RedistributeFilter is built from the "redistribute" rules for the
+	// redistribution of kernel routes. Rules for local addresses are
+	// skipped as addresses of local interfaces are not redistributed.
+	RedistributeFilter filter.Filter
+
+	// Keys are the authentication keys by their identifier.
+	Keys map[string]Key
+
+	// LocalPath and LocalPort are the addresses of the local control socket.
+	LocalPath string
+	LocalPort int
+
+	// KernelPriority is the priority of routes installed into the kernel.
+	KernelPriority int
+
+	// ExportTable is the kernel routing table into which routes are installed.
+	ExportTable int
+}
+
+// KeyType is the algorithm of an authentication key.
+type KeyType string
+
+const (
+	KeyTypeNone       KeyType = "none"
+	KeyTypeHMACSHA256 KeyType = "hmac-sha256"
+	KeyTypeBLAKE2s128 KeyType = "blake2s128"
+)
+
+// Key is an authentication key.
+//
+// See: RFC 8967 MAC Authentication for the Babel Routing Protocol
+// https://datatracker.ietf.org/doc/html/rfc8967
+type Key struct {
+	ID    string
+	Type  KeyType
+	Value []byte
+}
+
+// maxKeyLength returns the maximal length of a key as accepted by babeld.
+func (t KeyType) maxKeyLength() int {
+	switch t {
+	case KeyTypeHMACSHA256:
+		return 64
+	case KeyTypeBLAKE2s128:
+		return 32
+	default:
+		return 0
+	}
+}
+
+// ParseFile parses a babeld configuration file.
+func ParseFile(fn string) (*Config, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	return Parse(f)
+}
+
+// Parse parses a babeld configuration from a reader.
+func Parse(r io.Reader) (*Config, error) {
+	params := babel.DefaultParameters
+
+	p := &parser{
+		config: &Config{
+			Speaker: &babel.SpeakerConfig{
+				Parameters: &params,
+				Multicast:  true,
+				Interfaces: map[string]babel.InterfaceConfig{},
+			},
+			Keys: map[string]Key{},
+		},
+		interfaceKeys: map[string]map[string]bool{},
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.line++
+
+		args, err := tokenize(scanner.Text())
+		if err == nil && len(args) > 0 {
+			err = p.parseStatement(args)
+		}
+
+		if err != nil {
+			return nil, &Error{
+				Line: p.line,
+				Err:  err,
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	p.finish()
+
+	return p.config, nil
+}
+
+type parser struct {
+	config *Config
+	line   int
+
+	// diversity and diversityFactor are only applied if
+	// channel-based diversity routing has been enabled.
+	diversity       bool
+	diversityFactor uint16
+
+	// interfaceKeys are the parameters which have been set
+	// explicitly by the interface statements per interface.
+	interfaceKeys map[string]map[string]bool
+}
+
+func (p *parser) parseStatement(args []string) error {
+	stmt, args := args[0], args[1:]
+
+	switch stmt {
+	case "interface":
+		if len(args) < 1 {
+			return fmt.Errorf("%w: interface name", ErrMissingArgument)
+		}
+
+		name := args[0]
+
+		ic, ok := p.config.Speaker.Interfaces[name]
+		if !ok {
+			p.config.InterfaceNames = append(p.config.InterfaceNames, name)
+			p.interfaceKeys[name] = map[string]bool{}
+		}
+
+		if err := parseInterfaceConfig(&ic, args[1:], p.interfaceKeys[name]); err != nil {
+			return err
+		}
+
+		p.config.Speaker.Interfaces[name] = ic
+
+	case "default":
+		return parseInterfaceConfig(&p.config.Speaker.InterfaceDefaults, args, map[string]bool{})
+
+	case "in", "out", "redistribute":
+		r, err := parseRule(stmt, args)
+		if err != nil {
+			return err
+		}
+
+		switch stmt {
+		case "in":
+			p.config.Input = append(p.config.Input, r)
+		case "out":
+			p.config.Output = append(p.config.Output, r)
+		case "redistribute":
+			p.config.Redistribute = append(p.config.Redistribute, r)
+		}
+
+	case "key":
+		k, err := parseKey(args)
+		if err != nil {
+			return err
+		}
+
+		p.config.Keys[k.ID] = k
+
+	default:
+		return p.parseOption(stmt, args)
+	}
+
+	return nil
+}
+
+// parseOption parses a global option.
+func (p *parser) parseOption(name string, args []string) error {
+	if !slices.Contains(globalOptions, name) {
+		return fmt.Errorf("%w: %s", ErrUnknown, name)
+	}
+
+	if len(args) != 1 {
+		return fmt.Errorf("%w: %s expects a single argument", ErrInvalidArgument, name)
+	}
+
+	arg := args[0]
+	sc := p.config.Speaker
+
+	switch name {
+	case "router-id":
+		rid, err := parseRouterID(arg)
+		if err != nil {
+			return err
+		}
+
+		sc.RouterID = rid
+
+	case "smoothing-half-life":
+		secs, err := parseUint(name, arg, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+
+		sc.MetricSmoothingHalfLife = time.Duration(secs) * time.Second
+
+	case "local-path":
+		p.config.LocalPath = arg
+
+	case "local-port":
+		port, err := parseUint(name, arg, math.MaxUint16)
+		if err != nil {
+			return err
+		}
+
+		p.config.LocalPort = int(port)
+
+	case "kernel-priority":
+		prio, err := parseUint(name, arg, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+
+		p.config.KernelPriority = int(prio)
+
+	case "diversity":
+		switch arg {
+		case "0", "false", "no":
+			p.diversity = false
+		case "3":
+			p.diversity = true
+		case "1", "2", "true", "yes":
+			return fmt.Errorf("%w: diversity %s (only kind 3 is supported)", ErrUnsupported, arg)
+		default:
+			return fmt.Errorf("%w: diversity %s", ErrInvalidArgument, arg)
+		}
+
+	case "diversity-factor":
+		factor, err := parseUint(name, arg, 256)
+		if err != nil {
+			return err
+		} else if factor == 0 {
+			return fmt.Errorf("%w: diversity-factor must be positive", ErrInvalidArgument)
+		}
+
+		p.diversityFactor = uint16(factor)
+
+	case "export-table":
+		table, err := parseUint(name, arg, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+
+		p.config.ExportTable = int(table)
+
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupported, name)
+	}
+
+	return nil
+}
+
+// finish applies the defaults to all configured interfaces
+// and restricts the speaker to them.
+func (p *parser) finish() {
+	sc := p.config.Speaker
+	def := sc.InterfaceDefaults
+
+	for name, ic := range sc.Interfaces {
+		// Only parameters which have not been set for the interface itself
+		// are taken from the defaults. Hence, an interface can also revert
+		// a default to the zero value like "unicast false" or "type auto".
+		keys := p.interfaceKeys[name]
+
+		if !keys["type"] {
+			ic.Type = def.Type
+		}
+
+		if !keys["hello-interval"] {
+			ic.HelloInterval = def.HelloInterval
+		}
+
+		if !keys["update-interval"] {
+			ic.UpdateInterval = def.UpdateInterval
+		}
+
+		if !keys["rxcost"] {
+			ic.RxCost = def.RxCost
+		}
+
+		if !keys["split-horizon"] {
+			ic.SplitHorizon = def.SplitHorizon
+		}
+
+		if !keys["unicast"] {
+			ic.Unicast = def.Unicast
+		}
+
+		if !keys["channel"] {
+			ic.Channel = def.Channel
+		}
+
+		sc.Interfaces[name] = ic
+	}
+
+	// babeld does not scale link costs by default
+	if p.diversity {
+		sc.DiversityFactor = p.diversityFactor
+		if sc.DiversityFactor == 0 {
+			sc.DiversityFactor = 256
+		}
+	}
+
+	sc.InputFilter = filterRules("in", p.config.Input)
+	sc.OutputFilter = filterRules("out", p.config.Output)
+
+	kernelRules := []Rule{}
+	for _, r := range p.config.Redistribute {
+		if !r.Local {
+			kernelRules = append(kernelRules, r)
+		}
+	}
+
+	p.config.RedistributeFilter = filterRules("redistribute", kernelRules)
+
+	if len(sc.Interfaces) > 0 {
+		sc.InterfaceFilter = func(name string) bool {
+			_, ok := sc.Interfaces[name]
+			return ok
+		}
+	}
+}
+
+// globalOptions lists all global options known by babeld.
+var globalOptions = []string{
+	"protocol-group", "protocol-port", "kernel-priority", "reflect-kernel-metric",
+	"allow-duplicates", "local-port", "local-path", "local-port-readwrite",
+	"local-path-readwrite", "export-table", "import-table", "link-detect",
+	"diversity", "diversity-factor", "smoothing-half-life", "router-id",
+	"random-id", "first-table-number", "first-rule-priority", "ipv6-subtrees",
+	"debug", "log-file", "pid-file", "state-file", "daemonise", "skip-kernel-setup",
+}
+
+// parseInterfaceConfig parses the parameters of an interface or default
+// statement into ic and records the names of the parsed parameters in keys.
+func parseInterfaceConfig(ic *babel.InterfaceConfig, args []string, keys map[string]bool) error {
+	for len(args) > 0 {
+		name := args[0]
+
+		if len(args) < 2 {
+			return fmt.Errorf("%w: value of %s", ErrMissingArgument, name)
+		}
+
+		arg := args[1]
+		args = args[2:]
+
+		keys[name] = true
+
+		switch name {
+		case "type":
+			switch arg {
+			case "auto":
+				ic.Type = babel.InterfaceTypeAuto
+			case "wired":
+				ic.Type = babel.InterfaceTypeWired
+			case "wireless":
+				ic.Type = babel.InterfaceTypeWireless
+			case "tunnel":
+				ic.Type = babel.InterfaceTypeTunnel
+			default:
+				return fmt.Errorf("%w: type %s", ErrInvalidArgument, arg)
+			}
+
+		case "hello-interval", "update-interval":
+			d, err := parseInterval(name, arg)
+			if err != nil {
+				return err
+			}
+
+			if name == "hello-interval" {
+				ic.HelloInterval = d
+			} else {
+				ic.UpdateInterval = d
+			}
+
+		case "rxcost":
+			cost, err := parseUint(name, arg, math.MaxUint16)
+			if err != nil {
+				return err
+			} else if cost == 0 {
+				return fmt.Errorf("%w: rxcost must be positive", ErrInvalidArgument)
+			}
+
+			ic.RxCost = uint16(cost)
+
+		case "split-horizon":
+			if arg == "auto" {
+				ic.SplitHorizon = nil
+			} else {
+				b, err := parseBool(name, arg)
+				if err != nil {
+					return err
+				}
+
+				ic.SplitHorizon = &b
+			}
+
+		case "unicast":
+			b, err := parseBool(name, arg)
+			if err != nil {
+				return err
+			}
+
+			ic.Unicast = b
+
+		case "channel":
+			switch arg {
+			case "interfering":
+				ic.Channel = babel.ChannelInterfering
+			case "noninterfering":
+				ic.Channel = babel.ChannelNonInterfering
+			default:
+				ch, err := parseUint(name, arg, 254)
+				if err != nil {
+					return err
+				} else if ch == 0 {
+					return fmt.Errorf("%w: channel must be positive", ErrInvalidArgument)
+				}
+
+				ic.Channel = babel.Channel(ch)
+			}
+
+		case "link-quality", "faraway", "enable-timestamps",
+			"rtt-decay", "rtt-min", "rtt-max", "max-rtt-penalty", "v4-via-v6",
+			"rfc6126-compatible", "key", "accept-bad-signatures":
+			return fmt.Errorf("%w: interface parameter %s", ErrUnsupported, name)
+
+		default:
+			return fmt.Errorf("%w: interface parameter %s", ErrUnknown, name)
+		}
+	}
+
+	return nil
+}
+
+func parseKey(args []string) (k Key, err error) {
+	for len(args) > 0 {
+		name := args[0]
+
+		if len(args) < 2 {
+			return k, fmt.Errorf("%w: value of %s", ErrMissingArgument, name)
+		}
+
+		arg := args[1]
+		args = args[2:]
+
+		switch name {
+		case "id":
+			k.ID = arg
+
+		case "type":
+			switch t := KeyType(arg); t {
+			case KeyTypeNone, KeyTypeHMACSHA256, KeyTypeBLAKE2s128:
+				k.Type = t
+			default:
+				return k, fmt.Errorf("%w: key type %s", ErrInvalidArgument, arg)
+			}
+
+		case "value":
+			if k.Value, err = hex.DecodeString(arg); err != nil {
+				return k, fmt.Errorf("%w: key value: %w", ErrInvalidArgument, err)
+			}
+
+		default:
+			return k, fmt.Errorf("%w: key parameter %s", ErrUnknown, name)
+		}
+	}
+
+	switch {
+	case k.ID == "":
+		return k, fmt.Errorf("%w: key id", ErrMissingArgument)
+
+	case k.Type == "":
+		return k, fmt.Errorf("%w: key type", ErrMissingArgument)
+
+	case k.Type == KeyTypeNone && len(k.Value) > 0:
+		return k, fmt.Errorf("%w: key of type none must not have a value", ErrInvalidArgument)
+
+	case k.Type != KeyTypeNone && len(k.Value) == 0:
+		return k, fmt.Errorf("%w: key value", ErrMissingArgument)
+
+	case len(k.Value) > k.Type.maxKeyLength():
+		return k, fmt.Errorf("%w: key value is too long for %s", ErrInvalidArgument, k.Type)
+	}
+
+	return k, nil
+}
+
+// parseRouterID parses a router ID which is either given as
+// EUI-64 or as MAC-48 address which is converted to a modified EUI-64.
+func parseRouterID(s string) (proto.RouterID, error) {
+	if rid, err := proto.ParseRouterID(s); err == nil {
+		return rid, nil
+	}
+
+	mac, err := net.ParseMAC(s)
+	if err != nil || len(mac) != 6 {
+		return proto.RouterIDUnspecified, fmt.Errorf("%w: router-id %s", ErrInvalidArgument, s)
+	}
+
+	return proto.RouterID{mac[0] ^ 0x02, mac[1], mac[2], 0xff, 0xfe, mac[3], mac[4], mac[5]}, nil
+}
+
+// parseInterval parses an interval given in seconds with an optional fraction.
+func parseInterval(name, s string) (time.Duration, error) {
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs <= 0 || secs > math.MaxUint16/100 {
+		return 0, fmt.Errorf("%w: %s %s", ErrInvalidArgument, name, s)
+	}
+
+	return time.Duration(secs * float64(time.Second)).Round(time.Millisecond), nil
+}
+
+func parseUint(name, s string, maxValue uint64) (uint64, error) {
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || v > maxValue {
+		return 0, fmt.Errorf("%w: %s %s", ErrInvalidArgument, name, s)
+	}
+
+	return v, nil
+}
+
+func parseBool(name, s string) (bool, error) {
+	switch s {
+	case "true", "yes":
+		return true, nil
+	case "false", "no":
+		return false, nil
+	default:
+		return false, fmt.Errorf("%w: %s %s", ErrInvalidArgument, name, s)
+	}
+}
+
+func parsePrefix(name, s string) (netip.Prefix, error) {
+	pfx, err := netip.ParsePrefix(s)
+	if err != nil {
+		// A plain address denotes a host route
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("%w: %s %s", ErrInvalidArgument, name, s)
+		}
+
+		pfx = netip.PrefixFrom(addr, addr.BitLen())
+	}
+
+	return pfx.Masked(), nil
+}
+
+// tokenize splits a line into words.
+// Words may be quoted and comments start with a hash.
+func tokenize(line string) (words []string, err error) {
+	var word strings.Builder
+
+	inWord, inQuotes := false, false
+
+	for _, c := range line {
+		switch {
+		case inQuotes:
+			if c == '"' {
+				inQuotes = false
+			} else {
+				word.WriteRune(c)
+			}
+
+		case c == '"':
+			inWord, inQuotes = true, true
+
+		case c == '#':
+			if inWord {
+				words = append(words, word.String())
+			}
+
+			return words, nil
+
+		case c == ' ' || c == '\t' || c == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+
+		default:
+			inWord = true
+			word.WriteRune(c)
+		}
+	}
+
+	if inQuotes {
+		return nil, ErrUnterminated
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
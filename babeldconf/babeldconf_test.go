@@ -0,0 +1,175 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babeldconf_test
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/babeldconf"
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var update = flag.Bool("update", false, "update golden files")
+
+func TestBabeldConf(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "babeld configuration suite")
+}
+
+// golden is the representation of a parsed configuration in golden files.
+type golden struct {
+	*babeldconf.Config
+
+	Speaker struct {
+		RouterID          string
+		Parameters        babel.Parameters
+		Multicast         bool
+		Interfaces        map[string]babel.InterfaceConfig
+		InterfaceDefaults babel.InterfaceConfig
+		Filtered          map[string]bool
+		InputFilter       filter.Filter
+		OutputFilter      filter.Filter
+	}
+}
+
+func newGolden(cfg *babeldconf.Config) *golden {
+	g := &golden{Config: cfg}
+
+	sc := cfg.Speaker
+	g.Speaker.RouterID = proto.FormatRouterID(sc.RouterID)
+	g.Speaker.Parameters = *sc.Parameters
+	g.Speaker.Multicast = sc.Multicast
+	g.Speaker.Interfaces = sc.Interfaces
+	g.Speaker.InterfaceDefaults = sc.InterfaceDefaults
+	g.Speaker.InputFilter = sc.InputFilter
+	g.Speaker.OutputFilter = sc.OutputFilter
+
+	if sc.InterfaceFilter != nil {
+		g.Speaker.Filtered = map[string]bool{}
+		for _, name := range []string{"eth0", "lo"} {
+			g.Speaker.Filtered[name] = sc.InterfaceFilter(name)
+		}
+	}
+
+	return g
+}
+
+var _ = Context("Parse", func() {
+	fns, err := filepath.Glob("testdata/*.conf")
+	if err != nil {
+		panic(err)
+	}
+
+	for _, fn := range fns {
+		It("matches golden file "+filepath.Base(fn), func() {
+			cfg, err := babeldconf.ParseFile(fn)
+			Expect(err).To(Succeed())
+
+			actual, err := json.MarshalIndent(newGolden(cfg), "", "  ")
+			Expect(err).To(Succeed())
+
+			goldenFn := strings.TrimSuffix(fn, ".conf") + ".golden"
+
+			if *update {
+				err = os.WriteFile(goldenFn, append(actual, '\n'), 0o644) //nolint:gosec
+				Expect(err).To(Succeed())
+			}
+
+			expected, err := os.ReadFile(goldenFn)
+			Expect(err).To(Succeed())
+			Expect(string(actual) + "\n").To(Equal(string(expected)))
+		})
+	}
+
+	It("merges defaults into interfaces", func() {
+		cfg, err := babeldconf.Parse(strings.NewReader(`
+interface eth0 rxcost 200
+default rxcost 100 hello-interval 1.5
+interface eth1
+`))
+		Expect(err).To(Succeed())
+		Expect(cfg.InterfaceNames).To(Equal([]string{"eth0", "eth1"}))
+		Expect(cfg.Speaker.Interfaces).To(HaveKeyWithValue("eth0", babel.InterfaceConfig{
+			RxCost:        200,
+			HelloInterval: 1500 * time.Millisecond,
+		}))
+		Expect(cfg.Speaker.Interfaces).To(HaveKeyWithValue("eth1", babel.InterfaceConfig{
+			RxCost:        100,
+			HelloInterval: 1500 * time.Millisecond,
+		}))
+		Expect(cfg.Speaker.InterfaceFilter("eth1")).To(BeTrue())
+		Expect(cfg.Speaker.InterfaceFilter("eth2")).To(BeFalse())
+	})
+
+	DescribeTable("overrides defaults by interface parameters",
+		func(conf string, expected babel.InterfaceConfig) {
+			cfg, err := babeldconf.Parse(strings.NewReader(conf))
+			Expect(err).To(Succeed())
+			Expect(cfg.Speaker.Interfaces).To(HaveKeyWithValue("eth0", expected))
+		},
+		Entry("disabling unicast", "default unicast true\ninterface eth0 unicast false\n",
+			babel.InterfaceConfig{Unicast: false}),
+		Entry("enabling unicast", "default unicast false\ninterface eth0 unicast true\n",
+			babel.InterfaceConfig{Unicast: true}),
+		Entry("automatic type", "default type wired\ninterface eth0 type auto\n",
+			babel.InterfaceConfig{Type: babel.InterfaceTypeAuto}),
+		Entry("explicit type", "default type auto\ninterface eth0 type tunnel\n",
+			babel.InterfaceConfig{Type: babel.InterfaceTypeTunnel}),
+		Entry("automatic split horizon", "default split-horizon false\ninterface eth0 split-horizon auto\n",
+			babel.InterfaceConfig{SplitHorizon: nil}),
+	)
+
+	It("uses all interfaces if none is configured", func() {
+		cfg, err := babeldconf.Parse(strings.NewReader("# empty\n"))
+		Expect(err).To(Succeed())
+		Expect(cfg.Speaker.InterfaceFilter).To(BeNil())
+	})
+
+	DescribeTable("rejects invalid configurations",
+		func(conf string, line int, expected error) {
+			_, err := babeldconf.Parse(strings.NewReader(conf))
+			Expect(err).To(MatchError(expected))
+
+			var perr *babeldconf.Error
+			Expect(errors.As(err, &perr)).To(BeTrue())
+			Expect(perr.Line).To(Equal(line))
+		},
+		Entry("unknown statement", "interface eth0\nfoo bar\n", 2, babeldconf.ErrUnknown),
+		Entry("unsupported option", "ipv6-subtrees true\n", 1, babeldconf.ErrUnsupported),
+		Entry("unsupported interface parameter", "interface eth0 faraway true\n", 1, babeldconf.ErrUnsupported),
+		Entry("unsupported diversity kind", "diversity 1\n", 1, babeldconf.ErrUnsupported),
+		Entry("unknown interface parameter", "interface eth0 foo 1\n", 1, babeldconf.ErrUnknown),
+		Entry("unsupported filter action", "in ip ::/0 table 10\n", 1, babeldconf.ErrUnsupported),
+		Entry("unsupported install filter", "install ip ::/0 deny\n", 1, babeldconf.ErrUnknown),
+		Entry("missing interface name", "interface\n", 1, babeldconf.ErrMissingArgument),
+		Entry("missing parameter value", "interface eth0 rxcost\n", 1, babeldconf.ErrMissingArgument),
+		Entry("invalid interface type", "interface eth0 type fiber\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid interval", "interface eth0 hello-interval -1\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid rxcost", "interface eth0 rxcost 70000\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid split horizon", "interface eth0 split-horizon maybe\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid unicast", "interface eth0 unicast maybe\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid channel", "interface wlan0 channel 255\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid diversity factor", "diversity-factor 257\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid router-id", "router-id 1.2.3.4\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("option without argument", "router-id\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("invalid prefix", "in ip 10.0.0.0/33 deny\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("empty length range", "in ip ::/0 ge 64 le 48 deny\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("trailing arguments", "in ip ::/0 deny if eth0\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("proto in input filter", "in proto 3 deny\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("key without type", "key id k1 value 00\n", 1, babeldconf.ErrMissingArgument),
+		Entry("key too long", "key id k1 type blake2s128 value "+strings.Repeat("00", 33)+"\n", 1, babeldconf.ErrInvalidArgument),
+		Entry("unterminated quotes", "local-path \"/run/babeld.sock\n", 1, babeldconf.ErrUnterminated),
+	)
+})
@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babeldconf
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strings"
+
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/proto"
+)
+
+// Action is the action of a filter rule.
+type Action int
+
+const (
+	ActionAllow Action = iota
+	ActionDeny
+
+	// ActionMetric increases the metric of a route by Rule.Metric
+	// for "in" and "out" rules and sets it for "redistribute" rules.
+	ActionMetric
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAllow:
+		return "allow"
+	case ActionDeny:
+		return "deny"
+	case ActionMetric:
+		return "metric"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+}
+
+// Rule is a filter rule of an "in", "out" or "redistribute" statement.
+// A rule matches a route if all of its selectors match.
+type Rule struct {
+	// Prefix matches routes whose prefix is contained in it.
+	// Any prefix is matched if it is invalid.
+	Prefix proto.Prefix
+
+	// MinLength and MaxLength restrict the length of matched prefixes.
+	MinLength, MaxLength int
+
+	// SourcePrefix, MinSourceLength and MaxSourceLength match the
+	// source prefix of source-specific routes.
+	SourcePrefix                     proto.Prefix
+	MinSourceLength, MaxSourceLength int
+
+	// Neighbour matches routes learned from a neighbour.
+	Neighbour proto.Address
+
+	// RouterID matches routes originated by a router.
+	RouterID proto.RouterID
+
+	// Interface matches routes learned or sent on an interface.
+	Interface string
+
+	// Local matches addresses of local interfaces in "redistribute" rules.
+	Local bool
+
+	// Protocol matches the protocol of redistributed kernel routes.
+	Protocol int
+
+	Action Action
+	Metric proto.Metric
+}
+
+func parseRule(stmt string, args []string) (r Rule, err error) {
+	r = Rule{
+		MaxLength:       128,
+		MaxSourceLength: 128,
+	}
+
+	// A rule without action allows matching routes
+	hasAction := false
+
+	for len(args) > 0 && !hasAction {
+		name := args[0]
+		args = args[1:]
+
+		switch name {
+		case "allow":
+			r.Action, hasAction = ActionAllow, true
+			continue
+
+		case "deny":
+			r.Action, hasAction = ActionDeny, true
+			continue
+
+		case "local":
+			if stmt != "redistribute" {
+				return r, fmt.Errorf("%w: local is only valid in redistribute rules", ErrInvalidArgument)
+			}
+
+			r.Local = true
+			continue
+		}
+
+		if len(args) < 1 {
+			return r, fmt.Errorf("%w: value of %s", ErrMissingArgument, name)
+		}
+
+		arg := args[0]
+		args = args[1:]
+
+		switch name {
+		case "ip":
+			if r.Prefix, err = parsePrefix(name, arg); err != nil {
+				return r, err
+			}
+
+		case "src-ip":
+			if r.SourcePrefix, err = parsePrefix(name, arg); err != nil {
+				return r, err
+			}
+
+		case "eq", "ge", "le", "src-eq", "src-ge", "src-le":
+			l, err := parseUint(name, arg, 128)
+			if err != nil {
+				return r, err
+			}
+
+			switch name {
+			case "eq":
+				r.MinLength, r.MaxLength = int(l), int(l)
+			case "ge":
+				r.MinLength = int(l)
+			case "le":
+				r.MaxLength = int(l)
+			case "src-eq":
+				r.MinSourceLength, r.MaxSourceLength = int(l), int(l)
+			case "src-ge":
+				r.MinSourceLength = int(l)
+			case "src-le":
+				r.MaxSourceLength = int(l)
+			}
+
+		case "neigh":
+			if r.Neighbour, err = netip.ParseAddr(arg); err != nil {
+				return r, fmt.Errorf("%w: neigh %s", ErrInvalidArgument, arg)
+			}
+
+		case "id":
+			if r.RouterID, err = parseRouterID(arg); err != nil {
+				return r, err
+			}
+
+		case "if":
+			r.Interface = arg
+
+		case "proto":
+			if stmt != "redistribute" {
+				return r, fmt.Errorf("%w: proto is only valid in redistribute rules", ErrInvalidArgument)
+			}
+
+			p, err := parseUint(name, arg, math.MaxUint8)
+			if err != nil {
+				return r, err
+			}
+
+			r.Protocol = int(p)
+
+		case "metric":
+			m, err := parseUint(name, arg, uint64(proto.Retraction))
+			if err != nil {
+				return r, err
+			}
+
+			r.Action, hasAction = ActionMetric, true
+			r.Metric = proto.Metric(m)
+
+		case "src-prefix", "table", "pref-src":
+			return r, fmt.Errorf("%w: filter action %s", ErrUnsupported, name)
+
+		default:
+			return r, fmt.Errorf("%w: filter selector %s", ErrUnknown, name)
+		}
+	}
+
+	switch {
+	case len(args) > 0:
+		return r, fmt.Errorf("%w: trailing arguments after action: %s", ErrInvalidArgument, strings.Join(args, " "))
+
+	case r.MinLength > r.MaxLength, r.MinSourceLength > r.MaxSourceLength:
+		return r, fmt.Errorf("%w: empty prefix length range", ErrInvalidArgument)
+	}
+
+	return r, nil
+}
+
+// filterRule converts the rule into a rule of the filter engine.
+// The metric action of "in" and "out" rules adds to the metric
+// while it replaces the metric for "redistribute" rules.
+func (r *Rule) filterRule(stmt string) filter.Rule {
+	fr := filter.Rule{
+		Prefix:       r.Prefix,
+		SourcePrefix: r.SourcePrefix,
+		RouterID:     r.RouterID,
+		Neighbour:    r.Neighbour,
+		Interface:    r.Interface,
+		Protocol:     r.Protocol,
+		Value:        r.Metric,
+	}
+
+	if r.MinLength > 0 || r.MaxLength < 128 {
+		fr.Length = filter.Between(r.MinLength, r.MaxLength)
+	}
+
+	if r.MinSourceLength > 0 || r.MaxSourceLength < 128 {
+		fr.SourceLength = filter.Between(r.MinSourceLength, r.MaxSourceLength)
+	}
+
+	switch r.Action {
+	case ActionAllow:
+		fr.Action = filter.ActionAllow
+	case ActionDeny:
+		fr.Action = filter.ActionDeny
+	case ActionMetric:
+		if stmt == "redistribute" {
+			fr.Action = filter.ActionSetMetric
+		} else {
+			fr.Action = filter.ActionAddMetric
+		}
+	}
+
+	return fr
+}
+
+// filterRules converts rules into a filter.
+func filterRules(stmt string, rules []Rule) (f filter.Filter) {
+	for i := range rules {
+		f = append(f, rules[i].filterRule(stmt))
+	}
+
+	return f
+}
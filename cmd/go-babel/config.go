@@ -0,0 +1,373 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/kernel"
+	"cunicu.li/go-babel/proto"
+	"github.com/vishvananda/netlink"
+	"gopkg.in/yaml.v3"
+)
+
+var errInvalidListenAddress = errors.New("invalid listen address")
+
+// Config is the configuration of the daemon.
+type Config struct {
+	// RouterID is the router ID of the speaker.
+	// A random router ID is generated if empty.
+	RouterID string `yaml:"router_id"`
+
+	// Interfaces is a list of glob patterns of interface names
+	// on which the speaker is enabled. All interfaces are used if empty.
+	Interfaces []string `yaml:"interfaces"`
+
+	// Multicast enables the use of multicast on the interfaces.
+	Multicast bool `yaml:"multicast"`
+
+	// StrictDecoding drops received packets which violate RFC 8966.
+	StrictDecoding bool `yaml:"strict_decoding"`
+
+	LogLevel slog.Level `yaml:"log_level"`
+
+	Parameters ParametersConfig `yaml:"parameters"`
+	Control    ControlConfig    `yaml:"control"`
+	Metrics    MetricsConfig    `yaml:"metrics"`
+	Kernel     KernelConfig     `yaml:"kernel"`
+}
+
+// ParametersConfig overrides the protocol parameters.
+// Zero values keep the defaults.
+type ParametersConfig struct {
+	HelloInterval           time.Duration `yaml:"hello_interval"`
+	UnicastHelloInterval    time.Duration `yaml:"unicast_hello_interval"`
+	IHUInterval             time.Duration `yaml:"ihu_interval"`
+	UpdateInterval          time.Duration `yaml:"update_interval"`
+	MetricSmoothingHalfLife time.Duration `yaml:"metric_smoothing_half_life"`
+	NominalLinkCost         uint16        `yaml:"nominal_link_cost"`
+}
+
+type ControlConfig struct {
+	// Listen is the address of the local control socket
+	// either as "unix:<path>" or "tcp:<host>:<port>".
+	// The control socket is disabled if empty.
+	Listen string `yaml:"listen"`
+}
+
+type MetricsConfig struct {
+	// Listen is the TCP address on which metrics are served via HTTP.
+	// The metrics endpoint is disabled if empty.
+	Listen string `yaml:"listen"`
+}
+
+type KernelConfig struct {
+	// Install enables the installation of selected routes into the kernel.
+	Install bool `yaml:"install"`
+
+	Table    int `yaml:"table"`
+	Priority int `yaml:"priority"`
+
+	// Protocol is the protocol number with which installed routes
+	// are tagged. Routes with this protocol are never redistributed.
+	Protocol int `yaml:"protocol"`
+
+	Redistribute RedistributeConfig `yaml:"redistribute"`
+}
+
+type RedistributeConfig struct {
+	// Enabled enables the redistribution of kernel routes.
+	Enabled bool `yaml:"enabled"`
+
+	// Table is the kernel routing table whose routes are redistributed.
+	Table int `yaml:"table"`
+
+	// Protocols are the protocol numbers of redistributed routes.
+	// Static, boot and DHCP routes are redistributed if empty.
+	Protocols []int `yaml:"protocols"`
+
+	// Prefixes restricts the redistribution to routes whose
+	// prefixes are contained in one of them.
+	// All routes are redistributed if empty.
+	Prefixes []string `yaml:"prefixes"`
+
+	// Metric is the metric of redistributed routes.
+	Metric uint16 `yaml:"metric"`
+}
+
+// DefaultConfig returns the configuration which is used
+// for settings which are neither set in the config file nor by flags.
+func DefaultConfig() *Config {
+	return &Config{
+		Multicast: true,
+		LogLevel:  slog.LevelInfo,
+		Kernel: KernelConfig{
+			Table: kernel.DefaultTable,
+			Redistribute: RedistributeConfig{
+				Table: kernel.DefaultTable,
+			},
+		},
+	}
+}
+
+// LoadConfig reads a configuration file on top of the defaults.
+func LoadConfig(fn string) (*Config, error) {
+	cfg := DefaultConfig()
+
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open config file: %w", err)
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+
+	if err := dec.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// flags holds the command line flags which override the configuration file.
+type flags struct {
+	*flag.FlagSet
+
+	configFile string
+	cfg        Config
+	interfaces stringList
+}
+
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+func newFlags(name string) *flags {
+	f := &flags{
+		FlagSet: flag.NewFlagSet(name, flag.ContinueOnError),
+	}
+
+	f.StringVar(&f.configFile, "config", "", "path of the configuration file")
+	f.StringVar(&f.cfg.RouterID, "router-id", "", "router ID of the speaker")
+	f.Var(&f.interfaces, "interface", "glob pattern of the interfaces to use (can be repeated)")
+	f.BoolVar(&f.cfg.Multicast, "multicast", true, "use multicast on interfaces")
+	f.BoolVar(&f.cfg.StrictDecoding, "strict-decoding", false, "drop received packets which violate RFC 8966")
+	f.TextVar(&f.cfg.LogLevel, "log-level", slog.LevelInfo, "log level (debug, info, warn, error)")
+	f.StringVar(&f.cfg.Control.Listen, "control", "", "address of the control socket (unix:<path> or tcp:<host>:<port>)")
+	f.StringVar(&f.cfg.Metrics.Listen, "metrics", "", "address of the HTTP metrics endpoint")
+	f.BoolVar(&f.cfg.Kernel.Install, "install-routes", false, "install selected routes into the kernel")
+	f.IntVar(&f.cfg.Kernel.Table, "kernel-table", kernel.DefaultTable, "kernel routing table for installed routes")
+	f.BoolVar(&f.cfg.Kernel.Redistribute.Enabled, "redistribute", false, "redistribute static and DHCP routes of the kernel")
+
+	return f
+}
+
+// Config loads the configuration file, if any, and
+// applies all explicitly set flags on top of it.
+func (f *flags) Config() (*Config, error) {
+	cfg := DefaultConfig()
+
+	if f.configFile != "" {
+		var err error
+		if cfg, err = LoadConfig(f.configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	f.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "router-id":
+			cfg.RouterID = f.cfg.RouterID
+		case "interface":
+			cfg.Interfaces = f.interfaces
+		case "multicast":
+			cfg.Multicast = f.cfg.Multicast
+		case "strict-decoding":
+			cfg.StrictDecoding = f.cfg.StrictDecoding
+		case "log-level":
+			cfg.LogLevel = f.cfg.LogLevel
+		case "control":
+			cfg.Control.Listen = f.cfg.Control.Listen
+		case "metrics":
+			cfg.Metrics.Listen = f.cfg.Metrics.Listen
+		case "install-routes":
+			cfg.Kernel.Install = f.cfg.Kernel.Install
+		case "kernel-table":
+			cfg.Kernel.Table = f.cfg.Kernel.Table
+		case "redistribute":
+			cfg.Kernel.Redistribute.Enabled = f.cfg.Kernel.Redistribute.Enabled
+		}
+	})
+
+	return cfg, cfg.Validate()
+}
+
+func (c *Config) Validate() error {
+	if c.RouterID != "" {
+		if _, err := proto.ParseRouterID(c.RouterID); err != nil {
+			return err
+		}
+	}
+
+	for _, pattern := range c.Interfaces {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid interface pattern %q: %w", pattern, err)
+		}
+	}
+
+	if c.Control.Listen != "" {
+		if _, _, err := c.Control.Address(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := c.Kernel.Redistribute.Filter(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// SpeakerConfig returns the configuration of the speaker.
+func (c *Config) SpeakerConfig() (*babel.SpeakerConfig, error) {
+	params := babel.DefaultParameters
+
+	if p := c.Parameters; p.HelloInterval > 0 {
+		params.MulticastHelloInterval = p.HelloInterval
+	}
+
+	if p := c.Parameters; p.UnicastHelloInterval > 0 {
+		params.UnicastHelloInterval = p.UnicastHelloInterval
+	}
+
+	if p := c.Parameters; p.IHUInterval > 0 {
+		params.IHUInterval = p.IHUInterval
+	}
+
+	if p := c.Parameters; p.UpdateInterval > 0 {
+		params.UpdateInterval = p.UpdateInterval
+	}
+
+	if p := c.Parameters; p.MetricSmoothingHalfLife > 0 {
+		params.MetricSmoothingHalfLife = p.MetricSmoothingHalfLife
+	}
+
+	if p := c.Parameters; p.NominalLinkCost > 0 {
+		params.NominalLinkCost = p.NominalLinkCost
+	}
+
+	sc := &babel.SpeakerConfig{
+		Parameters:     &params,
+		Multicast:      c.Multicast,
+		StrictDecoding: c.StrictDecoding,
+	}
+
+	if c.RouterID != "" {
+		var err error
+		if sc.RouterID, err = proto.ParseRouterID(c.RouterID); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Interfaces) > 0 {
+		patterns := c.Interfaces
+		sc.InterfaceFilter = func(name string) bool {
+			for _, pattern := range patterns {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+
+			return false
+		}
+	}
+
+	return sc, nil
+}
+
+// Address returns the network and address of the control socket.
+func (c ControlConfig) Address() (network, address string, err error) {
+	network, address, ok := strings.Cut(c.Listen, ":")
+	if !ok || address == "" {
+		return "", "", fmt.Errorf("%w: %s", errInvalidListenAddress, c.Listen)
+	}
+
+	switch network {
+	case "unix":
+	case "tcp", "tcp4", "tcp6":
+		if _, _, err := net.SplitHostPort(address); err != nil {
+			return "", "", fmt.Errorf("%w: %w", errInvalidListenAddress, err)
+		}
+
+	default:
+		return "", "", fmt.Errorf("%w: unsupported network %s", errInvalidListenAddress, network)
+	}
+
+	return network, address, nil
+}
+
+// Filter returns a filter which only allows routes
+// contained in one of the configured prefixes.
+func (c RedistributeConfig) Filter() (filter.Filter, error) {
+	if len(c.Prefixes) == 0 {
+		return nil, nil
+	}
+
+	f := filter.Filter{}
+
+	for _, p := range c.Prefixes {
+		pfx, err := netip.ParsePrefix(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid redistributed prefix: %w", err)
+		}
+
+		f = append(f, filter.Rule{
+			Prefix: pfx.Masked(),
+			Action: filter.ActionAllow,
+		})
+	}
+
+	return append(f, filter.Rule{
+		Action: filter.ActionDeny,
+	}), nil
+}
+
+// RedistributorConfig returns the configuration of the kernel route redistributor.
+func (c RedistributeConfig) RedistributorConfig() (*kernel.RedistributorConfig, error) {
+	f, err := c.Filter()
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &kernel.RedistributorConfig{
+		Table:  c.Table,
+		Filter: f,
+		Metric: proto.Metric(c.Metric),
+	}
+
+	for _, p := range c.Protocols {
+		rc.Protocols = append(rc.Protocols, netlink.RouteProtocol(p))
+	}
+
+	return rc, nil
+}
@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"time"
+
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/kernel"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Context("Config", func() {
+	var fn string
+
+	writeConfig := func(content string) {
+		fn = filepath.Join(GinkgoT().TempDir(), "go-babel.yaml")
+		err := os.WriteFile(fn, []byte(content), 0o600)
+		Expect(err).To(Succeed())
+	}
+
+	It("uses defaults without config file and flags", func() {
+		f := newFlags("go-babel")
+		err := f.Parse(nil)
+		Expect(err).To(Succeed())
+
+		cfg, err := f.Config()
+		Expect(err).To(Succeed())
+		Expect(cfg).To(Equal(DefaultConfig()))
+	})
+
+	It("loads a config file", func() {
+		writeConfig(`
+router_id: "02:00:00:00:00:00:00:01"
+interfaces: [ "eth*", "wg0" ]
+multicast: false
+strict_decoding: true
+log_level: debug
+parameters:
+  hello_interval: 2s
+  nominal_link_cost: 128
+control:
+  listen: unix:/run/go-babel.sock
+metrics:
+  listen: ":9100"
+kernel:
+  install: true
+  table: 100
+  redistribute:
+    enabled: true
+    protocols: [ 4 ]
+    prefixes: [ "2001:db8::/32" ]
+`)
+
+		cfg, err := LoadConfig(fn)
+		Expect(err).To(Succeed())
+		Expect(cfg.RouterID).To(Equal("02:00:00:00:00:00:00:01"))
+		Expect(cfg.Interfaces).To(Equal([]string{"eth*", "wg0"}))
+		Expect(cfg.Multicast).To(BeFalse())
+		Expect(cfg.StrictDecoding).To(BeTrue())
+		Expect(cfg.LogLevel).To(Equal(slog.LevelDebug))
+		Expect(cfg.Parameters.HelloInterval).To(Equal(2 * time.Second))
+		Expect(cfg.Parameters.NominalLinkCost).To(BeNumerically("==", 128))
+		Expect(cfg.Control.Listen).To(Equal("unix:/run/go-babel.sock"))
+		Expect(cfg.Metrics.Listen).To(Equal(":9100"))
+		Expect(cfg.Kernel.Install).To(BeTrue())
+		Expect(cfg.Kernel.Table).To(Equal(100))
+		Expect(cfg.Kernel.Redistribute.Enabled).To(BeTrue())
+		Expect(cfg.Kernel.Redistribute.Table).To(Equal(kernel.DefaultTable))
+		Expect(cfg.Kernel.Redistribute.Protocols).To(Equal([]int{4}))
+	})
+
+	It("rejects unknown settings", func() {
+		writeConfig("unknown: 1\n")
+
+		_, err := LoadConfig(fn)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("overrides the config file by flags", func() {
+		writeConfig(`
+router_id: "02:00:00:00:00:00:00:01"
+interfaces: [ "eth*" ]
+log_level: warn
+`)
+
+		f := newFlags("go-babel")
+		err := f.Parse([]string{"-config", fn, "-interface", "wg0", "-interface", "wg1", "-log-level", "debug", "-strict-decoding"})
+		Expect(err).To(Succeed())
+
+		cfg, err := f.Config()
+		Expect(err).To(Succeed())
+		Expect(cfg.RouterID).To(Equal("02:00:00:00:00:00:00:01"))
+		Expect(cfg.Interfaces).To(Equal([]string{"wg0", "wg1"}))
+		Expect(cfg.LogLevel).To(Equal(slog.LevelDebug))
+		Expect(cfg.Multicast).To(BeTrue())
+		Expect(cfg.StrictDecoding).To(BeTrue())
+	})
+
+	DescribeTable("validates",
+		func(cfg Config, valid bool) {
+			err := cfg.Validate()
+			if valid {
+				Expect(err).To(Succeed())
+			} else {
+				Expect(err).To(HaveOccurred())
+			}
+		},
+		Entry("empty", Config{}, true),
+		Entry("router ID", Config{RouterID: "02:00:00:00:00:00:00:01"}, true),
+		Entry("invalid router ID", Config{RouterID: "02:00"}, false),
+		Entry("invalid interface pattern", Config{Interfaces: []string{"eth["}}, false),
+		Entry("unix control socket", Config{Control: ControlConfig{Listen: "unix:/run/go-babel.sock"}}, true),
+		Entry("tcp control socket", Config{Control: ControlConfig{Listen: "tcp:[::1]:33123"}}, true),
+		Entry("tcp control socket without port", Config{Control: ControlConfig{Listen: "tcp:localhost"}}, false),
+		Entry("unsupported control socket", Config{Control: ControlConfig{Listen: "udp:[::1]:33123"}}, false),
+		Entry("redistributed prefix", Config{Kernel: KernelConfig{Redistribute: RedistributeConfig{Prefixes: []string{"10.0.0.0/8"}}}}, true),
+		Entry("invalid redistributed prefix", Config{Kernel: KernelConfig{Redistribute: RedistributeConfig{Prefixes: []string{"10.0.0.0"}}}}, false),
+	)
+
+	It("builds a redistributor config", func() {
+		rc, err := RedistributeConfig{
+			Protocols: []int{4, 16},
+			Prefixes:  []string{"10.0.0.0/8"},
+			Metric:    10,
+		}.RedistributorConfig()
+		Expect(err).To(Succeed())
+		Expect(rc.Protocols).To(Equal([]netlink.RouteProtocol{4, 16}))
+		Expect(rc.Metric).To(BeNumerically("==", 10))
+
+		_, ok := rc.Filter.Apply(&filter.Route{Prefix: netip.MustParsePrefix("10.1.0.0/16")})
+		Expect(ok).To(BeTrue())
+
+		_, ok = rc.Filter.Apply(&filter.Route{Prefix: netip.MustParsePrefix("2001:db8::/32")})
+		Expect(ok).To(BeFalse())
+	})
+
+	It("builds a speaker config", func() {
+		cfg := DefaultConfig()
+		cfg.RouterID = "02:00:00:00:00:00:00:01"
+		cfg.Interfaces = []string{"eth*"}
+		cfg.Parameters.UpdateInterval = 8 * time.Second
+		cfg.StrictDecoding = true
+
+		sc, err := cfg.SpeakerConfig()
+		Expect(err).To(Succeed())
+		Expect(sc.RouterID).To(Equal(proto.RouterID{0x02, 0, 0, 0, 0, 0, 0, 0x01}))
+		Expect(sc.Multicast).To(BeTrue())
+		Expect(sc.StrictDecoding).To(BeTrue())
+		Expect(sc.Parameters.UpdateInterval).To(Equal(8 * time.Second))
+		Expect(sc.InterfaceFilter("eth0")).To(BeTrue())
+		Expect(sc.InterfaceFilter("wg0")).To(BeFalse())
+	})
+})
@@ -0,0 +1,296 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/control"
+	"cunicu.li/go-babel/kernel"
+	"cunicu.li/go-babel/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/vishvananda/netlink"
+)
+
+// shutdownTimeout is the time we wait for HTTP requests
+// to complete during shutdown.
+const shutdownTimeout = 5 * time.Second
+
+// daemon runs a speaker along with its optional services.
+type daemon struct {
+	config *Config
+
+	speaker  *babel.Speaker
+	handler  *metrics.Metrics
+	registry *prometheus.Registry
+	control  *control.Server
+	http     *http.Server
+
+	installer     *kernel.Installer
+	installerStop context.CancelFunc
+	installerDone chan error
+
+	redistributor     *kernel.Redistributor
+	redistributorStop context.CancelFunc
+	redistributorDone chan error
+
+	logLevel *slog.LevelVar
+	logger   *slog.Logger
+}
+
+func newDaemon(cfg *Config, logLevel *slog.LevelVar, logger *slog.Logger) (d *daemon, err error) {
+	d = &daemon{
+		config:   cfg,
+		registry: prometheus.NewRegistry(),
+		logLevel: logLevel,
+		logger:   logger,
+	}
+
+	// Release everything which has already been started
+	defer func() {
+		if err != nil {
+			d.Close() //nolint:errcheck
+		}
+	}()
+
+	d.logLevel.Set(cfg.LogLevel)
+
+	sc, err := cfg.SpeakerConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := metrics.New(d.registry)
+	if err != nil {
+		return nil, fmt.Errorf("failed to register metrics: %w", err)
+	}
+
+	d.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+
+	d.handler = m
+
+	sc.Handler = m
+	sc.Logger = logger
+
+	if d.speaker, err = babel.NewSpeaker(sc); err != nil {
+		return nil, fmt.Errorf("failed to create speaker: %w", err)
+	}
+
+	m.SetSpeaker(d.speaker)
+
+	if cfg.Kernel.Install {
+		if err := d.startInstaller(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Kernel.Redistribute.Enabled {
+		if err := d.startRedistributor(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Control.Listen != "" {
+		if err := d.startControl(); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Metrics.Listen != "" {
+		if err := d.startMetrics(); err != nil {
+			return nil, err
+		}
+	}
+
+	return d, nil
+}
+
+func (d *daemon) startInstaller() (err error) {
+	if d.installer, err = kernel.NewInstaller(d.speaker, &kernel.InstallerConfig{
+		Table:    d.config.Kernel.Table,
+		Protocol: netlink.RouteProtocol(d.config.Kernel.Protocol),
+		Priority: d.config.Kernel.Priority,
+		Logger:   d.logger.With(slog.String("component", "kernel")),
+	}); err != nil {
+		return fmt.Errorf("failed to create route installer: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.installerStop = cancel
+	d.installerDone = make(chan error, 1)
+
+	go func() {
+		d.installerDone <- d.installer.Run(ctx)
+	}()
+
+	return nil
+}
+
+func (d *daemon) startRedistributor() error {
+	rc, err := d.config.Kernel.Redistribute.RedistributorConfig()
+	if err != nil {
+		return err
+	}
+
+	rc.InstallerProtocol = netlink.RouteProtocol(d.config.Kernel.Protocol)
+	rc.Logger = d.logger.With(slog.String("component", "redistribute"))
+
+	d.redistributor = kernel.NewRedistributor(d.speaker, rc)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	d.redistributorStop = cancel
+	d.redistributorDone = make(chan error, 1)
+
+	go func() {
+		d.redistributorDone <- d.redistributor.Run(ctx)
+	}()
+
+	return nil
+}
+
+func (d *daemon) startControl() error {
+	network, address, err := d.config.Control.Address()
+	if err != nil {
+		return err
+	}
+
+	// Remove stale socket of a previous run
+	if network == "unix" {
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale control socket: %w", err)
+		}
+	}
+
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+
+	d.control = control.NewServer(d.speaker, d.logger.With(slog.String("component", "control")))
+
+	go func() {
+		if err := d.control.Serve(l); err != nil {
+			d.logger.Error("Failed to serve control socket", slog.Any("error", err))
+		}
+	}()
+
+	return nil
+}
+
+func (d *daemon) startMetrics() error {
+	l, err := net.Listen("tcp", d.config.Metrics.Listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(d.registry, promhttp.HandlerOpts{}))
+
+	d.http = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: shutdownTimeout,
+	}
+
+	go func() {
+		if err := d.http.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			d.logger.Error("Failed to serve metrics", slog.Any("error", err))
+		}
+	}()
+
+	return nil
+}
+
+// Reload applies a new configuration.
+// The log level and the settings of the speaker are changed at runtime.
+// Changes of the other services require a restart of the daemon.
+func (d *daemon) Reload(cfg *Config) error {
+	sc, err := cfg.SpeakerConfig()
+	if err != nil {
+		return err
+	}
+
+	sc.Handler = d.handler
+	sc.Logger = d.logger
+
+	if err := d.speaker.UpdateConfig(sc); err != nil {
+		return fmt.Errorf("failed to update speaker: %w", err)
+	}
+
+	d.logLevel.Set(cfg.LogLevel)
+
+	if cfg.Control != d.config.Control || cfg.Metrics != d.config.Metrics || !reflect.DeepEqual(cfg.Kernel, d.config.Kernel) {
+		d.logger.Warn("Changes of the control socket, metrics or kernel settings require a restart")
+	}
+
+	// Keep the settings of the running services
+	newCfg := *cfg
+	newCfg.Control = d.config.Control
+	newCfg.Metrics = d.config.Metrics
+	newCfg.Kernel = d.config.Kernel
+
+	d.config = &newCfg
+
+	d.logger.Info("Reloaded configuration")
+
+	return nil
+}
+
+// Close stops all services and removes the installed routes.
+func (d *daemon) Close() error {
+	errs := []error{}
+
+	if d.http != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+
+		if err := d.http.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop metrics: %w", err))
+		}
+	}
+
+	if d.control != nil {
+		if err := d.control.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to stop control socket: %w", err))
+		}
+	}
+
+	if d.redistributorStop != nil {
+		d.redistributorStop()
+
+		if err := <-d.redistributorDone; err != nil {
+			errs = append(errs, fmt.Errorf("failed to redistribute routes: %w", err))
+		}
+	}
+
+	if d.installerStop != nil {
+		d.installerStop()
+
+		if err := <-d.installerDone; err != nil {
+			errs = append(errs, fmt.Errorf("failed to remove installed routes: %w", err))
+		}
+	}
+
+	if d.speaker != nil {
+		if err := d.speaker.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close speaker: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
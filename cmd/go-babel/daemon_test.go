@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	g "cunicu.li/gont/v2/pkg"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// dumpControl connects to a control socket and returns the lines of a dump.
+func dumpControl(path string) ([]string, error) {
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	if _, err := fmt.Fprintln(c, "dump"); err != nil {
+		return nil, err
+	}
+
+	lines := []string{}
+	oks := 0
+
+	// The greeting and the dump are both terminated by "ok"
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "ok" {
+			if oks++; oks == 2 {
+				return lines, nil
+			}
+		}
+
+		lines = append(lines, line)
+	}
+
+	return nil, scanner.Err()
+}
+
+// multicastInterfaces returns the names of all local interfaces
+// which are up and capable of multicast.
+func multicastInterfaces() ([]string, error) {
+	intfs, err := net.Interfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, intf := range intfs {
+		if intf.Flags&net.FlagLoopback == 0 && intf.Flags&net.FlagUp != 0 && intf.Flags&net.FlagMulticast != 0 {
+			names = append(names, intf.Name)
+		}
+	}
+
+	return names, nil
+}
+
+// babelGoroutines returns the stacks of all goroutines
+// which run code of the speaker and its services.
+func babelGoroutines() []string {
+	buf := make([]byte, 1<<20)
+	buf = buf[:runtime.Stack(buf, true)]
+
+	stacks := []string{}
+	for _, stack := range strings.Split(string(buf), "\n\n") {
+		for _, pkg := range []string{"cunicu.li/go-babel.", "cunicu.li/go-babel/internal/", "cunicu.li/go-babel/control."} {
+			if strings.Contains(stack, pkg) {
+				stacks = append(stacks, stack)
+				break
+			}
+		}
+	}
+
+	return stacks
+}
+
+var _ = Context("Daemon lifecycle", func() {
+	It("stops all goroutines when closed", func() {
+		names, err := multicastInterfaces()
+		Expect(err).To(Succeed())
+
+		if len(names) == 0 {
+			Skip("No multicast capable interfaces")
+		}
+
+		Expect(babelGoroutines()).To(BeEmpty())
+
+		cfg := DefaultConfig()
+		cfg.Interfaces = names
+		cfg.Control.Listen = "unix:" + filepath.Join(GinkgoT().TempDir(), "babel.sock")
+
+		d, err := newDaemon(cfg, &slog.LevelVar{}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+		Expect(err).To(Succeed())
+
+		Expect(d.speaker.Originate(netip.MustParsePrefix("2001:db8::/64"), 0)).To(Succeed())
+		Expect(babelGoroutines()).NotTo(BeEmpty())
+
+		Expect(d.Close()).To(Succeed())
+
+		Eventually(babelGoroutines).Should(BeEmpty())
+	})
+})
+
+var _ = Context("Daemon", Label("integration"), func() {
+	var err error
+	var n *g.Network
+
+	BeforeEach(func() {
+		if err := g.CheckCaps(); err != nil {
+			Skip(fmt.Sprintf("%s", err))
+		}
+
+		n, err = g.NewNetwork("")
+		Expect(err).To(Succeed())
+	})
+
+	AfterEach(func() {
+		err = n.Close()
+		Expect(err).To(Succeed())
+	})
+
+	It("runs two daemons which find each other", func() {
+		sw, err := n.AddSwitch("sw1")
+		Expect(err).To(Succeed())
+
+		h1, err := n.AddHost("h1",
+			g.NewInterface("eth0", sw))
+		Expect(err).To(Succeed())
+
+		h2, err := n.AddHost("h2",
+			g.NewInterface("eth0", sw))
+		Expect(err).To(Succeed())
+
+		dir := GinkgoT().TempDir()
+		daemons := []*daemon{}
+
+		for i, h := range []*g.Host{h1, h2} {
+			cfg := DefaultConfig()
+			cfg.Interfaces = []string{"eth*"}
+			cfg.Control.Listen = "unix:" + filepath.Join(dir, fmt.Sprintf("h%d.sock", i+1))
+			cfg.Kernel.Install = true
+
+			err = h.RunFunc(func() error {
+				logger := slog.Default().With(slog.String("daemon", h.Name()))
+				d, err := newDaemon(cfg, &slog.LevelVar{}, logger)
+				if err == nil {
+					daemons = append(daemons, d)
+				}
+				return err
+			})
+			Expect(err).To(Succeed())
+		}
+
+		By("Waiting until neighbours are reported by the control socket")
+
+		for i := range daemons {
+			sock := filepath.Join(dir, fmt.Sprintf("h%d.sock", i+1))
+
+			Eventually(func() ([]string, error) {
+				return dumpControl(sock)
+			}, 100*time.Second, time.Second).Should(ContainElement(HavePrefix("add neighbour")))
+		}
+
+		By("Checking metrics")
+
+		for _, d := range daemons {
+			mfs, err := d.registry.Gather()
+			Expect(err).To(Succeed())
+
+			names := []string{}
+			for _, mf := range mfs {
+				names = append(names, mf.GetName())
+			}
+
+			Expect(names).To(ContainElement("babel_packets_received_total"))
+			Expect(names).To(ContainElement("babel_neighbour_cost"))
+		}
+
+		By("Shutting down gracefully")
+
+		for _, d := range daemons {
+			err = d.Close()
+			Expect(err).To(Succeed())
+		}
+	})
+})
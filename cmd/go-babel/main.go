@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// go-babel is a standalone Babel routing daemon.
+//
+// It is configured by a YAML file and/or command line flags.
+// Flags take precedence over settings of the configuration file.
+// SIGHUP reloads the configuration file and applies it without
+// restarting the speaker. SIGINT or SIGTERM shut down the daemon gracefully.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	os.Exit(run(os.Args))
+}
+
+func run(args []string) int {
+	f := newFlags(args[0])
+
+	if err := f.Parse(args[1:]); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+
+		return 2
+	}
+
+	logLevel := &slog.LevelVar{}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	slog.SetDefault(logger)
+
+	cfg, err := f.Config()
+	if err != nil {
+		logger.Error("Invalid configuration", slog.Any("error", err))
+		return 1
+	}
+
+	d, err := newDaemon(cfg, logLevel, logger)
+	if err != nil {
+		logger.Error("Failed to start daemon", slog.Any("error", err))
+		return 1
+	}
+
+	logger.Info("Started daemon")
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+
+	for sig := range signals {
+		if sig != syscall.SIGHUP {
+			logger.Info("Shutting down", slog.String("signal", sig.String()))
+			break
+		}
+
+		cfg, err := f.Config()
+		if err != nil {
+			logger.Error("Failed to reload configuration", slog.Any("error", err))
+			continue
+		}
+
+		if err := d.Reload(cfg); err != nil {
+			logger.Error("Failed to reload configuration", slog.Any("error", err))
+		}
+	}
+
+	if err := d.Close(); err != nil {
+		logger.Error("Failed to shut down daemon", slog.Any("error", err))
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,324 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package control implements the local configuration protocol of babeld
+// on top of a speaker so that existing babeld tooling can be used.
+//
+// See: babeld(8) section "Local configuration interface"
+// https://www.irif.fr/~jch/software/babel/babeld.html
+package control
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	babel "cunicu.li/go-babel"
+)
+
+const (
+	// ProtocolVersion is the version of the local configuration protocol.
+	ProtocolVersion = "BABEL 1.0"
+
+	// monitorInterval is the interval in which monitoring clients are
+	// updated about changes which are not signaled by events.
+	monitorInterval = time.Second
+)
+
+// Speaker is the part of babel.Speaker which is used by the server.
+type Speaker interface {
+	Snapshot() *babel.Snapshot
+	Subscribe(opts babel.SubscriptionOptions) *babel.Subscription
+}
+
+// Server serves the local configuration protocol of babeld.
+type Server struct {
+	speaker Speaker
+	logger  *slog.Logger
+
+	listeners map[net.Listener]any
+	conns     map[*conn]any
+	closed    bool
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+}
+
+func NewServer(s Speaker, logger *slog.Logger) *Server {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &Server{
+		speaker:   s,
+		logger:    logger,
+		listeners: map[net.Listener]any{},
+		conns:     map[*conn]any{},
+	}
+}
+
+// Serve accepts connections on the listener until it is closed.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return net.ErrClosed
+	}
+	s.listeners[l] = nil
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.listeners, l)
+		s.mu.Unlock()
+	}()
+
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+
+			return fmt.Errorf("failed to accept connection: %w", err)
+		}
+
+		c := &conn{
+			Conn:   nc,
+			server: s,
+			logger: s.logger.With(slog.Any("remote", nc.RemoteAddr())),
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			return nil
+		}
+		s.conns[c] = nil
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go func() {
+			defer s.wg.Done()
+
+			c.serve()
+
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops all listeners and closes all connections.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+
+	errs := []error{}
+	for l := range s.listeners {
+		if err := l.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+
+	// Connections might have been closed by their client already
+	for c := range s.conns {
+		if err := c.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+			errs = append(errs, err)
+		}
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+type conn struct {
+	net.Conn
+
+	server *Server
+	logger *slog.Logger
+
+	monitor *monitor
+	mu      sync.Mutex // Serializes writes
+}
+
+func (c *conn) serve() {
+	defer c.stopMonitor()
+	defer c.Close()
+
+	hostname, _ := os.Hostname()
+	snap := c.server.speaker.Snapshot()
+
+	if err := c.writeLines(
+		ProtocolVersion,
+		"version go-babel",
+		"host "+hostname,
+		"my-id "+snap.RouterID,
+		"ok",
+	); err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(c)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		if quit, err := c.handle(line); err != nil {
+			if !errors.Is(err, net.ErrClosed) && !errors.Is(err, io.EOF) {
+				c.logger.Debug("Failed to handle command", slog.String("line", line), slog.Any("error", err))
+			}
+			return
+		} else if quit {
+			return
+		}
+	}
+}
+
+// handle handles a single command line of a client.
+func (c *conn) handle(line string) (quit bool, err error) {
+	cmd, _, _ := strings.Cut(line, " ")
+
+	switch cmd {
+	case "quit":
+		return true, nil
+
+	case "dump", "dump-interfaces", "dump-neighbours", "dump-routes", "dump-xroutes":
+		lines := formatSnapshot(c.server.speaker.Snapshot(), kindsOf(cmd))
+		return false, c.writeLines(append(lines, "ok")...)
+
+	case "monitor", "monitor-interfaces", "monitor-neighbours", "monitor-routes", "monitor-xroutes":
+		c.startMonitor(kindsOf(cmd))
+		return false, nil
+
+	case "unmonitor":
+		c.stopMonitor()
+		return false, c.writeLines("ok")
+
+	default:
+		// Configuration statements are not supported (yet)
+		return false, c.writeLines("no")
+	}
+}
+
+// kindsOf returns the kinds of entries selected by a dump or monitor command.
+func kindsOf(cmd string) kind {
+	_, suffix, _ := strings.Cut(cmd, "-")
+
+	switch suffix {
+	case "interfaces":
+		return kindInterface
+	case "neighbours":
+		return kindNeighbour
+	case "routes":
+		return kindRoute
+	case "xroutes":
+		return kindXRoute
+	default:
+		return kindAll
+	}
+}
+
+func (c *conn) writeLines(lines ...string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var sb strings.Builder
+	for _, line := range lines {
+		sb.WriteString(line)
+		sb.WriteByte('\n')
+	}
+
+	_, err := io.WriteString(c.Conn, sb.String())
+
+	return err
+}
+
+// monitor streams changes of the speaker state to a client.
+type monitor struct {
+	kinds kind
+	sub   *babel.Subscription
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// startMonitor dumps the current state and afterwards
+// streams changes to the client.
+func (c *conn) startMonitor(kinds kind) {
+	c.stopMonitor()
+
+	m := &monitor{
+		kinds: kinds,
+		// Events are only used as a trigger to compare snapshots.
+		// So we can safely drop events while we are busy.
+		sub:  c.server.speaker.Subscribe(babel.SubscriptionOptions{BufferSize: 1}),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	last := c.server.speaker.Snapshot()
+
+	if err := c.writeLines(append(formatSnapshot(last, kinds), "ok")...); err != nil {
+		m.sub.Close()
+		return
+	}
+
+	c.monitor = m
+
+	go c.runMonitor(m, last)
+}
+
+func (c *conn) runMonitor(m *monitor, last *babel.Snapshot) {
+	defer close(m.done)
+	defer m.sub.Close()
+
+	ticker := time.NewTicker(monitorInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-m.stop:
+			return
+
+		case _, ok := <-m.sub.C:
+			if !ok {
+				return
+			}
+
+		case <-ticker.C:
+		}
+
+		snap := c.server.speaker.Snapshot()
+
+		if lines := formatDiff(last.Diff(snap), m.kinds); len(lines) > 0 {
+			if err := c.writeLines(lines...); err != nil {
+				return
+			}
+		}
+
+		last = snap
+	}
+}
+
+func (c *conn) stopMonitor() {
+	if c.monitor == nil {
+		return
+	}
+
+	close(c.monitor.stop)
+	<-c.monitor.done
+
+	c.monitor = nil
+}
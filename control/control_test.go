@@ -0,0 +1,306 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package control
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	babel "cunicu.li/go-babel"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestControl(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Control suite")
+}
+
+// fakeSpeaker returns a fixed snapshot which can be changed by the test.
+type fakeSpeaker struct {
+	*babel.Speaker
+
+	snap *babel.Snapshot
+	mu   sync.Mutex
+}
+
+func (s *fakeSpeaker) Snapshot() *babel.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snap
+}
+
+func (s *fakeSpeaker) SetSnapshot(snap *babel.Snapshot) {
+	s.mu.Lock()
+	s.snap = snap
+	s.mu.Unlock()
+}
+
+var (
+	intf = babel.InterfaceSnapshot{
+		Index:            2,
+		Name:             "eth0",
+		Up:               true,
+		AddressLinkLocal: netip.MustParseAddr("fe80::2"),
+		AddressIPv4:      netip.MustParseAddr("192.0.2.2"),
+	}
+
+	neigh = babel.NeighbourSnapshot{
+		Interface: "eth0",
+		Address:   netip.MustParseAddr("fe80::1"),
+		MulticastHellos: babel.HelloHistorySnapshot{
+			Vector: 0b0111,
+		},
+		RxCost: 96,
+		TxCost: 128,
+		Cost:   128,
+	}
+
+	route = babel.RouteSnapshot{
+		Prefix:    netip.MustParsePrefix("2001:db8::/64"),
+		RouterID:  "00:00:00:00:00:00:00:01",
+		Interface: "eth0",
+		Neighbour: netip.MustParseAddr("fe80::1"),
+		Metric:    224,
+		RefMetric: 96,
+		Selected:  true,
+	}
+)
+
+var _ = Describe("Format", func() {
+	It("formats interfaces", func() {
+		Expect(formatInterface(verbAdd, intf)).To(Equal("add interface eth0 up true ipv6 fe80::2 ipv4 192.0.2.2"))
+
+		down := intf
+		down.Up = false
+		Expect(formatInterface(verbChange, down)).To(Equal("change interface eth0 up false"))
+		Expect(formatInterface(verbFlush, intf)).To(Equal("flush interface eth0"))
+	})
+
+	It("formats neighbours", func() {
+		Expect(formatNeighbour(verbAdd, neigh)).To(Equal(fmt.Sprintf(
+			"add neighbour %x address fe80::1 if eth0 reach e000 ureach 0000 rxcost 96 txcost 128 rtt 0.000 rttcost 0 cost 128",
+			entryID("eth0", neigh.Address))))
+
+		n := neigh
+		n.RTT = 12345 * time.Microsecond
+		Expect(formatNeighbour(verbChange, n)).To(ContainSubstring(" rtt 12.345 rttcost 0 "))
+	})
+
+	It("formats routes", func() {
+		Expect(formatRoute(verbAdd, route)).To(Equal(fmt.Sprintf(
+			"add route %x prefix 2001:db8::/64 from ::/0 installed yes id 00:00:00:00:00:00:00:01 metric 224 refmetric 96 via fe80::1 if eth0",
+			entryID(route.Prefix, "eth0", route.Neighbour))))
+
+		r := route
+		r.Prefix = netip.MustParsePrefix("10.0.0.0/8")
+		r.NextHop = netip.MustParseAddr("192.0.2.1")
+		r.Selected = false
+		Expect(formatRoute(verbFlush, r)).To(MatchRegexp(
+			"^flush route [0-9a-f]+ prefix 10.0.0.0/8 from 0.0.0.0/0 installed no .* via 192.0.2.1 if eth0$"))
+	})
+
+	It("formats originated routes", func() {
+		x := babel.XRouteSnapshot{
+			Prefix: netip.MustParsePrefix("10.0.0.0/8"),
+			Metric: 10,
+		}
+
+		Expect(formatXRoute(verbAdd, x)).To(Equal("add xroute 10.0.0.0/8-0.0.0.0/0 prefix 10.0.0.0/8 from 0.0.0.0/0 metric 10"))
+		Expect(formatSnapshot(&babel.Snapshot{XRoutes: []babel.XRouteSnapshot{x}}, kindXRoute)).To(HaveLen(1))
+		Expect(formatSnapshot(&babel.Snapshot{XRoutes: []babel.XRouteSnapshot{x}}, kindRoute)).To(BeEmpty())
+	})
+
+	It("uses stable identifiers", func() {
+		changed := route
+		changed.Metric = 300
+
+		id := func(line string) string {
+			var v, k, id string
+			fmt.Sscan(line, &v, &k, &id) //nolint:errcheck
+			return id
+		}
+
+		Expect(id(formatRoute(verbAdd, route))).To(Equal(id(formatRoute(verbChange, changed))))
+	})
+
+	It("adds parents first and flushes children first", func() {
+		older := &babel.Snapshot{
+			Interfaces: []babel.InterfaceSnapshot{intf},
+			Neighbours: []babel.NeighbourSnapshot{neigh},
+			Routes:     []babel.RouteSnapshot{route},
+		}
+
+		lines := formatSnapshot(older, kindAll)
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(HavePrefix("add interface"))
+		Expect(lines[1]).To(HavePrefix("add neighbour"))
+		Expect(lines[2]).To(HavePrefix("add route"))
+
+		lines = formatDiff(older.Diff(&babel.Snapshot{}), kindAll)
+		Expect(lines).To(HaveLen(3))
+		Expect(lines[0]).To(HavePrefix("flush route"))
+		Expect(lines[1]).To(HavePrefix("flush neighbour"))
+		Expect(lines[2]).To(HavePrefix("flush interface"))
+
+		Expect(formatSnapshot(older, kindRoute)).To(HaveLen(1))
+	})
+})
+
+var _ = Describe("Server", func() {
+	var spk *fakeSpeaker
+	var srv *Server
+	var c net.Conn
+	var r *bufio.Reader
+
+	readLine := func() string {
+		line, err := r.ReadString('\n')
+		Expect(err).To(Succeed())
+		return line[:len(line)-1]
+	}
+
+	readUntilOK := func() []string {
+		lines := []string{}
+		for line := readLine(); line != "ok"; line = readLine() {
+			lines = append(lines, line)
+		}
+		return lines
+	}
+
+	send := func(line string) {
+		_, err := fmt.Fprintln(c, line)
+		Expect(err).To(Succeed())
+	}
+
+	BeforeEach(func() {
+		spk = &fakeSpeaker{
+			Speaker: &babel.Speaker{},
+			snap: &babel.Snapshot{
+				RouterID:   "00:00:00:00:00:00:00:02",
+				Interfaces: []babel.InterfaceSnapshot{intf},
+			},
+		}
+
+		srv = NewServer(spk, nil)
+
+		path := filepath.Join(GinkgoT().TempDir(), "babel.sock")
+		l, err := net.Listen("unix", path)
+		Expect(err).To(Succeed())
+
+		go srv.Serve(l) //nolint:errcheck
+
+		c, err = net.Dial("unix", path)
+		Expect(err).To(Succeed())
+
+		r = bufio.NewReader(c)
+	})
+
+	AfterEach(func() {
+		c.Close()
+		Expect(srv.Close()).To(Succeed())
+	})
+
+	It("greets the client", func() {
+		Expect(readUntilOK()).To(ConsistOf(
+			ProtocolVersion,
+			"version go-babel",
+			HavePrefix("host "),
+			"my-id 00:00:00:00:00:00:00:02",
+		))
+	})
+
+	It("dumps the state", func() {
+		readUntilOK()
+
+		send("dump")
+		Expect(readUntilOK()).To(Equal([]string{
+			"add interface eth0 up true ipv6 fe80::2 ipv4 192.0.2.2",
+		}))
+
+		send("dump-routes")
+		Expect(readUntilOK()).To(BeEmpty())
+	})
+
+	It("monitors changes", func() {
+		readUntilOK()
+
+		send("monitor")
+		Expect(readUntilOK()).To(HaveLen(1))
+
+		spk.SetSnapshot(&babel.Snapshot{
+			Interfaces: []babel.InterfaceSnapshot{intf},
+			Neighbours: []babel.NeighbourSnapshot{neigh},
+		})
+
+		Expect(readLine()).To(HavePrefix("add neighbour"))
+
+		spk.SetSnapshot(&babel.Snapshot{
+			Interfaces: []babel.InterfaceSnapshot{intf},
+		})
+
+		Expect(readLine()).To(HavePrefix("flush neighbour"))
+
+		send("unmonitor")
+		Expect(readLine()).To(Equal("ok"))
+	})
+
+	It("does not monitor changes of fields which are not shown", func() {
+		spk.SetSnapshot(&babel.Snapshot{
+			Interfaces: []babel.InterfaceSnapshot{intf},
+			Neighbours: []babel.NeighbourSnapshot{neigh},
+		})
+
+		readUntilOK()
+
+		send("monitor")
+		Expect(readUntilOK()).To(HaveLen(2))
+
+		busyIntf := intf
+		busyIntf.QueueLength = 5
+
+		busyNeigh := neigh
+		busyNeigh.QueueLength = 3
+		busyNeigh.PendingAcknowledgments = 1
+
+		spk.SetSnapshot(&babel.Snapshot{
+			Interfaces: []babel.InterfaceSnapshot{busyIntf},
+			Neighbours: []babel.NeighbourSnapshot{busyNeigh},
+		})
+
+		// Wait for the monitor to pick up the snapshot
+		time.Sleep(monitorInterval + 100*time.Millisecond)
+
+		spk.SetSnapshot(&babel.Snapshot{
+			Interfaces: []babel.InterfaceSnapshot{busyIntf},
+		})
+
+		Expect(readLine()).To(HavePrefix("flush neighbour"))
+
+		send("unmonitor")
+		Expect(readLine()).To(Equal("ok"))
+	})
+
+	It("rejects configuration statements", func() {
+		readUntilOK()
+
+		send("interface eth1")
+		Expect(readLine()).To(Equal("no"))
+	})
+
+	It("closes the connection on quit", func() {
+		readUntilOK()
+
+		send("quit")
+
+		_, err := r.ReadString('\n')
+		Expect(err).To(HaveOccurred())
+	})
+})
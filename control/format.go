@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package control
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/proto"
+)
+
+type verb string
+
+const (
+	verbAdd    verb = "add"
+	verbChange verb = "change"
+	verbFlush  verb = "flush"
+)
+
+// kind is a type of entries which can be dumped and monitored.
+type kind int
+
+const (
+	kindInterface kind = 1 << iota
+	kindNeighbour
+	kindRoute
+	kindXRoute
+
+	kindAll = kindInterface | kindNeighbour | kindRoute | kindXRoute
+)
+
+// entryID derives a stable identifier of a table entry from its key.
+// babeld uses the memory address of the entry instead.
+func entryID(key ...any) uint64 {
+	h := fnv.New64a()
+	fmt.Fprint(h, key...)
+
+	return h.Sum64()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+
+	return "no"
+}
+
+// formatInterface formats an interface line:
+//
+//	add interface eth0 up true ipv6 fe80::1 ipv4 192.0.2.1
+func formatInterface(v verb, i babel.InterfaceSnapshot) string {
+	if v == verbFlush {
+		return fmt.Sprintf("%s interface %s", v, i.Name)
+	}
+
+	if !i.Up {
+		return fmt.Sprintf("%s interface %s up false", v, i.Name)
+	}
+
+	line := fmt.Sprintf("%s interface %s up true", v, i.Name)
+
+	if i.AddressLinkLocal.IsValid() {
+		line += " ipv6 " + i.AddressLinkLocal.String()
+	}
+
+	if i.AddressIPv4.IsValid() {
+		line += " ipv4 " + i.AddressIPv4.String()
+	}
+
+	return line
+}
+
+// formatNeighbour formats a neighbour line:
+//
+//	add neighbour 5f3e2a1c address fe80::1 if eth0 reach ffff ureach 0000 rxcost 96 txcost 96 rtt 0.000 rttcost 0 cost 96
+//
+// babeld shifts new Hellos into the history from the most significant bit.
+// The rttcost is always zero as the RTT does not contribute to the link cost.
+func formatNeighbour(v verb, n babel.NeighbourSnapshot) string {
+	return fmt.Sprintf("%s neighbour %x address %s if %s reach %04x ureach %04x rxcost %d txcost %d rtt %.3f rttcost %d cost %d",
+		v, entryID(n.Interface, n.Address),
+		n.Address, n.Interface,
+		bits.Reverse16(n.MulticastHellos.Vector),
+		bits.Reverse16(n.UnicastHellos.Vector),
+		n.RxCost, n.TxCost,
+		float64(n.RTT.Microseconds())/1e3, 0,
+		n.Cost)
+}
+
+// formatRoute formats a route line:
+//
+//	add route 1a2b3c4d prefix 2001:db8::/64 from ::/0 installed yes id 00:00:00:00:00:00:00:01 metric 96 refmetric 0 via fe80::1 if eth0
+func formatRoute(v verb, r babel.RouteSnapshot) string {
+	via := r.NextHop
+	if !via.IsValid() {
+		via = r.Neighbour
+	}
+
+	return fmt.Sprintf("%s route %x prefix %s from %s installed %s id %s metric %d refmetric %d via %s if %s",
+		v, entryID(r.Prefix, r.Interface, r.Neighbour),
+		r.Prefix, sourcePrefix(r.Prefix),
+		yesNo(r.Selected), r.RouterID,
+		r.Metric, r.RefMetric,
+		via, r.Interface)
+}
+
+// formatXRoute formats a line of an originated route:
+//
+//	add xroute 2001:db8::/64-::/0 prefix 2001:db8::/64 from ::/0 metric 0
+func formatXRoute(v verb, x babel.XRouteSnapshot) string {
+	src := sourcePrefix(x.Prefix)
+
+	return fmt.Sprintf("%s xroute %s-%s prefix %s from %s metric %d",
+		v, x.Prefix, src, x.Prefix, src, x.Metric)
+}
+
+// sourcePrefix returns the source prefix of a non-source-specific route.
+//
+// See: RFC 9079 Source-Specific Routing in the Babel Routing Protocol
+// https://datatracker.ietf.org/doc/html/rfc9079
+func sourcePrefix(pfx proto.Prefix) string {
+	if pfx.Addr().Is4() {
+		return "0.0.0.0/0"
+	}
+
+	return "::/0"
+}
+
+// formatSnapshot formats add lines for all entries of a snapshot.
+func formatSnapshot(snap *babel.Snapshot, kinds kind) (lines []string) {
+	return formatDiff(new(babel.Snapshot).Diff(snap), kinds)
+}
+
+// appendChange appends a change line for an entry unless the line
+// equals the one of the old entry. Snapshots contain fields such
+// as queue lengths which are not included in the lines.
+func appendChange[T any](lines []string, c babel.Change[T], format func(verb, T) string) []string {
+	if line := format(verbChange, c.New); line != format(verbChange, c.Old) {
+		lines = append(lines, line)
+	}
+
+	return lines
+}
+
+// formatDiff formats add, change and flush lines for the differences
+// between two snapshots.
+// Entries are added parents first and flushed children first.
+func formatDiff(diff *babel.SnapshotDiff, kinds kind) (lines []string) {
+	if kinds&kindInterface != 0 {
+		for _, i := range diff.Interfaces.Added {
+			lines = append(lines, formatInterface(verbAdd, i))
+		}
+
+		for _, c := range diff.Interfaces.Changed {
+			lines = appendChange(lines, c, formatInterface)
+		}
+	}
+
+	if kinds&kindNeighbour != 0 {
+		for _, n := range diff.Neighbours.Added {
+			lines = append(lines, formatNeighbour(verbAdd, n))
+		}
+
+		for _, c := range diff.Neighbours.Changed {
+			lines = appendChange(lines, c, formatNeighbour)
+		}
+	}
+
+	if kinds&kindRoute != 0 {
+		for _, r := range diff.Routes.Added {
+			lines = append(lines, formatRoute(verbAdd, r))
+		}
+
+		for _, c := range diff.Routes.Changed {
+			lines = appendChange(lines, c, formatRoute)
+		}
+
+		for _, r := range diff.Routes.Removed {
+			lines = append(lines, formatRoute(verbFlush, r))
+		}
+	}
+
+	if kinds&kindXRoute != 0 {
+		for _, x := range diff.XRoutes.Added {
+			lines = append(lines, formatXRoute(verbAdd, x))
+		}
+
+		for _, c := range diff.XRoutes.Changed {
+			lines = appendChange(lines, c, formatXRoute)
+		}
+
+		for _, x := range diff.XRoutes.Removed {
+			lines = append(lines, formatXRoute(verbFlush, x))
+		}
+	}
+
+	if kinds&kindNeighbour != 0 {
+		for _, n := range diff.Neighbours.Removed {
+			lines = append(lines, formatNeighbour(verbFlush, n))
+		}
+	}
+
+	if kinds&kindInterface != 0 {
+		for _, i := range diff.Interfaces.Removed {
+			lines = append(lines, formatInterface(verbFlush, i))
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"fmt"
+
+	"cunicu.li/go-babel/proto"
+)
+
+// Diversity Routing for the Babel Routing Protocol
+// https://datatracker.ietf.org/doc/html/draft-chroboczek-babel-diversity-routing-01
+
+// maxDiversityHops is the maximal number of channels advertised
+// in the Diversity sub-TLV of our Updates (as used by babeld).
+const maxDiversityHops = 8
+
+// Channel is the radio channel of an interface.
+// Links on interfering channels can not be used at the same time.
+type Channel int
+
+const (
+	// ChannelAuto treats wireless interfaces as interfering
+	// and all other interfaces as non-interfering.
+	ChannelAuto Channel = 0
+
+	// ChannelNonInterfering does not interfere with any other channel.
+	ChannelNonInterfering Channel = -1
+
+	// ChannelInterfering interferes with all other channels.
+	ChannelInterfering = Channel(proto.ChannelInterfering)
+)
+
+func (c Channel) String() string {
+	switch c {
+	case ChannelAuto:
+		return "auto"
+	case ChannelNonInterfering:
+		return "noninterfering"
+	case ChannelInterfering:
+		return "interfering"
+	default:
+		return fmt.Sprintf("%d", int(c))
+	}
+}
+
+// interferes checks if two channels interfere with each other.
+func (c Channel) interferes(o Channel) bool {
+	switch {
+	case c == ChannelNonInterfering || o == ChannelNonInterfering:
+		return false
+	case c == ChannelInterfering || o == ChannelInterfering:
+		return true
+	default:
+		return c == o
+	}
+}
+
+// channel returns the radio channel of the interface.
+func (i *Interface) channel() Channel {
+	if c := i.config().Channel; c != ChannelAuto {
+		return c
+	}
+
+	if i.linkType() == InterfaceTypeWireless {
+		return ChannelInterfering
+	}
+
+	return ChannelNonInterfering
+}
+
+// interferes checks if the channel of the interface interferes
+// with any of the channels along the path of a route.
+func (i *Interface) interferes(channels []uint8) bool {
+	c := i.channel()
+
+	for _, ch := range channels {
+		// Channel 0 is reserved and terminates the list
+		if ch == 0 {
+			break
+		}
+
+		if c.interferes(Channel(ch)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// diversityCost returns the cost of the link to the neighbour of a route.
+// If diversity routing is enabled, the cost is scaled by the diversity
+// factor if the link does not interfere with the remaining path of the
+// route. Channel-diverse paths are preferred as they can forward
+// packets on all of their links at the same time.
+func (s *Speaker) diversityCost(r *Route, cost uint16) uint16 {
+	f := s.config().DiversityFactor
+	if f == 0 || cost == proto.Retraction || r.Neighbour.intf.interferes(r.Channels) {
+		return cost
+	}
+
+	return uint16(min((uint32(cost)*uint32(f)+255)/256, uint32(proto.Retraction)-1))
+}
+
+// advertisedChannels returns the channels of the path of a route as
+// advertised in our Updates. It starts with the channel of the link
+// to the neighbour unless it does not interfere with any other channel.
+func (r *Route) advertisedChannels() []uint8 {
+	var channels []uint8
+
+	if c := r.Neighbour.intf.channel(); c != ChannelNonInterfering {
+		channels = append(channels, uint8(c))
+	}
+
+	for _, ch := range r.Channels {
+		if len(channels) == maxDiversityHops || ch == 0 {
+			break
+		}
+
+		channels = append(channels, ch)
+	}
+
+	return channels
+}
@@ -0,0 +1,105 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Diversity", func() {
+	var sim *simulation
+	var node *simNode
+
+	update := func(from int, metric proto.Metric, channels ...uint8) *Route {
+		sim.update(from, 1, metric, channels...)
+		return sim.route(from)
+	}
+
+	configure := func(factor uint16, channel Channel) {
+		params := *node.config().Parameters
+		params.DiversityFactor = factor
+
+		cfg := *node.config()
+		cfg.Parameters = &params
+		cfg.InterfaceDefaults = InterfaceConfig{
+			Type:    InterfaceTypeWireless,
+			Channel: channel,
+		}
+		node.cfg.Store(&cfg)
+	}
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+		node.neighbours[2].TxCost = 10
+	})
+
+	DescribeTable("interference of channels",
+		func(a, b Channel, expected bool) {
+			Expect(a.interferes(b)).To(Equal(expected))
+			Expect(b.interferes(a)).To(Equal(expected))
+		},
+		Entry("same channel", Channel(1), Channel(1), true),
+		Entry("different channels", Channel(1), Channel(6), false),
+		Entry("interfering", ChannelInterfering, Channel(6), true),
+		Entry("non-interfering", ChannelNonInterfering, Channel(6), false),
+		Entry("interfering and non-interfering", ChannelInterfering, ChannelNonInterfering, false),
+	)
+
+	It("does not change metrics if disabled", func() {
+		configure(0, 1)
+
+		r := update(0, 100, 6)
+		Expect(r.Metric).To(BeNumerically("==", 100+node.neighbours[0].Cost()))
+	})
+
+	It("reduces the cost of links which do not interfere with the path", func() {
+		configure(128, 1)
+
+		cost := node.neighbours[0].Cost()
+
+		r := update(0, 100, 6, 11)
+		Expect(r.Metric).To(BeNumerically("==", 100+(cost+1)/2))
+
+		r = update(0, 100, 6, 1)
+		Expect(r.Metric).To(BeNumerically("==", 100+cost))
+	})
+
+	It("treats wireless interfaces as interfering by default", func() {
+		configure(128, ChannelAuto)
+
+		r := update(0, 100, 6)
+		Expect(r.Metric).To(BeNumerically("==", 100+node.neighbours[0].Cost()))
+	})
+
+	It("prefers channel-diverse paths", func() {
+		configure(128, 1)
+
+		interfering := update(0, 100, 1)
+		diverse := update(2, 100, 6)
+
+		Expect(diverse.Selected).To(BeTrue())
+		Expect(interfering.Selected).To(BeFalse())
+	})
+
+	It("advertises the channels along the path", func() {
+		configure(128, 1)
+
+		r := update(0, 100, 6, 11)
+		Expect(node.advertisedUpdate(r).Channels).To(Equal([]uint8{1, 6, 11}))
+
+		r = update(0, 100, 2, 3, 4, 5, 6, 7, 8, 9, 10)
+		Expect(node.advertisedUpdate(r).Channels).To(HaveLen(maxDiversityHops))
+
+		configure(128, ChannelNonInterfering)
+
+		r = update(0, 100, 6)
+		Expect(node.advertisedUpdate(r).Channels).To(Equal([]uint8{6}))
+
+		configure(0, 1)
+
+		Expect(node.advertisedUpdate(r).Channels).To(BeEmpty())
+	})
+})
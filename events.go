@@ -0,0 +1,379 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"cunicu.li/go-babel/proto"
+)
+
+type EventType int
+
+const (
+	EventNeighbourUp EventType = iota
+	EventNeighbourDown
+	EventNeighbourCostChanged
+
+	EventRouteAdded
+	EventRouteChanged
+	EventRouteSelected
+	EventRouteUnselected
+	EventRouteRetracted
+	EventRouteExpired
+	EventRouteRemoved
+
+	EventXRouteAdded
+	EventXRouteChanged
+	EventXRouteRemoved
+
+	EventSourceCollected
+
+	EventSeqnoRequestSent
+	EventSeqnoRequestForwarded
+	EventSeqnoRequestSatisfied
+
+	// EventAuthenticationFailed is reserved for packets which fail
+	// authentication. It is not emitted until support for RFC 8967
+	// MAC authentication has been added.
+	EventAuthenticationFailed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventNeighbourUp:
+		return "NeighbourUp"
+	case EventNeighbourDown:
+		return "NeighbourDown"
+	case EventNeighbourCostChanged:
+		return "NeighbourCostChanged"
+	case EventRouteAdded:
+		return "RouteAdded"
+	case EventRouteChanged:
+		return "RouteChanged"
+	case EventRouteSelected:
+		return "RouteSelected"
+	case EventRouteUnselected:
+		return "RouteUnselected"
+	case EventRouteRetracted:
+		return "RouteRetracted"
+	case EventRouteExpired:
+		return "RouteExpired"
+	case EventRouteRemoved:
+		return "RouteRemoved"
+	case EventXRouteAdded:
+		return "XRouteAdded"
+	case EventXRouteChanged:
+		return "XRouteChanged"
+	case EventXRouteRemoved:
+		return "XRouteRemoved"
+	case EventSourceCollected:
+		return "SourceCollected"
+	case EventSeqnoRequestSent:
+		return "SeqnoRequestSent"
+	case EventSeqnoRequestForwarded:
+		return "SeqnoRequestForwarded"
+	case EventSeqnoRequestSatisfied:
+		return "SeqnoRequestSatisfied"
+	case EventAuthenticationFailed:
+		return "AuthenticationFailed"
+	default:
+		return "<Unknown>"
+	}
+}
+
+// Event is emitted by the speaker on changes of its state.
+// Events carry copies of the state at the time they have been emitted.
+// Hence, they can be safely used by subscribers.
+type Event interface {
+	EventType() EventType
+}
+
+// NeighbourEvent is emitted when a neighbour becomes reachable (up),
+// unreachable (down) or the cost of the link to it changes.
+type NeighbourEvent struct {
+	Type      EventType
+	Interface string
+	Address   proto.Address
+
+	RxCost  uint16
+	TxCost  uint16
+	Cost    uint16
+	OldCost uint16
+}
+
+func (e *NeighbourEvent) EventType() EventType { return e.Type }
+
+// RouteEvent is emitted for changes of the route table.
+type RouteEvent struct {
+	Type      EventType
+	Prefix    proto.Prefix
+	RouterID  proto.RouterID
+	Interface string
+	Neighbour proto.Address
+	NextHop   proto.Address
+	Seqno     proto.SequenceNumber
+	Metric    uint16
+}
+
+func (e *RouteEvent) EventType() EventType { return e.Type }
+
+// XRouteEvent is emitted when the speaker starts or stops originating
+// a prefix or changes the metric of an originated prefix.
+type XRouteEvent struct {
+	Type   EventType
+	Prefix proto.Prefix
+	Metric uint16
+}
+
+func (e *XRouteEvent) EventType() EventType { return e.Type }
+
+// SourceEvent is emitted when an entry of the source table
+// has been garbage-collected.
+type SourceEvent struct {
+	Type     EventType
+	Prefix   proto.Prefix
+	RouterID proto.RouterID
+}
+
+func (e *SourceEvent) EventType() EventType { return e.Type }
+
+// SeqnoRequestEvent is emitted when a seqno request has been originated,
+// forwarded on behalf of a neighbour or satisfied by an update.
+type SeqnoRequestEvent struct {
+	Type     EventType
+	Prefix   proto.Prefix
+	RouterID proto.RouterID
+	Seqno    proto.SequenceNumber
+	HopCount uint8
+}
+
+func (e *SeqnoRequestEvent) EventType() EventType { return e.Type }
+
+// AuthenticationEvent describes a packet which failed authentication.
+// It is reserved until MAC authentication is supported.
+//
+// See: RFC 8967 MAC Authentication for the Babel Routing Protocol
+// https://datatracker.ietf.org/doc/html/rfc8967
+type AuthenticationEvent struct {
+	Type      EventType
+	Interface string
+	Address   proto.Address
+	Error     error
+}
+
+func (e *AuthenticationEvent) EventType() EventType { return e.Type }
+
+// EventFilter decides whether an event is passed to a subscriber.
+type EventFilter func(Event) bool
+
+// EventTypes returns a filter which only passes events of the provided types.
+func EventTypes(types ...EventType) EventFilter {
+	return func(e Event) bool {
+		return slices.Contains(types, e.EventType())
+	}
+}
+
+type SubscriptionOptions struct {
+	// Filter selects the events which are passed to the subscriber.
+	// All events are passed if nil.
+	Filter EventFilter
+
+	// BufferSize is the capacity of the event channel.
+	BufferSize int
+
+	// Lossless blocks the speaker until the subscriber has received
+	// the event if the buffer is full. Otherwise, events are dropped
+	// and counted.
+	// Lossless subscribers must not call back into the speaker
+	// while they are not receiving events.
+	Lossless bool
+}
+
+// Subscription is a stream of events emitted by the speaker.
+type Subscription struct {
+	C <-chan Event
+
+	ch       chan Event
+	done     chan struct{}
+	once     sync.Once
+	dropped  atomic.Uint64
+	filter   EventFilter
+	lossless bool
+
+	bus *eventBus
+}
+
+// Dropped returns the number of events which have been dropped
+// as the buffer of the subscription was full.
+func (sub *Subscription) Dropped() uint64 {
+	return sub.dropped.Load()
+}
+
+// Close ends the subscription and closes its channel.
+func (sub *Subscription) Close() {
+	sub.once.Do(func() {
+		// Unblock a lossless emitter before removing the subscription
+		close(sub.done)
+
+		sub.bus.remove(sub)
+
+		close(sub.ch)
+	})
+}
+
+func (sub *Subscription) deliver(e Event) {
+	if sub.filter != nil && !sub.filter(e) {
+		return
+	}
+
+	if sub.lossless {
+		select {
+		case sub.ch <- e:
+		case <-sub.done:
+		}
+	} else {
+		select {
+		case sub.ch <- e:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// eventBus distributes events to subscriptions.
+// Its zero value is ready to use.
+type eventBus struct {
+	subs map[*Subscription]any
+	mu   sync.RWMutex
+}
+
+func (b *eventBus) subscribe(opts SubscriptionOptions) *Subscription {
+	ch := make(chan Event, max(opts.BufferSize, 0))
+
+	sub := &Subscription{
+		C:        ch,
+		ch:       ch,
+		done:     make(chan struct{}),
+		filter:   opts.Filter,
+		lossless: opts.Lossless,
+		bus:      b,
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.subs == nil {
+		b.subs = map[*Subscription]any{}
+	}
+
+	b.subs[sub] = nil
+
+	return sub
+}
+
+func (b *eventBus) remove(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.subs, sub)
+}
+
+func (b *eventBus) emit(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subs {
+		sub.deliver(e)
+	}
+}
+
+func (b *eventBus) close() {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for sub := range b.subs {
+		subs = append(subs, sub)
+	}
+	b.mu.RUnlock()
+
+	for _, sub := range subs {
+		sub.Close()
+	}
+}
+
+// Subscribe returns a new subscription to the events of the speaker.
+func (s *Speaker) Subscribe(opts SubscriptionOptions) *Subscription {
+	return s.events.subscribe(opts)
+}
+
+func (s *Speaker) emitRouteEvent(typ EventType, r *Route) {
+	e := &RouteEvent{
+		Type:     typ,
+		Prefix:   r.Source.Prefix,
+		RouterID: r.Source.RouterID,
+		NextHop:  r.NextHop,
+		Seqno:    r.SeqNo,
+		Metric:   r.Metric,
+	}
+
+	if n := r.Neighbour; n != nil {
+		e.Interface = n.intf.name()
+		e.Neighbour = n.Address
+	}
+
+	s.events.emit(e)
+}
+
+func (s *Speaker) emitXRouteEvent(typ EventType, x *XRoute) {
+	s.events.emit(&XRouteEvent{
+		Type:   typ,
+		Prefix: x.Prefix,
+		Metric: x.Metric,
+	})
+}
+
+func (s *Speaker) emitSeqnoRequestEvent(typ EventType, req *PendingSeqNoRequest) {
+	s.events.emit(&SeqnoRequestEvent{
+		Type:     typ,
+		Prefix:   req.Prefix,
+		RouterID: req.RouterID,
+		Seqno:    req.Seqno,
+		HopCount: req.HopCount,
+	})
+}
+
+// neighbourCostChanged emits an event if the cost of the link
+// to a neighbour has changed since the last call.
+func (s *Speaker) neighbourCostChanged(n *Neighbour, cost uint16) {
+	oldCost := uint16(0xFFFF)
+	if n.costKnown {
+		oldCost = n.lastCost
+	}
+
+	n.lastCost = cost
+	n.costKnown = true
+
+	if cost == oldCost {
+		return
+	}
+
+	typ := EventNeighbourCostChanged
+	if cost == 0xFFFF {
+		typ = EventNeighbourDown
+	} else if oldCost == 0xFFFF {
+		typ = EventNeighbourUp
+	}
+
+	s.events.emit(&NeighbourEvent{
+		Type:      typ,
+		Interface: n.intf.name(),
+		Address:   n.Address,
+		RxCost:    n.RxCost(),
+		TxCost:    n.TxCost,
+		Cost:      cost,
+		OldCost:   oldCost,
+	})
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"time"
+
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Events", func() {
+	var sim *simulation
+	var node *simNode
+
+	// received returns the types of all buffered events.
+	received := func(sub *Subscription) []EventType {
+		types := []EventType{}
+
+		for {
+			select {
+			case e := <-sub.C:
+				types = append(types, e.EventType())
+			default:
+				return types
+			}
+		}
+	}
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+	})
+
+	It("emits route events", func() {
+		sub := node.Subscribe(SubscriptionOptions{BufferSize: 16})
+		defer sub.Close()
+
+		sim.update(0, 1, 100)
+		sim.update(0, 2, 100)
+		sim.update(0, 2, proto.Retraction)
+
+		Expect(received(sub)).To(Equal([]EventType{
+			EventRouteAdded,
+			EventRouteSelected,
+			EventRouteChanged,
+			EventRouteRetracted,
+			EventRouteUnselected,
+		}))
+	})
+
+	It("passes a copy of the route", func() {
+		sub := node.Subscribe(SubscriptionOptions{
+			Filter:     EventTypes(EventRouteAdded),
+			BufferSize: 1,
+		})
+		defer sub.Close()
+
+		sim.update(0, 1, 100)
+
+		var e Event
+		Expect(sub.C).To(Receive(&e))
+
+		re := e.(*RouteEvent)
+		Expect(re.Prefix).To(Equal(sim.prefix))
+		Expect(re.RouterID).To(Equal(sim.originID))
+		Expect(re.Neighbour).To(Equal(node.neighbours[0].Address))
+		Expect(re.Seqno).To(BeNumerically("==", 1))
+		Expect(re.Metric).To(BeNumerically("==", 110))
+	})
+
+	It("emits neighbour events", func() {
+		sub := node.Subscribe(SubscriptionOptions{BufferSize: 16})
+		defer sub.Close()
+
+		n := node.neighbours[0]
+
+		node.updateNeighbourRoutes(n)
+		n.TxCost = 20
+		node.updateNeighbourRoutes(n)
+		node.updateNeighbourRoutes(n)
+		n.TxCost = 0xFFFF
+		node.updateNeighbourRoutes(n)
+
+		Expect(received(sub)).To(Equal([]EventType{
+			EventNeighbourUp,
+			EventNeighbourCostChanged,
+			EventNeighbourDown,
+		}))
+	})
+
+	It("emits seqno request events", func() {
+		sub := node.Subscribe(SubscriptionOptions{
+			Filter:     EventTypes(EventSeqnoRequestSent, EventSeqnoRequestSatisfied),
+			BufferSize: 16,
+		})
+		defer sub.Close()
+
+		sim.update(0, 1, 100)
+		node.sendUpdates()
+
+		// The route becomes unfeasible
+		sim.update(0, 1, 200)
+
+		sim.update(0, 2, 100)
+
+		Expect(received(sub)).To(Equal([]EventType{
+			EventSeqnoRequestSent,
+			EventSeqnoRequestSatisfied,
+		}))
+	})
+
+	It("drops and counts events if the buffer is full", func() {
+		sub := node.Subscribe(SubscriptionOptions{BufferSize: 1})
+		defer sub.Close()
+
+		sim.update(0, 1, 100)
+
+		Expect(received(sub)).To(HaveLen(1))
+		Expect(sub.Dropped()).To(BeNumerically("==", 1))
+	})
+
+	It("blocks lossless subscriptions until events are received", func() {
+		sub := node.Subscribe(SubscriptionOptions{Lossless: true})
+		defer sub.Close()
+
+		done := make(chan struct{})
+		go func() {
+			sim.update(0, 1, 100)
+			close(done)
+		}()
+
+		Consistently(done, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		Eventually(sub.C).Should(Receive())
+		Eventually(sub.C).Should(Receive())
+		Eventually(done).Should(BeClosed())
+		Expect(sub.Dropped()).To(BeZero())
+	})
+
+	It("unblocks the speaker when closing a lossless subscription", func() {
+		sub := node.Subscribe(SubscriptionOptions{Lossless: true})
+
+		done := make(chan struct{})
+		go func() {
+			sim.update(0, 1, 100)
+			close(done)
+		}()
+
+		Consistently(done, 50*time.Millisecond).ShouldNot(BeClosed())
+
+		sub.Close()
+
+		Eventually(done).Should(BeClosed())
+		Eventually(sub.C).Should(BeClosed())
+	})
+
+	It("closes all subscriptions", func() {
+		sub1 := node.Subscribe(SubscriptionOptions{})
+		sub2 := node.Subscribe(SubscriptionOptions{})
+
+		node.events.close()
+
+		Expect(sub1.C).To(BeClosed())
+		Expect(sub2.C).To(BeClosed())
+
+		// Closing twice is safe
+		sub1.Close()
+	})
+})
@@ -5,14 +5,34 @@ package babel
 
 import "cunicu.li/go-babel/proto"
 
+// FeasibilityDistance is the distance of the best route
+// which has been advertised for a source.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.1
 type FeasibilityDistance struct {
 	SeqNo  proto.SequenceNumber
-	Metric uint
+	Metric proto.Metric
 }
 
-// Less checks if the the feasibility is better than the provided one
+// IsBetter checks if the the feasibility is better than the provided one
 //
 // https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.1
 func (d FeasibilityDistance) IsBetter(o FeasibilityDistance) bool {
 	return proto.SeqnoLess(o.SeqNo, d.SeqNo) || (d.SeqNo == o.SeqNo && d.Metric < o.Metric)
 }
+
+// IsFeasible checks if an update with the provided sequence number and metric
+// satisfies the feasibility condition with respect to this distance.
+// Retractions are always feasible.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.1
+func (d FeasibilityDistance) IsFeasible(seqno proto.SequenceNumber, metric proto.Metric) bool {
+	if metric == proto.Retraction {
+		return true
+	}
+
+	return FeasibilityDistance{
+		SeqNo:  seqno,
+		Metric: metric,
+	}.IsBetter(d)
+}
@@ -0,0 +1,569 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/netip"
+	"time"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// simNode is a speaker which is not attached to any network
+// and whose updates are delivered by the simulation.
+type simNode struct {
+	*Speaker
+
+	intf       *Interface
+	neighbours map[int]*Neighbour
+}
+
+type simMessage struct {
+	from, to int
+	update   proto.Update
+}
+
+type simulation struct {
+	rnd      *rand.Rand
+	nodes    []*simNode
+	pending  []simMessage
+	prefix   proto.Prefix
+	originID proto.RouterID
+	seqno    proto.SequenceNumber
+}
+
+func newSimNode(idx int) *simNode {
+	params := DefaultParameters
+
+	s := &Speaker{
+		Interfaces: NewInterfaceTable(),
+		Sources:    NewSourceTable(),
+		Routes:     NewRouteTable(),
+		XRoutes:    NewXRouteTable(),
+
+		SeqnoRequests: NewPendingSeqNoRequestTable(),
+	}
+
+	s.cfg.Store(&SpeakerConfig{
+		Parameters: &params,
+		RouterID:   proto.RouterID{0, 0, 0, 0, 0, 0, 0, byte(idx + 1)},
+		Logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+
+	s.logger = s.config().Logger
+
+	return &simNode{
+		Speaker: s,
+		intf: &Interface{
+			Neighbours: NewNeighbourTable(),
+			speaker:    s,
+			logger:     s.logger,
+		},
+		neighbours: map[int]*Neighbour{},
+	}
+}
+
+// sendUpdates returns the Updates for all selected routes as they are
+// advertised by the node. Like sending them, it records the
+// feasibility distances.
+func (node *simNode) sendUpdates() []proto.Value {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+
+	return node.intf.filterOutput(node.selectedUpdates(nil, nil), nil)
+}
+
+// newSimulation creates a random connected topology. Node 0 is the
+// origin of the simulated prefix.
+func newSimulation(seed int64, numNodes int) *simulation {
+	sim := &simulation{
+		rnd:      rand.New(rand.NewSource(seed)), //nolint:gosec
+		prefix:   netip.MustParsePrefix("2001:db8::/64"),
+		originID: proto.RouterID{0xff, 0, 0, 0, 0, 0, 0, 1},
+	}
+
+	for i := 0; i < numNodes; i++ {
+		sim.nodes = append(sim.nodes, newSimNode(i))
+	}
+
+	for i := 1; i < numNodes; i++ {
+		// Connect each node to at least one previous node
+		sim.link(i, sim.rnd.Intn(i))
+
+		for j := 0; j < i; j++ {
+			if sim.rnd.Float64() < 0.3 {
+				sim.link(i, j)
+			}
+		}
+	}
+
+	return sim
+}
+
+func (sim *simulation) link(a, b int) {
+	for _, l := range [][2]int{{a, b}, {b, a}} {
+		x, y := sim.nodes[l[0]], l[1]
+		if _, ok := x.neighbours[y]; ok {
+			continue
+		}
+
+		n := &Neighbour{
+			Address: netip.AddrFrom16([16]byte{0xfe, 0x80, 15: byte(y + 1)}),
+			TxCost:  sim.randomCost(),
+			intf:    x.intf,
+			logger:  x.logger,
+		}
+
+		// Pretend that we have received Hellos to get a finite rxcost
+		n.helloMulticast.Update(1)
+		n.helloMulticast.Update(2)
+
+		x.neighbours[y] = n
+	}
+}
+
+// testNode is the index of the node whose behaviour is
+// examined by specs using newTestSimulation.
+const testNode = 1
+
+// newTestSimulation creates a simulation in which the test node
+// is linked to the nodes 0 and 2 with a cost of 10 and 20.
+func newTestSimulation() (*simulation, *simNode) {
+	sim := newSimulation(1, 3)
+	sim.link(testNode, 2)
+
+	node := sim.nodes[testNode]
+	node.neighbours[0].TxCost = 10
+	node.neighbours[2].TxCost = 20
+
+	return sim, node
+}
+
+// attachNeighbours inserts the neighbours of the test node into the
+// neighbour tables of their interfaces and returns writers which
+// collect the values sent to each of them. Neighbours which are
+// still attached are closed after the spec.
+func (sim *simulation) attachNeighbours() map[int]*valueWriter {
+	node := sim.nodes[testNode]
+	ws := map[int]*valueWriter{}
+
+	for j, n := range node.neighbours {
+		ws[j] = &valueWriter{}
+
+		n.queue = queue.NewQueue(1400, node.config().UrgentTimeout, ws[j])
+		n.PendingAcknowledgments = NewPendingAcknowledgmentTable()
+		n.ihuTicker = time.NewTicker(time.Hour)
+		n.helloTicker = time.NewTicker(time.Hour)
+		n.closed = make(chan struct{})
+
+		n.intf.Neighbours.Insert(n)
+	}
+
+	DeferCleanup(func() {
+		for _, n := range node.neighbours {
+			if _, ok := n.intf.Neighbours.Lookup(n.Address); ok {
+				Expect(n.Close()).To(Succeed())
+			}
+		}
+	})
+
+	return ws
+}
+
+// update delivers an Update for the simulated prefix
+// from the node from to the test node.
+func (sim *simulation) update(from int, seqno proto.SequenceNumber, metric proto.Metric, channels ...uint8) {
+	node := sim.nodes[testNode]
+
+	node.onUpdate(node.neighbours[from], &proto.Update{
+		Interval: time.Second,
+		Seqno:    seqno,
+		Metric:   metric,
+		Prefix:   sim.prefix,
+		RouterID: sim.originID,
+		Channels: channels,
+	})
+}
+
+// route returns the route of the test node for the
+// simulated prefix via the node from.
+func (sim *simulation) route(from int) *Route {
+	node := sim.nodes[testNode]
+
+	r, ok := node.Routes.Lookup(sim.prefix, node.neighbours[from])
+	Expect(ok).To(BeTrue())
+
+	return r
+}
+
+func (sim *simulation) randomCost() uint16 {
+	return uint16(1 + sim.rnd.Intn(512))
+}
+
+func (sim *simulation) flood(from int, upd proto.Update) {
+	for to := range sim.nodes[from].neighbours {
+		sim.pending = append(sim.pending, simMessage{
+			from:   from,
+			to:     to,
+			update: upd,
+		})
+	}
+}
+
+func (sim *simulation) step() {
+	switch r := sim.rnd.Float64(); {
+	case r < 0.5 && len(sim.pending) > 0:
+		// Deliver a random message to simulate reordering
+		idx := sim.rnd.Intn(len(sim.pending))
+		msg := sim.pending[idx]
+		sim.pending = append(sim.pending[:idx], sim.pending[idx+1:]...)
+
+		// Messages can get lost
+		if sim.rnd.Float64() < 0.1 || msg.to == 0 {
+			return
+		}
+
+		to := sim.nodes[msg.to]
+		to.onUpdate(to.neighbours[msg.from], &msg.update)
+
+	case r < 0.7:
+		// Periodic update of a random node
+		from := sim.rnd.Intn(len(sim.nodes))
+		if from == 0 {
+			sim.flood(0, proto.Update{
+				Interval: DefaultUpdateInterval,
+				Seqno:    sim.seqno,
+				Metric:   0,
+				Prefix:   sim.prefix,
+				RouterID: sim.originID,
+			})
+			return
+		}
+
+		upds := sim.nodes[from].sendUpdates()
+		if len(upds) == 0 {
+			upds = append(upds, &proto.Update{
+				Interval: DefaultUpdateInterval,
+				Metric:   proto.Retraction,
+				Prefix:   sim.prefix,
+			})
+		}
+
+		for _, upd := range upds {
+			sim.flood(from, *upd.(*proto.Update))
+		}
+
+	case r < 0.9:
+		// Change the cost of a random link
+		node := sim.nodes[1+sim.rnd.Intn(len(sim.nodes)-1)]
+		for _, n := range node.neighbours {
+			if sim.rnd.Float64() < 0.1 {
+				n.TxCost = 0xFFFF
+			} else {
+				n.TxCost = sim.randomCost()
+			}
+
+			node.updateNeighbourRoutes(n)
+			break
+		}
+
+	default:
+		// The origin increases its sequence number
+		sim.seqno++
+	}
+}
+
+// selectedNextHop returns the index of the node which has been selected
+// as next hop for the simulated prefix by the provided node.
+func (sim *simulation) selectedNextHop(idx int) (int, bool) {
+	node := sim.nodes[idx]
+
+	for j, n := range node.neighbours {
+		if r, ok := node.Routes.Lookup(sim.prefix, n); ok && r.Selected {
+			return j, true
+		}
+	}
+
+	return -1, false
+}
+
+// findLoop checks if the selected routes of all nodes form a routing loop.
+func (sim *simulation) findLoop() []int {
+	for start := 1; start < len(sim.nodes); start++ {
+		path := []int{start}
+		visited := map[int]bool{start: true}
+
+		for cur := start; ; {
+			next, ok := sim.selectedNextHop(cur)
+			if !ok || next == 0 {
+				break
+			}
+
+			path = append(path, next)
+			if visited[next] {
+				return path
+			}
+
+			visited[next] = true
+			cur = next
+		}
+	}
+
+	return nil
+}
+
+func (sim *simulation) numSelected() int {
+	num := 0
+	for i := 1; i < len(sim.nodes); i++ {
+		if _, ok := sim.selectedNextHop(i); ok {
+			num++
+		}
+	}
+
+	return num
+}
+
+var _ = Context("Feasibility", func() {
+	DescribeTable("IsBetter",
+		func(a, b FeasibilityDistance, better bool) {
+			Expect(a.IsBetter(b)).To(Equal(better))
+		},
+		Entry("newer seqno", FeasibilityDistance{2, 100}, FeasibilityDistance{1, 10}, true),
+		Entry("older seqno", FeasibilityDistance{1, 10}, FeasibilityDistance{2, 100}, false),
+		Entry("same seqno, smaller metric", FeasibilityDistance{1, 10}, FeasibilityDistance{1, 100}, true),
+		Entry("same seqno, larger metric", FeasibilityDistance{1, 100}, FeasibilityDistance{1, 10}, false),
+		Entry("equal", FeasibilityDistance{1, 10}, FeasibilityDistance{1, 10}, false),
+		Entry("seqno wrap-around", FeasibilityDistance{0, 100}, FeasibilityDistance{0xffff, 10}, true),
+	)
+
+	DescribeTable("IsFeasible",
+		func(seqno, metric int, feasible bool) {
+			d := FeasibilityDistance{SeqNo: 10, Metric: 100}
+			Expect(d.IsFeasible(uint16(seqno), uint16(metric))).To(Equal(feasible))
+		},
+		Entry("retraction", 10, 0xffff, true),
+		Entry("newer seqno", 11, 1000, true),
+		Entry("older seqno", 9, 10, false),
+		Entry("smaller metric", 10, 99, true),
+		Entry("equal metric", 10, 100, false),
+		Entry("larger metric", 10, 101, false),
+	)
+
+	Describe("Source", func() {
+		var src *Source
+
+		BeforeEach(func() {
+			src = newSource(netip.MustParsePrefix("10.0.0.0/8"), proto.RouterID{1})
+		})
+
+		It("has no distance before advertisement", func() {
+			Expect(src.HasDistance()).To(BeFalse())
+			Expect(src.IsFeasible(0, 1000)).To(BeTrue())
+		})
+
+		It("takes the first advertised distance", func() {
+			src.advertise(10, 100, time.Minute)
+			Expect(src.HasDistance()).To(BeTrue())
+			Expect(src.Distance).To(Equal(FeasibilityDistance{10, 100}))
+		})
+
+		It("only improves the distance", func() {
+			src.advertise(10, 100, time.Minute)
+			src.advertise(10, 200, time.Minute)
+			Expect(src.Distance).To(Equal(FeasibilityDistance{10, 100}))
+
+			src.advertise(10, 50, time.Minute)
+			Expect(src.Distance).To(Equal(FeasibilityDistance{10, 50}))
+
+			src.advertise(11, 500, time.Minute)
+			Expect(src.Distance).To(Equal(FeasibilityDistance{11, 500}))
+		})
+
+		It("ignores retractions", func() {
+			src.advertise(10, 100, time.Minute)
+			src.advertise(11, proto.Retraction, time.Minute)
+			Expect(src.Distance).To(Equal(FeasibilityDistance{10, 100}))
+		})
+
+		It("forgets the distance after garbage-collection", func() {
+			src.advertise(10, 100, -time.Second)
+			Expect(src.HasDistance()).To(BeFalse())
+			Expect(src.IsFeasible(10, 200)).To(BeTrue())
+		})
+	})
+
+	Describe("Update processing", func() {
+		var sim *simulation
+
+		BeforeEach(func() {
+			sim = newSimulation(1, 3)
+			sim.link(1, 2)
+		})
+
+		It("ignores unfeasible updates for new routes", func() {
+			node := sim.nodes[1]
+
+			src := newSource(sim.prefix, sim.originID)
+			src.advertise(5, 100, time.Minute)
+			node.Sources.Insert(src)
+
+			node.onUpdate(node.neighbours[2], &proto.Update{
+				Seqno:    5,
+				Metric:   100,
+				Prefix:   sim.prefix,
+				RouterID: sim.originID,
+			})
+
+			_, ok := node.Routes.Lookup(sim.prefix, node.neighbours[2])
+			Expect(ok).To(BeFalse())
+		})
+
+		It("ignores source-specific updates", func() {
+			node := sim.nodes[1]
+
+			srcPfx := netip.MustParsePrefix("2001:db8:ff::/48")
+
+			node.onUpdate(node.neighbours[2], &proto.Update{
+				Seqno:        1,
+				Metric:       100,
+				Prefix:       sim.prefix,
+				SourcePrefix: &srcPfx,
+				RouterID:     sim.originID,
+			})
+
+			Expect(node.Routes.Len()).To(BeZero())
+			Expect(node.Sources.Len()).To(BeZero())
+		})
+
+		It("selects the route with the smallest metric and updates the feasibility distance", func() {
+			node := sim.nodes[1]
+			node.neighbours[0].TxCost = 100
+			node.neighbours[2].TxCost = 10
+
+			for _, j := range []int{0, 2} {
+				node.onUpdate(node.neighbours[j], &proto.Update{
+					Seqno:    1,
+					Metric:   10,
+					Prefix:   sim.prefix,
+					RouterID: sim.originID,
+				})
+			}
+
+			next, ok := sim.selectedNextHop(1)
+			Expect(ok).To(BeTrue())
+			Expect(next).To(Equal(2))
+
+			Expect(node.sendUpdates()).To(HaveLen(1))
+
+			src, ok := node.Sources.Lookup(sim.prefix, sim.originID)
+			Expect(ok).To(BeTrue())
+			Expect(src.Distance).To(Equal(FeasibilityDistance{1, 20}))
+		})
+
+		It("unselects routes after a retraction", func() {
+			node := sim.nodes[1]
+
+			upd := &proto.Update{
+				Seqno:    1,
+				Metric:   10,
+				Prefix:   sim.prefix,
+				RouterID: sim.originID,
+			}
+
+			node.onUpdate(node.neighbours[0], upd)
+			_, ok := sim.selectedNextHop(1)
+			Expect(ok).To(BeTrue())
+
+			upd.Metric = proto.Retraction
+			node.onUpdate(node.neighbours[0], upd)
+			_, ok = sim.selectedNextHop(1)
+			Expect(ok).To(BeFalse())
+		})
+
+		It("recomputes routes after the link to the neighbour recovers", func() {
+			node := sim.nodes[1]
+			n := node.neighbours[0]
+			n.TxCost = 10
+
+			node.onUpdate(n, &proto.Update{
+				Seqno:    1,
+				Metric:   10,
+				Prefix:   sim.prefix,
+				RouterID: sim.originID,
+			})
+
+			r, ok := node.Routes.Lookup(sim.prefix, n)
+			Expect(ok).To(BeTrue())
+			Expect(r.Selected).To(BeTrue())
+
+			metric := r.Metric
+
+			n.TxCost = 0xFFFF
+			node.updateNeighbourRoutes(n)
+			Expect(r.IsRetracted()).To(BeTrue())
+			Expect(r.Selected).To(BeFalse())
+
+			n.TxCost = 10
+			node.updateNeighbourRoutes(n)
+			Expect(r.Metric).To(Equal(metric))
+			Expect(r.Selected).To(BeTrue())
+		})
+
+		It("keeps the source of routes on retractions", func() {
+			node := sim.nodes[1]
+			n := node.neighbours[0]
+
+			node.onUpdate(n, &proto.Update{
+				Seqno:    1,
+				Metric:   10,
+				Prefix:   sim.prefix,
+				RouterID: sim.originID,
+			})
+
+			r, ok := node.Routes.Lookup(sim.prefix, n)
+			Expect(ok).To(BeTrue())
+
+			src := r.Source
+
+			// Retractions may carry an arbitrary router ID
+			rid := proto.RouterID{0xee, 0, 0, 0, 0, 0, 0, 1}
+			node.onUpdate(n, &proto.Update{
+				Seqno:    1,
+				Metric:   proto.Retraction,
+				Prefix:   sim.prefix,
+				RouterID: rid,
+			})
+
+			Expect(r.Source).To(BeIdenticalTo(src))
+
+			_, ok = node.Sources.Lookup(sim.prefix, rid)
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	It("never forms routing loops under random update orderings", func() {
+		for seed := int64(0); seed < 50; seed++ {
+			sim := newSimulation(seed, 8)
+			selected := 0
+
+			for i := 0; i < 5000; i++ {
+				sim.step()
+
+				loop := sim.findLoop()
+				Expect(loop).To(BeNil(), fmt.Sprintf("Routing loop after %d steps with seed %d: %v", i, seed, loop))
+
+				selected += sim.numSelected()
+			}
+
+			Expect(selected).To(BeNumerically(">", 0), "No routes have been selected with seed %d", seed)
+		}
+	})
+})
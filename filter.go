@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/proto"
+)
+
+// inputMetric applies the input filter to the metric with which
+// a route has been advertised by a neighbour.
+// It returns an infinite metric if the route is rejected.
+func (s *Speaker) inputMetric(n *Neighbour, pfx proto.Prefix, rid proto.RouterID, metric proto.Metric) proto.Metric {
+	f := s.config().InputFilter
+	if len(f) == 0 || metric == proto.Retraction {
+		return metric
+	}
+
+	metric, _ = f.Apply(&filter.Route{
+		Prefix:    pfx,
+		RouterID:  rid,
+		Neighbour: n.Address,
+		Interface: n.intf.name(),
+		Metric:    metric,
+	})
+
+	return metric
+}
+
+// routeMetric computes the metric of a route from the filtered
+// metric advertised by the neighbour and the cost of the link.
+// The cost is reduced for channel-diverse paths.
+//
+// See: 3.5.2. Metric Computation
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.2
+func (s *Speaker) routeMetric(r *Route, cost uint16) proto.Metric {
+	return addMetric(s.inputMetric(r.Neighbour, r.Source.Prefix, r.Source.RouterID, r.RefMetric), s.diversityCost(r, cost))
+}
+
+// filterOutput applies the output filter to the Updates sent on the
+// interface either by multicast or to a single neighbour.
+// Afterwards, the feasibility distances are updated from the metrics
+// which are actually advertised. Hence, it must be called with the
+// speaker lock held if vs contains Updates.
+func (i *Interface) filterOutput(vs []proto.Value, n *Neighbour) []proto.Value {
+	vs = i.applyOutputFilter(vs, n)
+
+	i.speaker.updateDistances(vs)
+
+	return vs
+}
+
+// advertisement identifies an advertised route by its prefixes.
+type advertisement struct {
+	Prefix       proto.Prefix
+	SourcePrefix proto.Prefix
+}
+
+// applyOutputFilter applies the output filter to the Updates.
+// Updates rejected by the filter are dropped unless the route has
+// been advertised before. In this case, it is retracted instead.
+// Retractions are always sent.
+func (i *Interface) applyOutputFilter(vs []proto.Value, n *Neighbour) []proto.Value {
+	f := i.speaker.config().OutputFilter
+
+	// Values other than Updates may be sent without the speaker lock.
+	// Hence, the advertisements are only accessed for Updates.
+	var adv map[advertisement]bool
+
+	rt := filter.Route{
+		Interface: i.name(),
+	}
+
+	if n != nil {
+		rt.Neighbour = n.Address
+	}
+
+	out := make([]proto.Value, 0, len(vs))
+
+	for _, v := range vs {
+		upd, ok := v.(*proto.Update)
+		if !ok {
+			out = append(out, v)
+			continue
+		}
+
+		if adv == nil {
+			adv = i.advertisements(n)
+		}
+
+		key := advertisement{
+			Prefix:       upd.Prefix,
+			SourcePrefix: sourcePrefix(upd),
+		}
+
+		if len(f) > 0 && upd.Metric != proto.Retraction {
+			rt.Prefix = upd.Prefix
+			rt.SourcePrefix = sourcePrefix(upd)
+			rt.RouterID = upd.RouterID
+			rt.Metric = upd.Metric
+
+			metric, ok := f.Apply(&rt)
+			if !ok {
+				if !adv[key] {
+					continue
+				}
+
+				// Otherwise, neighbours would keep the route until it expires
+				metric = proto.Retraction
+			}
+
+			if metric != upd.Metric {
+				u := *upd
+				u.Metric = metric
+				upd = &u
+			}
+		}
+
+		if upd.Metric == proto.Retraction {
+			delete(adv, key)
+		} else {
+			adv[key] = true
+		}
+
+		out = append(out, upd)
+	}
+
+	return out
+}
+
+// advertisements returns the routes which are currently advertised on
+// the interface by multicast or to the neighbour n if it is not nil.
+// It must be called with the speaker lock held.
+func (i *Interface) advertisements(n *Neighbour) map[advertisement]bool {
+	if n != nil {
+		if n.advertised == nil {
+			n.advertised = map[advertisement]bool{}
+		}
+
+		return n.advertised
+	}
+
+	if i.advertised == nil {
+		i.advertised = map[advertisement]bool{}
+	}
+
+	return i.advertised
+}
+
+// sourcePrefix returns the source prefix of a source-specific
+// Update or an invalid prefix otherwise.
+func sourcePrefix(upd *proto.Update) proto.Prefix {
+	if upd.SourcePrefix == nil {
+		return proto.Prefix{}
+	}
+
+	return *upd.SourcePrefix
+}
@@ -0,0 +1,180 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package filter implements rule-based route filters.
+//
+// A filter is an ordered list of rules. The first rule which matches
+// a route decides whether the route is accepted and with which metric.
+// Routes which are not matched by any rule are accepted unchanged.
+package filter
+
+import (
+	"fmt"
+
+	"cunicu.li/go-babel/proto"
+)
+
+// Action is the action of a rule.
+type Action int
+
+const (
+	// ActionAllow accepts a route with an unchanged metric.
+	ActionAllow Action = iota
+
+	// ActionDeny rejects a route.
+	ActionDeny
+
+	// ActionSetMetric replaces the metric of a route by Rule.Metric.
+	ActionSetMetric
+
+	// ActionAddMetric increases the metric of a route by Rule.Metric.
+	ActionAddMetric
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionAllow:
+		return "allow"
+	case ActionDeny:
+		return "deny"
+	case ActionSetMetric:
+		return "set-metric"
+	case ActionAddMetric:
+		return "add-metric"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(a))
+	}
+}
+
+// Range is an inclusive range of integers.
+type Range struct {
+	Min, Max int
+}
+
+// Between returns a range which includes min and max.
+func Between(minValue, maxValue int) *Range {
+	return &Range{minValue, maxValue}
+}
+
+// Exactly returns a range which only includes v.
+func Exactly(v int) *Range {
+	return &Range{v, v}
+}
+
+// contains checks if v is included in the range.
+// A nil range includes all values.
+func (r *Range) contains(v int) bool {
+	return r == nil || (v >= r.Min && v <= r.Max)
+}
+
+// Route holds the attributes of a route which are matched by rules.
+type Route struct {
+	Prefix       proto.Prefix
+	SourcePrefix proto.Prefix
+	RouterID     proto.RouterID
+
+	// Neighbour is the neighbour from which the route has been learned
+	// or to which it is sent. It is invalid for multicast updates.
+	Neighbour proto.Address
+
+	// Interface is the name of the interface on which
+	// the route has been learned or is sent.
+	Interface string
+
+	// Protocol is the routing protocol of redistributed kernel routes.
+	Protocol int
+
+	Metric proto.Metric
+}
+
+// Rule matches routes whose attributes satisfy all selectors.
+// Unset selectors match any route.
+type Rule struct {
+	// Prefix matches routes whose prefix is contained in it.
+	Prefix proto.Prefix
+
+	// Length restricts the length of matched prefixes.
+	Length *Range
+
+	// SourcePrefix and SourceLength match the source prefix of
+	// source-specific routes like Prefix and Length.
+	SourcePrefix proto.Prefix
+	SourceLength *Range
+
+	RouterID  proto.RouterID
+	Neighbour proto.Address
+	Interface string
+
+	// Protocol matches the routing protocol of redistributed kernel routes.
+	// Routes of any protocol are matched if zero.
+	Protocol int
+
+	// Metric restricts the metric of matched routes.
+	Metric *Range
+
+	Action Action
+
+	// Value is the metric which is set or added by the action.
+	Value proto.Metric
+}
+
+// Match checks if the rule matches the route.
+func (r *Rule) Match(rt *Route) bool {
+	return matchPrefix(r.Prefix, r.Length, rt.Prefix) &&
+		matchPrefix(r.SourcePrefix, r.SourceLength, rt.SourcePrefix) &&
+		(r.RouterID == proto.RouterIDUnspecified || r.RouterID == rt.RouterID) &&
+		(!r.Neighbour.IsValid() || r.Neighbour == rt.Neighbour) &&
+		(r.Interface == "" || r.Interface == rt.Interface) &&
+		(r.Protocol == 0 || r.Protocol == rt.Protocol) &&
+		r.Metric.contains(int(rt.Metric))
+}
+
+// apply applies the action of the rule to a metric.
+func (r *Rule) apply(metric proto.Metric) (proto.Metric, bool) {
+	switch r.Action {
+	case ActionDeny:
+		return proto.Retraction, false
+
+	case ActionSetMetric:
+		metric = r.Value
+
+	case ActionAddMetric:
+		if sum := uint32(metric) + uint32(r.Value); sum < uint32(proto.Retraction) {
+			metric = proto.Metric(sum)
+		} else {
+			metric = proto.Retraction
+		}
+	}
+
+	return metric, metric != proto.Retraction
+}
+
+// Filter is an ordered list of rules.
+type Filter []Rule
+
+// Apply returns the metric of a route after applying the first matching rule.
+// It returns false if the route is rejected either explicitly by a deny rule
+// or because its metric has become infinite.
+func (f Filter) Apply(rt *Route) (proto.Metric, bool) {
+	for i := range f {
+		if r := &f[i]; r.Match(rt) {
+			return r.apply(rt.Metric)
+		}
+	}
+
+	return rt.Metric, rt.Metric != proto.Retraction
+}
+
+func matchPrefix(pfx proto.Prefix, length *Range, p proto.Prefix) bool {
+	if pfx.IsValid() {
+		if !p.IsValid() || p.Addr().Is4() != pfx.Addr().Is4() || p.Bits() < pfx.Bits() || !pfx.Contains(p.Addr()) {
+			return false
+		}
+	}
+
+	if length != nil && (!p.IsValid() || !length.contains(p.Bits())) {
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package filter_test
+
+import (
+	"net/netip"
+	"testing"
+
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFilter(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Filter suite")
+}
+
+var (
+	route = filter.Route{
+		Prefix:    netip.MustParsePrefix("10.1.2.0/24"),
+		RouterID:  proto.RouterID{1, 2, 3, 4, 5, 6, 7, 8},
+		Neighbour: netip.MustParseAddr("fe80::1"),
+		Interface: "eth0",
+		Metric:    100,
+	}
+
+	defaultRoute = filter.Route{
+		Prefix: netip.MustParsePrefix("::/0"),
+		Metric: 100,
+	}
+
+	sourceSpecificRoute = filter.Route{
+		Prefix:       netip.MustParsePrefix("::/0"),
+		SourcePrefix: netip.MustParsePrefix("2001:db8:1::/48"),
+		Metric:       100,
+	}
+)
+
+var _ = Describe("Rule", func() {
+	DescribeTable("matches",
+		func(rule filter.Rule, rt filter.Route, expected bool) {
+			Expect(rule.Match(&rt)).To(Equal(expected))
+		},
+		Entry("any route", filter.Rule{}, route, true),
+		Entry("containing prefix", filter.Rule{Prefix: netip.MustParsePrefix("10.0.0.0/8")}, route, true),
+		Entry("equal prefix", filter.Rule{Prefix: netip.MustParsePrefix("10.1.2.0/24")}, route, true),
+		Entry("more specific prefix", filter.Rule{Prefix: netip.MustParsePrefix("10.1.2.0/25")}, route, false),
+		Entry("other prefix", filter.Rule{Prefix: netip.MustParsePrefix("192.168.0.0/16")}, route, false),
+		Entry("other address family", filter.Rule{Prefix: netip.MustParsePrefix("::/0")}, route, false),
+		Entry("length in range", filter.Rule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Length: filter.Between(16, 24)}, route, true),
+		Entry("length out of range", filter.Rule{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Length: filter.Between(8, 16)}, route, false),
+		Entry("default route", filter.Rule{Prefix: netip.MustParsePrefix("::/0"), Length: filter.Exactly(0)}, defaultRoute, true),
+		Entry("default route only", filter.Rule{Prefix: netip.MustParsePrefix("::/0"), Length: filter.Exactly(0)}, filter.Route{Prefix: netip.MustParsePrefix("2001:db8::/32")}, false),
+		Entry("source prefix", filter.Rule{SourcePrefix: netip.MustParsePrefix("2001:db8::/32")}, sourceSpecificRoute, true),
+		Entry("source length", filter.Rule{SourceLength: filter.Between(0, 32)}, sourceSpecificRoute, false),
+		Entry("source prefix of non-source-specific route", filter.Rule{SourcePrefix: netip.MustParsePrefix("::/0")}, defaultRoute, false),
+		Entry("router ID", filter.Rule{RouterID: route.RouterID}, route, true),
+		Entry("other router ID", filter.Rule{RouterID: proto.RouterID{1}}, route, false),
+		Entry("neighbour", filter.Rule{Neighbour: netip.MustParseAddr("fe80::1")}, route, true),
+		Entry("other neighbour", filter.Rule{Neighbour: netip.MustParseAddr("fe80::2")}, route, false),
+		Entry("interface", filter.Rule{Interface: "eth0"}, route, true),
+		Entry("other interface", filter.Rule{Interface: "eth1"}, route, false),
+		Entry("protocol", filter.Rule{Protocol: 4}, filter.Route{Protocol: 4}, true),
+		Entry("other protocol", filter.Rule{Protocol: 4}, filter.Route{Protocol: 16}, false),
+		Entry("metric in range", filter.Rule{Metric: filter.Between(0, 100)}, route, true),
+		Entry("metric out of range", filter.Rule{Metric: filter.Between(101, 200)}, route, false),
+		Entry("all selectors", filter.Rule{
+			Prefix:    netip.MustParsePrefix("10.0.0.0/8"),
+			Length:    filter.Exactly(24),
+			RouterID:  route.RouterID,
+			Neighbour: route.Neighbour,
+			Interface: "eth0",
+			Metric:    filter.Exactly(100),
+		}, route, true),
+	)
+})
+
+var _ = Describe("Filter", func() {
+	DescribeTable("applies",
+		func(f filter.Filter, rt filter.Route, expectedMetric proto.Metric, expectedOK bool) {
+			metric, ok := f.Apply(&rt)
+			Expect(ok).To(Equal(expectedOK))
+			Expect(metric).To(Equal(expectedMetric))
+		},
+		Entry("empty filter", filter.Filter{}, route, proto.Metric(100), true),
+		Entry("allow", filter.Filter{{Action: filter.ActionAllow}}, route, proto.Metric(100), true),
+		Entry("deny", filter.Filter{{Action: filter.ActionDeny}}, route, proto.Retraction, false),
+		Entry("set metric", filter.Filter{{Action: filter.ActionSetMetric, Value: 42}}, route, proto.Metric(42), true),
+		Entry("add metric", filter.Filter{{Action: filter.ActionAddMetric, Value: 42}}, route, proto.Metric(142), true),
+		Entry("add metric to infinity", filter.Filter{{Action: filter.ActionAddMetric, Value: 0xFFF0}}, route, proto.Retraction, false),
+		Entry("set infinite metric", filter.Filter{{Action: filter.ActionSetMetric, Value: proto.Retraction}}, route, proto.Retraction, false),
+		Entry("retraction", filter.Filter{}, filter.Route{Metric: proto.Retraction}, proto.Retraction, false),
+		Entry("first matching rule", filter.Filter{
+			{Interface: "eth1", Action: filter.ActionDeny},
+			{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: filter.ActionAddMetric, Value: 10},
+			{Action: filter.ActionDeny},
+		}, route, proto.Metric(110), true),
+		Entry("fall through to deny", filter.Filter{
+			{Prefix: netip.MustParsePrefix("192.168.0.0/16"), Action: filter.ActionAllow},
+			{Action: filter.ActionDeny},
+		}, route, proto.Retraction, false),
+	)
+})
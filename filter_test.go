@@ -0,0 +1,204 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"net/netip"
+	"time"
+
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	var sim *simulation
+	var node *simNode
+
+	setFilters := func(in, out filter.Filter) {
+		cfg := *node.config()
+		cfg.InputFilter = in
+		cfg.OutputFilter = out
+		node.cfg.Store(&cfg)
+	}
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+	})
+
+	Context("input", func() {
+		It("ignores denied updates", func() {
+			setFilters(filter.Filter{
+				{Neighbour: node.neighbours[0].Address, Action: filter.ActionDeny},
+			}, nil)
+
+			sim.update(0, 1, 100)
+			sim.update(2, 1, 100)
+
+			_, ok := node.Routes.Lookup(sim.prefix, node.neighbours[0])
+			Expect(ok).To(BeFalse())
+
+			r, ok := node.Routes.Lookup(sim.prefix, node.neighbours[2])
+			Expect(ok).To(BeTrue())
+			Expect(r.Selected).To(BeTrue())
+		})
+
+		It("adds to the metric of matching routes", func() {
+			setFilters(filter.Filter{
+				{Prefix: netip.MustParsePrefix("2001:db8::/32"), Action: filter.ActionAddMetric, Value: 50},
+			}, nil)
+
+			sim.update(0, 1, 100)
+
+			r, ok := node.Routes.Lookup(sim.prefix, node.neighbours[0])
+			Expect(ok).To(BeTrue())
+			Expect(r.RefMetric).To(BeNumerically("==", 100))
+			Expect(r.Metric).To(BeNumerically("==", 160))
+		})
+
+		It("re-evaluates routes after the filter has changed", func() {
+			sim.update(0, 1, 100)
+			sim.update(2, 1, 100)
+
+			r0, _ := node.Routes.Lookup(sim.prefix, node.neighbours[0])
+			r2, _ := node.Routes.Lookup(sim.prefix, node.neighbours[2])
+			Expect(r0.Selected).To(BeTrue())
+
+			setFilters(filter.Filter{
+				{RouterID: sim.originID, Neighbour: node.neighbours[0].Address, Action: filter.ActionDeny},
+			}, nil)
+
+			node.updateNeighbourRoutes(node.neighbours[0])
+
+			Expect(r0.IsRetracted()).To(BeTrue())
+			Expect(r0.RefMetric).To(BeNumerically("==", 100))
+			Expect(r2.Selected).To(BeTrue())
+
+			// Routes rejected before are accepted again once the filter is removed
+			setFilters(nil, nil)
+
+			node.updateNeighbourRoutes(node.neighbours[0])
+
+			Expect(r0.IsRetracted()).To(BeFalse())
+			Expect(r0.Metric).To(BeNumerically("==", 110))
+		})
+	})
+
+	Context("output", func() {
+		var n *Neighbour
+		var w *valueWriter
+
+		upd := func(pfx string, metric proto.Metric) *proto.Update {
+			return &proto.Update{
+				Interval: time.Second,
+				Metric:   metric,
+				Prefix:   netip.MustParsePrefix(pfx),
+				RouterID: sim.originID,
+			}
+		}
+
+		BeforeEach(func() {
+			w = sim.attachNeighbours()[0]
+			n = node.neighbours[0]
+		})
+
+		It("drops denied updates and changes metrics", func() {
+			setFilters(nil, filter.Filter{
+				{Prefix: netip.MustParsePrefix("2001:db8:1::/48"), Action: filter.ActionDeny},
+				{Neighbour: n.Address, Action: filter.ActionSetMetric, Value: 42},
+			})
+
+			n.sendValues([]proto.Value{
+				upd("2001:db8:1::/64", 100),
+				upd("2001:db8:2::/64", 100),
+				upd("2001:db8:1::/64", proto.Retraction),
+			}, queue.PriorityNormal, time.Millisecond)
+
+			metrics := func() map[string]proto.Metric {
+				m := map[string]proto.Metric{}
+				for _, upd := range w.Updates() {
+					m[upd.Prefix.String()] = upd.Metric
+				}
+				return m
+			}
+
+			Eventually(metrics).Should(Equal(map[string]proto.Metric{
+				"2001:db8:1::/64": proto.Retraction,
+				"2001:db8:2::/64": 42,
+			}))
+		})
+
+		It("retracts advertised routes once they are denied", func() {
+			metrics := func() []proto.Metric {
+				m := []proto.Metric{}
+				for _, upd := range w.Updates() {
+					m = append(m, upd.Metric)
+				}
+				return m
+			}
+
+			n.sendValues([]proto.Value{upd("2001:db8:1::/64", 100)}, queue.PriorityNormal, time.Millisecond)
+			Eventually(metrics).Should(Equal([]proto.Metric{100}))
+
+			setFilters(nil, filter.Filter{
+				{Prefix: netip.MustParsePrefix("2001:db8:1::/48"), Action: filter.ActionDeny},
+			})
+
+			n.sendValues([]proto.Value{upd("2001:db8:1::/64", 100)}, queue.PriorityNormal, time.Millisecond)
+			Eventually(metrics).Should(Equal([]proto.Metric{100, proto.Retraction}))
+
+			// Once retracted, denied updates are dropped again
+			n.sendValues([]proto.Value{upd("2001:db8:1::/64", 100)}, queue.PriorityNormal, time.Millisecond)
+			Consistently(metrics, 50*time.Millisecond).Should(HaveLen(2))
+		})
+
+		It("matches the source prefix of source-specific updates", func() {
+			setFilters(nil, filter.Filter{
+				{SourcePrefix: netip.MustParsePrefix("2001:db8:ff::/48"), Action: filter.ActionDeny},
+			})
+
+			srcPfx := netip.MustParsePrefix("2001:db8:ff:1::/64")
+			denied := upd("2001:db8:1::/64", 100)
+			denied.SourcePrefix = &srcPfx
+
+			n.sendValues([]proto.Value{
+				denied,
+				upd("2001:db8:2::/64", 100),
+			}, queue.PriorityNormal, time.Millisecond)
+
+			prefixes := func() []string {
+				pfxs := []string{}
+				for _, upd := range w.Updates() {
+					pfxs = append(pfxs, upd.Prefix.String())
+				}
+				return pfxs
+			}
+
+			Eventually(prefixes).Should(Equal([]string{"2001:db8:2::/64"}))
+		})
+
+		It("records the feasibility distance from the filtered metric", func() {
+			setFilters(nil, filter.Filter{
+				{Neighbour: n.Address, Action: filter.ActionSetMetric, Value: 42},
+			})
+
+			sim.update(2, 1, 100)
+
+			Expect(n.sendUpdate()).To(Succeed())
+
+			// Periodic updates are sent within half a Hello interval
+			Eventually(w.Values, node.config().MulticastHelloInterval).Should(ContainElement(And(
+				HaveField("Prefix", sim.prefix),
+				HaveField("Metric", BeNumerically("==", 42)),
+			)))
+
+			// The distance must not exceed the advertised metric
+			src, ok := node.Sources.Lookup(sim.prefix, sim.originID)
+			Expect(ok).To(BeTrue())
+			Expect(src.Distance).To(Equal(FeasibilityDistance{1, 42}))
+		})
+	})
+})
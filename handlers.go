@@ -3,6 +3,8 @@
 
 package babel
 
+import "cunicu.li/go-babel/proto"
+
 type NeighbourHandler interface {
 	NeighbourAdded(*Neighbour)
 	NeighbourRemoved(*Neighbour)
@@ -12,3 +14,103 @@ type InterfaceHandler interface {
 	InterfaceAdded(*Interface)
 	InterfaceRemoved(*Interface)
 }
+
+type AcknowledgmentHandler interface {
+	AcknowledgmentFailed(*Neighbour, *PendingAcknowledgment)
+}
+
+// PacketHandler is notified about packets sent and received by the speaker.
+// The interface passed to PacketDecodingFailed is nil if the packet
+// has been received on an unknown interface.
+// Received packets are only valid during the invocation of
+// PacketReceived and must be copied if retained.
+type PacketHandler interface {
+	PacketReceived(*Interface, *proto.Packet)
+	PacketSent(*Interface, []proto.Value, error)
+	PacketDecodingFailed(*Interface, error)
+}
+
+// RouteHandler is notified about changes of the selected routes.
+// The route is nil if the prefix has become unreachable.
+// It is invoked while the routing state is locked. Hence, handlers
+// must not call back into the speaker.
+type RouteHandler interface {
+	SelectedRouteChanged(proto.Prefix, *Route)
+}
+
+// Handlers combines multiple handlers into one.
+// Each event is passed to all handlers which implement
+// the respective handler interface.
+type Handlers []any
+
+func (hs Handlers) NeighbourAdded(n *Neighbour) {
+	for _, h := range hs {
+		if h, ok := h.(NeighbourHandler); ok {
+			h.NeighbourAdded(n)
+		}
+	}
+}
+
+func (hs Handlers) NeighbourRemoved(n *Neighbour) {
+	for _, h := range hs {
+		if h, ok := h.(NeighbourHandler); ok {
+			h.NeighbourRemoved(n)
+		}
+	}
+}
+
+func (hs Handlers) InterfaceAdded(i *Interface) {
+	for _, h := range hs {
+		if h, ok := h.(InterfaceHandler); ok {
+			h.InterfaceAdded(i)
+		}
+	}
+}
+
+func (hs Handlers) InterfaceRemoved(i *Interface) {
+	for _, h := range hs {
+		if h, ok := h.(InterfaceHandler); ok {
+			h.InterfaceRemoved(i)
+		}
+	}
+}
+
+func (hs Handlers) AcknowledgmentFailed(n *Neighbour, ack *PendingAcknowledgment) {
+	for _, h := range hs {
+		if h, ok := h.(AcknowledgmentHandler); ok {
+			h.AcknowledgmentFailed(n, ack)
+		}
+	}
+}
+
+func (hs Handlers) PacketReceived(i *Interface, pkt *proto.Packet) {
+	for _, h := range hs {
+		if h, ok := h.(PacketHandler); ok {
+			h.PacketReceived(i, pkt)
+		}
+	}
+}
+
+func (hs Handlers) PacketSent(i *Interface, vs []proto.Value, err error) {
+	for _, h := range hs {
+		if h, ok := h.(PacketHandler); ok {
+			h.PacketSent(i, vs, err)
+		}
+	}
+}
+
+func (hs Handlers) PacketDecodingFailed(i *Interface, err error) {
+	for _, h := range hs {
+		if h, ok := h.(PacketHandler); ok {
+			h.PacketDecodingFailed(i, err)
+		}
+	}
+}
+
+func (hs Handlers) SelectedRouteChanged(pfx proto.Prefix, r *Route) {
+	for _, h := range hs {
+		if h, ok := h.(RouteHandler); ok {
+			h.SelectedRouteChanged(pfx, r)
+		}
+	}
+}
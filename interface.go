@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"log/slog"
 	"net"
+	"net/netip"
+	"sync"
 	"time"
 
 	netx "cunicu.li/go-babel/internal/net"
@@ -26,6 +28,10 @@ const (
 	// - https://datatracker.ietf.org/doc/html/rfc2474#autoid-9
 	// - https://datatracker.ietf.org/doc/html/rfc4594#section-3.1
 	TrafficClassNetworkControl = 48 << 2 // DiffServ / DSCP name CS6
+
+	// addressCacheTimeout is the time after which the cached
+	// addresses of an interface are queried again.
+	addressCacheTimeout = 5 * time.Second
 )
 
 // 3.2.3. The Interface Table
@@ -35,16 +41,27 @@ type Interface struct {
 	*net.Interface
 
 	multicast bool
+	wireless  bool
 
 	Neighbours NeighbourTable
 
 	helloMulticastSeqNo proto.SequenceNumber
 	helloMulticastTimer *time.Ticker
 	periodicUpdateTimer *time.Ticker
+	closed              chan struct{}
+	closeOnce           sync.Once
 
 	queue   *queue.Queue
 	speaker *Speaker
 
+	// advertised holds the routes which are currently
+	// advertised by multicast (protected by speaker.mu).
+	advertised map[advertisement]bool
+
+	addrs        []proto.Address // protected by addrsMu
+	addrsExpires time.Time       // protected by addrsMu
+	addrsMu      sync.Mutex
+
 	logger *slog.Logger
 }
 
@@ -60,24 +77,28 @@ func (s *Speaker) newInterface(index int) (*Interface, error) {
 
 		speaker: s,
 
-		multicast:           s.config.Multicast,
-		helloMulticastTimer: time.NewTicker(s.config.MulticastHelloInterval),
-		periodicUpdateTimer: time.NewTicker(s.config.UpdateInterval),
+		multicast: s.config().Multicast,
+		wireless:  isWireless(intf.Name),
+		closed:    make(chan struct{}),
 
-		logger: s.config.Logger.With(
+		logger: s.config().Logger.With(
 			slog.String("intf", intf.Name)),
 	}
 
+	i.helloMulticastTimer = time.NewTicker(i.helloInterval())
+	i.periodicUpdateTimer = time.NewTicker(i.updateInterval())
+
 	if i.multicast {
 		multicastAddr := &net.UDPAddr{
 			IP:   MulticastGroupIPv6.AsSlice(),
 			Port: Port,
 		}
 
-		i.queue = queue.NewQueue(intf.MTU, &netx.PacketConnWriter{
+		i.queue = queue.NewQueue(intf.MTU, s.config().UrgentTimeout, &netx.PacketConnWriter{
 			PacketConn: i.speaker.conn.PacketConn,
 			Dest:       multicastAddr,
 		})
+		i.queue.OnSent(i.onSent)
 
 		if err := i.speaker.conn.JoinGroup(i.Interface, multicastAddr); err != nil {
 			return nil, fmt.Errorf("failed to join multicast group: %w", err)
@@ -91,21 +112,73 @@ func (s *Speaker) newInterface(index int) (*Interface, error) {
 	return i, nil
 }
 
-func (i *Interface) Close() error {
+// Close stops the timers and the queue of the interface.
+// Subsequent calls do nothing.
+func (i *Interface) Close() (err error) {
+	i.closeOnce.Do(func() {
+		err = i.close()
+	})
+
+	return err
+}
+
+func (i *Interface) close() error {
+	close(i.closed)
+
 	i.periodicUpdateTimer.Stop()
+	i.helloMulticastTimer.Stop()
 
 	if i.multicast {
 		if err := i.queue.Close(); err != nil {
 			return fmt.Errorf("failed to close queue: %w", err)
 		}
+
+		if err := i.speaker.conn.LeaveGroup(i.Interface, &net.UDPAddr{
+			IP: MulticastGroupIPv6.AsSlice(),
+		}); err != nil {
+			return fmt.Errorf("failed to leave multicast group: %w", err)
+		}
 	}
 
 	return nil
 }
 
+// shutdown sends all queued values and closes the
+// interface along with all of its neighbours.
+func (i *Interface) shutdown() error {
+	errs := []error{}
+
+	for _, n := range i.neighbours() {
+		i.Neighbours.Remove(n)
+
+		if err := n.queue.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush queue of neighbour %s: %w", n.Address, err))
+		}
+
+		if err := n.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close neighbour %s: %w", n.Address, err))
+		}
+	}
+
+	if i.multicast {
+		if err := i.queue.Flush(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to flush queue: %w", err))
+		}
+	}
+
+	if err := i.Close(); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
 func (i *Interface) runTimers() {
 	for {
 		select {
+		case <-i.closed:
+			return
+
 		case <-i.periodicUpdateTimer.C:
 			if err := i.sendUpdate(); err != nil {
 				i.logger.Error("Failed to send periodic update", slog.Any("error", err))
@@ -128,6 +201,10 @@ func (i *Interface) onPacket(pkt *proto.Packet, srcAddr, dstAddr proto.Address)
 		slog.Bool("multicast", isMulticast),
 		slog.Any("packet", pkt))
 
+	if h, ok := i.speaker.config().Handler.(PacketHandler); ok {
+		h.PacketReceived(i, pkt)
+	}
+
 	n, ok := i.Neighbours.Lookup(srcAddr)
 	if !ok {
 		var err error
@@ -138,7 +215,7 @@ func (i *Interface) onPacket(pkt *proto.Packet, srcAddr, dstAddr proto.Address)
 		i.logger.Debug("Found new neighbour",
 			slog.Any("addr", srcAddr))
 
-		if h, ok := i.speaker.config.Handler.(NeighbourHandler); ok {
+		if h, ok := i.speaker.config().Handler.(NeighbourHandler); ok {
 			h.NeighbourAdded(n)
 		}
 
@@ -148,83 +225,214 @@ func (i *Interface) onPacket(pkt *proto.Packet, srcAddr, dstAddr proto.Address)
 	return n.onPacket(pkt, srcAddr, dstAddr)
 }
 
+// onSent is invoked by the queues of the interface and its neighbours
+// after a packet has been sent.
+func (i *Interface) onSent(vs []proto.Value, err error) {
+	if h, ok := i.speaker.config().Handler.(PacketHandler); ok {
+		h.PacketSent(i, vs, err)
+	}
+}
+
+// name returns the name of the interface or an empty string
+// if the interface is not backed by a network interface.
+func (i *Interface) name() string {
+	if i == nil || i.Interface == nil {
+		return ""
+	}
+
+	return i.Name
+}
+
+// QueueLength returns the number of values queued for multicast transmission.
+func (i *Interface) QueueLength() int {
+	if i.queue == nil {
+		return 0
+	}
+
+	return i.queue.Len()
+}
+
 func (i *Interface) sendMulticastHello() error {
 	i.logger.Debug("Sending multicast hello")
 
 	i.helloMulticastSeqNo++
 
 	i.sendValue(&proto.Hello{
-		Seqno:    i.helloMulticastSeqNo,
-		Interval: i.speaker.config.MulticastHelloInterval,
-	}, i.speaker.config.MulticastHelloInterval/2)
+		Seqno:     i.helloMulticastSeqNo,
+		Interval:  i.helloInterval(),
+		Timestamp: &proto.TimestampHello{},
+	}, i.helloInterval()/2)
 
 	return nil
 }
 
 func (i *Interface) sendUpdate() error {
-	// TODO: Implement sending of updates
-	// i.logger.Debug("Sending update")
-	// i.sendValue(&proto.Update{}, i.speaker.config.MulticastHelloInterval/2)
+	if i.unicast() {
+		// The neighbour table must not be locked while sending as
+		// the selected updates are collected under the speaker lock.
+		for _, n := range i.neighbours() {
+			if err := n.sendUpdate(); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	i.speaker.mu.Lock()
+	defer i.speaker.mu.Unlock()
+
+	upds := i.speaker.selectedUpdates(i, nil)
+	if len(upds) == 0 {
+		return nil
+	}
+
+	i.logger.Debug("Sending update", slog.Int("num_routes", len(upds)))
+
+	i.sendValues(upds, queue.PriorityBulk, i.speaker.config().MulticastHelloInterval/2)
 
 	return nil
 }
 
+// neighbours returns a copy of the neighbours of the interface.
+// It allows for calling functions which take the speaker lock
+// for each neighbour without holding the lock of the table.
+func (i *Interface) neighbours() []*Neighbour {
+	ns := []*Neighbour{}
+	i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+		ns = append(ns, n)
+		return nil
+	})
+
+	return ns
+}
+
 // TODO: Use function
 func (i *Interface) sendMulticastRouteRequest() error { //nolint:unused
 	i.logger.Debug("Sending multicast route request")
 
-	i.sendValue(&proto.RouteRequest{}, i.speaker.config.MulticastHelloInterval/2)
+	i.sendValue(&proto.RouteRequest{}, i.speaker.config().MulticastHelloInterval/2)
 
 	return nil
 }
 
-// TODO: Use function
-func (i *Interface) sendMulticastSeqnoRequest() error { //nolint:unused
-	i.logger.Debug("Sending multicast seqno request")
+func (i *Interface) sendValue(v proto.Value, maxDelay time.Duration) {
+	i.sendValues([]proto.Value{v}, queue.PriorityOf(v), maxDelay)
+}
 
-	i.sendValue(&proto.SeqnoRequest{}, i.speaker.config.MulticastHelloInterval/2)
+func (i *Interface) sendValues(vs []proto.Value, prio queue.Priority, maxDelay time.Duration) {
+	if i.multicast {
+		if vs := i.filterOutput(vs, nil); len(vs) > 0 {
+			i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+				n.supersedePendingAcknowledgments(vs)
+				return nil
+			})
 
-	return nil
+			i.queue.SendValuesWithPriority(i.withNextHops(vs), prio, maxDelay)
+		}
+	} else {
+		i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+			n.sendValues(vs, prio, maxDelay)
+			return nil
+		})
+	}
 }
 
-// TODO: Use function
+// withNextHops sets the IPv4 address of the interface as next hop
+// of IPv4 updates as our neighbours can not derive it from
+// the IPv6 link-local source address of our packets.
 //
-//nolint:unused
-func (i *Interface) findLinkLocalAddress() (net.IP, error) {
-	addrs, err := i.Addrs()
-	if err != nil {
-		return nil, err
-	}
+// See: 4.5. Parser State and Encoding of Updates
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.5
+func (i *Interface) withNextHops(vs []proto.Value) []proto.Value {
+	var nh proto.Address
+
+	out := make([]proto.Value, 0, len(vs))
+
+	for _, v := range vs {
+		if upd, ok := v.(*proto.Update); ok && !upd.NextHop.IsValid() && upd.Prefix.Addr().Is4() {
+			if !nh.IsValid() {
+				if nh = i.addressIPv4(); !nh.IsValid() {
+					return vs
+				}
+			}
 
-	for _, addr := range addrs {
-		ipNetAddr, ok := addr.(*net.IPNet)
-		if !ok {
-			continue
+			u := *upd
+			u.NextHop = nh
+			v = &u
 		}
 
-		ipAddr := ipNetAddr.IP
+		out = append(out, v)
+	}
+
+	return out
+}
 
-		if ipAddr.To4() != nil {
-			continue // skip IPv4
+// addressIPv4 returns the first IPv4 address assigned to the interface.
+func (i *Interface) addressIPv4() proto.Address {
+	for _, addr := range i.addresses() {
+		if addr.Is4() {
+			return addr
 		}
+	}
+
+	return proto.Address{}
+}
 
-		if !ipAddr.IsLinkLocalUnicast() {
-			continue // skip non link-local
+// addressLinkLocal returns the first IPv6 link-local address assigned to the interface.
+func (i *Interface) addressLinkLocal() proto.Address {
+	for _, addr := range i.addresses() {
+		if addr.Is6() && addr.IsLinkLocalUnicast() {
+			return addr
 		}
+	}
+
+	return proto.Address{}
+}
+
+// isLocalAddress checks if the address is assigned to the interface.
+// This includes link-local as well as configured addresses.
+func (i *Interface) isLocalAddress(addr proto.Address) bool {
+	addr = addr.Unmap()
 
-		return ipAddr, nil
+	for _, a := range i.addresses() {
+		if a == addr {
+			return true
+		}
 	}
 
-	return nil, errors.New("failed to find IPv6 link-local address")
+	return false
 }
 
-func (i *Interface) sendValue(v proto.Value, maxDelay time.Duration) {
-	if i.multicast {
-		i.queue.SendValue(v, maxDelay)
-	} else {
-		i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
-			n.queue.SendValue(v, maxDelay)
-			return nil
-		})
+// addresses returns all addresses assigned to the interface.
+// As they are checked for each received IHU, the addresses are
+// cached for some time instead of querying the kernel every time.
+// The returned slice must not be modified.
+func (i *Interface) addresses() []proto.Address {
+	i.addrsMu.Lock()
+	defer i.addrsMu.Unlock()
+
+	if time.Now().Before(i.addrsExpires) {
+		return i.addrs
+	}
+
+	addrs, err := i.Addrs()
+	if err != nil {
+		i.logger.Error("Failed to get interface addresses", slog.Any("error", err))
+		return nil
+	}
+
+	as := []proto.Address{}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			if a, ok := netip.AddrFromSlice(ipNet.IP); ok {
+				as = append(as, a.Unmap())
+			}
+		}
 	}
+
+	i.addrs = as
+	i.addrsExpires = time.Now().Add(addressCacheTimeout)
+
+	return as
 }
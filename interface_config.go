@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// InterfaceType describes the kind of link an interface is attached to.
+type InterfaceType int
+
+const (
+	// InterfaceTypeAuto treats interfaces as wired
+	// unless they are detected as wireless.
+	InterfaceTypeAuto InterfaceType = iota
+	InterfaceTypeWired
+	InterfaceTypeWireless
+	InterfaceTypeTunnel
+)
+
+func (t InterfaceType) String() string {
+	switch t {
+	case InterfaceTypeAuto:
+		return "auto"
+	case InterfaceTypeWired:
+		return "wired"
+	case InterfaceTypeWireless:
+		return "wireless"
+	case InterfaceTypeTunnel:
+		return "tunnel"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(t))
+	}
+}
+
+// InterfaceConfig holds per-interface settings.
+// Zero values fall back to the parameters of the speaker.
+type InterfaceConfig struct {
+	Type InterfaceType
+
+	// HelloInterval is the interval of multicast Hellos sent on the interface.
+	HelloInterval time.Duration
+
+	// UpdateInterval is the interval of periodic Updates sent on the interface.
+	UpdateInterval time.Duration
+
+	// RxCost is the nominal cost of receiving from neighbours on the interface.
+	RxCost uint16
+
+	// SplitHorizon controls whether routes are advertised back on
+	// the interface on which they have been learned.
+	// If nil, it is chosen based on the interface type.
+	SplitHorizon *bool
+
+	// Unicast sends Updates to each neighbour individually instead of
+	// multicasting them on the interface. Hellos are still multicast
+	// to discover new neighbours.
+	Unicast bool
+
+	// Channel is the radio channel of the interface used for diversity routing.
+	Channel Channel
+}
+
+// interfaceConfig returns the configuration of the named interface.
+func (c *SpeakerConfig) interfaceConfig(name string) InterfaceConfig {
+	ic, ok := c.Interfaces[name]
+	if !ok {
+		return c.InterfaceDefaults
+	}
+
+	return ic
+}
+
+// config returns the configuration of the interface.
+func (i *Interface) config() InterfaceConfig {
+	return i.speaker.config().interfaceConfig(i.name())
+}
+
+// helloInterval returns the interval of multicast Hellos on the interface.
+func (i *Interface) helloInterval() time.Duration {
+	if ic := i.config(); ic.HelloInterval > 0 {
+		return ic.HelloInterval
+	}
+
+	return i.speaker.config().MulticastHelloInterval
+}
+
+// updateInterval returns the interval of periodic Updates on the interface.
+func (i *Interface) updateInterval() time.Duration {
+	if ic := i.config(); ic.UpdateInterval > 0 {
+		return ic.UpdateInterval
+	}
+
+	return i.speaker.config().UpdateInterval
+}
+
+// rxCost returns the nominal cost of receiving from neighbours on the interface.
+func (i *Interface) rxCost() uint16 {
+	switch ic := i.config(); {
+	case ic.RxCost > 0:
+		return ic.RxCost
+	case i.linkType() == InterfaceTypeWireless:
+		return DefaultWirelessLinkCost
+	default:
+		return i.speaker.config().NominalLinkCost
+	}
+}
+
+// linkType returns the type of the link the interface is attached to.
+func (i *Interface) linkType() InterfaceType {
+	if ic := i.config(); ic.Type != InterfaceTypeAuto {
+		return ic.Type
+	}
+
+	if i.wireless {
+		return InterfaceTypeWireless
+	}
+
+	return InterfaceTypeWired
+}
+
+// splitHorizon checks if routes learned on the interface are
+// advertised back on it. Unless configured explicitly, split horizon
+// is used on wired and tunnel interfaces only as wireless links
+// are not transitive.
+//
+// 3.7.4. Split Horizon
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7.4
+func (i *Interface) splitHorizon() bool {
+	if sh := i.config().SplitHorizon; sh != nil {
+		return *sh
+	}
+
+	return i.linkType() != InterfaceTypeWireless
+}
+
+// unicast checks if Updates are sent to each neighbour individually.
+func (i *Interface) unicast() bool {
+	return !i.multicast || i.config().Unicast
+}
+
+// isWireless checks if the named interface is a wireless interface.
+func isWireless(name string) bool {
+	_, err := os.Stat(filepath.Join("/sys/class/net", name, "wireless"))
+	return err == nil
+}
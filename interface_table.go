@@ -19,6 +19,14 @@ func (t *InterfaceTable) Insert(i *Interface) {
 	(*table.Table[int, *Interface])(t).Insert(i.Index, i)
 }
 
+func (t *InterfaceTable) Remove(i *Interface) {
+	(*table.Table[int, *Interface])(t).Remove(i.Index)
+}
+
 func (t *InterfaceTable) Foreach(cb func(int, *Interface) error) error {
 	return (*table.Table[int, *Interface])(t).ForEach(cb)
 }
+
+func (t *InterfaceTable) Len() int {
+	return (*table.Table[int, *Interface])(t).Len()
+}
@@ -79,3 +79,11 @@ func (h *HelloHistory) OutOf(k, j int) bool {
 		return false
 	}
 }
+
+// State returns the history vector and the next expected sequence number.
+func (h *HelloHistory) State() (vector uint16, expectedSeqno proto.SequenceNumber) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	return h.vector, h.expectedSeqno
+}
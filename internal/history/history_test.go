@@ -73,4 +73,14 @@ var _ = Describe("Vector", func() {
 		resetted = v.Update(201)
 		Expect(resetted).To(BeFalse())
 	})
+
+	It("State", func() {
+		v.Update(1)
+		v.Missed()
+		v.Update(3)
+
+		vector, expected := v.State()
+		Expect(vector).To(Equal(uint16(0b101)))
+		Expect(expected).To(Equal(uint16(4)))
+	})
 })
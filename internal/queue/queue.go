@@ -8,6 +8,7 @@ import (
 	"io"
 	"log/slog"
 	"math/rand"
+	"slices"
 	"sync"
 	"time"
 
@@ -20,28 +21,67 @@ const (
 	pacingTimeout = 10 * time.Millisecond
 )
 
+// Priority is the class of a queued value.
+// Values of a higher class are always sent before
+// values of lower classes.
+type Priority int
+
+const (
+	// PriorityUrgent is used for values which must be sent
+	// within the urgent timeout, e.g. retractions and replies
+	// to seqno requests.
+	PriorityUrgent Priority = iota
+
+	// PriorityNormal is used for all other values.
+	PriorityNormal
+
+	// PriorityBulk is used for periodic full route dumps.
+	PriorityBulk
+
+	numPriorities
+)
+
+// PriorityOf returns the default priority class of a value.
+func PriorityOf(v proto.Value) Priority {
+	if proto.IsUrgent(v) {
+		return PriorityUrgent
+	}
+
+	return PriorityNormal
+}
+
+// bundle is a group of values which are sent within the same packet.
+type bundle []proto.Value
+
 // Queue sends out TLV values over a net.PacketConn
 type Queue struct {
-	mtu    int
-	writer io.Writer
+	mtu           int
+	urgentTimeout time.Duration
+	writer        io.Writer
 
 	timer deadline.Deadline
 
-	values *list.List // protected by mu
+	values [numPriorities]*list.List  // protected by mu
+	due    time.Time                  // protected by mu
+	onSent func([]proto.Value, error) // protected by mu
 	mu     sync.Mutex
 
 	stop    chan any
 	stopped chan any
 }
 
-func NewQueue(mtu int, writer io.Writer) *Queue {
+func NewQueue(mtu int, urgentTimeout time.Duration, writer io.Writer) *Queue {
 	q := &Queue{
-		mtu:     mtu,
-		writer:  writer,
-		stop:    make(chan any),
-		stopped: make(chan any),
-		values:  list.New(),
-		timer:   deadline.NewDeadline(),
+		mtu:           mtu,
+		urgentTimeout: urgentTimeout,
+		writer:        writer,
+		stop:          make(chan any),
+		stopped:       make(chan any),
+		timer:         deadline.NewDeadline(),
+	}
+
+	for i := range q.values {
+		q.values[i] = list.New()
 	}
 
 	go q.run()
@@ -53,29 +93,108 @@ func (q *Queue) Close() error {
 	close(q.stop)
 	<-q.stopped
 
+	q.timer.Stop()
+
+	return nil
+}
+
+// Flush sends all queued values immediately.
+func (q *Queue) Flush() error {
+	for q.Len() > 0 {
+		if err := q.send(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-func (b *Queue) SendValues(vs []proto.Value, maxDelay time.Duration) {
-	b.push(vs...)
-	b.SendIn(maxDelay)
+// OnSent sets a callback which is invoked with the values
+// of each packet after it has been written.
+func (q *Queue) OnSent(cb func(vs []proto.Value, err error)) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.onSent = cb
+}
+
+// SendValues queues values with their default priority class.
+func (q *Queue) SendValues(vs []proto.Value, maxDelay time.Duration) {
+	for _, v := range vs {
+		q.SendValue(v, maxDelay)
+	}
+}
+
+// SendValue queues a value with its default priority class.
+func (q *Queue) SendValue(v proto.Value, maxDelay time.Duration) {
+	q.SendValuesWithPriority([]proto.Value{v}, PriorityOf(v), maxDelay)
+}
+
+// SendValuesWithPriority queues values in the provided priority class.
+// Urgent values are sent at latest after the urgent timeout.
+func (q *Queue) SendValuesWithPriority(vs []proto.Value, prio Priority, maxDelay time.Duration) {
+	if prio == PriorityUrgent && (maxDelay <= 0 || maxDelay > q.urgentTimeout) {
+		maxDelay = q.urgentTimeout
+	}
+
+	q.push(prio, vs...)
+	q.SendIn(maxDelay)
 }
 
-func (b *Queue) SendValue(v proto.Value, maxDelay time.Duration) {
-	b.push(v)
-	b.SendIn(maxDelay)
+// SendBundle queues values in the provided priority class which
+// are guaranteed to be sent within the same packet. This is required
+// for values which are accompanied by an acknowledgment request.
+func (q *Queue) SendBundle(vs []proto.Value, prio Priority, maxDelay time.Duration) {
+	q.SendValuesWithPriority([]proto.Value{bundle(vs)}, prio, maxDelay)
 }
 
+// SendIn schedules the transmission of the next packet within
+// a jittered delay of at most maxDelay. An already scheduled
+// earlier transmission is not postponed.
 func (q *Queue) SendIn(maxDelay time.Duration) {
-	jitter := maxDelay*3/4 + time.Duration(rand.Float64()*float64(maxDelay/2))
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.sendIn(maxDelay)
+}
 
+func (q *Queue) sendIn(maxDelay time.Duration) {
+	jitter := maxDelay/2 + time.Duration(rand.Float64()*float64(maxDelay/2))
+	due := time.Now().Add(jitter)
+
+	if !q.due.IsZero() && q.due.Before(due) {
+		return
+	}
+
+	q.due = due
 	q.timer.Reset(jitter)
 }
 
+// Len returns the number of queued values.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	l := 0
+	for _, vs := range q.values {
+		l += vs.Len()
+	}
+
+	return l
+}
+
 func (q *Queue) run() {
-	for range q.timer.C {
-		if err := q.send(); err != nil {
-			slog.Error("Failed to send packet", slog.Any("error", err))
+	defer close(q.stopped)
+
+	for {
+		select {
+		case <-q.stop:
+			return
+
+		case <-q.timer.C:
+			if err := q.send(); err != nil {
+				slog.Error("Failed to send packet", slog.Any("error", err))
+			}
 		}
 	}
 }
@@ -84,27 +203,78 @@ func (q *Queue) send() error {
 	var empty bool
 	var v proto.Value
 
+	q.mu.Lock()
+	q.due = time.Time{}
+	q.sort()
+	q.mu.Unlock()
+
 	p := proto.NewParser()
 
 	b := make([]byte, 0, q.mtu)
 	b = p.StartPacket(b)
 
+	sent := []proto.Value{}
+
 	for {
+		var vs []proto.Value
+		var tooLarge bool
+
 		// Take next value from queue if it can still fit
 		// into the MTU-sized buffer.
 		if v, empty = q.popIf(func(v any) bool {
-			return cap(b)-len(b)-p.ValueLength(v) >= 0
+			if bdl, ok := v.(bundle); ok {
+				vs = []proto.Value{}
+				for _, v := range bdl {
+					vs = append(vs, prepare(p, v)...)
+				}
+			} else {
+				vs = prepare(p, v)
+			}
+
+			fits := cap(b)-len(b)-int(p.ValuesLength(vs)) >= 0
+
+			// Values which do not even fit into an empty packet
+			// would block the queue forever. Hence, they are dropped.
+			if !fits && len(b) == proto.PacketHeaderLength {
+				tooLarge = true
+				return true
+			}
+
+			return fits
 		}); v == nil {
 			break
 		}
 
-		b = p.AppendValue(b, v)
+		if tooLarge {
+			slog.Warn("Dropping value exceeding the MTU",
+				slog.Any("value", v),
+				slog.Int("mtu", q.mtu))
+			continue
+		}
+
+		b = p.AppendValues(b, vs)
+		sent = append(sent, vs...)
+	}
+
+	// Do not send empty packets
+	if len(b) == proto.PacketHeaderLength {
+		return nil
 	}
 
 	p.FinalizePacket(b)
 
 	// TODO: Handle partial writes?
-	if _, err := q.writer.Write(b); err != nil {
+	_, err := q.writer.Write(b)
+
+	q.mu.Lock()
+	onSent := q.onSent
+	q.mu.Unlock()
+
+	if onSent != nil {
+		onSent(sent, err)
+	}
+
+	if err != nil {
 		return err
 	}
 
@@ -117,27 +287,108 @@ func (q *Queue) send() error {
 	return nil
 }
 
-func (q *Queue) push(vs ...proto.Value) {
+func (q *Queue) push(prio Priority, vs ...proto.Value) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
 	for _, v := range vs {
-		q.values.PushBack(v)
+		q.values[prio].PushBack(v)
 	}
 }
 
+// prepare returns the values which are required to encode a value.
+// Updates might be preceded by Router-Id or Next Hop TLVs.
+// Timestamps of Hellos are set as late as possible so that
+// they do not include the time spent in the queue.
+//
+// https://datatracker.ietf.org/doc/html/draft-ietf-babel-rtt-extension-00#section-3.1
+func prepare(p *proto.Parser, v proto.Value) []proto.Value {
+	switch v := v.(type) {
+	case *proto.Update:
+		return p.PrepareUpdate(v)
+
+	case *proto.Hello:
+		if v.Timestamp != nil {
+			v.Timestamp.Transmit = proto.TimestampFromTime(time.Now())
+		}
+	}
+
+	return []proto.Value{v}
+}
+
+// sort moves the updates of each priority class behind all other values
+// and orders them such that they can be encoded in a compact form.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.5
+func (q *Queue) sort() {
+	for _, l := range q.values {
+		others := []proto.Value{}
+		upds := []*proto.Update{}
+
+		for e := l.Front(); e != nil; e = e.Next() {
+			if upd, ok := e.Value.(*proto.Update); ok {
+				upds = append(upds, upd)
+			} else {
+				others = append(others, e.Value)
+			}
+		}
+
+		if len(upds) < 2 {
+			continue
+		}
+
+		slices.SortStableFunc(upds, func(a, b *proto.Update) int {
+			switch {
+			case a.Less(b):
+				return -1
+			case b.Less(a):
+				return 1
+			default:
+				return 0
+			}
+		})
+
+		l.Init()
+
+		for _, v := range others {
+			l.PushBack(v)
+		}
+
+		for _, upd := range upds {
+			l.PushBack(upd)
+		}
+	}
+}
+
+// popIf removes the next value of the highest non-empty priority class
+// from the queue if it passes the test.
 func (q *Queue) popIf(test func(v any) bool) (proto.Value, bool) {
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
-	v := q.values.Front()
-	if v == nil {
-		return nil, true
+	for i, vs := range q.values {
+		v := vs.Front()
+		if v == nil {
+			continue
+		}
+
+		if !test(v.Value) {
+			return nil, false
+		}
+
+		return vs.Remove(v), q.isEmpty(Priority(i))
 	}
 
-	if !test(v.Value) {
-		return nil, false
+	return nil, true
+}
+
+// isEmpty checks if all classes starting from prio are empty.
+func (q *Queue) isEmpty(prio Priority) bool {
+	for _, vs := range q.values[prio:] {
+		if vs.Len() > 0 {
+			return false
+		}
 	}
 
-	return q.values.Remove(v), q.values.Len() == 0
+	return true
 }
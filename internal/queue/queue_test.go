@@ -4,8 +4,13 @@
 package queue_test
 
 import (
+	"net/netip"
+	"sync"
 	"testing"
+	"time"
 
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -15,5 +20,230 @@ func TestQueue(t *testing.T) {
 	RunSpecs(t, "Queue suite")
 }
 
+// packetWriter collects the values of all written packets.
+// Router-Id and Next Hop TLVs are only counted as they are
+// reflected in the decoded updates.
+type packetWriter struct {
+	values    []proto.Value
+	packets   [][]proto.Value
+	times     []time.Time
+	routerIDs int
+	length    int
+	mu        sync.Mutex
+}
+
+func (w *packetWriter) Write(b []byte) (int, error) {
+	_, pkt, err := proto.NewParser().Packet(b)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.length += len(b)
+	w.packets = append(w.packets, pkt.Body)
+
+	for _, v := range pkt.Body {
+		switch v.(type) {
+		case *proto.RouterIDValue:
+			w.routerIDs++
+		case *proto.NextHop:
+		default:
+			w.values = append(w.values, v)
+			w.times = append(w.times, time.Now())
+		}
+	}
+
+	return len(b), nil
+}
+
+func (w *packetWriter) Values() []proto.Value {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]proto.Value{}, w.values...)
+}
+
+func (w *packetWriter) Times() []time.Time {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]time.Time{}, w.times...)
+}
+
 var _ = Describe("Queue", func() {
+	const urgentTimeout = 20 * time.Millisecond
+
+	var w *packetWriter
+	var q *queue.Queue
+
+	update := func(metric proto.Metric) *proto.Update {
+		return &proto.Update{
+			Interval: time.Second,
+			Metric:   metric,
+			Prefix:   netip.MustParsePrefix("2001:db8::/64"),
+			RouterID: proto.RouterID{1},
+		}
+	}
+
+	BeforeEach(func() {
+		w = &packetWriter{}
+		q = queue.NewQueue(1400, urgentTimeout, w)
+	})
+
+	AfterEach(func() {
+		Expect(q.Close()).To(Succeed())
+	})
+
+	DescribeTable("PriorityOf",
+		func(v proto.Value, prio queue.Priority) {
+			Expect(queue.PriorityOf(v)).To(Equal(prio))
+		},
+		Entry("update", update(100), queue.PriorityNormal),
+		Entry("retraction", update(proto.Retraction), queue.PriorityUrgent),
+		Entry("seqno request", &proto.SeqnoRequest{}, queue.PriorityUrgent),
+		Entry("acknowledgment", &proto.Acknowledgment{}, queue.PriorityUrgent),
+		Entry("hello", &proto.Hello{}, queue.PriorityNormal),
+	)
+
+	It("sends urgent values before bulk values", func() {
+		q.SendValuesWithPriority([]proto.Value{update(1), update(2)}, queue.PriorityBulk, 50*time.Millisecond)
+		q.SendValuesWithPriority([]proto.Value{update(3)}, queue.PriorityNormal, 50*time.Millisecond)
+		q.SendValuesWithPriority([]proto.Value{update(proto.Retraction)}, queue.PriorityUrgent, 0)
+
+		Eventually(w.Values).Should(HaveLen(4))
+
+		metrics := []proto.Metric{}
+		for _, v := range w.Values() {
+			metrics = append(metrics, v.(*proto.Update).Metric)
+		}
+
+		Expect(metrics).To(Equal([]proto.Metric{proto.Retraction, 3, 1, 2}))
+	})
+
+	It("flushes queued values immediately", func() {
+		q.SendValuesWithPriority([]proto.Value{update(1), update(2)}, queue.PriorityBulk, time.Hour)
+
+		Expect(q.Flush()).To(Succeed())
+		Expect(w.Values()).To(HaveLen(2))
+		Expect(q.Len()).To(BeZero())
+	})
+
+	It("sends urgent values within the urgent timeout", func() {
+		q.SendValuesWithPriority([]proto.Value{update(1)}, queue.PriorityBulk, time.Hour)
+
+		start := time.Now()
+		q.SendValue(update(proto.Retraction), time.Hour)
+
+		Eventually(w.Values).Should(HaveLen(2))
+		Expect(w.Times()[0].Sub(start)).To(BeNumerically("<=", 2*urgentTimeout))
+	})
+
+	It("does not postpone an earlier transmission", func() {
+		start := time.Now()
+		q.SendValue(update(1), 10*time.Millisecond)
+		q.SendValue(update(2), time.Hour)
+
+		Eventually(w.Values).Should(HaveLen(2))
+		Expect(w.Times()[1].Sub(start)).To(BeNumerically("<", time.Second))
+	})
+
+	It("aggregates updates and compresses them", func() {
+		rids := []proto.RouterID{{1}, {2}}
+		upds := []proto.Value{}
+
+		for i := 0; i < 20; i++ {
+			upds = append(upds, &proto.Update{
+				Interval: time.Second,
+				Metric:   proto.Metric(i),
+				Prefix:   netip.PrefixFrom(netip.AddrFrom16([16]byte{0x20, 0x01, 0x0d, 0xb8, 6: byte(i)}), 64),
+				RouterID: rids[i%2],
+			})
+		}
+
+		q.SendValues(upds, 10*time.Millisecond)
+
+		Eventually(w.Values).Should(HaveLen(len(upds)))
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		// Updates are sorted by router ID
+		Expect(w.routerIDs).To(Equal(2))
+
+		for _, v := range w.values {
+			upd := v.(*proto.Update)
+			Expect(upd.Flags & proto.FlagUpdatePrefix).NotTo(BeZero())
+
+			upd.Flags = 0
+			Expect(upds).To(ContainElement(upd))
+		}
+
+		p := proto.NewParser()
+		Expect(w.length).To(BeNumerically("<", proto.PacketHeaderLength+int(p.ValuesLength(upds))))
+	})
+
+	It("sends bundled values within the same packet", func() {
+		hello := func(seqno proto.SequenceNumber) *proto.Hello {
+			return &proto.Hello{Seqno: seqno, Interval: time.Second}
+		}
+
+		Expect(q.Close()).To(Succeed())
+
+		// Room for five Hellos per packet
+		q = queue.NewQueue(proto.PacketHeaderLength+5*proto.NewParser().ValueLength(hello(0)), urgentTimeout, w)
+
+		q.SendValues([]proto.Value{hello(1), hello(2), hello(3)}, 10*time.Millisecond)
+		q.SendBundle([]proto.Value{
+			&proto.AcknowledgmentRequest{Opaque: 1, Interval: time.Second},
+			hello(4),
+			hello(5),
+		}, queue.PriorityNormal, 10*time.Millisecond)
+
+		Eventually(w.Values).Should(HaveLen(6))
+
+		w.mu.Lock()
+		defer w.mu.Unlock()
+
+		Expect(w.packets).To(HaveLen(2))
+		Expect(w.packets[0]).To(HaveLen(3))
+		Expect(w.packets[1][0]).To(BeAssignableToTypeOf(&proto.AcknowledgmentRequest{}))
+	})
+
+	It("drops values exceeding the MTU", func() {
+		hello := &proto.Hello{Seqno: 1, Interval: time.Second}
+
+		Expect(q.Close()).To(Succeed())
+
+		// Room for a single Hello per packet
+		q = queue.NewQueue(proto.PacketHeaderLength+proto.NewParser().ValueLength(hello), urgentTimeout, w)
+
+		q.SendValues([]proto.Value{&proto.PadN{N: 100}}, 10*time.Millisecond)
+		q.SendBundle([]proto.Value{hello, hello}, queue.PriorityNormal, 10*time.Millisecond)
+		q.SendValues([]proto.Value{hello}, 10*time.Millisecond)
+
+		Eventually(w.Values).Should(ConsistOf(hello))
+		Eventually(q.Len).Should(BeZero())
+	})
+
+	It("notifies about sent values", func() {
+		sent := make(chan []proto.Value, 1)
+
+		q.OnSent(func(vs []proto.Value, err error) {
+			Expect(err).To(Succeed())
+			sent <- vs
+		})
+
+		q.SendValues([]proto.Value{&proto.Hello{Seqno: 1, Interval: time.Second}}, 10*time.Millisecond)
+
+		var vs []proto.Value
+		Eventually(sent).Should(Receive(&vs))
+		Expect(vs).To(ConsistOf(BeAssignableToTypeOf(&proto.Hello{})))
+	})
+
+	It("reports the number of queued values", func() {
+		q.SendValues([]proto.Value{update(1), update(2)}, time.Hour)
+		Expect(q.Len()).To(Equal(2))
+	})
 })
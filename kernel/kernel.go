@@ -0,0 +1,273 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package kernel installs the routes selected by a speaker into the kernel
+// routing table and redistributes kernel routes via a speaker.
+package kernel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/proto"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// ProtocolBabel is the routing protocol identifier of Babel routes.
+	//
+	// See: /etc/iproute2/rt_protos
+	ProtocolBabel netlink.RouteProtocol = 42
+
+	// DefaultTable is the main routing table.
+	DefaultTable = unix.RT_TABLE_MAIN
+
+	// syncInterval is the interval in which the installed routes are
+	// synchronized with the route table of the speaker even if no
+	// event has been received.
+	syncInterval = 5 * time.Second
+)
+
+// Speaker is the part of babel.Speaker which is used by the installer.
+type Speaker interface {
+	Snapshot() *babel.Snapshot
+	Subscribe(opts babel.SubscriptionOptions) *babel.Subscription
+}
+
+// Handle is the part of netlink.Handle which is used by the installer.
+type Handle interface {
+	RouteReplace(route *netlink.Route) error
+	RouteDel(route *netlink.Route) error
+	LinkByName(name string) (netlink.Link, error)
+}
+
+type InstallerConfig struct {
+	// Table is the kernel routing table into which the routes are installed.
+	Table int
+
+	// Protocol is used to tag installed routes.
+	Protocol netlink.RouteProtocol
+
+	// Priority is the kernel metric of installed routes.
+	Priority int
+
+	// Handle is used to modify the kernel routing table.
+	// A handle for the current network namespace is used if nil.
+	Handle Handle
+
+	Logger *slog.Logger
+}
+
+func (c *InstallerConfig) SetDefaults() error {
+	if c.Table == 0 {
+		c.Table = DefaultTable
+	}
+
+	if c.Protocol == 0 {
+		c.Protocol = ProtocolBabel
+	}
+
+	if c.Handle == nil {
+		h, err := netlink.NewHandle()
+		if err != nil {
+			return fmt.Errorf("failed to create netlink handle: %w", err)
+		}
+
+		c.Handle = h
+	}
+
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+
+	return nil
+}
+
+// Installer keeps the kernel routing table in sync with the
+// selected routes of a speaker.
+type Installer struct {
+	speaker Speaker
+	config  InstallerConfig
+	logger  *slog.Logger
+
+	installed map[proto.Prefix]*netlink.Route
+	mu        sync.Mutex
+}
+
+func NewInstaller(s Speaker, cfg *InstallerConfig) (*Installer, error) {
+	i := &Installer{
+		speaker:   s,
+		config:    *cfg,
+		installed: map[proto.Prefix]*netlink.Route{},
+	}
+
+	if err := i.config.SetDefaults(); err != nil {
+		return nil, err
+	}
+
+	i.logger = i.config.Logger
+
+	return i, nil
+}
+
+// Run installs the selected routes until the context is canceled.
+// Afterwards, all installed routes are removed.
+func (i *Installer) Run(ctx context.Context) error {
+	// Events are only used as a trigger to synchronize the routes.
+	// So we can safely drop events while we are busy.
+	sub := i.speaker.Subscribe(babel.SubscriptionOptions{
+		Filter: babel.EventTypes(babel.EventRouteSelected, babel.EventRouteUnselected,
+			babel.EventXRouteAdded, babel.EventXRouteRemoved),
+		BufferSize: 1,
+	})
+	defer sub.Close()
+
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := i.Sync(); err != nil {
+			i.logger.Error("Failed to synchronize routes", slog.Any("error", err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return i.Flush()
+
+		case _, ok := <-sub.C:
+			if !ok {
+				return i.Flush()
+			}
+
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sync installs all selected routes of the speaker and
+// removes installed routes which are not selected anymore.
+func (i *Installer) Sync() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	errs := []error{}
+	selected := map[proto.Prefix]*netlink.Route{}
+	snap := i.speaker.Snapshot()
+
+	// Routes originated by ourself take precedence over learned ones
+	originated := map[proto.Prefix]any{}
+	for _, x := range snap.XRoutes {
+		originated[x.Prefix] = nil
+	}
+
+	for _, r := range snap.Routes {
+		if _, ok := originated[r.Prefix]; !r.Selected || ok {
+			continue
+		}
+
+		nr, err := i.kernelRoute(r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		selected[r.Prefix] = nr
+	}
+
+	for pfx, nr := range i.installed {
+		if _, ok := selected[pfx]; ok {
+			continue
+		}
+
+		if err := i.config.Handle.RouteDel(nr); err != nil && !errors.Is(err, unix.ESRCH) {
+			errs = append(errs, fmt.Errorf("failed to remove route %s: %w", pfx, err))
+			continue
+		}
+
+		i.logger.Debug("Removed route", slog.Any("prefix", pfx))
+
+		delete(i.installed, pfx)
+	}
+
+	for pfx, nr := range selected {
+		if old, ok := i.installed[pfx]; ok && old.Equal(*nr) {
+			continue
+		}
+
+		if err := i.config.Handle.RouteReplace(nr); err != nil {
+			errs = append(errs, fmt.Errorf("failed to install route %s: %w", pfx, err))
+			continue
+		}
+
+		i.logger.Debug("Installed route", slog.Any("prefix", pfx), slog.Any("via", nr.Gw))
+
+		i.installed[pfx] = nr
+	}
+
+	return errors.Join(errs...)
+}
+
+// Flush removes all installed routes.
+func (i *Installer) Flush() error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	errs := []error{}
+
+	for pfx, nr := range i.installed {
+		if err := i.config.Handle.RouteDel(nr); err != nil && !errors.Is(err, unix.ESRCH) {
+			errs = append(errs, fmt.Errorf("failed to remove route %s: %w", pfx, err))
+			continue
+		}
+
+		delete(i.installed, pfx)
+	}
+
+	return errors.Join(errs...)
+}
+
+// kernelRoute converts a selected route into a kernel route.
+// IPv4 routes with an IPv6 next hop are installed with a via attribute.
+//
+// See: RFC 9229 IPv4 Routes with an IPv6 Next Hop in the Babel Routing Protocol
+// https://datatracker.ietf.org/doc/html/rfc9229
+func (i *Installer) kernelRoute(r babel.RouteSnapshot) (*netlink.Route, error) {
+	link, err := i.config.Handle.LinkByName(r.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", r.Interface, err)
+	}
+
+	nh := r.NextHop
+	if !nh.IsValid() {
+		nh = r.Neighbour
+	}
+
+	nr := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Dst: &net.IPNet{
+			IP:   r.Prefix.Addr().AsSlice(),
+			Mask: net.CIDRMask(r.Prefix.Bits(), r.Prefix.Addr().BitLen()),
+		},
+		Table:    i.config.Table,
+		Protocol: i.config.Protocol,
+		Priority: i.config.Priority,
+	}
+
+	if r.Prefix.Addr().Is4() && !nh.Unmap().Is4() {
+		nr.Via = &netlink.Via{
+			AddrFamily: netlink.FAMILY_V6,
+			Addr:       nh.AsSlice(),
+		}
+	} else {
+		nr.Gw = nh.Unmap().AsSlice()
+	}
+
+	return nr, nil
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package kernel_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/netip"
+	"sync"
+	"testing"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/kernel"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+)
+
+func TestKernel(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Kernel suite")
+}
+
+// fakeHandle records the routes installed into the kernel.
+type fakeHandle struct {
+	routes   map[string]netlink.Route
+	replaced int
+	mu       sync.Mutex
+}
+
+func (h *fakeHandle) RouteReplace(r *netlink.Route) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.routes[r.Dst.String()] = *r
+	h.replaced++
+
+	return nil
+}
+
+func (h *fakeHandle) RouteDel(r *netlink.Route) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.routes, r.Dst.String())
+
+	return nil
+}
+
+func (h *fakeHandle) LinkByName(name string) (netlink.Link, error) {
+	return &netlink.Dummy{
+		LinkAttrs: netlink.LinkAttrs{Name: name, Index: 7},
+	}, nil
+}
+
+func (h *fakeHandle) Routes() map[string]netlink.Route {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	rs := map[string]netlink.Route{}
+	for k, v := range h.routes {
+		rs[k] = v
+	}
+
+	return rs
+}
+
+// fakeSpeaker returns a fixed snapshot which can be changed by the test.
+type fakeSpeaker struct {
+	*babel.Speaker
+
+	routes  []babel.RouteSnapshot
+	xroutes []babel.XRouteSnapshot
+	mu      sync.Mutex
+}
+
+func (s *fakeSpeaker) Snapshot() *babel.Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return &babel.Snapshot{Routes: s.routes, XRoutes: s.xroutes}
+}
+
+var _ = Describe("Installer", func() {
+	var h *fakeHandle
+	var s *fakeSpeaker
+	var i *kernel.Installer
+
+	route := func(pfx, nh string, selected bool) babel.RouteSnapshot {
+		return babel.RouteSnapshot{
+			Prefix:    netip.MustParsePrefix(pfx),
+			Interface: "eth0",
+			Neighbour: netip.MustParseAddr("fe80::1"),
+			NextHop:   netip.MustParseAddr(nh),
+			Selected:  selected,
+		}
+	}
+
+	BeforeEach(func() {
+		var err error
+
+		h = &fakeHandle{routes: map[string]netlink.Route{}}
+		s = &fakeSpeaker{Speaker: &babel.Speaker{}}
+
+		i, err = kernel.NewInstaller(s, &kernel.InstallerConfig{
+			Handle: h,
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		})
+		Expect(err).To(Succeed())
+	})
+
+	It("installs selected routes", func() {
+		s.routes = []babel.RouteSnapshot{
+			route("2001:db8::/64", "fe80::1", true),
+			route("2001:db8:1::/64", "fe80::2", false),
+			route("10.0.0.0/8", "192.0.2.1", true),
+		}
+
+		Expect(i.Sync()).To(Succeed())
+
+		rs := h.Routes()
+		Expect(rs).To(HaveLen(2))
+		Expect(rs).To(HaveKey("2001:db8::/64"))
+		Expect(rs["2001:db8::/64"].Gw.String()).To(Equal("fe80::1"))
+		Expect(rs["2001:db8::/64"].LinkIndex).To(Equal(7))
+		Expect(rs["2001:db8::/64"].Protocol).To(Equal(kernel.ProtocolBabel))
+		Expect(rs["2001:db8::/64"].Table).To(Equal(kernel.DefaultTable))
+		Expect(rs["10.0.0.0/8"].Gw.String()).To(Equal("192.0.2.1"))
+	})
+
+	It("installs IPv4 routes with an IPv6 next hop", func() {
+		s.routes = []babel.RouteSnapshot{
+			route("10.0.0.0/8", "fe80::1", true),
+		}
+
+		Expect(i.Sync()).To(Succeed())
+
+		r := h.Routes()["10.0.0.0/8"]
+		Expect(r.Gw).To(BeNil())
+		Expect(r.Via).NotTo(BeNil())
+		Expect(r.Via.(*netlink.Via).Addr.String()).To(Equal("fe80::1"))
+	})
+
+	It("removes routes which are not selected anymore", func() {
+		s.routes = []babel.RouteSnapshot{route("2001:db8::/64", "fe80::1", true)}
+		Expect(i.Sync()).To(Succeed())
+
+		s.routes = []babel.RouteSnapshot{route("2001:db8::/64", "fe80::1", false)}
+		Expect(i.Sync()).To(Succeed())
+
+		Expect(h.Routes()).To(BeEmpty())
+	})
+
+	It("only replaces changed routes", func() {
+		s.routes = []babel.RouteSnapshot{route("2001:db8::/64", "fe80::1", true)}
+		Expect(i.Sync()).To(Succeed())
+		Expect(i.Sync()).To(Succeed())
+		Expect(h.replaced).To(Equal(1))
+
+		s.routes = []babel.RouteSnapshot{route("2001:db8::/64", "fe80::2", true)}
+		Expect(i.Sync()).To(Succeed())
+		Expect(h.replaced).To(Equal(2))
+		Expect(h.Routes()["2001:db8::/64"].Gw.String()).To(Equal("fe80::2"))
+	})
+
+	It("does not install routes for originated prefixes", func() {
+		s.routes = []babel.RouteSnapshot{
+			route("2001:db8::/64", "fe80::1", true),
+			route("2001:db8:1::/64", "fe80::1", true),
+		}
+		s.xroutes = []babel.XRouteSnapshot{
+			{Prefix: netip.MustParsePrefix("2001:db8::/64")},
+		}
+
+		Expect(i.Sync()).To(Succeed())
+
+		rs := h.Routes()
+		Expect(rs).To(HaveLen(1))
+		Expect(rs).To(HaveKey("2001:db8:1::/64"))
+	})
+
+	It("flushes installed routes when stopped", func() {
+		s.routes = []babel.RouteSnapshot{route("2001:db8::/64", "fe80::1", true)}
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan error)
+		go func() { done <- i.Run(ctx) }()
+
+		Eventually(h.Routes).Should(HaveLen(1))
+
+		cancel()
+
+		Eventually(done).Should(Receive(Succeed()))
+		Expect(h.Routes()).To(BeEmpty())
+	})
+})
@@ -0,0 +1,304 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package kernel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/proto"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+var errSubscriptionClosed = errors.New("route subscription has been closed")
+
+// DefaultRedistributedProtocols are the protocols of kernel routes which
+// are redistributed by default. These are routes which have been
+// configured statically or assigned by DHCP.
+var DefaultRedistributedProtocols = []netlink.RouteProtocol{
+	unix.RTPROT_BOOT,
+	unix.RTPROT_STATIC,
+	unix.RTPROT_DHCP,
+}
+
+// Originator is the part of babel.Speaker which is used by the redistributor.
+type Originator interface {
+	Originate(pfx proto.Prefix, metric proto.Metric) error
+	Withdraw(pfx proto.Prefix)
+}
+
+// SubscribeFunc subscribes to changes of the kernel routing tables.
+// It has the signature of netlink.RouteSubscribeWithOptions.
+type SubscribeFunc func(ch chan<- netlink.RouteUpdate, done <-chan struct{}, opts netlink.RouteSubscribeOptions) error
+
+type RedistributorConfig struct {
+	// Table is the kernel routing table whose routes are redistributed.
+	Table int
+
+	// Protocols are the protocols of redistributed routes.
+	// DefaultRedistributedProtocols are used if empty.
+	// Routes installed by Babel are never redistributed.
+	Protocols []netlink.RouteProtocol
+
+	// InstallerProtocol is the protocol with which the installer
+	// tags the routes it installs (see InstallerConfig.Protocol).
+	// These routes are never redistributed. ProtocolBabel is used if zero.
+	InstallerProtocol netlink.RouteProtocol
+
+	// Filter decides which routes are redistributed and with which metric.
+	// All routes are redistributed with Metric if empty.
+	Filter filter.Filter
+
+	// Metric is the metric with which routes are originated
+	// unless it is changed by the filter.
+	Metric proto.Metric
+
+	// Subscribe is used to subscribe to route changes.
+	// netlink.RouteSubscribeWithOptions is used if nil.
+	Subscribe SubscribeFunc
+
+	Logger *slog.Logger
+}
+
+func (c *RedistributorConfig) SetDefaults() {
+	if c.Table == 0 {
+		c.Table = DefaultTable
+	}
+
+	if len(c.Protocols) == 0 {
+		c.Protocols = DefaultRedistributedProtocols
+	}
+
+	if c.InstallerProtocol == 0 {
+		c.InstallerProtocol = ProtocolBabel
+	}
+
+	if c.Subscribe == nil {
+		c.Subscribe = netlink.RouteSubscribeWithOptions
+	}
+
+	if c.Logger == nil {
+		c.Logger = slog.Default()
+	}
+}
+
+// kernelRouteKey identifies a kernel route among multiple routes to
+// the same prefix within a table. Like the kernel, we consider routes
+// with the same TOS and priority as the same route when replacing them.
+type kernelRouteKey struct {
+	Tos      int
+	Priority int
+}
+
+// Redistributor originates prefixes of kernel routes via a speaker.
+// It tracks additions and deletions of kernel routes by
+// listening to RTNLGRP_IPV4_ROUTE and RTNLGRP_IPV6_ROUTE notifications.
+type Redistributor struct {
+	speaker Originator
+	config  RedistributorConfig
+	logger  *slog.Logger
+
+	// routes holds the metrics of matching kernel routes by prefix.
+	routes map[proto.Prefix]map[kernelRouteKey]proto.Metric
+
+	// originated holds the metrics of originated prefixes.
+	originated map[proto.Prefix]proto.Metric
+
+	mu sync.Mutex
+}
+
+func NewRedistributor(s Originator, cfg *RedistributorConfig) *Redistributor {
+	r := &Redistributor{
+		speaker:    s,
+		config:     *cfg,
+		routes:     map[proto.Prefix]map[kernelRouteKey]proto.Metric{},
+		originated: map[proto.Prefix]proto.Metric{},
+	}
+
+	r.config.SetDefaults()
+
+	r.logger = r.config.Logger
+
+	return r
+}
+
+// Run redistributes the existing and future kernel routes until the
+// context is canceled. Afterwards, all originated prefixes are withdrawn.
+func (r *Redistributor) Run(ctx context.Context) error {
+	updates := make(chan netlink.RouteUpdate)
+	done := make(chan struct{})
+	errs := make(chan error, 1)
+
+	if err := r.config.Subscribe(updates, done, netlink.RouteSubscribeOptions{
+		ListExisting: true,
+		ErrorCallback: func(err error) {
+			select {
+			case errs <- err:
+			default:
+			}
+		},
+	}); err != nil {
+		return fmt.Errorf("failed to subscribe to route changes: %w", err)
+	}
+
+	// Stop the subscription and drain pending updates
+	// so that its receiving goroutine can terminate.
+	defer func() {
+		close(done)
+
+		for range updates {
+		}
+	}()
+
+	defer r.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case u, ok := <-updates:
+			if !ok {
+				select {
+				case err := <-errs:
+					return fmt.Errorf("%w: %w", errSubscriptionClosed, err)
+				default:
+					return errSubscriptionClosed
+				}
+			}
+
+			r.OnRouteUpdate(u)
+
+		case err := <-errs:
+			r.logger.Warn("Failed to receive route changes", slog.Any("error", err))
+		}
+	}
+}
+
+// OnRouteUpdate handles a notification about an added or deleted kernel route.
+func (r *Redistributor) OnRouteUpdate(u netlink.RouteUpdate) {
+	pfx, metric, ok := r.match(&u.Route)
+	if !ok {
+		return
+	}
+
+	key := kernelRouteKey{
+		Tos:      u.Tos,
+		Priority: u.Priority,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch u.Type {
+	case unix.RTM_NEWROUTE:
+		if r.routes[pfx] == nil {
+			r.routes[pfx] = map[kernelRouteKey]proto.Metric{}
+		}
+
+		r.routes[pfx][key] = metric
+
+	case unix.RTM_DELROUTE:
+		delete(r.routes[pfx], key)
+
+	default:
+		return
+	}
+
+	r.sync(pfx)
+}
+
+// Flush withdraws all originated prefixes.
+func (r *Redistributor) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for pfx := range r.originated {
+		r.speaker.Withdraw(pfx)
+		delete(r.originated, pfx)
+	}
+
+	clear(r.routes)
+}
+
+// sync originates a prefix with the lowest metric of its kernel routes
+// or withdraws it if there are no kernel routes left.
+func (r *Redistributor) sync(pfx proto.Prefix) {
+	routes := r.routes[pfx]
+	if len(routes) == 0 {
+		delete(r.routes, pfx)
+
+		if _, ok := r.originated[pfx]; ok {
+			r.logger.Debug("Withdrawing kernel route", slog.Any("prefix", pfx))
+
+			r.speaker.Withdraw(pfx)
+			delete(r.originated, pfx)
+		}
+
+		return
+	}
+
+	metric := slices.Min(slices.Collect(maps.Values(routes)))
+	if old, ok := r.originated[pfx]; ok && old == metric {
+		return
+	}
+
+	if err := r.speaker.Originate(pfx, metric); err != nil {
+		r.logger.Error("Failed to originate kernel route", slog.Any("prefix", pfx), slog.Any("error", err))
+		return
+	}
+
+	r.logger.Debug("Redistributing kernel route", slog.Any("prefix", pfx), slog.Any("metric", metric))
+
+	r.originated[pfx] = metric
+}
+
+// match checks if a kernel route is redistributed and
+// returns its prefix and the metric with which it is originated.
+func (r *Redistributor) match(rt *netlink.Route) (proto.Prefix, proto.Metric, bool) {
+	if rt.Table != r.config.Table ||
+		rt.Type != unix.RTN_UNICAST ||
+		rt.Protocol == ProtocolBabel ||
+		rt.Protocol == r.config.InstallerProtocol ||
+		!slices.Contains(r.config.Protocols, rt.Protocol) {
+		return proto.Prefix{}, 0, false
+	}
+
+	pfx, ok := prefixFromIPNet(rt.Dst)
+	if !ok || pfx.Addr().IsLinkLocalUnicast() || pfx.Addr().IsMulticast() {
+		return proto.Prefix{}, 0, false
+	}
+
+	metric, ok := r.config.Filter.Apply(&filter.Route{
+		Prefix:   pfx,
+		Protocol: int(rt.Protocol),
+		Metric:   r.config.Metric,
+	})
+
+	return pfx, metric, ok
+}
+
+func prefixFromIPNet(n *net.IPNet) (proto.Prefix, bool) {
+	if n == nil {
+		return proto.Prefix{}, false
+	}
+
+	addr, ok := netip.AddrFromSlice(n.IP)
+	if !ok {
+		return proto.Prefix{}, false
+	}
+
+	bits, _ := n.Mask.Size()
+
+	return netip.PrefixFrom(addr.Unmap(), bits).Masked(), true
+}
@@ -0,0 +1,213 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package kernel_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"sync"
+
+	"cunicu.li/go-babel/filter"
+	"cunicu.li/go-babel/kernel"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// fakeOriginator records the prefixes originated by the redistributor.
+type fakeOriginator struct {
+	originated map[string]proto.Metric
+	mu         sync.Mutex
+}
+
+func (o *fakeOriginator) Originate(pfx proto.Prefix, metric proto.Metric) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.originated[pfx.String()] = metric
+
+	return nil
+}
+
+func (o *fakeOriginator) Withdraw(pfx proto.Prefix) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	delete(o.originated, pfx.String())
+}
+
+func (o *fakeOriginator) Originated() map[string]proto.Metric {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	m := map[string]proto.Metric{}
+	for k, v := range o.originated {
+		m[k] = v
+	}
+
+	return m
+}
+
+var _ = Describe("Redistributor", func() {
+	var o *fakeOriginator
+	var r *kernel.Redistributor
+	var cfg *kernel.RedistributorConfig
+
+	update := func(typ uint16, pfx string, protocol netlink.RouteProtocol, priority int) netlink.RouteUpdate {
+		p := netip.MustParsePrefix(pfx)
+
+		return netlink.RouteUpdate{
+			Type: typ,
+			Route: netlink.Route{
+				Dst: &net.IPNet{
+					IP:   p.Addr().AsSlice(),
+					Mask: net.CIDRMask(p.Bits(), p.Addr().BitLen()),
+				},
+				Table:    kernel.DefaultTable,
+				Type:     unix.RTN_UNICAST,
+				Protocol: protocol,
+				Priority: priority,
+			},
+		}
+	}
+
+	added := func(pfx string, protocol netlink.RouteProtocol) netlink.RouteUpdate {
+		return update(unix.RTM_NEWROUTE, pfx, protocol, 0)
+	}
+
+	deleted := func(pfx string, protocol netlink.RouteProtocol) netlink.RouteUpdate {
+		return update(unix.RTM_DELROUTE, pfx, protocol, 0)
+	}
+
+	BeforeEach(func() {
+		o = &fakeOriginator{originated: map[string]proto.Metric{}}
+		cfg = &kernel.RedistributorConfig{
+			Metric: 10,
+			Logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+	})
+
+	JustBeforeEach(func() {
+		r = kernel.NewRedistributor(o, cfg)
+	})
+
+	It("originates static and DHCP routes", func() {
+		r.OnRouteUpdate(added("2001:db8::/64", unix.RTPROT_STATIC))
+		r.OnRouteUpdate(added("10.0.0.0/8", unix.RTPROT_DHCP))
+
+		Expect(o.Originated()).To(Equal(map[string]proto.Metric{
+			"2001:db8::/64": 10,
+			"10.0.0.0/8":    10,
+		}))
+	})
+
+	It("ignores other routes", func() {
+		r.OnRouteUpdate(added("2001:db8::/64", unix.RTPROT_KERNEL))
+		r.OnRouteUpdate(added("2001:db8:1::/64", kernel.ProtocolBabel))
+		r.OnRouteUpdate(added("fe80::/64", unix.RTPROT_STATIC))
+
+		u := added("2001:db8:2::/64", unix.RTPROT_STATIC)
+		u.Table = 100
+		r.OnRouteUpdate(u)
+
+		u = added("2001:db8:3::/64", unix.RTPROT_STATIC)
+		u.Type = unix.RTN_UNREACHABLE
+		r.OnRouteUpdate(u)
+
+		Expect(o.Originated()).To(BeEmpty())
+	})
+
+	Context("with an installer protocol", func() {
+		BeforeEach(func() {
+			cfg.InstallerProtocol = unix.RTPROT_STATIC
+		})
+
+		It("ignores routes installed by the installer", func() {
+			r.OnRouteUpdate(added("2001:db8::/64", unix.RTPROT_STATIC))
+			r.OnRouteUpdate(added("2001:db8:1::/64", kernel.ProtocolBabel))
+			r.OnRouteUpdate(added("10.0.0.0/8", unix.RTPROT_DHCP))
+
+			Expect(o.Originated()).To(Equal(map[string]proto.Metric{
+				"10.0.0.0/8": 10,
+			}))
+		})
+	})
+
+	It("withdraws deleted routes", func() {
+		r.OnRouteUpdate(added("2001:db8::/64", unix.RTPROT_STATIC))
+		r.OnRouteUpdate(update(unix.RTM_NEWROUTE, "2001:db8::/64", unix.RTPROT_STATIC, 100))
+
+		r.OnRouteUpdate(deleted("2001:db8::/64", unix.RTPROT_STATIC))
+		Expect(o.Originated()).To(HaveKey("2001:db8::/64"))
+
+		r.OnRouteUpdate(update(unix.RTM_DELROUTE, "2001:db8::/64", unix.RTPROT_STATIC, 100))
+		Expect(o.Originated()).To(BeEmpty())
+	})
+
+	Context("with filter", func() {
+		BeforeEach(func() {
+			cfg.Filter = filter.Filter{
+				{Prefix: netip.MustParsePrefix("10.0.0.0/8"), Action: filter.ActionDeny},
+				{Protocol: unix.RTPROT_DHCP, Action: filter.ActionSetMetric, Value: 256},
+			}
+		})
+
+		It("applies the filter", func() {
+			r.OnRouteUpdate(added("10.1.0.0/16", unix.RTPROT_STATIC))
+			r.OnRouteUpdate(added("2001:db8::/64", unix.RTPROT_DHCP))
+			r.OnRouteUpdate(added("2001:db8:1::/64", unix.RTPROT_STATIC))
+
+			Expect(o.Originated()).To(Equal(map[string]proto.Metric{
+				"2001:db8::/64":   256,
+				"2001:db8:1::/64": 10,
+			}))
+		})
+	})
+
+	Context("when running", func() {
+		var updates chan<- netlink.RouteUpdate
+		var subscribed chan netlink.RouteSubscribeOptions
+
+		BeforeEach(func() {
+			subscribed = make(chan netlink.RouteSubscribeOptions, 1)
+
+			cfg.Subscribe = func(ch chan<- netlink.RouteUpdate, done <-chan struct{}, opts netlink.RouteSubscribeOptions) error {
+				updates = ch
+
+				go func() {
+					<-done
+					close(ch)
+				}()
+
+				subscribed <- opts
+
+				return nil
+			}
+		})
+
+		It("tracks route changes and withdraws all prefixes when stopped", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+
+			done := make(chan error)
+			go func() { done <- r.Run(ctx) }()
+
+			var opts netlink.RouteSubscribeOptions
+			Eventually(subscribed).Should(Receive(&opts))
+			Expect(opts.ListExisting).To(BeTrue())
+
+			updates <- added("2001:db8::/64", unix.RTPROT_STATIC)
+			Eventually(o.Originated).Should(HaveKey("2001:db8::/64"))
+
+			cancel()
+
+			Eventually(done).Should(Receive(Succeed()))
+			Expect(o.Originated()).To(BeEmpty())
+		})
+	})
+})
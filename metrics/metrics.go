@@ -0,0 +1,308 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package metrics exports the state and traffic of a Babel speaker
+// as Prometheus metrics.
+package metrics
+
+import (
+	"errors"
+	"sync"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const namespace = "babel"
+
+// decodingErrors are the errors of the proto package which
+// are distinguished by the parse error counter.
+var decodingErrors = []error{
+	proto.ErrInvalidLength,
+	proto.ErrInvalidHeader,
+	proto.ErrInvalidMagic,
+	proto.ErrUnsupportedVersion,
+	proto.ErrCompressionNotAllowed,
+	proto.ErrInvalidAddress,
+	proto.ErrMissingDefaultPrefix,
+	proto.ErrInvalidRouterID,
+	proto.ErrInvalidValueType,
+	proto.ErrTooShort,
+	proto.ErrTooLong,
+	proto.ErrUnsupportedValue,
+	proto.ErrUnsupportedButMandatoryValue,
+	proto.ErrInvalidValueForTrailer,
+	proto.ErrInvalidTrailer,
+	proto.ErrZeroInterval,
+	proto.ErrZeroHopCount,
+	proto.ErrInvalidPrefixLength,
+	proto.ErrInvalidPrefixBits,
+}
+
+var (
+	descInterfaceNeighbours = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "interface", "neighbours"),
+		"Number of neighbours on the interface.",
+		[]string{"interface"}, nil)
+	descInterfaceQueueLength = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "interface", "queue_length"),
+		"Number of values queued for multicast transmission on the interface.",
+		[]string{"interface"}, nil)
+	descNeighbourRxCost = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "neighbour", "rxcost"),
+		"Receive cost of the link to the neighbour.",
+		[]string{"interface", "neighbour"}, nil)
+	descNeighbourTxCost = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "neighbour", "txcost"),
+		"Transmission cost of the link to the neighbour as reported by its IHUs.",
+		[]string{"interface", "neighbour"}, nil)
+	descNeighbourCost = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "neighbour", "cost"),
+		"Cost of the link to the neighbour.",
+		[]string{"interface", "neighbour"}, nil)
+	descNeighbourQueueLength = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "neighbour", "queue_length"),
+		"Number of values queued for unicast transmission to the neighbour.",
+		[]string{"interface", "neighbour"}, nil)
+	descRoutes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "routes"),
+		"Number of entries in the route table.",
+		nil, nil)
+	descSelectedRoutes = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "selected_routes"),
+		"Number of selected routes in the route table.",
+		nil, nil)
+	descSources = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "sources"),
+		"Number of entries in the source table.",
+		nil, nil)
+)
+
+// Metrics collects metrics of a Babel speaker.
+//
+// Traffic metrics are gathered by passing Metrics as the handler of the speaker
+// (see babel.SpeakerConfig.Handler and babel.Handlers).
+// State metrics are gathered from the speaker set by SetSpeaker
+// when the metrics are collected.
+type Metrics struct {
+	packetsReceived *prometheus.CounterVec
+	packetsSent     *prometheus.CounterVec
+	valuesReceived  *prometheus.CounterVec
+	valuesSent      *prometheus.CounterVec
+	sendErrors      *prometheus.CounterVec
+	decodingErrors  *prometheus.CounterVec
+	routeChanges    prometheus.Counter
+	ackFailures     *prometheus.CounterVec
+
+	speaker *babel.Speaker
+	mu      sync.RWMutex
+}
+
+// New creates new metrics and registers them with the registry.
+func New(reg prometheus.Registerer) (*Metrics, error) {
+	m := &Metrics{
+		packetsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_received_total",
+			Help:      "Number of received packets.",
+		}, []string{"interface"}),
+		packetsSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "packets_sent_total",
+			Help:      "Number of sent packets.",
+		}, []string{"interface"}),
+		valuesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "values_received_total",
+			Help:      "Number of received TLVs by type.",
+		}, []string{"interface", "type"}),
+		valuesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "values_sent_total",
+			Help:      "Number of sent TLVs by type.",
+		}, []string{"interface", "type"}),
+		sendErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "send_errors_total",
+			Help:      "Number of packets which could not be sent.",
+		}, []string{"interface"}),
+		decodingErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "decoding_errors_total",
+			Help:      "Number of received packets which could not be decoded by error.",
+		}, []string{"interface", "error"}),
+		routeChanges: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "selected_route_changes_total",
+			Help:      "Number of changes of selected routes.",
+		}),
+		ackFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "acknowledgment_failures_total",
+			Help:      "Number of acknowledgment requests which have not been acknowledged.",
+		}, []string{"interface"}),
+	}
+
+	if err := reg.Register(m); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SetSpeaker sets the speaker whose state is exported.
+func (m *Metrics) SetSpeaker(s *babel.Speaker) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.speaker = s
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.packetsReceived,
+		m.packetsSent,
+		m.valuesReceived,
+		m.valuesSent,
+		m.sendErrors,
+		m.decodingErrors,
+		m.routeChanges,
+		m.ackFailures,
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+
+	ch <- descInterfaceNeighbours
+	ch <- descInterfaceQueueLength
+	ch <- descNeighbourRxCost
+	ch <- descNeighbourTxCost
+	ch <- descNeighbourCost
+	ch <- descNeighbourQueueLength
+	ch <- descRoutes
+	ch <- descSelectedRoutes
+	ch <- descSources
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+
+	m.mu.RLock()
+	s := m.speaker
+	m.mu.RUnlock()
+
+	if s != nil {
+		collectSpeaker(ch, s)
+	}
+}
+
+func collectSpeaker(ch chan<- prometheus.Metric, s *babel.Speaker) {
+	snap := s.Snapshot()
+
+	neighbours := map[string]int{}
+	for _, n := range snap.Neighbours {
+		neighbours[n.Interface]++
+
+		ch <- prometheus.MustNewConstMetric(descNeighbourRxCost, prometheus.GaugeValue,
+			float64(n.RxCost), n.Interface, n.Address.String())
+		ch <- prometheus.MustNewConstMetric(descNeighbourTxCost, prometheus.GaugeValue,
+			float64(n.TxCost), n.Interface, n.Address.String())
+		ch <- prometheus.MustNewConstMetric(descNeighbourCost, prometheus.GaugeValue,
+			float64(n.Cost), n.Interface, n.Address.String())
+		ch <- prometheus.MustNewConstMetric(descNeighbourQueueLength, prometheus.GaugeValue,
+			float64(n.QueueLength), n.Interface, n.Address.String())
+	}
+
+	for _, i := range snap.Interfaces {
+		ch <- prometheus.MustNewConstMetric(descInterfaceNeighbours, prometheus.GaugeValue,
+			float64(neighbours[i.Name]), i.Name)
+		ch <- prometheus.MustNewConstMetric(descInterfaceQueueLength, prometheus.GaugeValue,
+			float64(i.QueueLength), i.Name)
+	}
+
+	selected := 0
+	for _, r := range snap.Routes {
+		if r.Selected {
+			selected++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(descRoutes, prometheus.GaugeValue, float64(len(snap.Routes)))
+	ch <- prometheus.MustNewConstMetric(descSelectedRoutes, prometheus.GaugeValue, float64(selected))
+	ch <- prometheus.MustNewConstMetric(descSources, prometheus.GaugeValue, float64(len(snap.Sources)))
+}
+
+// PacketReceived implements babel.PacketHandler.
+func (m *Metrics) PacketReceived(i *babel.Interface, pkt *proto.Packet) {
+	name := interfaceName(i)
+
+	m.packetsReceived.WithLabelValues(name).Inc()
+
+	for _, v := range pkt.Body {
+		m.valuesReceived.WithLabelValues(name, proto.ValuesType(v).String()).Inc()
+	}
+}
+
+// PacketSent implements babel.PacketHandler.
+func (m *Metrics) PacketSent(i *babel.Interface, vs []proto.Value, err error) {
+	name := interfaceName(i)
+
+	if err != nil {
+		m.sendErrors.WithLabelValues(name).Inc()
+		return
+	}
+
+	m.packetsSent.WithLabelValues(name).Inc()
+
+	for _, v := range vs {
+		m.valuesSent.WithLabelValues(name, proto.ValuesType(v).String()).Inc()
+	}
+}
+
+// PacketDecodingFailed implements babel.PacketHandler.
+func (m *Metrics) PacketDecodingFailed(i *babel.Interface, err error) {
+	m.decodingErrors.WithLabelValues(interfaceName(i), errorLabel(err)).Inc()
+}
+
+// SelectedRouteChanged implements babel.RouteHandler.
+func (m *Metrics) SelectedRouteChanged(proto.Prefix, *babel.Route) {
+	m.routeChanges.Inc()
+}
+
+// AcknowledgmentFailed implements babel.AcknowledgmentHandler.
+func (m *Metrics) AcknowledgmentFailed(n *babel.Neighbour, _ *babel.PendingAcknowledgment) {
+	m.ackFailures.WithLabelValues(interfaceName(n.Interface())).Inc()
+}
+
+func interfaceName(i *babel.Interface) string {
+	if i == nil || i.Interface == nil {
+		return ""
+	}
+
+	return i.Name
+}
+
+// errorLabel returns the message of the proto error wrapped by err.
+func errorLabel(err error) string {
+	for _, e := range decodingErrors {
+		if errors.Is(err, e) {
+			return e.Error()
+		}
+	}
+
+	return "other"
+}
+
+var (
+	_ babel.PacketHandler         = (*Metrics)(nil)
+	_ babel.RouteHandler          = (*Metrics)(nil)
+	_ babel.AcknowledgmentHandler = (*Metrics)(nil)
+)
@@ -0,0 +1,156 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package metrics_test
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+
+	babel "cunicu.li/go-babel"
+	"cunicu.li/go-babel/metrics"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Metrics suite")
+}
+
+var _ = Describe("Metrics", func() {
+	var reg *prometheus.Registry
+	var m *metrics.Metrics
+	var intf *babel.Interface
+
+	BeforeEach(func() {
+		var err error
+
+		reg = prometheus.NewRegistry()
+		m, err = metrics.New(reg)
+		Expect(err).To(Succeed())
+
+		intf = &babel.Interface{
+			Interface:  &net.Interface{Index: 1, Name: "eth0"},
+			Neighbours: babel.NewNeighbourTable(),
+		}
+	})
+
+	It("fails to register twice", func() {
+		_, err := metrics.New(reg)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("counts received packets and values", func() {
+		m.PacketReceived(intf, &proto.Packet{
+			Body: []proto.Value{
+				&proto.Hello{},
+				&proto.IHU{},
+				&proto.Hello{},
+			},
+		})
+
+		Expect(testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP babel_packets_received_total Number of received packets.
+# TYPE babel_packets_received_total counter
+babel_packets_received_total{interface="eth0"} 1
+# HELP babel_values_received_total Number of received TLVs by type.
+# TYPE babel_values_received_total counter
+babel_values_received_total{interface="eth0",type="Hello"} 2
+babel_values_received_total{interface="eth0",type="IHU"} 1
+`), "babel_packets_received_total", "babel_values_received_total")).To(Succeed())
+	})
+
+	It("counts sent packets and send errors", func() {
+		m.PacketSent(intf, []proto.Value{&proto.Update{}}, nil)
+		m.PacketSent(intf, []proto.Value{&proto.Update{}}, net.ErrClosed)
+
+		Expect(testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP babel_packets_sent_total Number of sent packets.
+# TYPE babel_packets_sent_total counter
+babel_packets_sent_total{interface="eth0"} 1
+# HELP babel_send_errors_total Number of packets which could not be sent.
+# TYPE babel_send_errors_total counter
+babel_send_errors_total{interface="eth0"} 1
+# HELP babel_values_sent_total Number of sent TLVs by type.
+# TYPE babel_values_sent_total counter
+babel_values_sent_total{interface="eth0",type="Update"} 1
+`), "babel_packets_sent_total", "babel_send_errors_total", "babel_values_sent_total")).To(Succeed())
+	})
+
+	It("counts decoding errors by proto error", func() {
+		m.PacketDecodingFailed(intf, fmt.Errorf("failed to parse: %w", proto.ErrInvalidLength))
+		m.PacketDecodingFailed(nil, proto.ErrTooShort)
+		m.PacketDecodingFailed(intf, net.ErrClosed)
+
+		Expect(testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP babel_decoding_errors_total Number of received packets which could not be decoded by error.
+# TYPE babel_decoding_errors_total counter
+babel_decoding_errors_total{error="buffer is too short",interface=""} 1
+babel_decoding_errors_total{error="invalid TLV length",interface="eth0"} 1
+babel_decoding_errors_total{error="other",interface="eth0"} 1
+`), "babel_decoding_errors_total")).To(Succeed())
+	})
+
+	It("counts selected route changes", func() {
+		m.SelectedRouteChanged(netip.MustParsePrefix("2001:db8::/64"), &babel.Route{})
+		m.SelectedRouteChanged(netip.MustParsePrefix("2001:db8::/64"), nil)
+
+		Expect(testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP babel_selected_route_changes_total Number of changes of selected routes.
+# TYPE babel_selected_route_changes_total counter
+babel_selected_route_changes_total 2
+`), "babel_selected_route_changes_total")).To(Succeed())
+	})
+
+	It("exports the state of the speaker", func() {
+		s := &babel.Speaker{
+			Interfaces: babel.NewInterfaceTable(),
+			Routes:     babel.NewRouteTable(),
+			Sources:    babel.NewSourceTable(),
+		}
+
+		s.Interfaces.Insert(intf)
+
+		src := &babel.Source{
+			Prefix:   netip.MustParsePrefix("2001:db8::/64"),
+			RouterID: proto.RouterID{1},
+		}
+
+		s.Sources.Insert(src)
+		s.Routes.Insert(&babel.Route{Source: src, Selected: true})
+
+		m.SetSpeaker(s)
+
+		Expect(testutil.CollectAndCompare(m, strings.NewReader(`
+# HELP babel_interface_neighbours Number of neighbours on the interface.
+# TYPE babel_interface_neighbours gauge
+babel_interface_neighbours{interface="eth0"} 0
+# HELP babel_interface_queue_length Number of values queued for multicast transmission on the interface.
+# TYPE babel_interface_queue_length gauge
+babel_interface_queue_length{interface="eth0"} 0
+# HELP babel_routes Number of entries in the route table.
+# TYPE babel_routes gauge
+babel_routes 1
+# HELP babel_selected_routes Number of selected routes in the route table.
+# TYPE babel_selected_routes gauge
+babel_selected_routes 1
+# HELP babel_sources Number of entries in the source table.
+# TYPE babel_sources gauge
+babel_sources 1
+`), "babel_interface_neighbours", "babel_interface_queue_length",
+			"babel_routes", "babel_selected_routes", "babel_sources")).To(Succeed())
+	})
+
+	It("passes the linter", func() {
+		problems, err := testutil.CollectAndLint(m)
+		Expect(err).To(Succeed())
+		Expect(problems).To(BeEmpty())
+	})
+})
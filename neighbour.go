@@ -8,6 +8,7 @@ import (
 	"math"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"cunicu.li/go-babel/internal/deadline"
@@ -17,6 +18,10 @@ import (
 	"cunicu.li/go-babel/proto"
 )
 
+// rttDecay is the weight (out of 256) of a new RTT sample in
+// the smoothed RTT of a neighbour. It matches the default of babeld.
+const rttDecay = 42
+
 // 3.2.4. The Neighbour Table
 // https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.4
 
@@ -27,8 +32,31 @@ type Neighbour struct {
 
 	Address proto.Address
 
+	// AddressIPv4 is the IPv4 address of the neighbour.
+	// It is learned from Next Hop TLVs and used as the
+	// next hop of IPv4 routes received from the neighbour
+	// (protected by speaker.mu).
+	AddressIPv4 proto.Address
+
+	// TxCost is the cost of the link to the neighbour which
+	// has been reported by its last IHU (protected by speaker.mu).
 	TxCost uint16
 
+	// lastCost is the cost of the link to the neighbour which has
+	// been announced by the last neighbour event (protected by speaker.mu).
+	lastCost  uint16
+	costKnown bool
+
+	// RTT is the smoothed round-trip time to the neighbour. It is
+	// measured using the timestamps of the RTT extension and zero
+	// until the first sample has been taken (protected by speaker.mu).
+	RTT time.Duration
+
+	// helloTimestamp holds the transmit timestamp of the last
+	// Hello received from the neighbour along with the time it
+	// has been received. It is echoed in our IHUs (protected by speaker.mu).
+	helloTimestamp *proto.TimestampIHU
+
 	helloUnicast   history.HelloHistory
 	helloMulticast history.HelloHistory
 
@@ -37,6 +65,18 @@ type Neighbour struct {
 	ihuTicker   *time.Ticker
 	helloTicker *time.Ticker
 	ihuTimeout  deadline.Deadline
+	closed      chan struct{}
+	closeOnce   sync.Once
+
+	PendingAcknowledgments PendingAcknowledgmentTable
+
+	// ackOpaque is the opaque value of the last acknowledgment
+	// request sent to the neighbour (protected by speaker.mu).
+	ackOpaque uint16
+
+	// advertised holds the routes which are currently advertised
+	// to the neighbour individually (protected by speaker.mu).
+	advertised map[advertisement]bool
 
 	queue *queue.Queue
 }
@@ -50,13 +90,16 @@ func (i *Interface) NewNeighbour(addr proto.Address) (*Neighbour, error) {
 	n := &Neighbour{
 		Address: addr,
 
-		queue: queue.NewQueue(i.MTU, &netx.PacketConnWriter{
+		queue: queue.NewQueue(i.MTU, i.speaker.config().UrgentTimeout, &netx.PacketConnWriter{
 			PacketConn: i.speaker.conn.PacketConn,
 			Dest:       neighbourAddr,
 		}),
 
+		PendingAcknowledgments: NewPendingAcknowledgmentTable(),
+
 		ihuTimeout: deadline.NewDeadline(),
-		ihuTicker:  time.NewTicker(i.speaker.config.IHUInterval),
+		ihuTicker:  time.NewTicker(i.speaker.config().IHUInterval),
+		closed:     make(chan struct{}),
 
 		intf: i,
 
@@ -65,21 +108,46 @@ func (i *Interface) NewNeighbour(addr proto.Address) (*Neighbour, error) {
 
 	// Only create unicast hello ticker, if its enabled.
 	// Otherwise, create a stopped ticker.
-	if interval := n.intf.speaker.config.UnicastHelloInterval; interval > 0 {
+	if interval := n.intf.speaker.config().UnicastHelloInterval; interval > 0 {
 		n.helloTicker = time.NewTicker(interval)
 	} else {
 		n.helloTicker = time.NewTicker(math.MaxInt64)
 		n.helloTicker.Stop()
 	}
 
+	n.queue.OnSent(i.onSent)
+
 	go n.runTimers()
 
 	return n, nil
 }
 
+// Close stops the timers and the queue of the neighbour.
+// Subsequent calls do nothing.
+func (n *Neighbour) Close() (err error) {
+	n.closeOnce.Do(func() {
+		err = n.close()
+	})
+
+	return err
+}
+
+func (n *Neighbour) close() error {
+	close(n.closed)
+
+	n.ihuTicker.Stop()
+	n.helloTicker.Stop()
+	n.ihuTimeout.Stop()
+
+	return n.queue.Close()
+}
+
 func (n *Neighbour) runTimers() {
 	for {
 		select {
+		case <-n.closed:
+			return
+
 		case <-n.helloTicker.C:
 			if err := n.sendUnicastHello(); err != nil {
 				n.logger.Error("Failed to send Hello", slog.Any("error", err))
@@ -92,36 +160,115 @@ func (n *Neighbour) runTimers() {
 
 		case <-n.ihuTimeout.C:
 			n.logger.Warn("IHU deadline missed")
-			n.TxCost = 0xFFFF
+			n.setTxCost(0xFFFF)
 		}
 	}
 }
 
 func (n *Neighbour) onUpdate(upd *proto.Update) {
+	n.intf.speaker.onUpdate(n, upd)
 }
 
-func (n *Neighbour) onHello(hello *proto.Hello) {
+func (n *Neighbour) onHello(hello *proto.Hello, rx time.Time) {
 	if isUnicast := hello.Flags&proto.FlagHelloUnicast != 0; isUnicast {
 		n.helloUnicast.Update(hello.Seqno)
 	} else {
 		n.helloMulticast.Update(hello.Seqno)
 	}
 
+	if hello.Timestamp != nil {
+		n.intf.speaker.mu.Lock()
+		n.helloTimestamp = &proto.TimestampIHU{
+			Origin:  hello.Timestamp.Transmit,
+			Receive: proto.TimestampFromTime(rx),
+		}
+		n.intf.speaker.mu.Unlock()
+	}
+
 	n.logger.Debug("Handled Hello", "rxcost", n.RxCost())
+
+	n.intf.speaker.updateNeighbourRoutes(n)
+}
+
+// onIHU handles an IHU received from the neighbour.
+// IHUs which are addressed to other nodes are ignored. IHUs using the
+// wildcard address encoding are only accepted if they have been sent unicast.
+// It returns true if the IHU has been accepted.
+//
+// See: 4.6.6. IHU
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.6.6
+func (n *Neighbour) onIHU(ihu *proto.IHU, unicast bool) bool {
+	if ihu.Address.IsUnspecified() {
+		if !unicast {
+			n.logger.Debug("Ignoring multicast IHU with wildcard address")
+			return false
+		}
+	} else if !n.intf.isLocalAddress(ihu.Address) {
+		n.logger.Debug("Ignoring IHU for other node", slog.Any("addr", ihu.Address))
+		return false
+	}
+
+	n.ihuTimeout.Reset(time.Duration(n.intf.speaker.config().IHUHoldTimeFactor * float32(ihu.Interval)))
+
+	n.logger.Debug("Handled IHU", "txcost", ihu.RxCost, "rxcost", n.RxCost())
+
+	n.setTxCost(ihu.RxCost)
+
+	return true
+}
+
+// onRTTSample updates the RTT to the neighbour from the timestamps of
+// an IHU and the Hello which has been received within the same packet:
+//
+//	RTT = (t4 - t1) - (t3 - t2)
+//
+// See: 3.3. Computing RTT samples
+// https://datatracker.ietf.org/doc/html/draft-ietf-babel-rtt-extension-00#section-3.3
+func (n *Neighbour) onRTTSample(ts *proto.TimestampIHU, hello *proto.TimestampHello, rx time.Time) {
+	// Timestamps wrap around. Hence, differences are
+	// interpreted as signed values.
+	local := int32(proto.TimestampFromTime(rx) - ts.Origin)
+	remote := int32(hello.Transmit - ts.Receive)
+
+	if local < 0 || remote < 0 || remote > local {
+		n.logger.Debug("Ignoring invalid RTT sample",
+			slog.Any("local", local),
+			slog.Any("remote", remote))
+		return
+	}
+
+	sample := time.Duration(local-remote) * time.Microsecond
+
+	s := n.intf.speaker
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n.RTT == 0 {
+		n.RTT = sample
+	} else {
+		n.RTT = (rttDecay*sample + (256-rttDecay)*n.RTT) / 256
+	}
 }
 
-func (n *Neighbour) onIHU(ihu *proto.IHU) {
-	n.ihuTimeout.Reset(time.Duration(n.intf.speaker.config.IHUHoldTimeFactor * float32(ihu.Interval)))
+// setTxCost updates the transmission cost of the link to the
+// neighbour and the metrics of the routes learned from it.
+func (n *Neighbour) setTxCost(txCost uint16) {
+	s := n.intf.speaker
 
-	n.TxCost = ihu.RxCost
+	s.mu.Lock()
+	n.TxCost = txCost
+	s.mu.Unlock()
 
-	n.logger.Debug("Handled IHU", "txcost", n.TxCost, "rxcost", n.RxCost(), "cost", n.Cost())
+	s.updateNeighbourRoutes(n)
 }
 
 func (n *Neighbour) onRouteRequest(rr *proto.RouteRequest) {
+	n.intf.speaker.onRouteRequest(n, rr)
 }
 
 func (n *Neighbour) onSeqnoRequest(sr *proto.SeqnoRequest) {
+	n.intf.speaker.onSeqnoRequest(n, sr)
 }
 
 func (n *Neighbour) onAcknowledgmentRequest(ar *proto.AcknowledgmentRequest) {
@@ -130,10 +277,33 @@ func (n *Neighbour) onAcknowledgmentRequest(ar *proto.AcknowledgmentRequest) {
 	}
 }
 
-func (n *Neighbour) onAcknowledgment(a *proto.Acknowledgment) {
+func (n *Neighbour) onNextHop(nh *proto.NextHop) {
+	s := n.intf.speaker
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if addr := nh.NextHop.Unmap(); addr.Is4() && addr != n.AddressIPv4 {
+		n.logger.Debug("Learned IPv4 address of neighbour",
+			slog.Any("addr", addr))
+
+		n.AddressIPv4 = addr
+	}
 }
 
 func (n *Neighbour) onPacket(pkt *proto.Packet, srcAddr, dstAddr proto.Address) error {
+	isUnicast := !dstAddr.IsMulticast()
+	rx := time.Now()
+
+	// The timestamp of a Hello is required to compute
+	// the RTT from an IHU which precedes it in the packet.
+	var helloTimestamp *proto.TimestampHello
+	for _, value := range pkt.Body {
+		if hello, ok := value.(*proto.Hello); ok && hello.Timestamp != nil {
+			helloTimestamp = hello.Timestamp
+		}
+	}
+
 	for _, value := range pkt.Body {
 		typ := proto.ValuesType(value).String()
 		n.logger.Debug("Received value",
@@ -148,13 +318,17 @@ func (n *Neighbour) onPacket(pkt *proto.Packet, srcAddr, dstAddr proto.Address)
 		case *proto.AcknowledgmentRequest:
 			n.onAcknowledgmentRequest(value)
 		case *proto.Hello:
-			n.onHello(value)
+			n.onHello(value, rx)
 		case *proto.IHU:
-			n.onIHU(value)
+			if n.onIHU(value, isUnicast) && value.Timestamp != nil && helloTimestamp != nil {
+				n.onRTTSample(value.Timestamp, helloTimestamp, rx)
+			}
 		case *proto.RouteRequest:
 			n.onRouteRequest(value)
 		case *proto.SeqnoRequest:
 			n.onSeqnoRequest(value)
+		case *proto.NextHop:
+			n.onNextHop(value)
 		}
 	}
 
@@ -164,41 +338,105 @@ func (n *Neighbour) onPacket(pkt *proto.Packet, srcAddr, dstAddr proto.Address)
 }
 
 func (n *Neighbour) sendUnicastHello() error {
-	n.outgoingUnicastHelloSeqNo++
+	interval := n.intf.speaker.config().UnicastHelloInterval
 
-	n.queue.SendValue(&proto.Hello{
-		Flags:    proto.FlagHelloUnicast,
-		Seqno:    n.outgoingUnicastHelloSeqNo,
-		Interval: n.intf.speaker.config.UnicastHelloInterval,
-	}, n.intf.speaker.config.UnicastHelloInterval*3/5)
+	n.queue.SendValue(n.unicastHello(interval), interval*3/5)
 
 	return nil
 }
 
-// TODO: Use function
-func (n *Neighbour) sendUnicastRouteRequest() error { //nolint:unused
-	n.queue.SendValue(&proto.RouteRequest{}, n.intf.speaker.config.MulticastHelloInterval/2)
+// unicastHello returns a new unicast Hello for the neighbour.
+// An interval of zero denotes an unscheduled Hello.
+func (n *Neighbour) unicastHello(interval time.Duration) *proto.Hello {
+	s := n.intf.speaker
+
+	s.mu.Lock()
+	n.outgoingUnicastHelloSeqNo++
+	seqno := n.outgoingUnicastHelloSeqNo
+	s.mu.Unlock()
+
+	return &proto.Hello{
+		Flags:     proto.FlagHelloUnicast,
+		Seqno:     seqno,
+		Interval:  interval,
+		Timestamp: &proto.TimestampHello{},
+	}
+}
+
+func (n *Neighbour) sendUpdate() error {
+	n.intf.speaker.mu.Lock()
+	defer n.intf.speaker.mu.Unlock()
+
+	upds := n.intf.speaker.selectedUpdates(n.intf, n)
+	if len(upds) == 0 {
+		return nil
+	}
+
+	n.logger.Debug("Sending unicast update",
+		slog.Any("neighbour", n.Address),
+		slog.Int("num_routes", len(upds)))
+
+	n.sendValues(upds, queue.PriorityBulk, n.intf.speaker.config().MulticastHelloInterval/2)
 
 	return nil
 }
 
 // TODO: Use function
-func (n *Neighbour) sendUnicastSeqnoRequest() error { //nolint:unused
-	n.queue.SendValue(&proto.SeqnoRequest{}, n.intf.speaker.config.MulticastHelloInterval/2)
+func (n *Neighbour) sendUnicastRouteRequest() error { //nolint:unused
+	n.queue.SendValue(&proto.RouteRequest{}, n.intf.speaker.config().MulticastHelloInterval/2)
 
 	return nil
 }
 
 func (n *Neighbour) sendIHU() error {
-	n.queue.SendValue(&proto.IHU{
-		RxCost:   n.RxCost(),
-		Address:  n.Address,
-		Interval: n.intf.speaker.config.IHUInterval,
-	}, n.intf.speaker.config.IHUInterval*3/5)
+	s := n.intf.speaker
+
+	s.mu.Lock()
+	ts := n.helloTimestamp
+	s.mu.Unlock()
+
+	ihu := &proto.IHU{
+		RxCost:    n.RxCost(),
+		Address:   n.Address,
+		Interval:  s.config().IHUInterval,
+		Timestamp: ts,
+	}
+
+	maxDelay := s.config().IHUInterval * 3 / 5
+
+	if ts == nil {
+		n.queue.SendValue(ihu, maxDelay)
+		return nil
+	}
+
+	// The neighbour requires the transmit timestamp of a
+	// Hello within the same packet to compute the RTT.
+	n.queue.SendBundle([]proto.Value{n.unicastHello(0), ihu}, queue.PriorityOf(ihu), maxDelay)
 
 	return nil
 }
 
+// Interface returns the interface on which the neighbour has been discovered.
+func (n *Neighbour) Interface() *Interface {
+	return n.intf
+}
+
+// QueueLength returns the number of values queued for unicast transmission.
+func (n *Neighbour) QueueLength() int {
+	if n.queue == nil {
+		return 0
+	}
+
+	return n.queue.Len()
+}
+
+func (n *Neighbour) sendValues(vs []proto.Value, prio queue.Priority, maxDelay time.Duration) {
+	if vs := n.intf.filterOutput(vs, n); len(vs) > 0 {
+		n.supersedePendingAcknowledgments(vs)
+		n.queue.SendValuesWithPriority(n.intf.withNextHops(vs), prio, maxDelay)
+	}
+}
+
 func (n *Neighbour) sendAcknowledgment(opaque uint16, interval time.Duration) error {
 	n.queue.SendValue(&proto.Acknowledgment{
 		Opaque: opaque,
@@ -211,7 +449,7 @@ func (n *Neighbour) sendAcknowledgment(opaque uint16, interval time.Duration) er
 // See: https://datatracker.ietf.org/doc/html/rfc8966#section-a.2.1
 func (n *Neighbour) RxCost() uint16 {
 	if n.helloUnicast.OutOf(2, 3) || n.helloMulticast.OutOf(2, 3) {
-		return n.intf.speaker.config.NominalLinkCost
+		return n.intf.rxCost()
 	} else {
 		return 0xFFFF
 	}
@@ -24,8 +24,16 @@ func (t *NeighbourTable) Insert(n *Neighbour) {
 	(*table.Table[proto.Address, *Neighbour])(t).Insert(n.Address, n)
 }
 
+func (t *NeighbourTable) Remove(n *Neighbour) {
+	(*table.Table[proto.Address, *Neighbour])(t).Remove(n.Address)
+}
+
 func (t *NeighbourTable) Foreach(cb func(*Neighbour) error) error {
 	return (*table.Table[proto.Address, *Neighbour])(t).ForEach(func(k netip.Addr, v *Neighbour) error {
 		return cb(v)
 	})
 }
+
+func (t *NeighbourTable) Len() int {
+	return (*table.Table[proto.Address, *Neighbour])(t).Len()
+}
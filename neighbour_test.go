@@ -0,0 +1,431 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"math"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"cunicu.li/go-babel/internal/deadline"
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+// valueWriter collects the values of all packets written by a queue.
+type valueWriter struct {
+	values []proto.Value
+	mu     sync.Mutex
+}
+
+func (w *valueWriter) Write(b []byte) (int, error) {
+	_, pkt, err := proto.NewParser().Packet(b)
+	if err != nil {
+		return 0, err
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.values = append(w.values, pkt.Body...)
+
+	return len(b), nil
+}
+
+func (w *valueWriter) Values() []proto.Value {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return append([]proto.Value{}, w.values...)
+}
+
+// Updates returns the collected Updates.
+func (w *valueWriter) Updates() []*proto.Update {
+	upds := []*proto.Update{}
+	for _, v := range w.Values() {
+		if upd, ok := v.(*proto.Update); ok {
+			upds = append(upds, upd)
+		}
+	}
+
+	return upds
+}
+
+type ackHandler struct {
+	failed []*PendingAcknowledgment
+}
+
+func (h *ackHandler) AcknowledgmentFailed(_ *Neighbour, ack *PendingAcknowledgment) {
+	h.failed = append(h.failed, ack)
+}
+
+var _ = Context("Neighbour", func() {
+	Describe("IHU handling", func() {
+		var n *Neighbour
+
+		// The loopback interface has a well-known address
+		local := netip.MustParseAddr("127.0.0.1")
+		other := netip.MustParseAddr("127.0.0.2")
+
+		multicast := MulticastGroupIPv6
+		unicast := netip.MustParseAddr("fe80::2")
+
+		ihu := func(addr proto.Address, rxCost uint16) *proto.IHU {
+			return &proto.IHU{
+				RxCost:   rxCost,
+				Interval: time.Minute,
+				Address:  addr,
+			}
+		}
+
+		BeforeEach(func() {
+			lo, err := net.InterfaceByName("lo")
+			if err != nil {
+				Skip("No loopback interface")
+			}
+
+			node := newSimNode(0)
+			node.intf.Interface = lo
+
+			n = &Neighbour{
+				Address:    netip.MustParseAddr("fe80::1"),
+				TxCost:     0xFFFF,
+				ihuTimeout: deadline.NewDeadline(),
+				intf:       node.intf,
+				logger:     node.logger,
+			}
+		})
+
+		AfterEach(func() {
+			n.ihuTimeout.Stop()
+		})
+
+		It("accepts IHUs for a local address", func() {
+			n.onIHU(ihu(local, 100), false)
+			Expect(n.TxCost).To(BeNumerically("==", 100))
+		})
+
+		It("ignores IHUs for other nodes", func() {
+			n.onIHU(ihu(other, 100), false)
+			Expect(n.TxCost).To(BeNumerically("==", 0xFFFF))
+		})
+
+		It("accepts unicast IHUs with wildcard address", func() {
+			n.onIHU(ihu(netip.IPv6Unspecified(), 100), true)
+			Expect(n.TxCost).To(BeNumerically("==", 100))
+		})
+
+		It("ignores multicast IHUs with wildcard address", func() {
+			n.onIHU(ihu(netip.IPv6Unspecified(), 100), false)
+			Expect(n.TxCost).To(BeNumerically("==", 0xFFFF))
+		})
+
+		It("only applies the IHU addressed to us in packets with multiple IHUs", func() {
+			pkt := &proto.Packet{
+				Body: []proto.Value{
+					ihu(other, 200),
+					ihu(local, 100),
+					ihu(netip.MustParseAddr("fe80::3"), 300),
+					ihu(netip.IPv6Unspecified(), 400),
+				},
+			}
+
+			Expect(n.onPacket(pkt, n.Address, multicast)).To(Succeed())
+			Expect(n.TxCost).To(BeNumerically("==", 100))
+
+			Expect(n.onPacket(pkt, n.Address, unicast)).To(Succeed())
+			Expect(n.TxCost).To(BeNumerically("==", 400))
+		})
+
+		It("holds IHUs for a multiple of their interval", func() {
+			n.onIHU(ihu(local, 100), false)
+			Consistently(n.ihuTimeout.Expired, 50*time.Millisecond).Should(BeFalse())
+		})
+
+		It("caches the addresses of the interface", func() {
+			n.onIHU(ihu(local, 100), false)
+			Expect(n.TxCost).To(BeNumerically("==", 100))
+
+			// Querying the addresses of a non-existent interface fails
+			n.intf.Interface = &net.Interface{Index: 0xFFFF, Name: "none"}
+
+			n.onIHU(ihu(local, 200), false)
+			Expect(n.TxCost).To(BeNumerically("==", 200))
+
+			n.intf.addrsExpires = time.Time{}
+
+			n.onIHU(ihu(local, 300), false)
+			Expect(n.TxCost).To(BeNumerically("==", 200))
+		})
+	})
+
+	Describe("Acknowledgments", func() {
+		var n *Neighbour
+		var w *valueWriter
+		var h *ackHandler
+
+		retraction := &proto.Update{
+			Interval: time.Second,
+			Metric:   proto.Retraction,
+			Prefix:   netip.MustParsePrefix("2001:db8::/32"),
+		}
+
+		// pending returns the single pending acknowledgment request
+		pending := func() *PendingAcknowledgment {
+			var ack *PendingAcknowledgment
+			n.PendingAcknowledgments.Foreach(func(a *PendingAcknowledgment) error { //nolint:errcheck
+				ack = a
+				return nil
+			})
+			return ack
+		}
+
+		BeforeEach(func() {
+			w = &valueWriter{}
+			h = &ackHandler{}
+
+			node := newSimNode(0)
+			node.config().Handler = h
+
+			n = &Neighbour{
+				Address:                netip.MustParseAddr("fe80::1"),
+				PendingAcknowledgments: NewPendingAcknowledgmentTable(),
+				queue:                  queue.NewQueue(1400, node.config().UrgentTimeout, w),
+				intf:                   node.intf,
+				logger:                 node.logger,
+			}
+
+			n.sendReliableValues([]proto.Value{retraction}, time.Millisecond)
+		})
+
+		AfterEach(func() {
+			Expect(n.queue.Close()).To(Succeed())
+		})
+
+		It("sends values along with an acknowledgment request", func() {
+			Eventually(w.Values).Should(HaveLen(2))
+
+			vs := w.Values()
+			Expect(vs[0]).To(BeAssignableToTypeOf(&proto.AcknowledgmentRequest{}))
+			Expect(vs[1].(*proto.Update).Prefix).To(Equal(retraction.Prefix))
+
+			ack := pending()
+			Expect(ack).NotTo(BeNil())
+			Expect(vs[0].(*proto.AcknowledgmentRequest).Opaque).To(Equal(ack.Opaque))
+		})
+
+		It("uses unique opaque values", func() {
+			other := *retraction
+			other.Prefix = netip.MustParsePrefix("2001:db9::/32")
+
+			n.sendReliableValues([]proto.Value{&other}, time.Millisecond)
+			Expect(n.PendingAcknowledgments.Len()).To(Equal(2))
+		})
+
+		It("wraps around opaque values", func() {
+			n.ackOpaque = math.MaxUint16
+
+			n.sendReliableValues([]proto.Value{retraction}, time.Millisecond)
+
+			_, ok := n.PendingAcknowledgments.Lookup(0)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("counts opaque values per neighbour", func() {
+			m := &Neighbour{
+				Address:                netip.MustParseAddr("fe80::2"),
+				PendingAcknowledgments: NewPendingAcknowledgmentTable(),
+				queue:                  queue.NewQueue(1400, n.intf.speaker.config().UrgentTimeout, &valueWriter{}),
+				intf:                   n.intf,
+				logger:                 n.logger,
+			}
+			defer m.queue.Close() //nolint:errcheck
+
+			m.sendReliableValues([]proto.Value{retraction}, time.Millisecond)
+
+			_, ok := m.PendingAcknowledgments.Lookup(pending().Opaque)
+			Expect(ok).To(BeTrue())
+		})
+
+		It("stops tracking acknowledged requests", func() {
+			Eventually(w.Values).Should(HaveLen(2))
+
+			n.onAcknowledgment(&proto.Acknowledgment{Opaque: pending().Opaque})
+			Expect(n.PendingAcknowledgments.Len()).To(BeZero())
+
+			n.resendAcknowledgmentRequests(time.Now().Add(time.Hour))
+			Consistently(w.Values, 50*time.Millisecond).Should(HaveLen(2))
+		})
+
+		It("ignores unexpected acknowledgments", func() {
+			n.onAcknowledgment(&proto.Acknowledgment{Opaque: pending().Opaque + 1})
+			Expect(n.PendingAcknowledgments.Len()).To(Equal(1))
+		})
+
+		It("resends unacknowledged values with back-off", func() {
+			interval := pending().Interval
+
+			n.resendAcknowledgmentRequests(time.Now())
+			Expect(pending().Resent).To(BeZero())
+
+			n.resendAcknowledgmentRequests(pending().Expires.Add(time.Millisecond))
+			Expect(pending().Resent).To(Equal(1))
+			Expect(pending().Interval).To(Equal(2 * interval))
+
+			Eventually(w.Values).Should(HaveLen(4))
+		})
+
+		It("does not resend retractions superseded by an update", func() {
+			Eventually(w.Values).Should(HaveLen(2))
+
+			upd := *retraction
+			upd.Metric = 256
+
+			n.sendValues([]proto.Value{&upd}, queue.PriorityNormal, time.Millisecond)
+			Eventually(w.Values).Should(HaveLen(3))
+			Expect(n.PendingAcknowledgments.Len()).To(BeZero())
+
+			n.resendAcknowledgmentRequests(time.Now().Add(time.Hour))
+			Consistently(w.Values, 50*time.Millisecond).Should(HaveLen(3))
+		})
+
+		It("replaces pending values for the same prefix", func() {
+			upd := *retraction
+			upd.Metric = 256
+
+			n.sendReliableValues([]proto.Value{&upd}, time.Millisecond)
+			Expect(n.PendingAcknowledgments.Len()).To(Equal(1))
+			Expect(pending().Values[0].(*proto.Update).Metric).To(BeNumerically("==", 256))
+		})
+
+		It("reports a failure after the last resend", func() {
+			for i := 0; i <= maxAcknowledgmentResends; i++ {
+				Expect(h.failed).To(BeEmpty())
+				n.resendAcknowledgmentRequests(pending().Expires.Add(time.Millisecond))
+			}
+
+			Expect(h.failed).To(HaveLen(1))
+			Expect(h.failed[0].Values).To(HaveLen(1))
+			Expect(n.PendingAcknowledgments.Len()).To(BeZero())
+		})
+	})
+
+	Describe("RTT", func() {
+		var n *Neighbour
+		var w *valueWriter
+
+		unicast := netip.MustParseAddr("fe80::2")
+
+		// packet returns a packet with an IHU which echoes a Hello sent at
+		// sent and a Hello which has been sent remote after its reception.
+		packet := func(sent time.Time, remote time.Duration) *proto.Packet {
+			return &proto.Packet{
+				Body: []proto.Value{
+					&proto.IHU{
+						RxCost:   100,
+						Interval: time.Minute,
+						Address:  netip.IPv6Unspecified(),
+						Timestamp: &proto.TimestampIHU{
+							Origin:  proto.TimestampFromTime(sent),
+							Receive: 1000,
+						},
+					},
+					&proto.Hello{
+						Flags: proto.FlagHelloUnicast,
+						Seqno: 1,
+						Timestamp: &proto.TimestampHello{
+							Transmit: 1000 + proto.Timestamp(remote.Microseconds()),
+						},
+					},
+				},
+			}
+		}
+
+		BeforeEach(func() {
+			w = &valueWriter{}
+
+			node := newSimNode(0)
+
+			n = &Neighbour{
+				Address:    netip.MustParseAddr("fe80::1"),
+				TxCost:     0xFFFF,
+				ihuTimeout: deadline.NewDeadline(),
+				queue:      queue.NewQueue(1400, node.config().UrgentTimeout, w),
+				intf:       node.intf,
+				logger:     node.logger,
+			}
+		})
+
+		AfterEach(func() {
+			n.ihuTimeout.Stop()
+			Expect(n.queue.Close()).To(Succeed())
+		})
+
+		It("computes the RTT from the timestamps of an IHU and a Hello", func() {
+			pkt := packet(time.Now().Add(-30*time.Millisecond), 10*time.Millisecond)
+
+			Expect(n.onPacket(pkt, n.Address, unicast)).To(Succeed())
+			Expect(n.RTT).To(BeNumerically("~", 20*time.Millisecond, 5*time.Millisecond))
+			Expect(n.snapshot().RTT).To(Equal(n.RTT))
+		})
+
+		It("smooths RTT samples", func() {
+			n.RTT = 10 * time.Millisecond
+
+			pkt := packet(time.Now().Add(-30*time.Millisecond), 10*time.Millisecond)
+
+			Expect(n.onPacket(pkt, n.Address, unicast)).To(Succeed())
+			Expect(n.RTT).To(BeNumerically("~", 10*time.Millisecond+10*time.Millisecond*rttDecay/256, time.Millisecond))
+		})
+
+		It("ignores samples with inconsistent timestamps", func() {
+			pkt := packet(time.Now().Add(-10*time.Millisecond), 20*time.Millisecond)
+
+			Expect(n.onPacket(pkt, n.Address, unicast)).To(Succeed())
+			Expect(n.RTT).To(BeZero())
+		})
+
+		It("echoes the timestamp of the last Hello in IHUs", func() {
+			pkt := &proto.Packet{
+				Body: []proto.Value{
+					&proto.Hello{
+						Seqno:     1,
+						Interval:  time.Second,
+						Timestamp: &proto.TimestampHello{Transmit: 1234},
+					},
+				},
+			}
+
+			Expect(n.onPacket(pkt, n.Address, MulticastGroupIPv6)).To(Succeed())
+			Expect(n.sendIHU()).To(Succeed())
+			Expect(n.queue.Flush()).To(Succeed())
+
+			vs := w.Values()
+			Expect(vs).To(HaveLen(2))
+
+			// The IHU is accompanied by an unscheduled Hello with a timestamp
+			hello := vs[0].(*proto.Hello)
+			Expect(hello.Flags & proto.FlagHelloUnicast).NotTo(BeZero())
+			Expect(hello.Interval).To(BeZero())
+			Expect(hello.Timestamp).NotTo(BeNil())
+
+			ihu := vs[1].(*proto.IHU)
+			Expect(ihu.Timestamp).NotTo(BeNil())
+			Expect(ihu.Timestamp.Origin).To(BeNumerically("==", 1234))
+		})
+
+		It("sends IHUs without timestamps until a Hello has been received", func() {
+			Expect(n.sendIHU()).To(Succeed())
+			Expect(n.queue.Flush()).To(Succeed())
+
+			vs := w.Values()
+			Expect(vs).To(HaveLen(1))
+			Expect(vs[0].(*proto.IHU).Timestamp).To(BeNil())
+		})
+	})
+})
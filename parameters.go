@@ -22,32 +22,97 @@ type Parameters struct {
 	UpdateInterval         time.Duration
 	UrgentTimeout          time.Duration
 	NominalLinkCost        uint16
+
+	// MetricSmoothingHalfLife is the half-life of the exponential
+	// smoothing applied to route metrics before route selection.
+	// A value of zero disables smoothing.
+	MetricSmoothingHalfLife time.Duration
+
+	// AcknowledgmentTimeout is the initial time after which values
+	// sent reliably are resent if they have not been acknowledged.
+	AcknowledgmentTimeout time.Duration
+
+	// DiversityFactor scales the cost of links which do not interfere
+	// with the remaining path of a route in units of 1/256.
+	// A value of zero disables diversity routing.
+	DiversityFactor uint16
 }
 
 const (
 	DefaultIHUInterval            = 12 * time.Second // 3 * DefaultMulticastHelloInterval
 	DefaultInitialRequestTimeout  = 2 * time.Second
 	DefaultMulticastHelloInterval = 4 * time.Second
-	DefaultRouteExpiryTime        = 56 // 3.5 * DefaultUpdateInterval
+	DefaultRouteExpiryTime        = 56 * time.Second // 3.5 * DefaultUpdateInterval
 	DefaultSourceGCTime           = 3 * time.Minute
 	DefaultUnicastHelloInterval   = 0                // infinitive, no Hellos are send
 	DefaultUpdateInterval         = 16 * time.Second // 4 * DefaultMulticastHelloInterval
 	DefaultUrgentTimeout          = 200 * time.Millisecond
 
+	DefaultMetricSmoothingHalfLife = 4 * time.Second // as used by babeld
+	DefaultAcknowledgmentTimeout   = 1 * time.Second
+
 	DefaultIHUHoldTimeFactor = 3.5 // times the advertised IHU interval
 	DefaultWiredLinkCost     = 96
+	DefaultWirelessLinkCost  = 256 // as used by babeld
 )
 
 var DefaultParameters = Parameters{
-	MulticastHelloInterval: DefaultMulticastHelloInterval,
-	UnicastHelloInterval:   DefaultUnicastHelloInterval,
-	UpdateInterval:         DefaultUpdateInterval,
-	IHUInterval:            DefaultIHUInterval,
-	RouteExpiryTime:        DefaultRouteExpiryTime,
-	InitialRequestTimeout:  DefaultInitialRequestTimeout,
-	UrgentTimeout:          DefaultUrgentTimeout,
-	SourceGCTime:           DefaultSourceGCTime,
-	NominalLinkCost:        DefaultWiredLinkCost, // TODO: estimated using ETX on wireless links; 2-out-of-3 with C=96 on wired links.
+	MulticastHelloInterval:  DefaultMulticastHelloInterval,
+	UnicastHelloInterval:    DefaultUnicastHelloInterval,
+	UpdateInterval:          DefaultUpdateInterval,
+	IHUInterval:             DefaultIHUInterval,
+	RouteExpiryTime:         DefaultRouteExpiryTime,
+	InitialRequestTimeout:   DefaultInitialRequestTimeout,
+	UrgentTimeout:           DefaultUrgentTimeout,
+	SourceGCTime:            DefaultSourceGCTime,
+	MetricSmoothingHalfLife: DefaultMetricSmoothingHalfLife,
+	IHUHoldTimeFactor:       DefaultIHUHoldTimeFactor,
+	AcknowledgmentTimeout:   DefaultAcknowledgmentTimeout,
+	NominalLinkCost:         DefaultWiredLinkCost, // TODO: estimated using ETX on wireless links; 2-out-of-3 with C=96 on wired links.
+}
+
+// setDefaults replaces unset parameters by their defaults.
+// Parameters for which zero is a valid value are kept.
+func (p *Parameters) setDefaults() {
+	if p.IHUHoldTimeFactor == 0 {
+		p.IHUHoldTimeFactor = DefaultIHUHoldTimeFactor
+	}
+
+	if p.IHUInterval == 0 {
+		p.IHUInterval = DefaultIHUInterval
+	}
+
+	if p.InitialRequestTimeout == 0 {
+		p.InitialRequestTimeout = DefaultInitialRequestTimeout
+	}
+
+	if p.MulticastHelloInterval == 0 {
+		p.MulticastHelloInterval = DefaultMulticastHelloInterval
+	}
+
+	if p.RouteExpiryTime == 0 {
+		p.RouteExpiryTime = DefaultRouteExpiryTime
+	}
+
+	if p.SourceGCTime == 0 {
+		p.SourceGCTime = DefaultSourceGCTime
+	}
+
+	if p.UpdateInterval == 0 {
+		p.UpdateInterval = DefaultUpdateInterval
+	}
+
+	if p.UrgentTimeout == 0 {
+		p.UrgentTimeout = DefaultUrgentTimeout
+	}
+
+	if p.NominalLinkCost == 0 {
+		p.NominalLinkCost = DefaultWiredLinkCost
+	}
+
+	if p.AcknowledgmentTimeout == 0 {
+		p.AcknowledgmentTimeout = DefaultAcknowledgmentTimeout
+	}
 }
 
 // 5. IANA Considerations
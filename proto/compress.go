@@ -5,7 +5,6 @@ package proto
 
 import (
 	"net"
-	"net/netip"
 )
 
 func AddressFrom(addr net.Addr) Address {
@@ -47,66 +46,32 @@ func addressFamilyFromAddressEncoding(ae AddressEncoding) AddressFamily {
 	}
 }
 
-// TODO: Use function
-//
-//nolint:unused
-func decompressIPv4(b []byte, o int, p netip.Addr) netip.Addr {
-	c := [4]byte{}
-
-	q := p.As4()
-	for i := 0; i < o; i++ {
-		c[i] = q[i]
-	}
-
-	for i := 0; i < 4; i++ {
-		c[i+o] = b[i]
-	}
-
-	return netip.AddrFrom4(c)
-}
-
-// TODO: Use function
-//
-//nolint:unused
-func decompressIPv6(b []byte, o int, p netip.Addr) netip.Addr {
-	c := [16]byte{}
-
-	q := p.As16()
-	for i := 0; i < o; i++ {
-		c[i] = q[i]
-	}
-
-	for i := 0; i < 16; i++ {
-		c[i+o] = b[i]
+// addressOctets returns the octets of an address as they are encoded on the wire.
+func addressOctets(a Address) []byte {
+	s := a.AsSlice()
+	if a.Is4In6() {
+		return s[12:]
 	}
 
-	return netip.AddrFrom16(c)
+	return s
 }
 
-// TODO: Use function
-//
-//nolint:unused
-func compressIPv4(b []byte, a netip.Addr, p netip.Addr) int {
-	r := a.As4()
-	s := p.As4()
-
-	o := 0
-	for r[o] == s[o] && o <= 4 {
-		o++
+// isCompressible checks if an address encoding allows the
+// omission of octets which are shared with the default prefix.
+func isCompressible(ae AddressEncoding) bool {
+	switch ae {
+	case AddressEncodingIPv4, AddressEncodingIPv6, AddressEncodingIPv4inIPv6:
+		return true
+	default:
+		return false
 	}
-
-	return o
 }
 
-// TODO: Use function
-//
-//nolint:unused
-func compressIPv6(b []byte, a netip.Addr, p netip.Addr) int {
-	r := a.As16()
-	s := p.As16()
-
+// commonOctets returns the number of leading octets which
+// are shared by a and b, but at most maxOctets.
+func commonOctets(a, b []byte, maxOctets int) int {
 	o := 0
-	for r[o] == s[o] && o <= 16 {
+	for o < maxOctets && o < len(a) && o < len(b) && a[o] == b[o] {
 		o++
 	}
 
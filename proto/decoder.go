@@ -0,0 +1,162 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+// Decoder decodes packets without allocating memory once it has
+// warmed up. Unlike a Parser, it reuses the parser state as well as
+// the storage of the decoded packet and its values across packets.
+// Hence, a decoded packet and its values are only valid until the next
+// call to Packet. Values which are retained afterwards must be copied.
+// This includes the slices referenced by the values like the channels
+// of Updates, the payload of unknown TLVs and sub-TLVs.
+//
+// A Decoder must not be used concurrently.
+type Decoder struct {
+	Parser
+
+	pkt Packet
+}
+
+// NewDecoder creates a new decoder.
+func NewDecoder() *Decoder {
+	d := &Decoder{
+		Parser: *NewParser(),
+		pkt: Packet{
+			Body:    []Value{},
+			Trailer: []Value{},
+		},
+	}
+
+	d.reuse = true
+	d.values.bytes = []byte{}
+
+	return d
+}
+
+// Packet attempts to decode a packet from the provided buffer.
+// It returns a advanced buffer slice starting at the end
+// of the parsed packet. The returned packet is only valid
+// until the next call to Packet.
+func (d *Decoder) Packet(b []byte) ([]byte, *Packet, error) {
+	d.values.reset()
+
+	clear(d.pkt.Body)
+	clear(d.pkt.Trailer)
+
+	d.pkt.Body = d.pkt.Body[:0]
+	d.pkt.Trailer = d.pkt.Trailer[:0]
+
+	b, err := d.packet(b, &d.pkt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, &d.pkt, nil
+}
+
+// slab holds values of a single type which are reused across packets.
+type slab[V any] struct {
+	values []*V
+	used   int
+}
+
+// next returns the next unused value of the slab after zeroing it.
+func (s *slab[V]) next() *V {
+	if s.used == len(s.values) {
+		s.values = append(s.values, new(V))
+	}
+
+	v := s.values[s.used]
+	s.used++
+
+	var zero V
+	*v = zero
+
+	return v
+}
+
+// arena holds the storage of all values decoded from a packet.
+type arena struct {
+	pad1s           slab[Pad1]
+	padNs           slab[PadN]
+	ackReqs         slab[AcknowledgmentRequest]
+	acks            slab[Acknowledgment]
+	hellos          slab[Hello]
+	ihus            slab[IHU]
+	routerIDs       slab[RouterIDValue]
+	nextHops        slab[NextHop]
+	updates         slab[Update]
+	routeReqs       slab[RouteRequest]
+	seqnoReqs       slab[SeqnoRequest]
+	unknowns        slab[UnknownValue]
+	timestampsHello slab[TimestampHello]
+	timestampsIHU   slab[TimestampIHU]
+	sourcePrefixes  slab[Prefix]
+
+	bytes     []byte     // Backs the byte slices of values.
+	subValues []SubValue // Backs the sub-TLVs of values.
+}
+
+// reset marks all values of the arena as unused.
+func (a *arena) reset() {
+	a.pad1s.used = 0
+	a.padNs.used = 0
+	a.ackReqs.used = 0
+	a.acks.used = 0
+	a.hellos.used = 0
+	a.ihus.used = 0
+	a.routerIDs.used = 0
+	a.nextHops.used = 0
+	a.updates.used = 0
+	a.routeReqs.used = 0
+	a.seqnoReqs.used = 0
+	a.unknowns.used = 0
+	a.timestampsHello.used = 0
+	a.timestampsIHU.used = 0
+	a.sourcePrefixes.used = 0
+
+	clear(a.subValues)
+
+	a.bytes = a.bytes[:0]
+	a.subValues = a.subValues[:0]
+}
+
+// newValue returns a new zeroed value. It is taken from the arena
+// if the parser reuses values, otherwise it is freshly allocated.
+func newValue[V any](p *Parser, s *slab[V]) *V {
+	if !p.reuse {
+		return new(V)
+	}
+
+	return s.next()
+}
+
+// copyBytes returns a copy of b. It is backed by the arena
+// if the parser reuses values, otherwise it is freshly allocated.
+func copyBytes(p *Parser, b []byte) []byte {
+	if !p.reuse {
+		return append([]byte{}, b...)
+	}
+
+	a := &p.values
+	o := len(a.bytes)
+	a.bytes = append(a.bytes, b...)
+
+	return a.bytes[o:len(a.bytes):len(a.bytes)]
+}
+
+// appendSubValue appends the sub-TLV sv to svs. If the parser reuses
+// values, svs is backed by the arena. As the sub-TLVs of a TLV are
+// decoded in a row, svs is always the tail of the arena's sub-TLVs.
+func appendSubValue(p *Parser, svs []SubValue, sv SubValue) []SubValue {
+	if !p.reuse {
+		return append(svs, sv)
+	}
+
+	a := &p.values
+	o := len(a.subValues) - len(svs)
+	a.subValues = append(a.subValues, sv)
+
+	return a.subValues[o:len(a.subValues):len(a.subValues)]
+}
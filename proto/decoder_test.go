@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var benchSrc = netip.MustParseAddr("fe80::1")
+
+const (
+	typeBench    ValueType = 0xd0
+	subTypeBench ValueType = 0x71
+)
+
+// benchSubValue is an extension sub-TLV whose
+// decoding does not allocate memory by itself.
+type benchSubValue uint8
+
+func init() {
+	if err := RegisterSubValue(subTypeBench, Codec[benchSubValue]{
+		Name: "Bench",
+		Decode: func(p *Parser, b []byte) (benchSubValue, error) {
+			_, v, err := p.uint8(b)
+			return benchSubValue(v), err
+		},
+		Append: func(p *Parser, b []byte, v benchSubValue) []byte {
+			return p.appendUint8(b, uint8(v))
+		},
+		Length: func(*Parser, benchSubValue) int {
+			return 1
+		},
+	}); err != nil {
+		panic(err)
+	}
+}
+
+// benchPacket returns an encoded packet as sent periodically
+// by a speaker: a Hello, an IHU and a full routing table dump.
+// Some Updates carry channels and extension sub-TLVs. An unknown
+// TLV is appended as well.
+func benchPacket(numUpdates int) []byte {
+	rid := RouterID{1, 2, 3, 4, 5, 6, 7, 8}
+
+	vs := []Value{
+		&Hello{
+			Seqno:    1,
+			Interval: 4 * time.Second,
+		},
+		&IHU{
+			RxCost:   96,
+			Interval: 12 * time.Second,
+			Address:  netip.MustParseAddr("fe80::3"),
+			Timestamp: &TimestampIHU{
+				Origin:  1,
+				Receive: 2,
+			},
+		},
+		&AcknowledgmentRequest{
+			Opaque:   1,
+			Interval: time.Second,
+		},
+		&RouterIDValue{
+			RouterID: rid,
+		},
+		&NextHop{
+			NextHop: netip.MustParseAddr("192.0.2.1"),
+		},
+	}
+
+	for i := range numUpdates {
+		vs = append(vs, &Update{
+			Flags:    FlagUpdatePrefix,
+			Interval: 16 * time.Second,
+			Seqno:    uint16(i),
+			Metric:   256,
+			Prefix:   netip.MustParsePrefix(fmt.Sprintf("2001:db8:%x::/48", i)),
+		}, &Update{
+			Interval: 16 * time.Second,
+			Seqno:    uint16(i),
+			Metric:   256,
+			Prefix:   netip.MustParsePrefix(fmt.Sprintf("10.%d.0.0/16", i%256)),
+		}, &Update{
+			Interval:  16 * time.Second,
+			Seqno:     uint16(i),
+			Metric:    256,
+			Prefix:    netip.MustParsePrefix(fmt.Sprintf("2001:db8:%x:1::/64", i)),
+			Channels:  []uint8{1, 6, 11},
+			SubValues: []SubValue{benchSubValue(1), benchSubValue(2)},
+		})
+	}
+
+	vs = append(vs, &UnknownValue{
+		Type:    typeBench,
+		Payload: []byte{1, 2, 3, 4},
+	})
+
+	return NewParser().AppendPacket(nil, &Packet{Body: vs})
+}
+
+var _ = Describe("Decoder", func() {
+	var d *Decoder
+	var b []byte
+
+	BeforeEach(func() {
+		d = NewDecoder()
+		d.Init(benchSrc)
+
+		b = benchPacket(16)
+	})
+
+	It("decodes packets like a parser", func() {
+		_, expected, err := NewPacketParser(benchSrc).Packet(b)
+		Expect(err).To(Succeed())
+
+		for range 2 {
+			_, pkt, err := d.Packet(b)
+			Expect(err).To(Succeed())
+			Expect(pkt).To(Equal(expected))
+		}
+	})
+
+	It("reuses values across packets", func() {
+		_, pkt, err := d.Packet(b)
+		Expect(err).To(Succeed())
+
+		hello := pkt.Body[0]
+
+		_, pkt, err = d.Packet(NewParser().AppendPacket(nil, &Packet{
+			Body: []Value{
+				&Hello{Seqno: 2},
+			},
+		}))
+		Expect(err).To(Succeed())
+		Expect(pkt.Body).To(HaveLen(1))
+		Expect(pkt.Body[0]).To(BeIdenticalTo(hello))
+		Expect(hello.(*Hello).Seqno).To(BeNumerically("==", 2))
+	})
+
+	It("resets the parser state for each packet", func() {
+		d.SetInitialNextHop(netip.MustParseAddr("192.0.2.2"))
+		d.Init(benchSrc)
+
+		Expect(d.InitialNextHop).To(Equal(map[AddressFamily]Address{
+			AddressFamilyIPv6: benchSrc,
+		}))
+	})
+
+	It("does not allocate", func() {
+		allocs := testing.AllocsPerRun(100, func() {
+			d.Packet(b) //nolint:errcheck
+		})
+
+		Expect(allocs).To(BeZero())
+	})
+})
+
+func BenchmarkParser(b *testing.B) {
+	buf := benchPacket(32)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+
+	for b.Loop() {
+		p := NewPacketParser(benchSrc)
+		if _, _, err := p.Packet(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	buf := benchPacket(32)
+	d := NewDecoder()
+
+	// The decoder must not allocate once it has warmed up
+	if allocs := testing.AllocsPerRun(10, func() {
+		d.Init(benchSrc)
+		d.Packet(buf) //nolint:errcheck
+	}); allocs > 0 {
+		b.Fatalf("Decoder allocates %.0f times per packet", allocs)
+	}
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+
+	for b.Loop() {
+		d.Init(benchSrc)
+		if _, _, err := d.Packet(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
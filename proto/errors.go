@@ -3,7 +3,10 @@
 
 package proto
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrInvalidLength                = errors.New("invalid TLV length")
@@ -20,4 +23,27 @@ var (
 	ErrUnsupportedValue             = errors.New("value is not supported")
 	ErrUnsupportedButMandatoryValue = errors.New("value is not supported but mandatory")
 	ErrInvalidValueForTrailer       = errors.New("value is not supported in packet trailer")
+	ErrInvalidTrailer               = errors.New("invalid packet trailer")
+
+	// Errors for violations of RFC 8966 which are only detected by strict parsers.
+	ErrZeroInterval        = errors.New("interval must not be zero")
+	ErrZeroHopCount        = errors.New("hop count must not be zero")
+	ErrInvalidPrefixLength = errors.New("prefix length exceeds address encoding")
+	ErrInvalidPrefixBits   = errors.New("prefix has bits set beyond its length")
 )
+
+// ValueError is returned for a TLV which could not be decoded.
+// It identifies the TLV by its type and offset.
+type ValueError struct {
+	Type   ValueType
+	Offset int // The offset of the TLV from the start of the packet.
+	Err    error
+}
+
+func (e *ValueError) Error() string {
+	return fmt.Sprintf("invalid %s TLV at offset %d: %v", e.Type, e.Offset, e.Err)
+}
+
+func (e *ValueError) Unwrap() error {
+	return e.Err
+}
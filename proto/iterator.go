@@ -0,0 +1,142 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+import (
+	"iter"
+)
+
+// RawValue is a TLV of a packet as yielded by Parser.PacketValues.
+// The TLV is only decoded once requested by Decode.
+type RawValue struct {
+	Type    ValueType
+	Trailer bool   // The TLV is part of the packet trailer.
+	Offset  int    // The offset of the TLV from the start of the packet.
+	Raw     []byte // The encoded TLV including its header.
+
+	parser  *Parser
+	payload []byte
+
+	decoded bool
+	value   Value
+	err     error
+}
+
+// Decode decodes the TLV. It returns an error if the TLV is invalid.
+//
+// TLVs which alter the parser state (Router-ID, Next Hop and Update)
+// are decoded during the iteration already as subsequent Updates
+// depend on the state. All other TLVs are decoded on demand. Decode
+// must not be called after the parser has been used for another packet.
+func (v *RawValue) Decode() (Value, error) {
+	if !v.decoded {
+		v.value, v.err = v.parser.decodePayload(v.Type, v.payload)
+		v.decoded = true
+	}
+
+	return v.value, v.err
+}
+
+// PacketValues returns an iterator over the TLVs of the packet body
+// and trailer in the provided buffer. In contrast to Packet, it does
+// not stop at the first invalid TLV. Instead, the TLVs are yielded
+// without decoding them and callers decode only the TLVs they are
+// interested in (see RawValue.Decode). The iteration only yields an
+// error and stops if the packet header is invalid or the length of a
+// TLV exceeds the packet. TLVs which are not allowed in the trailer
+// are yielded together with ErrInvalidValueForTrailer.
+//
+// The parser must not be used otherwise during the iteration
+// as its state is required to decode subsequent Updates.
+//
+// See also: 4.2. Packet Format
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.2
+func (p *Parser) PacketValues(b []byte) iter.Seq2[RawValue, error] {
+	return func(yield func(RawValue, error) bool) {
+		// The parser state is always reset at the boundary of a packet
+		p.Reset()
+
+		r, bodyLength, err := p.packetHeader(b)
+		if err != nil {
+			yield(RawValue{decoded: true, err: err}, err)
+			return
+		}
+
+		// Truncated packets are decoded as far as possible
+		truncated := len(r) < bodyLength
+		if truncated {
+			bodyLength = len(r)
+		}
+
+		o := len(b) - len(r)
+		if !p.yieldValues(r[:bodyLength], o, false, yield) {
+			return
+		}
+
+		if truncated {
+			yield(RawValue{Offset: len(b), decoded: true, err: ErrTooShort}, ErrTooShort)
+			return
+		}
+
+		p.yieldValues(r[bodyLength:], o+bodyLength, true, yield)
+	}
+}
+
+// yieldValues delimits the TLVs in the buffer which starts at
+// offset o of the packet and yields them. It returns false
+// if the iteration has been stopped.
+func (p *Parser) yieldValues(b []byte, o int, trailer bool, yield func(RawValue, error) bool) bool {
+	for len(b) > 0 {
+		t, n, payload, err := p.nextRawValue(b, trailer)
+		if n == 0 {
+			yield(RawValue{
+				Type:    t,
+				Trailer: trailer,
+				Offset:  o,
+				Raw:     b,
+				decoded: true,
+				err:     err,
+			}, err)
+			return false
+		}
+
+		v := RawValue{
+			Type:    t,
+			Trailer: trailer,
+			Offset:  o,
+			Raw:     b[:n],
+			parser:  p,
+			payload: payload,
+		}
+
+		if err != nil {
+			v.decoded = true
+			v.err = err
+		} else if alterParserState(t) {
+			v.Decode() //nolint:errcheck
+		}
+
+		if !yield(v, err) {
+			return false
+		}
+
+		b = b[n:]
+		o += n
+	}
+
+	return true
+}
+
+// alterParserState checks whether TLVs of type t alter the parser state.
+//
+// See: 4.5. Parser State and Encoding of Updates
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.5
+func alterParserState(t ValueType) bool {
+	switch t {
+	case TypeRouterID, TypeNextHop, TypeUpdate:
+		return true
+	default:
+		return false
+	}
+}
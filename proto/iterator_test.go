@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Iterator", func() {
+	var p *Parser
+
+	type result struct {
+		RawValue
+		err error
+
+		value     Value
+		decodeErr error
+	}
+
+	collect := func(b []byte) []result {
+		rs := []result{}
+		for v, err := range p.PacketValues(b) {
+			value, decodeErr := v.Decode()
+			rs = append(rs, result{v, err, value, decodeErr})
+		}
+		return rs
+	}
+
+	// packet encodes a packet with a body and a raw trailer.
+	packet := func(trailer []byte, vs ...Value) []byte {
+		b := NewParser().AppendPacket(nil, &Packet{Body: vs})
+		return append(b, trailer...)
+	}
+
+	BeforeEach(func() {
+		p = NewParser()
+	})
+
+	It("yields all values of a packet", func() {
+		vs := []Value{
+			&Hello{Seqno: 1, Interval: time.Second},
+			&RouterIDValue{RouterID: RouterID{1, 2, 3, 4, 5, 6, 7, 8}},
+			&Update{
+				Flags:    FlagUpdatePrefix,
+				Interval: time.Second,
+				Prefix:   netip.MustParsePrefix("2001:db8:1::/48"),
+			},
+			&Update{
+				Interval: time.Second,
+				Prefix:   netip.MustParsePrefix("2001:db8:2::/48"),
+			},
+		}
+
+		b := packet(nil, vs...)
+
+		_, pkt, err := NewParser().Packet(b)
+		Expect(err).To(Succeed())
+
+		rs := collect(b)
+		Expect(rs).To(HaveLen(len(vs)))
+
+		o := PacketHeaderLength
+		for i, r := range rs {
+			Expect(r.err).To(Succeed())
+			Expect(r.decodeErr).To(Succeed())
+			Expect(r.Type).To(Equal(ValuesType(vs[i])))
+			Expect(r.Trailer).To(BeFalse())
+			Expect(r.Offset).To(Equal(o))
+			Expect(r.value).To(Equal(pkt.Body[i]))
+
+			o += len(r.Raw)
+		}
+
+		Expect(o).To(Equal(len(b)))
+	})
+
+	It("yields values of the trailer", func() {
+		b := packet([]byte{
+			byte(TypePadN), 2, 0, 0,
+			byte(TypeHello), 6, 0, 0, 0, 1, 0, 100,
+			byte(TypePad1),
+		}, &Hello{Seqno: 1})
+
+		rs := collect(b)
+		Expect(rs).To(HaveLen(4))
+
+		Expect(rs[0].Trailer).To(BeFalse())
+		Expect(rs[1].Trailer).To(BeTrue())
+		Expect(rs[1].value).To(Equal(&PadN{N: 2}))
+		Expect(rs[1].Raw).To(Equal([]byte{byte(TypePadN), 2, 0, 0}))
+		Expect(rs[2].Trailer).To(BeTrue())
+		Expect(rs[2].value).To(BeNil())
+		Expect(rs[2].err).To(MatchError(ErrInvalidValueForTrailer))
+		Expect(rs[2].decodeErr).To(MatchError(ErrInvalidValueForTrailer))
+		Expect(rs[3].Type).To(Equal(TypePad1))
+		Expect(rs[3].Raw).To(HaveLen(1))
+		Expect(rs[3].err).To(Succeed())
+	})
+
+	It("continues after invalid values", func() {
+		b := packet(nil,
+			&Hello{Seqno: 1},
+			&UnknownValue{Type: TypeRouterID, Payload: make([]byte, 10)},
+			&Hello{Seqno: 2},
+		)
+
+		rs := collect(b)
+		Expect(rs).To(HaveLen(3))
+
+		Expect(rs[0].err).To(Succeed())
+		Expect(rs[1].Type).To(Equal(TypeRouterID))
+		Expect(rs[1].Raw).To(HaveLen(ValueHeaderLength + 10))
+		Expect(rs[1].err).To(Succeed())
+		Expect(rs[1].value).To(BeNil())
+		Expect(rs[1].decodeErr).To(MatchError(ErrInvalidRouterID))
+		Expect(rs[2].err).To(Succeed())
+		Expect(rs[2].value).To(Equal(&Hello{Seqno: 2}))
+	})
+
+	It("decodes values only on demand", func() {
+		b := packet(nil,
+			&UnknownValue{Type: TypeHello, Payload: make([]byte, 2)},
+			&RouterIDValue{RouterID: RouterID{1, 2, 3, 4, 5, 6, 7, 8}},
+			&Update{
+				Interval: time.Second,
+				Prefix:   netip.MustParsePrefix("2001:db8:1::/48"),
+			},
+		)
+
+		var upd Value
+		for v, err := range p.PacketValues(b) {
+			Expect(err).To(Succeed())
+
+			// The invalid Hello and the Router-ID are not decoded
+			if v.Type == TypeUpdate {
+				upd, err = v.Decode()
+				Expect(err).To(Succeed())
+			}
+		}
+
+		// The Router-ID has been applied to the parser state nonetheless
+		Expect(upd).To(HaveField("RouterID", RouterID{1, 2, 3, 4, 5, 6, 7, 8}))
+	})
+
+	It("stops at values exceeding the packet", func() {
+		b := packet(nil, &Hello{Seqno: 1}, &Hello{Seqno: 2})
+
+		// Increase the length of the first Hello TLV
+		b[PacketHeaderLength+1] = 100
+
+		rs := collect(b)
+		Expect(rs).To(HaveLen(1))
+		Expect(rs[0].Offset).To(Equal(PacketHeaderLength))
+		Expect(rs[0].err).To(MatchError(ErrTooShort))
+	})
+
+	It("decodes truncated packets as far as possible", func() {
+		b := packet(nil, &Hello{Seqno: 1}, &Hello{Seqno: 2})
+		binary.BigEndian.PutUint16(b[2:], uint16(len(b)))
+
+		rs := collect(b)
+		Expect(rs).To(HaveLen(3))
+		Expect(rs[0].err).To(Succeed())
+		Expect(rs[1].err).To(Succeed())
+		Expect(rs[2].Offset).To(Equal(len(b)))
+		Expect(rs[2].err).To(MatchError(ErrTooShort))
+	})
+
+	It("fails for invalid packet headers", func() {
+		rs := collect([]byte{PacketHeaderMagic, 1, 0, 0})
+		Expect(rs).To(HaveLen(1))
+		Expect(rs[0].err).To(MatchError(ErrUnsupportedVersion))
+	})
+
+	It("stops when the caller breaks", func() {
+		b := packet(nil, &Hello{Seqno: 1}, &Hello{Seqno: 2})
+
+		n := 0
+		for range p.PacketValues(b) {
+			n++
+			break
+		}
+
+		Expect(n).To(Equal(1))
+	})
+})
@@ -6,6 +6,7 @@ package proto
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"math"
 	"net"
 	"net/netip"
@@ -22,19 +23,79 @@ type Parser struct {
 	CurrentDefaultPrefix map[AddressEncoding]Address
 	CurrentNextHop       map[AddressFamily]Address
 	CurrentRouterID      RouterID
+
+	// InitialNextHop contains the next hops per address family
+	// which are used to initialize the parser state at the start of
+	// each packet. These are usually the source address of the packet
+	// as well as a previously learned IPv4 address of the sender.
+	InitialNextHop map[AddressFamily]Address
+
+	// Strict enables the validation of decoded TLVs against the
+	// requirements of RFC 8966 which are tolerated otherwise:
+	// zero intervals of Acknowledgment Requests and IHUs, zero hop
+	// counts of Seqno Requests, bits set beyond the prefix length and
+	// invalid packet trailers. TLVs with prefix lengths exceeding the
+	// address encoding are rejected by strict parsers and dropped otherwise.
+	Strict bool
+
+	// reuse enables the reuse of decoded values from the arena (see Decoder).
+	reuse  bool
+	values arena
 }
 
 func NewParser() *Parser {
-	p := &Parser{}
+	p := &Parser{
+		CurrentDefaultPrefix: map[AddressEncoding]Address{},
+		CurrentNextHop:       map[AddressFamily]Address{},
+		InitialNextHop:       map[AddressFamily]Address{},
+	}
 	p.Reset()
 	return p
 }
 
+// NewPacketParser creates a new parser for decoding a packet
+// which has been received from src.
+// The source address is used as the implicit next hop of updates.
+func NewPacketParser(src Address) *Parser {
+	p := NewParser()
+	p.Init(src)
+	return p
+}
+
+// Init prepares the parser for decoding a packet which has been
+// received from src. Next hops which have been set previously by
+// SetInitialNextHop are discarded.
+// This allows for reusing a parser for multiple packets.
+func (p *Parser) Init(src Address) {
+	clear(p.InitialNextHop)
+
+	p.Reset()
+	p.SetInitialNextHop(src)
+}
+
+// SetInitialNextHop sets the next hop of updates for the address family
+// of the provided address in case it is not given by a Next Hop TLV.
+func (p *Parser) SetInitialNextHop(addr Address) {
+	if !addr.IsValid() || addr.IsUnspecified() {
+		return
+	}
+
+	addr = addr.Unmap()
+	af := addressFamilyFromAddressEncoding(addressEncoding(&addr))
+
+	p.InitialNextHop[af] = addr
+	p.CurrentNextHop[af] = addr
+}
+
 // Reset resets the internal parser state
 func (p *Parser) Reset() {
-	p.CurrentDefaultPrefix = map[AddressEncoding]Address{}
-	p.CurrentNextHop = map[AddressFamily]Address{}
+	clear(p.CurrentDefaultPrefix)
+	clear(p.CurrentNextHop)
 	p.CurrentRouterID = RouterIDUnspecified
+
+	for af, nh := range p.InitialNextHop {
+		p.CurrentNextHop[af] = nh
+	}
 }
 
 // Packet
@@ -60,41 +121,76 @@ func (p *Parser) PacketLength(pkt *Packet) uint16 {
 // It returns a advanced buffer slice starting at the end
 // of the parsed packet.
 func (p *Parser) Packet(b []byte) ([]byte, *Packet, error) {
+	pkt := &Packet{
+		Body:    []Value{},
+		Trailer: []Value{},
+	}
+
+	b, err := p.packet(b, pkt)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b, pkt, nil
+}
+
+// packet decodes a packet by appending its values to
+// the body and trailer of the provided packet.
+func (p *Parser) packet(b []byte, pkt *Packet) ([]byte, error) {
+	// The parser state is always reset at the boundary of a packet
+	p.Reset()
+
+	b, bodyLength, err := p.packetHeader(b)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) < bodyLength {
+		return nil, ErrTooShort
+	}
+
+	body, err := p.appendDecodedValues(pkt.Body, b[:bodyLength], PacketHeaderLength, false)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bytes following the packet body are decoded as packet trailer.
+	// An invalid trailer is ignored unless the parser is strict.
+	trailer, err := p.appendDecodedValues(pkt.Trailer, b[bodyLength:], PacketHeaderLength+bodyLength, true)
+	if err != nil && p.Strict {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidTrailer, err)
+	} else if err == nil {
+		pkt.Trailer = trailer
+	}
+
+	pkt.Body = body
+
+	return b[len(b):], nil
+}
+
+// packetHeader decodes the header of a packet and returns its body length.
+func (p *Parser) packetHeader(b []byte) ([]byte, int, error) {
 	var err error
 	var magic, version uint8
 	var bodyLength uint16
 
-	pkt := &Packet{}
-
 	if b, magic, err = p.uint8(b); err != nil {
-		return nil, nil, err
+		return nil, 0, err
 	} else if magic != PacketHeaderMagic {
-		return nil, nil, ErrInvalidMagic
+		return nil, 0, ErrInvalidMagic
 	}
 
 	if b, version, err = p.uint8(b); err != nil {
-		return nil, nil, err
+		return nil, 0, err
 	} else if version != PacketHeaderVersion {
-		return nil, nil, ErrUnsupportedVersion
+		return nil, 0, ErrUnsupportedVersion
 	}
 
 	if b, bodyLength, err = p.uint16(b); err != nil {
-		return nil, nil, err
-	}
-
-	if len(b) < int(bodyLength) {
-		return nil, nil, ErrTooShort
-	}
-
-	if b, pkt.Body, err = p.Values(b, false); err != nil {
-		return nil, nil, err
-	}
-
-	if b, pkt.Trailer, err = p.Values(b, true); err != nil {
-		return nil, nil, err
+		return nil, 0, err
 	}
 
-	return b, pkt, nil
+	return b, int(bodyLength), nil
 }
 
 // AppendPacket encodes a packet by appending it to the provided
@@ -105,12 +201,13 @@ func (p *Parser) AppendPacket(b []byte, pkt *Packet) []byte {
 
 	b = p.appendPacketHeader(b)
 	b = p.AppendValues(b, pkt.Body)
-	b = p.AppendValues(b, pkt.Trailer)
 
 	// Fill in body length
 	bodyLength := len(b) - o - PacketHeaderLength
 	binary.BigEndian.PutUint16(b[o+2:], uint16(bodyLength))
 
+	b = p.AppendValues(b, pkt.Trailer)
+
 	return b
 }
 
@@ -192,40 +289,50 @@ func (p *Parser) ValueLength(v Value) (l int) {
 	case *PadN:
 		l += v.N
 	case *AcknowledgmentRequest:
-		l += 6
+		l += 6 + p.subValuesLength(v.SubValues)
 	case *Acknowledgment:
-		l += 2
+		l += 2 + p.subValuesLength(v.SubValues)
 	case *Hello:
-		l += 6
+		l += 6 + p.subValuesLength(v.SubValues)
 		if v.Timestamp != nil {
 			l += ValueHeaderLength + 4
 		}
 	case *IHU:
-		l += 6 + p.addressLength(v.Address)
+		l += 6 + p.addressLength(v.Address) + p.subValuesLength(v.SubValues)
 		if v.Timestamp != nil {
 			l += ValueHeaderLength + 2*4
 		}
 	case *RouterIDValue:
-		l += 2 + 8
+		l += 2 + 8 + p.subValuesLength(v.SubValues)
 	case *NextHop:
-		l += 2 + p.addressLength(v.NextHop)
+		l += 2 + p.addressLength(v.NextHop) + p.subValuesLength(v.SubValues)
 	case *Update:
-		l += 10 + p.prefixLength(v.Prefix, true)
+		l += 10 + p.prefixLength(v.Prefix, true) + p.subValuesLength(v.SubValues)
 		if v.SourcePrefix != nil {
 			l += ValueHeaderLength + 1 + p.prefixLength(*v.SourcePrefix, false)
 		}
+		if len(v.Channels) > 0 {
+			l += ValueHeaderLength + len(v.Channels)
+		}
 	case *RouteRequest:
-		l += 2 + p.prefixLength(v.Prefix, false)
+		l += 2 + p.prefixLength(v.Prefix, false) + p.subValuesLength(v.SubValues)
 		if v.SourcePrefix != nil {
 			l += ValueHeaderLength + 1 + p.prefixLength(*v.SourcePrefix, false)
 		}
 	case *SeqnoRequest:
-		l += 14 + p.prefixLength(v.Prefix, false)
+		l += 14 + p.prefixLength(v.Prefix, false) + p.subValuesLength(v.SubValues)
 		if v.SourcePrefix != nil {
 			l += ValueHeaderLength + 1 + p.prefixLength(*v.SourcePrefix, false)
 		}
+	case *UnknownValue:
+		l += len(v.Payload)
 	default:
-		panic(ErrUnsupportedValue)
+		c := lookupCodecOf(v, false)
+		if c == nil {
+			panic(ErrUnsupportedValue)
+		}
+
+		l += c.length(p, v)
 	}
 
 	return l
@@ -270,7 +377,12 @@ func (p *Parser) valuePayload(t ValueType, b []byte) ([]byte, Value, error) {
 	case TypeSeqnoRequest:
 		return p.seqnoRequest(b)
 	default:
-		return nil, nil, ErrUnsupportedValue
+		if c := lookupCodec(t, false); c != nil {
+			v, err := c.decode(p, b)
+			return nil, v, err
+		}
+
+		return p.unknownValue(t, b)
 	}
 }
 
@@ -318,8 +430,19 @@ func (p *Parser) AppendValue(b []byte, v Value) []byte {
 		return p.appendValueHeader(b, TypeSeqnoRequest, func(b []byte) []byte {
 			return p.appendSeqnoRequest(b, v)
 		})
+	case *UnknownValue:
+		return p.appendValueHeader(b, v.Type, func(b []byte) []byte {
+			return append(b, v.Payload...)
+		})
 	default:
-		panic(ErrInvalidValueType)
+		c := lookupCodecOf(v, false)
+		if c == nil {
+			panic(ErrInvalidValueType)
+		}
+
+		return p.appendValueHeader(b, c.typ, func(b []byte) []byte {
+			return c.append(p, b, v)
+		})
 	}
 }
 
@@ -387,14 +510,52 @@ func (p *Parser) forEachValue(b []byte, cb func(t ValueType, b []byte) ([]byte,
 	return b, nil
 }
 
-func (p *Parser) forEachSubValue(b []byte, cb func(t ValueType, b []byte) ([]byte, error)) ([]byte, error) {
+// forEachSubValue invokes the callback for each sub-TLV following the
+// body of a TLV. The callback may be nil for TLVs without known sub-TLVs.
+// Sub-TLVs of registered types which are not handled by the callback
+// are appended to svs. Padding is skipped. Unsupported sub-TLVs are
+// silently skipped unless they are mandatory. In this case
+// ErrUnsupportedButMandatoryValue is returned and the enclosing TLV
+// must be ignored.
+//
+// See also: 4.4. Sub-TLV Format
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.4
+func (p *Parser) forEachSubValue(b []byte, svs *[]SubValue, cb func(t ValueType, b []byte) ([]byte, error)) ([]byte, error) {
 	return p.forEachValue(b, func(t ValueType, b []byte) ([]byte, error) {
 		var err error
-		if b, err = cb(t, b); err != nil {
-			if errors.Is(err, ErrUnsupportedValue) && t.IsMandatory() {
-				return nil, ErrUnsupportedButMandatoryValue
-			} else {
+
+		payload := b
+
+		switch {
+		case t == SubTypePad1 || t == SubTypePadN:
+			return nil, nil
+		case cb == nil:
+			err = ErrUnsupportedValue
+		default:
+			b, err = cb(t, b)
+		}
+
+		if errors.Is(err, ErrUnsupportedValue) {
+			if c := lookupCodec(t, true); c != nil {
+				sv, err := p.decodeRegistered(c, payload)
+				if err != nil {
+					return nil, err
+				}
+
+				*svs = appendSubValue(p, *svs, sv)
+
+				return nil, nil
+			}
+		}
+
+		if err != nil {
+			switch {
+			case !errors.Is(err, ErrUnsupportedValue):
 				return nil, err
+			case t.IsMandatory():
+				return nil, ErrUnsupportedButMandatoryValue
+			default:
+				return nil, nil
 			}
 		}
 
@@ -402,23 +563,93 @@ func (p *Parser) forEachSubValue(b []byte, cb func(t ValueType, b []byte) ([]byt
 	})
 }
 
+// Values decodes all TLVs from the provided buffer.
+// TLVs which carry an unsupported mandatory sub-TLV are silently ignored.
+// So are TLVs with an invalid prefix length unless the parser is strict.
+// Errors are returned as *ValueError with offsets relative to the buffer.
 func (p *Parser) Values(b []byte, trailer bool) ([]byte, []Value, error) {
-	var err error
-	vs := []Value{}
-	if b, err = p.forEachValue(b, func(t ValueType, b []byte) ([]byte, error) {
-		if trailer && !t.IsTrailerType() {
-			return nil, ErrInvalidValueForTrailer
-		} else if b, v, err := p.valuePayload(t, b); err != nil {
-			return nil, err
-		} else {
+	vs, err := p.appendDecodedValues([]Value{}, b, 0, trailer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return b[len(b):], vs, nil
+}
+
+// appendDecodedValues decodes all TLVs from the provided buffer which
+// starts at offset o of the packet and appends them to vs.
+func (p *Parser) appendDecodedValues(vs []Value, b []byte, o int, trailer bool) ([]Value, error) {
+	for len(b) > 0 {
+		t, n, v, err := p.nextValue(b, trailer)
+		if err != nil && !p.isIgnored(err) {
+			return nil, &ValueError{
+				Type:   t,
+				Offset: o,
+				Err:    err,
+			}
+		} else if err == nil {
 			vs = append(vs, v)
-			return b, nil
 		}
-	}); err != nil {
-		return nil, nil, err
+
+		b = b[n:]
+		o += n
+	}
+
+	return vs, nil
+}
+
+// isIgnored checks if a TLV which could not be decoded due
+// to err is dropped rather than failing the whole packet.
+func (p *Parser) isIgnored(err error) bool {
+	return errors.Is(err, ErrUnsupportedButMandatoryValue) ||
+		(!p.Strict && errors.Is(err, ErrInvalidPrefixLength))
+}
+
+// nextValue decodes the next TLV from the buffer. It returns the type
+// and the encoded length of the TLV. The length is zero if the TLV
+// exceeds the buffer and hence no further TLVs can be decoded.
+func (p *Parser) nextValue(b []byte, trailer bool) (ValueType, int, Value, error) {
+	t, n, payload, err := p.nextRawValue(b, trailer)
+	if err != nil {
+		return t, n, nil, err
+	}
+
+	v, err := p.decodePayload(t, payload)
+
+	return t, n, v, err
+}
+
+// nextRawValue delimits the next TLV in the buffer without decoding it.
+// It returns the type, the encoded length and the payload of the TLV.
+// Like for nextValue, the length is zero if the TLV exceeds the buffer.
+func (p *Parser) nextRawValue(b []byte, trailer bool) (ValueType, int, []byte, error) {
+	r, t, l, err := p.valueHeader(b)
+	if err != nil {
+		return t, 0, nil, err
+	} else if len(r) < l {
+		return t, 0, nil, ErrTooShort
+	}
+
+	n := len(b) - len(r) + l
+
+	if trailer && !t.IsTrailerType() {
+		return t, n, nil, ErrInvalidValueForTrailer
 	}
 
-	return b, vs, nil
+	return t, n, r[:l], nil
+}
+
+// decodePayload decodes the payload of a TLV of type t
+// which must be consumed entirely.
+func (p *Parser) decodePayload(t ValueType, b []byte) (Value, error) {
+	r, v, err := p.valuePayload(t, b)
+	if err != nil {
+		return nil, err
+	} else if len(r) > 0 {
+		return nil, ErrTooLong
+	}
+
+	return v, nil
 }
 
 func (p *Parser) AppendValues(b []byte, vs []Value) []byte {
@@ -488,7 +719,11 @@ func (p *Parser) addressLength(addr Address) int {
 	}
 }
 
-func (p *Parser) address(b []byte, ae AddressEncoding, omitted uint8, plen int8) ([]byte, Address, error) {
+func (p *Parser) address(b []byte, ae AddressEncoding, omitted uint8, plen int) ([]byte, Address, error) {
+	if omitted > 0 && !isCompressible(ae) {
+		return nil, Address{}, ErrCompressionNotAllowed
+	}
+
 	switch ae {
 	case AddressEncodingWildcard:
 		return b, netip.IPv6Unspecified(), nil
@@ -509,16 +744,26 @@ func (p *Parser) address(b []byte, ae AddressEncoding, omitted uint8, plen int8)
 			rplen = uint8(plen)
 		}
 
-		blen := rplen/8 - omitted
+		if rplen > alen*8 || omitted > alen {
+			return nil, Address{}, ErrInvalidAddress
+		}
+
+		blen := int(rplen/8) - int(omitted)
 		if rplen%8 != 0 { // Round upwards
 			blen++
 		}
 
-		if len(b) < int(blen) {
+		if blen < 0 {
+			return nil, Address{}, ErrInvalidAddress
+		} else if len(b) < blen {
 			return nil, Address{}, ErrTooShort
 		}
 
-		abuf := make([]byte, alen)
+		// The address is assembled in the trailing octets of an
+		// IPv6 address to avoid allocations. IPv4 addresses use
+		// the last four octets just like IPv4in6 mapped addresses.
+		var abuf [net.IPv6len]byte
+		o := net.IPv6len - int(alen)
 
 		if omitted > 0 {
 			dpfx, ok := p.CurrentDefaultPrefix[ae]
@@ -526,54 +771,55 @@ func (p *Parser) address(b []byte, ae AddressEncoding, omitted uint8, plen int8)
 				return nil, Address{}, ErrMissingDefaultPrefix
 			}
 
-			copy(abuf[0:], dpfx.AsSlice()[:omitted])
+			d := dpfx.As16()
+			copy(abuf[o:], d[o:o+int(omitted)])
 		}
 
-		copy(abuf[omitted:], b[:blen])
+		copy(abuf[o+int(omitted):], b[:blen])
 
 		// If plen is not a multiple of 8, then any bits beyond plen
 		// (i.e., the low-order (8 - plen % 8) bits of the last octet) are cleared
 		if mod := rplen % 8; mod != 0 {
-			mask := math.MaxUint8 << (8 - mod)
-			abuf[rplen/8] &= uint8(mask)
-		}
+			mask := uint8(math.MaxUint8 << (8 - mod))
+			last := &abuf[o+int(rplen/8)]
 
-		// Parse the IPv4in6 mapped address as an IPv6 ipnet.Addr.
-		if ae == AddressEncodingIPv4inIPv6 {
-			bbuf := make([]byte, net.IPv6len)
-			copy(bbuf, []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff})
-			copy(bbuf[12:], abuf)
+			// Strict parsers reject these bits unless the
+			// last octet has been taken from the default prefix.
+			if p.Strict && blen > 0 && *last&^mask != 0 {
+				return nil, Address{}, ErrInvalidPrefixBits
+			}
 
-			abuf = bbuf
+			*last &= mask
 		}
 
-		if a, ok := netip.AddrFromSlice(abuf); !ok {
-			return nil, Address{}, ErrInvalidAddress
-		} else {
-			return b[blen:], a, nil
+		switch ae {
+		case AddressEncodingIPv4:
+			return b[blen:], netip.AddrFrom4([4]byte(abuf[o:])), nil
+
+		case AddressEncodingIPv4inIPv6:
+			// Parse the IPv4in6 mapped address as an IPv6 ipnet.Addr.
+			abuf[10], abuf[11] = 0xff, 0xff
 		}
 
+		return b[blen:], netip.AddrFrom16(abuf), nil
+
 	case AddressEncodingIPv6LinkLocal:
 		if len(b) < 8 {
 			return nil, Address{}, ErrTooShort
 		}
 
-		abuf := make([]byte, 16)
-		copy(abuf[0:], []byte{0xfe, 0x80})
+		var abuf [net.IPv6len]byte
+		abuf[0], abuf[1] = 0xfe, 0x80
 		copy(abuf[8:], b[:8])
 
-		if a, ok := netip.AddrFromSlice(abuf); !ok {
-			return nil, Address{}, ErrInvalidAddress
-		} else {
-			return b[8:], a, nil
-		}
+		return b[8:], netip.AddrFrom16(abuf), nil
 
 	default:
 		return nil, Address{}, ErrInvalidAddress
 	}
 }
 
-func (p *Parser) appendAddress(b []byte, addr Address, plen int8) ([]byte, AddressEncoding) {
+func (p *Parser) appendAddress(b []byte, addr Address, omitted uint8, plen int) ([]byte, AddressEncoding) {
 	ae := addressEncoding(&addr)
 
 	switch ae {
@@ -607,7 +853,7 @@ func (p *Parser) appendAddress(b []byte, addr Address, plen int8) ([]byte, Addre
 			blen++
 		}
 
-		return append(b, a[:blen]...), ae
+		return append(b, a[omitted:blen]...), ae
 	default:
 		panic(ErrInvalidAddress)
 	}
@@ -617,39 +863,110 @@ func (p *Parser) appendAddress(b []byte, addr Address, plen int8) ([]byte, Addre
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.1.5
 
 func (p *Parser) prefixLength(pfx Prefix, compress bool) int {
-	blen := pfx.Bits() / 8
-	if pfx.Bits()%8 != 0 {
+	addr := pfx.Addr()
+	ae := addressEncoding(&addr)
+
+	switch ae {
+	case AddressEncodingWildcard:
+		return 0
+	case AddressEncodingIPv6LinkLocal:
+		return 8
+	}
+
+	bits := encodedBits(pfx)
+
+	blen := bits / 8
+	if bits%8 != 0 {
 		blen++
 	}
 
-	// if compress {
-	// TODO: Support prefix compression
-	// }
+	if compress {
+		blen -= int(p.omitted(pfx, ae))
+	}
 
 	return blen
 }
 
+// omitted returns the number of leading octets of a prefix which
+// can be omitted as they are shared with the current default prefix.
+func (p *Parser) omitted(pfx Prefix, ae AddressEncoding) uint8 {
+	if !isCompressible(ae) {
+		return 0
+	}
+
+	dpfx, ok := p.CurrentDefaultPrefix[ae]
+	if !ok {
+		return 0
+	}
+
+	return uint8(commonOctets(addressOctets(pfx.Addr()), addressOctets(dpfx), encodedBits(pfx)/8))
+}
+
+// prefix decodes a prefix of length plen. Prefix lengths exceeding the
+// address encoding can not be represented and are always rejected.
 func (p *Parser) prefix(b []byte, ae AddressEncoding, plen, omitted uint8) ([]byte, Prefix, error) {
-	b, addr, err := p.address(b, ae, omitted, int8(plen))
+	if plen > maxPrefixLength(ae) {
+		return nil, Prefix{}, ErrInvalidPrefixLength
+	}
+
+	b, addr, err := p.address(b, ae, omitted, int(plen))
 	if err != nil {
 		return nil, Prefix{}, err
 	}
 
-	return b, netip.PrefixFrom(addr, int(plen)), nil
+	bits := int(plen)
+	if ae == AddressEncodingIPv4inIPv6 {
+		bits += 96
+	}
+
+	return b, netip.PrefixFrom(addr, bits), nil
+}
+
+// encodedBits returns the prefix length as it is encoded on the wire.
+// The length of IPv4in6 mapped prefixes refers to the IPv4 address.
+//
+// See: RFC 9229, 2. Protocol Operation
+// https://datatracker.ietf.org/doc/html/rfc9229#section-2
+func encodedBits(pfx Prefix) int {
+	if pfx.Addr().Is4In6() {
+		return max(pfx.Bits()-96, 0)
+	}
+
+	return pfx.Bits()
+}
+
+// maxPrefixLength returns the maximal prefix length of an address encoding.
+// Unknown address encodings are rejected when decoding the address.
+func maxPrefixLength(ae AddressEncoding) uint8 {
+	switch ae {
+	case AddressEncodingWildcard:
+		return 0
+	case AddressEncodingIPv4, AddressEncodingIPv4inIPv6:
+		return 8 * net.IPv4len
+	default:
+		return 8 * net.IPv6len
+	}
 }
 
 func (p *Parser) appendPrefix(b []byte, pfx Prefix, compress bool) ([]byte, AddressEncoding, uint8, uint8) {
-	// TODO: Support prefix compression for update TLVs
-	b, ae := p.appendAddress(b, pfx.Addr(), int8(pfx.Bits()))
+	var omitted uint8
+	if compress {
+		addr := pfx.Addr()
+		omitted = p.omitted(pfx, addressEncoding(&addr))
+	}
 
-	return b, ae, uint8(pfx.Bits()), 0
+	bits := encodedBits(pfx)
+
+	b, ae := p.appendAddress(b, pfx.Addr(), omitted, bits)
+
+	return b, ae, uint8(bits), omitted
 }
 
 // Pad1
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.6.1
 
 func (p *Parser) pad1(b []byte) ([]byte, *Pad1, error) {
-	return b, &Pad1{}, nil
+	return b, newValue(p, &p.values.pad1s), nil
 }
 
 // TODO: Use function
@@ -663,9 +980,8 @@ func (p *Parser) appendPad1(b []byte, v *Pad1) []byte {
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.6.2
 
 func (p *Parser) padN(b []byte) ([]byte, *PadN, error) {
-	v := &PadN{
-		N: len(b),
-	}
+	v := newValue(p, &p.values.padNs)
+	v.N = len(b)
 
 	return b[v.N:], v, nil
 }
@@ -683,7 +999,7 @@ func (p *Parser) appendPadN(b []byte, v *PadN) []byte {
 
 func (p *Parser) acknowledgmentRequest(b []byte) ([]byte, *AcknowledgmentRequest, error) {
 	var err error
-	v := &AcknowledgmentRequest{}
+	v := newValue(p, &p.values.ackReqs)
 
 	if b, _, err = p.uint16(b); err != nil { // Reserved
 		return nil, nil, err
@@ -693,6 +1009,11 @@ func (p *Parser) acknowledgmentRequest(b []byte) ([]byte, *AcknowledgmentRequest
 	}
 	if b, v.Interval, err = p.interval(b); err != nil {
 		return nil, nil, err
+	} else if p.Strict && v.Interval == 0 {
+		return nil, nil, ErrZeroInterval
+	}
+	if b, err = p.forEachSubValue(b, &v.SubValues, nil); err != nil {
+		return nil, nil, err
 	}
 
 	return b, v, nil
@@ -702,6 +1023,7 @@ func (p *Parser) appendAcknowledgmentRequest(b []byte, v *AcknowledgmentRequest)
 	b = p.appendUint16(b, 0) // Reserved
 	b = p.appendUint16(b, v.Opaque)
 	b = p.appendInterval(b, v.Interval)
+	b = p.appendSubValues(b, v.SubValues)
 
 	return b
 }
@@ -711,17 +1033,21 @@ func (p *Parser) appendAcknowledgmentRequest(b []byte, v *AcknowledgmentRequest)
 
 func (p *Parser) acknowledgment(b []byte) ([]byte, *Acknowledgment, error) {
 	var err error
-	v := &Acknowledgment{}
+	v := newValue(p, &p.values.acks)
 
 	if b, v.Opaque, err = p.uint16(b); err != nil {
 		return nil, nil, err
 	}
+	if b, err = p.forEachSubValue(b, &v.SubValues, nil); err != nil {
+		return nil, nil, err
+	}
 
 	return b, v, nil
 }
 
 func (p *Parser) appendAcknowledgment(b []byte, v *Acknowledgment) []byte {
 	b = p.appendUint16(b, v.Opaque)
+	b = p.appendSubValues(b, v.SubValues)
 
 	return b
 }
@@ -731,7 +1057,7 @@ func (p *Parser) appendAcknowledgment(b []byte, v *Acknowledgment) []byte {
 
 func (p *Parser) hello(b []byte) ([]byte, *Hello, error) {
 	var err error
-	v := &Hello{}
+	v := newValue(p, &p.values.hellos)
 
 	if b, v.Flags, err = p.uint16(b); err != nil {
 		return nil, nil, err
@@ -744,10 +1070,10 @@ func (p *Parser) hello(b []byte) ([]byte, *Hello, error) {
 	}
 
 	// Decode sub-TLVs
-	b, err = p.forEachSubValue(b, func(t ValueType, b []byte) ([]byte, error) {
+	b, err = p.forEachSubValue(b, &v.SubValues, func(t ValueType, b []byte) ([]byte, error) {
 		switch t {
 		case SubTypeTimestamp:
-			v.Timestamp = &TimestampHello{}
+			v.Timestamp = newValue(p, &p.values.timestampsHello)
 			if b, v.Timestamp.Transmit, err = p.uint32(b); err != nil {
 				return nil, err
 			}
@@ -773,6 +1099,8 @@ func (p *Parser) appendHello(b []byte, v *Hello) []byte {
 		})
 	}
 
+	b = p.appendSubValues(b, v.SubValues)
+
 	return b
 }
 
@@ -782,7 +1110,7 @@ func (p *Parser) appendHello(b []byte, v *Hello) []byte {
 func (p *Parser) ihu(b []byte) ([]byte, *IHU, error) {
 	var err error
 	var ae uint8
-	v := &IHU{}
+	v := newValue(p, &p.values.ihus)
 
 	if b, ae, err = p.uint8(b); err != nil {
 		return nil, nil, err
@@ -795,15 +1123,17 @@ func (p *Parser) ihu(b []byte) ([]byte, *IHU, error) {
 	}
 	if b, v.Interval, err = p.interval(b); err != nil {
 		return nil, nil, err
+	} else if p.Strict && v.Interval == 0 {
+		return nil, nil, ErrZeroInterval
 	}
 	if b, v.Address, err = p.address(b, ae, 0, -1); err != nil {
 		return nil, nil, err
 	}
 
-	b, err = p.forEachSubValue(b, func(t ValueType, b []byte) ([]byte, error) {
+	b, err = p.forEachSubValue(b, &v.SubValues, func(t ValueType, b []byte) ([]byte, error) {
 		switch t {
 		case SubTypeTimestamp:
-			v.Timestamp = &TimestampIHU{}
+			v.Timestamp = newValue(p, &p.values.timestampsIHU)
 			if b, v.Timestamp.Origin, err = p.uint32(b); err != nil {
 				return nil, err
 			}
@@ -817,7 +1147,7 @@ func (p *Parser) ihu(b []byte) ([]byte, *IHU, error) {
 		}
 	})
 
-	return b, v, nil
+	return b, v, err
 }
 
 func (p *Parser) appendIHU(b []byte, v *IHU) []byte {
@@ -827,7 +1157,7 @@ func (p *Parser) appendIHU(b []byte, v *IHU) []byte {
 	b = p.appendUint8(b, 0) // Reserved
 	b = p.appendUint16(b, v.RxCost)
 	b = p.appendInterval(b, v.Interval)
-	b, ae := p.appendAddress(b, v.Address, -1)
+	b, ae := p.appendAddress(b, v.Address, 0, -1)
 
 	b[o+0] = ae
 
@@ -841,6 +1171,8 @@ func (p *Parser) appendIHU(b []byte, v *IHU) []byte {
 		})
 	}
 
+	b = p.appendSubValues(b, v.SubValues)
+
 	return b
 }
 
@@ -849,7 +1181,7 @@ func (p *Parser) appendIHU(b []byte, v *IHU) []byte {
 
 func (p *Parser) routerIDValue(b []byte) ([]byte, *RouterIDValue, error) {
 	var err error
-	v := &RouterIDValue{}
+	v := newValue(p, &p.values.routerIDs)
 
 	if b, _, err = p.uint16(b); err != nil { // Reserved
 		return nil, nil, err
@@ -858,12 +1190,23 @@ func (p *Parser) routerIDValue(b []byte) ([]byte, *RouterIDValue, error) {
 		return nil, nil, err
 	}
 
+	// The parser state is updated even if the TLV is ignored
+	// due to an unsupported mandatory sub-TLV.
+	p.CurrentRouterID = v.RouterID
+
+	if b, err = p.forEachSubValue(b, &v.SubValues, nil); err != nil {
+		return nil, nil, err
+	}
+
 	return b, v, nil
 }
 
 func (p *Parser) appendRouterIDValue(b []byte, v *RouterIDValue) []byte {
 	b = p.appendUint16(b, 0) // Reserved
 	b = p.appendRouterID(b, v.RouterID)
+	b = p.appendSubValues(b, v.SubValues)
+
+	p.CurrentRouterID = v.RouterID
 
 	return b
 }
@@ -874,7 +1217,7 @@ func (p *Parser) appendRouterIDValue(b []byte, v *RouterIDValue) []byte {
 func (p *Parser) nextHop(b []byte) ([]byte, *NextHop, error) {
 	var err error
 	var ae uint8
-	v := &NextHop{}
+	v := newValue(p, &p.values.nextHops)
 
 	if b, ae, err = p.uint8(b); err != nil {
 		return nil, nil, err
@@ -886,6 +1229,14 @@ func (p *Parser) nextHop(b []byte) ([]byte, *NextHop, error) {
 		return nil, nil, err
 	}
 
+	// The parser state is updated even if the TLV is ignored
+	// due to an unsupported mandatory sub-TLV.
+	p.CurrentNextHop[addressFamilyFromAddressEncoding(ae)] = v.NextHop
+
+	if b, err = p.forEachSubValue(b, &v.SubValues, nil); err != nil {
+		return nil, nil, err
+	}
+
 	return b, v, nil
 }
 
@@ -893,10 +1244,13 @@ func (p *Parser) appendNextHop(b []byte, v *NextHop) []byte {
 	o := len(b)
 	b = p.appendUint8(b, 0) // Placeholder: ae
 	b = p.appendUint8(b, 0) // Reserved
-	b, ae := p.appendAddress(b, v.NextHop, -1)
+	b, ae := p.appendAddress(b, v.NextHop, 0, -1)
+	b = p.appendSubValues(b, v.SubValues)
 
 	b[o+0] = ae
 
+	p.CurrentNextHop[addressFamilyFromAddressEncoding(ae)] = v.NextHop
+
 	return b
 }
 
@@ -906,7 +1260,7 @@ func (p *Parser) appendNextHop(b []byte, v *NextHop) []byte {
 func (p *Parser) update(b []byte) ([]byte, *Update, error) {
 	var err error
 	var ae, plen, omitted uint8
-	v := &Update{}
+	v := newValue(p, &p.values.updates)
 
 	if b, ae, err = p.uint8(b); err != nil {
 		return nil, nil, err
@@ -934,24 +1288,33 @@ func (p *Parser) update(b []byte) ([]byte, *Update, error) {
 	}
 
 	// Decode sub-TLVs
-	if b, err = p.forEachSubValue(b, func(t ValueType, b []byte) ([]byte, error) {
+	b, err = p.forEachSubValue(b, &v.SubValues, func(t ValueType, b []byte) ([]byte, error) {
 		switch t {
 		case SubTypeSourcePrefix:
 			var pfx Prefix
 			if b, pfx, err = p.sourcePrefix(b, ae); err != nil {
 				return nil, err
-			} else {
-				v.SourcePrefix = &pfx
 			}
+
+			v.SourcePrefix = newValue(p, &p.values.sourcePrefixes)
+			*v.SourcePrefix = pfx
+
 			return b, nil
 
+		case SubTypeDiversity:
+			v.Channels = copyBytes(p, b)
+			return nil, nil
+
 		default:
 			return nil, ErrUnsupportedValue
 		}
-	}); err != nil {
+	})
+	if err != nil && !errors.Is(err, ErrUnsupportedButMandatoryValue) {
 		return nil, nil, err
 	}
 
+	// The parser state is updated even if the update is ignored
+	// due to an unsupported mandatory sub-TLV.
 	if v.Flags&FlagUpdatePrefix != 0 {
 		p.CurrentDefaultPrefix[ae] = v.Prefix.Addr()
 	}
@@ -960,6 +1323,10 @@ func (p *Parser) update(b []byte) ([]byte, *Update, error) {
 		p.CurrentRouterID = RouterIDFromAddr(v.Prefix.Addr())
 	}
 
+	if err != nil {
+		return nil, nil, err
+	}
+
 	// Fill in fields from parser state
 	af := addressFamilyFromAddressEncoding(ae)
 
@@ -985,8 +1352,9 @@ func (p *Parser) appendUpdate(b []byte, v *Update) []byte {
 	b[o+2] = plen
 	b[o+3] = omitted
 
+	// The decoder only learns the masked prefix
 	if v.Flags&FlagUpdatePrefix != 0 {
-		p.CurrentDefaultPrefix[ae] = v.Prefix.Addr()
+		p.CurrentDefaultPrefix[ae] = v.Prefix.Masked().Addr()
 	}
 
 	if v.Flags&FlagUpdateRouterID != 0 {
@@ -997,6 +1365,12 @@ func (p *Parser) appendUpdate(b []byte, v *Update) []byte {
 		b = p.appendSourcePrefix(b, *v.SourcePrefix)
 	}
 
+	if len(v.Channels) > 0 {
+		b = p.appendDiversity(b, v.Channels)
+	}
+
+	b = p.appendSubValues(b, v.SubValues)
+
 	return b
 }
 
@@ -1006,7 +1380,7 @@ func (p *Parser) appendUpdate(b []byte, v *Update) []byte {
 func (p *Parser) routeRequest(b []byte) ([]byte, *RouteRequest, error) {
 	var err error
 	var ae, plen uint8
-	v := &RouteRequest{}
+	v := newValue(p, &p.values.routeReqs)
 
 	if b, ae, err = p.uint8(b); err != nil {
 		return nil, nil, err
@@ -1019,15 +1393,17 @@ func (p *Parser) routeRequest(b []byte) ([]byte, *RouteRequest, error) {
 	}
 
 	// Decode sub-TLVs
-	if b, err = p.forEachSubValue(b, func(t ValueType, b []byte) ([]byte, error) {
+	if b, err = p.forEachSubValue(b, &v.SubValues, func(t ValueType, b []byte) ([]byte, error) {
 		switch t {
 		case SubTypeSourcePrefix:
 			var pfx Prefix
 			if b, pfx, err = p.sourcePrefix(b, ae); err != nil {
 				return nil, err
-			} else {
-				v.SourcePrefix = &pfx
 			}
+
+			v.SourcePrefix = newValue(p, &p.values.sourcePrefixes)
+			*v.SourcePrefix = pfx
+
 			return b, nil
 
 		default:
@@ -1054,6 +1430,8 @@ func (p *Parser) appendRouteRequest(b []byte, v *RouteRequest) []byte {
 		b = p.appendSourcePrefix(b, *v.SourcePrefix)
 	}
 
+	b = p.appendSubValues(b, v.SubValues)
+
 	return b
 }
 
@@ -1063,7 +1441,7 @@ func (p *Parser) appendRouteRequest(b []byte, v *RouteRequest) []byte {
 func (p *Parser) seqnoRequest(b []byte) ([]byte, *SeqnoRequest, error) {
 	var err error
 	var ae, plen uint8
-	v := &SeqnoRequest{}
+	v := newValue(p, &p.values.seqnoReqs)
 
 	if b, ae, err = p.uint8(b); err != nil {
 		return nil, nil, err
@@ -1076,6 +1454,8 @@ func (p *Parser) seqnoRequest(b []byte) ([]byte, *SeqnoRequest, error) {
 	}
 	if b, v.HopCount, err = p.uint8(b); err != nil {
 		return nil, nil, err
+	} else if p.Strict && v.HopCount == 0 {
+		return nil, nil, ErrZeroHopCount
 	}
 	if b, _, err = p.uint8(b); err != nil { // Reserved
 		return nil, nil, err
@@ -1088,15 +1468,17 @@ func (p *Parser) seqnoRequest(b []byte) ([]byte, *SeqnoRequest, error) {
 	}
 
 	// Decode sub-TLVs
-	if b, err = p.forEachSubValue(b, func(t ValueType, b []byte) ([]byte, error) {
+	if b, err = p.forEachSubValue(b, &v.SubValues, func(t ValueType, b []byte) ([]byte, error) {
 		switch t {
 		case SubTypeSourcePrefix:
 			var pfx Prefix
 			if b, pfx, err = p.sourcePrefix(b, ae); err != nil {
 				return nil, err
-			} else {
-				v.SourcePrefix = &pfx
 			}
+
+			v.SourcePrefix = newValue(p, &p.values.sourcePrefixes)
+			*v.SourcePrefix = pfx
+
 			return b, nil
 
 		default:
@@ -1127,6 +1509,8 @@ func (p *Parser) appendSeqnoRequest(b []byte, v *SeqnoRequest) []byte {
 		b = p.appendSourcePrefix(b, *v.SourcePrefix)
 	}
 
+	b = p.appendSubValues(b, v.SubValues)
+
 	return b
 }
 
@@ -1153,3 +1537,78 @@ func (p *Parser) appendSourcePrefix(b []byte, pfx Prefix) []byte {
 		return b
 	})
 }
+
+// Sub-TLVs
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.4
+
+// appendSubValues encodes additional sub-TLVs of a TLV.
+func (p *Parser) appendSubValues(b []byte, svs []SubValue) []byte {
+	for _, sv := range svs {
+		switch sv := sv.(type) {
+		case *Pad1:
+			b = p.appendUint8(b, uint8(SubTypePad1))
+		case *PadN:
+			b = p.appendValueHeader(b, SubTypePadN, func(b []byte) []byte {
+				return p.appendPadN(b, sv)
+			})
+		case *UnknownValue:
+			b = p.appendValueHeader(b, sv.Type, func(b []byte) []byte {
+				return append(b, sv.Payload...)
+			})
+		default:
+			c := lookupCodecOf(sv, true)
+			if c == nil {
+				panic(ErrInvalidValueType)
+			}
+
+			b = p.appendValueHeader(b, c.typ, func(b []byte) []byte {
+				return c.append(p, b, sv)
+			})
+		}
+	}
+
+	return b
+}
+
+// subValuesLength returns the number of octets of additional sub-TLVs.
+func (p *Parser) subValuesLength(svs []SubValue) (l int) {
+	for _, sv := range svs {
+		switch sv := sv.(type) {
+		case *Pad1:
+			l++
+		case *PadN:
+			l += ValueHeaderLength + sv.N
+		case *UnknownValue:
+			l += ValueHeaderLength + len(sv.Payload)
+		default:
+			c := lookupCodecOf(sv, true)
+			if c == nil {
+				panic(ErrInvalidValueType)
+			}
+
+			l += ValueHeaderLength + c.length(p, sv)
+		}
+	}
+
+	return l
+}
+
+// Diversity sub-TLV
+// https://datatracker.ietf.org/doc/html/draft-chroboczek-babel-diversity-routing-01#section-2.1
+
+func (p *Parser) appendDiversity(b []byte, channels []uint8) []byte {
+	return p.appendValueHeader(b, SubTypeDiversity, func(b []byte) []byte {
+		return append(b, channels...)
+	})
+}
+
+// Unknown TLVs
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.3
+
+func (p *Parser) unknownValue(t ValueType, b []byte) ([]byte, *UnknownValue, error) {
+	v := newValue(p, &p.values.unknowns)
+	v.Type = t
+	v.Payload = copyBytes(p, b)
+
+	return nil, v, nil
+}
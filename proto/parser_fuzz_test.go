@@ -4,6 +4,9 @@
 package proto_test
 
 import (
+	"fmt"
+	"net/netip"
+	"reflect"
 	"testing"
 
 	"cunicu.li/go-babel/proto"
@@ -15,3 +18,56 @@ func FuzzParser(f *testing.F) {
 		p.Packet(b) //nolint:errcheck
 	})
 }
+
+func FuzzDecoder(f *testing.F) {
+	f.Add(proto.NewParser().AppendPacket(nil, &proto.Packet{
+		Body: []proto.Value{
+			&proto.Hello{Seqno: 1},
+			&proto.Update{Prefix: netip.MustParsePrefix("2001:db8::/32")},
+		},
+	}))
+
+	d := proto.NewDecoder()
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		_, expected, expectedErr := proto.NewParser().Packet(b)
+		_, pkt, err := d.Packet(b)
+
+		if fmt.Sprint(err) != fmt.Sprint(expectedErr) {
+			t.Fatalf("unexpected error: %v != %v", err, expectedErr)
+		} else if err == nil && !reflect.DeepEqual(pkt, expected) {
+			t.Fatalf("unexpected packet: %v != %v", pkt, expected)
+		}
+	})
+}
+
+func FuzzPacketValues(f *testing.F) {
+	f.Fuzz(func(t *testing.T, b []byte) {
+		for v, err := range proto.NewParser().PacketValues(b) {
+			if value, decodeErr := v.Decode(); err == nil && decodeErr == nil && value == nil {
+				t.Fatalf("missing value without error: %v", v)
+			}
+		}
+	})
+}
+
+func FuzzStrictParser(f *testing.F) {
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p := proto.NewParser()
+		p.Strict = true
+
+		_, pkt, err := p.Packet(b)
+		if err != nil {
+			return
+		}
+
+		// Packets accepted by a strict parser must be decoded
+		// identically by a lenient parser.
+		_, expected, err := proto.NewParser().Packet(b)
+		if err != nil {
+			t.Fatalf("lenient parser failed: %v", err)
+		} else if !reflect.DeepEqual(pkt, expected) {
+			t.Fatalf("unexpected packet: %v != %v", pkt, expected)
+		}
+	})
+}
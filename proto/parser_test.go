@@ -4,6 +4,8 @@
 package proto
 
 import (
+	"encoding/binary"
+	"errors"
 	"net/netip"
 	"time"
 
@@ -129,7 +131,7 @@ var _ = Context("Parser", func() {
 			func(addr string, len int, expAE uint8) {
 				v1 := netip.MustParseAddr(addr)
 
-				b, ae := p.appendAddress(nil, v1, -1)
+				b, ae := p.appendAddress(nil, v1, 0, -1)
 				Expect(b).To(HaveLen(len))
 				Expect(ae).To(Equal(expAE))
 
@@ -173,11 +175,46 @@ var _ = Context("Parser", func() {
 			Entry("AddressEncodingIPv6", "fd3d:bd4f:9738::/48", 6, AddressEncodingIPv6, uint8(48)),
 			Entry("AddressEncodingWildcard", "::/0", 0, AddressEncodingWildcard, uint8(0)),
 			Entry("AddressEncodingIPv6LinkLocal", "fe80::1234:5678:90AB:CDEF/128", 8, AddressEncodingIPv6LinkLocal, uint8(128)),
-			Entry("AddressEncodingIPv4inIPv6", "::ffff:10.0.0.0/16", 2, AddressEncodingIPv4inIPv6, uint8(16)),
+			Entry("AddressEncodingIPv4inIPv6", "::ffff:10.0.0.0/112", 2, AddressEncodingIPv4inIPv6, uint8(16)),
 		)
 
-		It("Prefixes compression", Pending, func() {
-			// TODO
+		DescribeTable("Prefixes compression",
+			func(dpfxStr, pfxStr string, expOmitted uint8, expLen int) {
+				dpfx := netip.MustParsePrefix(dpfxStr)
+				v1 := netip.MustParsePrefix(pfxStr)
+
+				daddr := dpfx.Addr()
+				p.CurrentDefaultPrefix[addressEncoding(&daddr)] = daddr
+
+				Expect(p.prefixLength(v1, true)).To(Equal(expLen))
+
+				b, ae, plen, omitted := p.appendPrefix(nil, v1, true)
+				Expect(omitted).To(Equal(expOmitted))
+				Expect(b).To(HaveLen(expLen))
+
+				b, v2, err := p.prefix(b, ae, plen, omitted)
+				Expect(err).To(Succeed())
+				Expect(v2).To(Equal(v1))
+				Expect(b).To(BeEmpty())
+			},
+			Entry("IPv4", "10.0.0.0/16", "10.0.1.0/24", uint8(2), 1),
+			Entry("IPv4 equal", "10.0.1.0/24", "10.0.1.0/24", uint8(3), 0),
+			Entry("IPv4 unrelated", "10.0.0.0/16", "192.168.0.0/16", uint8(0), 2),
+			Entry("IPv4 other family", "2001:db8::/32", "10.0.0.0/8", uint8(0), 1),
+			Entry("IPv6", "2001:db8:1::/48", "2001:db8:1:200::/64", uint8(6), 2),
+			Entry("IPv6 partial octet", "2001:db8:1::/48", "2001:db8:1:200::/60", uint8(6), 2),
+			Entry("IPv4in6 mapped", "::ffff:10.0.0.0/112", "::ffff:10.0.1.0/120", uint8(2), 1),
+			Entry("IPv6 link-local", "fe80::1/128", "fe80::2/128", uint8(0), 8),
+		)
+
+		It("Rejects omitted octets without a default prefix", func() {
+			_, _, err := p.prefix([]byte{1}, AddressEncodingIPv4, 24, 2)
+			Expect(err).To(MatchError(ErrMissingDefaultPrefix))
+		})
+
+		It("Rejects omitted octets for link-local addresses", func() {
+			_, _, err := p.prefix(make([]byte, 8), AddressEncodingIPv6LinkLocal, 128, 2)
+			Expect(err).To(MatchError(ErrCompressionNotAllowed))
 		})
 	})
 
@@ -286,8 +323,12 @@ var _ = Context("Parser", func() {
 
 		DescribeTable("Values",
 			func(typ1 ValueType, v1 Value) {
+				// The length depends on the parser state which is
+				// altered by appending the value.
+				l := p.ValueLength(v1)
+
 				b := p.AppendValue(nil, v1)
-				Expect(b).To(HaveLen(p.ValueLength(v1)))
+				Expect(b).To(HaveLen(l))
 
 				p.Reset()
 
@@ -355,6 +396,14 @@ var _ = Context("Parser", func() {
 				Prefix:       netip.MustParsePrefix("192.168.0.0/16"),
 				SourcePrefix: &pfx,
 			}),
+			Entry("Update with Diversity", TypeUpdate, &Update{
+				Flags:    FlagUpdatePrefix,
+				Interval: 2 * time.Second,
+				Seqno:    1233,
+				Metric:   100,
+				Prefix:   netip.MustParsePrefix("192.168.0.0/16"),
+				Channels: []uint8{1, 6, ChannelInterfering},
+			}),
 			Entry("RouteRequest", TypeRouteRequest, &RouteRequest{
 				Prefix: netip.MustParsePrefix("192.168.0.0/16"),
 			}),
@@ -379,16 +428,315 @@ var _ = Context("Parser", func() {
 	})
 
 	Describe("Sub-TLVs", func() {
-		It("Ignore unsupported sub-TLVs", Pending, func() {
-			// TODO
+		// appendSubValue appends a sub-TLV to the last TLV in the buffer.
+		appendSubValue := func(b []byte, o int, t ValueType, payload ...byte) []byte {
+			b = append(b, uint8(t), uint8(len(payload)))
+			b = append(b, payload...)
+			b[o+1] += uint8(ValueHeaderLength + len(payload))
+			return b
+		}
+
+		hello := &Hello{
+			Seqno:    1234,
+			Interval: 4 * time.Second,
+		}
+
+		It("Ignore unsupported sub-TLVs", func() {
+			b := p.AppendValue(nil, hello)
+			b = appendSubValue(b, 0, 0x42, 1, 2, 3)
+			b = appendSubValue(b, 0, SubTypePadN, 0, 0)
+
+			_, vs, err := p.Values(b, false)
+			Expect(err).To(Succeed())
+			Expect(vs).To(Equal([]Value{hello}))
 		})
 
-		It("Fail on mandatory sub-TLVs", Pending, func() {
-			// TODO
+		It("Ignore TLVs with unsupported mandatory sub-TLVs", func() {
+			b := p.AppendValue(nil, hello)
+			b = appendSubValue(b, 0, 0xc2, 1, 2, 3)
+
+			o := len(b)
+			b = p.AppendValue(b, hello)
+
+			_, vs, err := p.Values(b, false)
+			Expect(err).To(Succeed())
+			Expect(vs).To(Equal([]Value{hello}))
+
+			// Only the first TLV is affected
+			b = appendSubValue(b, o, 0xc2)
+
+			_, vs, err = p.Values(b, false)
+			Expect(err).To(Succeed())
+			Expect(vs).To(BeEmpty())
+		})
+
+		extra := []SubValue{
+			&Pad1{},
+			&PadN{N: 3},
+			&UnknownValue{Type: 0x42, Payload: []byte{1, 2, 3}},
+		}
+
+		DescribeTable("Encode and skip additional sub-TLVs",
+			func(v, expected Value) {
+				b := p.AppendValue(nil, v)
+				Expect(b).To(HaveLen(p.ValueLength(v)))
+				Expect(len(b) - p.ValueLength(expected)).To(Equal(1 + 5 + 5))
+
+				// Padding and unsupported sub-TLVs are skipped
+				_, vs, err := NewParser().Values(b, false)
+				Expect(err).To(Succeed())
+				Expect(vs).To(Equal([]Value{expected}))
+			},
+			Entry("AcknowledgmentRequest",
+				&AcknowledgmentRequest{Opaque: 1, Interval: time.Second, SubValues: extra},
+				&AcknowledgmentRequest{Opaque: 1, Interval: time.Second}),
+			Entry("Acknowledgment",
+				&Acknowledgment{Opaque: 1, SubValues: extra},
+				&Acknowledgment{Opaque: 1}),
+			Entry("Hello",
+				&Hello{Seqno: 1, Interval: time.Second, Timestamp: &TimestampHello{Transmit: 2}, SubValues: extra},
+				&Hello{Seqno: 1, Interval: time.Second, Timestamp: &TimestampHello{Transmit: 2}}),
+			Entry("RouterID",
+				&RouterIDValue{RouterID: RouterID{1, 2, 3, 4, 5, 6, 7, 8}, SubValues: extra},
+				&RouterIDValue{RouterID: RouterID{1, 2, 3, 4, 5, 6, 7, 8}}),
+			Entry("NextHop",
+				&NextHop{NextHop: netip.MustParseAddr("192.0.2.1"), SubValues: extra},
+				&NextHop{NextHop: netip.MustParseAddr("192.0.2.1")}),
+			Entry("Update",
+				&Update{Interval: time.Second, Metric: 1, Prefix: netip.MustParsePrefix("2001:db8::/32"), Channels: []uint8{1}, SubValues: extra},
+				&Update{Interval: time.Second, Metric: 1, Prefix: netip.MustParsePrefix("2001:db8::/32"), Channels: []uint8{1}}),
+		)
+
+		It("Update parser state for next hops with unsupported mandatory sub-TLVs", func() {
+			nh := netip.MustParseAddr("192.0.2.1")
+			pfx := netip.MustParsePrefix("10.0.0.0/8")
+
+			b := p.AppendValue(nil, &NextHop{
+				NextHop: nh,
+				SubValues: []SubValue{
+					&UnknownValue{Type: 0xc2},
+				},
+			})
+			b = p.AppendValue(b, &Update{Prefix: pfx})
+
+			_, vs, err := NewParser().Values(b, false)
+			Expect(err).To(Succeed())
+			Expect(vs).To(HaveLen(1))
+			Expect(vs[0].(*Update).NextHop).To(Equal(nh))
+		})
+
+		It("Update parser state for router IDs with unsupported mandatory sub-TLVs", func() {
+			rid := RouterID{1, 2, 3, 4, 5, 6, 7, 8}
+			pfx := netip.MustParsePrefix("2001:db8::/32")
+
+			b := p.AppendValue(nil, &RouterIDValue{
+				RouterID: rid,
+				SubValues: []SubValue{
+					&UnknownValue{Type: 0xc2},
+				},
+			})
+			b = p.AppendValue(b, &Update{Prefix: pfx})
+
+			_, vs, err := NewParser().Values(b, false)
+			Expect(err).To(Succeed())
+			Expect(vs).To(HaveLen(1))
+			Expect(vs[0].(*Update).RouterID).To(Equal(rid))
+		})
+
+		It("Update parser state for updates with unsupported mandatory sub-TLVs", func() {
+			pfx := netip.MustParsePrefix("2001:db8::1234:5678:90ab:cdef/128")
+
+			b := p.AppendValue(nil, &Update{
+				Flags:  FlagUpdatePrefix | FlagUpdateRouterID,
+				Prefix: pfx,
+			})
+			b = appendSubValue(b, 0, 0xc2)
+
+			p.Reset()
+
+			_, vs, err := p.Values(b, false)
+			Expect(err).To(Succeed())
+			Expect(vs).To(BeEmpty())
+			Expect(p.CurrentRouterID).To(Equal(RouterIDFromAddr(pfx.Addr())))
+			Expect(p.CurrentDefaultPrefix).To(HaveKeyWithValue(AddressEncodingIPv6, pfx.Addr()))
 		})
 	})
 
-	It("Ignore unsupported TLVs", Pending, func() {
-		// TODO
+	Describe("Next hops", func() {
+		src := netip.MustParseAddr("fe80::1")
+		src4 := netip.MustParseAddr("192.0.2.1")
+
+		pfx6 := netip.MustParsePrefix("2001:db8::/32")
+		pfx4 := netip.MustParsePrefix("10.0.0.0/8")
+
+		decode := func(p *Parser, vs ...Value) []*Update {
+			b := NewParser().AppendPacket(nil, &Packet{Body: vs})
+
+			_, pkt, err := p.Packet(b)
+			Expect(err).To(Succeed())
+
+			upds := []*Update{}
+			for _, v := range pkt.Body {
+				if upd, ok := v.(*Update); ok {
+					upds = append(upds, upd)
+				}
+			}
+
+			return upds
+		}
+
+		It("Uses the source address as implicit next hop", func() {
+			p := NewPacketParser(src)
+
+			upds := decode(p, &Update{Prefix: pfx6}, &Update{Prefix: pfx4})
+			Expect(upds[0].NextHop).To(Equal(src))
+			Expect(upds[1].NextHop.IsValid()).To(BeFalse())
+		})
+
+		It("Uses a learned IPv4 address as implicit next hop", func() {
+			p := NewPacketParser(src)
+			p.SetInitialNextHop(src4)
+
+			upds := decode(p, &Update{Prefix: pfx6}, &Update{Prefix: pfx4})
+			Expect(upds[0].NextHop).To(Equal(src))
+			Expect(upds[1].NextHop).To(Equal(src4))
+		})
+
+		It("Overrides the implicit next hop with Next Hop TLVs", func() {
+			p := NewPacketParser(src)
+			nh := netip.MustParseAddr("fe80::3")
+
+			upds := decode(p, &NextHop{NextHop: nh}, &Update{Prefix: pfx6}, &NextHop{NextHop: src4}, &Update{Prefix: pfx4})
+			Expect(upds[0].NextHop).To(Equal(nh))
+			Expect(upds[1].NextHop).To(Equal(src4))
+
+			// The parser state is reset for each packet
+			upds = decode(p, &Update{Prefix: pfx6})
+			Expect(upds[0].NextHop).To(Equal(src))
+		})
+	})
+
+	It("Ignore unsupported TLVs", func() {
+		unknown := &UnknownValue{
+			Type:    0x42,
+			Payload: []byte{1, 2, 3, 4},
+		}
+
+		pkt := &Packet{
+			Body: []Value{
+				&Hello{Seqno: 1},
+				unknown,
+				&Hello{Seqno: 2},
+			},
+		}
+
+		b := p.AppendPacket(nil, pkt)
+		Expect(b).To(HaveLen(int(p.PacketLength(pkt))))
+
+		_, pkt2, err := p.Packet(b)
+		Expect(err).To(Succeed())
+		Expect(pkt2.Body).To(Equal(pkt.Body))
+		Expect(ValuesType(pkt2.Body[1])).To(Equal(ValueType(0x42)))
+	})
+	Describe("Strict mode", func() {
+		rid := RouterID{1, 2, 3, 4, 5, 6, 7, 8}
+
+		// packet encodes a packet with a Hello followed by a raw TLV and trailer.
+		packet := func(value, trailer []byte) []byte {
+			b := NewParser().AppendPacket(nil, &Packet{
+				Body: []Value{
+					&Hello{Seqno: 1},
+				},
+			})
+
+			b = append(b, value...)
+			binary.BigEndian.PutUint16(b[2:], uint16(len(b)-PacketHeaderLength))
+
+			return append(b, trailer...)
+		}
+
+		encode := func(v Value) []byte {
+			return NewParser().AppendValue(nil, v)
+		}
+
+		DescribeTable("Violations",
+			func(value, trailer []byte, expected error) {
+				b := packet(value, trailer)
+
+				p.Strict = false
+				_, _, err := p.Packet(b)
+				Expect(err).To(Succeed())
+
+				p.Strict = true
+				_, _, err = p.Packet(b)
+				Expect(err).To(MatchError(expected))
+
+				if value != nil {
+					var verr *ValueError
+					Expect(errors.As(err, &verr)).To(BeTrue())
+					Expect(verr.Type).To(Equal(ValueType(value[0])))
+					Expect(verr.Offset).To(Equal(PacketHeaderLength + ValueHeaderLength + 6))
+				}
+			},
+			Entry("zero interval of acknowledgment request", encode(&AcknowledgmentRequest{Opaque: 1}), nil, ErrZeroInterval),
+			Entry("zero interval of IHU", encode(&IHU{RxCost: 96, Address: netip.MustParseAddr("fe80::1")}), nil, ErrZeroInterval),
+			Entry("zero hop count", encode(&SeqnoRequest{
+				RouterID: rid,
+				Prefix:   netip.MustParsePrefix("2001:db8::/32"),
+			}), nil, ErrZeroHopCount),
+			Entry("prefix length of wildcard", []byte{byte(TypeRouteRequest), 2, AddressEncodingWildcard, 8}, nil, ErrInvalidPrefixLength),
+			Entry("prefix length of link-local address", []byte{byte(TypeRouteRequest), 10, AddressEncodingIPv6LinkLocal, 129, 0, 0, 0, 0, 0, 0, 0, 1}, nil, ErrInvalidPrefixLength),
+			Entry("prefix length exceeding a signed octet", []byte{byte(TypeRouteRequest), 2, AddressEncodingIPv6, 200}, nil, ErrInvalidPrefixLength),
+			Entry("prefix bits beyond length", []byte{byte(TypeRouteRequest), 4, AddressEncodingIPv4, 12, 10, 0xff}, nil, ErrInvalidPrefixBits),
+			Entry("invalid trailer", nil, []byte{byte(TypeHello), 6, 0, 0, 0, 1, 0, 100}, ErrInvalidTrailer),
+			Entry("truncated trailer", nil, []byte{byte(TypePadN), 2, 0}, ErrInvalidTrailer),
+		)
+
+		DescribeTable("drops TLVs with prefix lengths exceeding the address encoding in lenient mode",
+			func(value []byte) {
+				_, vs, err := p.Values(append(value, encode(&Hello{Seqno: 1})...), false)
+				Expect(err).To(Succeed())
+				Expect(vs).To(Equal([]Value{&Hello{Seqno: 1}}))
+			},
+			Entry("IPv4", []byte{byte(TypeRouteRequest), 3, AddressEncodingIPv4, 33, 10}),
+			Entry("IPv6", []byte{byte(TypeRouteRequest), 3, AddressEncodingIPv6, 200, 0x20}),
+			Entry("IPv4in6 mapped", []byte{byte(TypeRouteRequest), 3, AddressEncodingIPv4inIPv6, 33, 10}),
+			Entry("wildcard", []byte{byte(TypeRouteRequest), 2, AddressEncodingWildcard, 8}),
+			Entry("update", []byte{byte(TypeUpdate), 11, AddressEncodingIPv6, 0, 129, 0, 0, 100, 0, 1, 0, 96, 0x20}),
+		)
+
+		It("ignores invalid trailers in lenient mode", func() {
+			_, pkt, err := p.Packet(packet(nil, []byte{byte(TypePadN), 2, 0}))
+			Expect(err).To(Succeed())
+			Expect(pkt.Body).To(HaveLen(1))
+			Expect(pkt.Trailer).To(BeEmpty())
+		})
+
+		It("clears prefix bits beyond length in lenient mode", func() {
+			_, vs, err := p.Values([]byte{byte(TypeRouteRequest), 4, AddressEncodingIPv4, 12, 10, 0xff}, false)
+			Expect(err).To(Succeed())
+			Expect(vs[0].(*RouteRequest).Prefix).To(Equal(netip.MustParsePrefix("10.240.0.0/12")))
+		})
+
+		It("accepts bits beyond the length of prefixes taken from the default prefix", func() {
+			p.Strict = true
+			p.CurrentDefaultPrefix[AddressEncodingIPv4] = netip.MustParseAddr("10.255.0.0")
+
+			_, pfx, err := p.prefix(nil, AddressEncodingIPv4, 12, 2)
+			Expect(err).To(Succeed())
+			Expect(pfx).To(Equal(netip.MustParsePrefix("10.240.0.0/12")))
+		})
+
+		It("decodes the packet trailer separately", func() {
+			pkt := &Packet{
+				Body:    []Value{&Hello{Seqno: 1}},
+				Trailer: []Value{&PadN{N: 2}},
+			}
+
+			p.Strict = true
+			_, pkt2, err := p.Packet(p.AppendPacket(nil, pkt))
+			Expect(err).To(Succeed())
+			Expect(pkt2).To(Equal(pkt))
+		})
 	})
 })
@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+var (
+	ErrBuiltinType       = errors.New("type is decoded by the parser itself")
+	ErrAlreadyRegistered = errors.New("type has already been registered")
+	ErrIncompleteCodec   = errors.New("codec is incomplete")
+)
+
+// Codec en- and decodes the payload of a TLV or sub-TLV type which is
+// not known by the parser. V is the Go type representing decoded values,
+// usually a pointer to a struct.
+//
+// The payload excludes the type and length fields. It is the complete
+// body of a TLV including its sub-TLVs or the body of a sub-TLV.
+type Codec[V any] struct {
+	// Name is the name of the type as returned by ValueType.String().
+	Name string
+
+	// Decode decodes a value from its payload.
+	Decode func(p *Parser, b []byte) (V, error)
+
+	// Append encodes a value by appending its payload to the buffer.
+	Append func(p *Parser, b []byte, v V) []byte
+
+	// Length returns the length of the payload of an encoded value.
+	Length func(p *Parser, v V) int
+}
+
+// codec is the type-erased variant of Codec as stored in the registry.
+type codec struct {
+	typ  ValueType
+	sub  bool
+	name string
+
+	decode func(p *Parser, b []byte) (any, error)
+	append func(p *Parser, b []byte, v any) []byte
+	length func(p *Parser, v any) int
+}
+
+var registry = struct {
+	values    map[ValueType]*codec
+	subValues map[ValueType]*codec
+	goTypes   map[reflect.Type]*codec
+
+	mu sync.RWMutex
+}{
+	values:    map[ValueType]*codec{},
+	subValues: map[ValueType]*codec{},
+	goTypes:   map[reflect.Type]*codec{},
+}
+
+// RegisterValue registers the codec of a TLV type which is not known
+// by the parser. Afterwards, TLVs of this type are decoded into values
+// of type V instead of *UnknownValue and values of type V can be encoded.
+// Registration is usually done from the init function of an extension.
+//
+// See also: 4.3. TLV Format
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.3
+func RegisterValue[V any](t ValueType, c Codec[V]) error {
+	if t <= TypeSeqnoRequest {
+		return fmt.Errorf("%w: %s", ErrBuiltinType, t)
+	}
+
+	return register(t, false, c)
+}
+
+// RegisterSubValue registers the codec of a sub-TLV type which is not
+// known by the parser. Afterwards, sub-TLVs of this type are decoded
+// into values of type V which are appended to the SubValues of their
+// enclosing TLV. Values of type V can be encoded as SubValues.
+//
+// See also: 4.4. Sub-TLV Format
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.4
+func RegisterSubValue[V any](t ValueType, c Codec[V]) error {
+	switch t {
+	case SubTypePad1, SubTypePadN, SubTypeDiversity, SubTypeTimestamp, SubTypeSourcePrefix:
+		return fmt.Errorf("%w: sub-TLV %d", ErrBuiltinType, t)
+	}
+
+	return register(t, true, c)
+}
+
+func register[V any](t ValueType, sub bool, c Codec[V]) error {
+	if c.Name == "" || c.Decode == nil || c.Append == nil || c.Length == nil {
+		return ErrIncompleteCodec
+	}
+
+	goType := reflect.TypeFor[V]()
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	codecs := registry.values
+	if sub {
+		codecs = registry.subValues
+	}
+
+	if _, ok := codecs[t]; ok {
+		return fmt.Errorf("%w: %d", ErrAlreadyRegistered, t)
+	} else if _, ok := registry.goTypes[goType]; ok {
+		return fmt.Errorf("%w: %s", ErrAlreadyRegistered, goType)
+	}
+
+	rc := &codec{
+		typ:  t,
+		sub:  sub,
+		name: c.Name,
+		decode: func(p *Parser, b []byte) (any, error) {
+			return c.Decode(p, b)
+		},
+		append: func(p *Parser, b []byte, v any) []byte {
+			return c.Append(p, b, v.(V))
+		},
+		length: func(p *Parser, v any) int {
+			return c.Length(p, v.(V))
+		},
+	}
+
+	codecs[t] = rc
+	registry.goTypes[goType] = rc
+
+	return nil
+}
+
+// lookupCodec returns the codec of a registered TLV or sub-TLV type.
+func lookupCodec(t ValueType, sub bool) *codec {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if sub {
+		return registry.subValues[t]
+	}
+
+	return registry.values[t]
+}
+
+// lookupCodecOf returns the codec of a value of a registered type.
+func lookupCodecOf(v any, sub bool) *codec {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	if c, ok := registry.goTypes[reflect.TypeOf(v)]; ok && c.sub == sub {
+		return c
+	}
+
+	return nil
+}
+
+// decodeRegistered decodes the payload of a registered sub-TLV.
+func (p *Parser) decodeRegistered(c *codec, b []byte) (any, error) {
+	v, err := c.decode(p, b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", c.name, err)
+	}
+
+	return v, nil
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+import (
+	"errors"
+	"net/netip"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+const (
+	typeOverlay    ValueType = 0xe0
+	subTypeOverlay ValueType = 0x70
+)
+
+var errEmptyOverlay = errors.New("empty overlay")
+
+// overlayValue is an extension TLV carrying opaque metadata.
+type overlayValue struct {
+	Data []byte
+}
+
+// overlaySubValue is an extension sub-TLV carrying an identifier.
+type overlaySubValue struct {
+	ID uint16
+}
+
+var _ = Describe("Registry", Ordered, func() {
+	var p *Parser
+
+	BeforeAll(func() {
+		Expect(RegisterValue(typeOverlay, Codec[*overlayValue]{
+			Name: "Overlay",
+			Decode: func(_ *Parser, b []byte) (*overlayValue, error) {
+				if len(b) == 0 {
+					return nil, errEmptyOverlay
+				}
+
+				return &overlayValue{Data: append([]byte{}, b...)}, nil
+			},
+			Append: func(_ *Parser, b []byte, v *overlayValue) []byte {
+				return append(b, v.Data...)
+			},
+			Length: func(_ *Parser, v *overlayValue) int {
+				return len(v.Data)
+			},
+		})).To(Succeed())
+
+		Expect(RegisterSubValue(subTypeOverlay, Codec[*overlaySubValue]{
+			Name: "OverlayID",
+			Decode: func(p *Parser, b []byte) (*overlaySubValue, error) {
+				v := &overlaySubValue{}
+
+				var err error
+				if b, v.ID, err = p.uint16(b); err != nil {
+					return nil, err
+				} else if len(b) > 0 {
+					return nil, ErrTooLong
+				}
+
+				return v, nil
+			},
+			Append: func(p *Parser, b []byte, v *overlaySubValue) []byte {
+				return p.appendUint16(b, v.ID)
+			},
+			Length: func(*Parser, *overlaySubValue) int {
+				return 2
+			},
+		})).To(Succeed())
+	})
+
+	BeforeEach(func() {
+		p = NewParser()
+	})
+
+	It("names registered types", func() {
+		Expect(typeOverlay.String()).To(Equal("Overlay"))
+		Expect(ValuesType(&overlayValue{})).To(Equal(typeOverlay))
+		Expect(subTypeOverlay.IsSubType()).To(BeTrue())
+	})
+
+	It("en- and decodes registered TLVs", func() {
+		pkt := &Packet{
+			Body: []Value{
+				&overlayValue{Data: []byte{1, 2, 3}},
+				&Hello{
+					Seqno:    1,
+					Interval: time.Second,
+					SubValues: []SubValue{
+						&overlaySubValue{ID: 42},
+					},
+				},
+				&Update{
+					Interval: time.Second,
+					Metric:   1,
+					Prefix:   netip.MustParsePrefix("2001:db8::/32"),
+					SubValues: []SubValue{
+						&PadN{N: 2},
+						&overlaySubValue{ID: 43},
+					},
+				},
+			},
+		}
+
+		b := p.AppendPacket(nil, pkt)
+		Expect(b).To(HaveLen(int(p.PacketLength(pkt))))
+
+		_, dec, err := NewParser().Packet(b)
+		Expect(err).To(Succeed())
+		Expect(dec.Body).To(HaveLen(3))
+		Expect(dec.Body[0]).To(Equal(pkt.Body[0]))
+		Expect(dec.Body[1]).To(Equal(pkt.Body[1]))
+		Expect(dec.Body[2].(*Update).SubValues).To(Equal([]SubValue{
+			&overlaySubValue{ID: 43},
+		}))
+	})
+
+	It("fails for invalid registered TLVs", func() {
+		b := p.AppendValue(nil, &UnknownValue{Type: typeOverlay})
+
+		_, _, err := p.Values(b, false)
+		Expect(err).To(MatchError(errEmptyOverlay))
+	})
+
+	It("rejects invalid registrations", func() {
+		codec := Codec[*UnknownValue]{
+			Name:   "Test",
+			Decode: func(*Parser, []byte) (*UnknownValue, error) { return nil, nil },
+			Append: func(_ *Parser, b []byte, _ *UnknownValue) []byte { return b },
+			Length: func(*Parser, *UnknownValue) int { return 0 },
+		}
+
+		Expect(RegisterValue(TypeUpdate, codec)).To(MatchError(ErrBuiltinType))
+		Expect(RegisterSubValue(SubTypeSourcePrefix, codec)).To(MatchError(ErrBuiltinType))
+		Expect(RegisterValue(typeOverlay, codec)).To(MatchError(ErrAlreadyRegistered))
+		Expect(RegisterValue(typeOverlay+1, Codec[*overlayValue]{
+			Name:   "Duplicate",
+			Decode: func(*Parser, []byte) (*overlayValue, error) { return nil, nil },
+			Append: func(_ *Parser, b []byte, _ *overlayValue) []byte { return b },
+			Length: func(*Parser, *overlayValue) int { return 0 },
+		})).To(MatchError(ErrAlreadyRegistered))
+		Expect(RegisterValue(typeOverlay+1, Codec[*UnknownValue]{})).To(MatchError(ErrIncompleteCodec))
+	})
+})
@@ -16,7 +16,9 @@ const (
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.3
 type Value any
 
-// SubValue represents a sub-TLV
+// SubValue represents a sub-TLV which is not decoded into a dedicated
+// field of its enclosing TLV. These are *Pad1, *PadN, *UnknownValue
+// whose Type is the sub-TLV type and values of registered sub-TLV types.
 // See also: 4.4. Sub-TLV Format
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.4
 type SubValue any
@@ -64,6 +66,12 @@ const (
 	SubTypeSourcePrefix ValueType = 128 //	RFC 9079
 )
 
+// Channels of the Diversity sub-TLV
+// https://datatracker.ietf.org/doc/html/draft-chroboczek-babel-diversity-routing-01#section-2.1
+const (
+	ChannelInterfering uint8 = 255 // The link interferes with all channels.
+)
+
 // Flags for Hello TLV
 // https://datatracker.ietf.org/doc/html/rfc8966#name-hello
 // https://www.iana.org/assignments/babel/babel.xhtml#hello
@@ -79,15 +87,23 @@ const (
 	FlagUpdateRouterID uint8 = 0x40
 )
 
+// IsUrgent checks if a value should be sent without delay.
+// These are retractions, seqno requests and acknowledgments.
+//
+// See: https://datatracker.ietf.org/doc/html/rfc8966#section-3.1
 func IsUrgent(v Value) bool {
-	switch v.(type) {
+	switch v := v.(type) {
+	case *Update:
+		return v.Metric == Retraction
+	case *SeqnoRequest, *Acknowledgment:
+		return true
 	default:
 		return false
 	}
 }
 
 func ValuesType(v Value) ValueType {
-	switch v.(type) {
+	switch v := v.(type) {
 	case *Pad1:
 		return TypePad1
 	case *PadN:
@@ -110,7 +126,13 @@ func ValuesType(v Value) ValueType {
 		return TypeRouteRequest
 	case *SeqnoRequest:
 		return TypeSeqnoRequest
+	case *UnknownValue:
+		return v.Type
 	default:
+		if c := lookupCodecOf(v, false); c != nil {
+			return c.typ
+		}
+
 		panic(ErrUnsupportedValue)
 	}
 }
@@ -152,6 +174,10 @@ func (t ValueType) String() string {
 	case TypeChallengeReply:
 		return "ChallengeReply"
 	default:
+		if c := lookupCodec(t, false); c != nil {
+			return c.name
+		}
+
 		return "<Unknown>"
 	}
 }
@@ -13,6 +13,9 @@ import (
 type AcknowledgmentRequest struct {
 	Opaque   uint16        // An arbitrary value that will be echoed in the receiver's Acknowledgment TLV.
 	Interval time.Duration // A time interval after which the sender will assume that this packet has been lost. This MUST NOT be 0. The receiver MUST send an Acknowledgment TLV before this time has elapsed (with a margin allowing for propagation time).
+
+	// Sub-TLVs
+	SubValues []SubValue // Additional sub-TLVs.
 }
 
 func (a *AcknowledgmentRequest) LogValue() slog.Value {
@@ -24,6 +27,9 @@ func (a *AcknowledgmentRequest) LogValue() slog.Value {
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.6.4
 type Acknowledgment struct {
 	Opaque uint16 // Set to the Opaque value of the Acknowledgment Request that prompted this Acknowledgment.
+
+	// Sub-TLVs
+	SubValues []SubValue // Additional sub-TLVs.
 }
 
 func (a *Acknowledgment) LogValue() slog.Value {
@@ -17,6 +17,7 @@ type Hello struct {
 
 	// Sub-TLVs
 	Timestamp *TimestampHello
+	SubValues []SubValue // Additional sub-TLVs which are encoded after the ones above.
 }
 
 func (h *Hello) LogValue() slog.Value {
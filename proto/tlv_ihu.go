@@ -18,6 +18,7 @@ type IHU struct {
 
 	// Sub-TLVs
 	Timestamp *TimestampIHU
+	SubValues []SubValue // Additional sub-TLVs which are encoded after the ones above.
 }
 
 func (i *IHU) LogValue() slog.Value {
@@ -12,6 +12,9 @@ import (
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.6.8
 type NextHop struct {
 	NextHop netip.Addr // The next-hop address advertised by subsequent Update TLVs for this address family.
+
+	// Sub-TLVs
+	SubValues []SubValue // Additional sub-TLVs.
 }
 
 func (n *NextHop) LogValue() slog.Value {
@@ -15,6 +15,7 @@ type RouteRequest struct {
 
 	// Sub-TLVs
 	SourcePrefix *Prefix
+	SubValues    []SubValue // Additional sub-TLVs which are encoded after the ones above.
 }
 
 func (r *RouteRequest) LogValue() slog.Value {
@@ -12,6 +12,9 @@ import (
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4.6.7
 type RouterIDValue struct {
 	RouterID RouterID // The router-id for routes advertised in subsequent Update TLVs. This MUST NOT consist of all zeroes or all ones.
+
+	// Sub-TLVs
+	SubValues []SubValue // Additional sub-TLVs.
 }
 
 func (r *RouterIDValue) LogValue() slog.Value {
@@ -18,6 +18,7 @@ type SeqnoRequest struct {
 
 	// Sub-TLVs
 	SourcePrefix *Prefix
+	SubValues    []SubValue // Additional sub-TLVs which are encoded after the ones above.
 }
 
 func (s *SeqnoRequest) LogValue() slog.Value {
@@ -0,0 +1,22 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package proto
+
+import "log/slog"
+
+// UnknownValue is a TLV whose type is not known by the parser.
+// It is preserved with its raw payload such that it can be re-encoded.
+//
+// See also: 4.3. TLV Format
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.3
+type UnknownValue struct {
+	Type    ValueType
+	Payload []byte
+}
+
+func (u *UnknownValue) LogValue() slog.Value {
+	return slog.GroupValue(
+		slog.Any("type", uint8(u.Type)),
+		slog.Int("len", len(u.Payload)))
+}
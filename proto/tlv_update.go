@@ -24,6 +24,8 @@ type Update struct {
 
 	// Sub-TLVs
 	SourcePrefix *Prefix
+	Channels     []uint8    // The channels of the links along the route starting at the sender (Diversity sub-TLV).
+	SubValues    []SubValue // Additional sub-TLVs which are encoded after the ones above.
 }
 
 func (u *Update) LogValue() slog.Value {
@@ -42,5 +44,10 @@ func (u *Update) LogValue() slog.Value {
 			slog.Any("src_prefix", *u.SourcePrefix))
 	}
 
+	if len(u.Channels) > 0 {
+		attrs = append(attrs,
+			slog.Any("channels", u.Channels))
+	}
+
 	return slog.GroupValue(attrs...)
 }
@@ -81,6 +81,13 @@ func GenerateRouterID() (RouterID, error) {
 	return *(*RouterID)(b), nil
 }
 
+// TimestampFromTime returns the timestamp of t.
+// Timestamps wrap around roughly every 71 minutes. Hence,
+// only differences of nearby timestamps are meaningful.
+func TimestampFromTime(t time.Time) Timestamp {
+	return Timestamp(t.UnixMicro())
+}
+
 // IsMandatory checks whether the sub-TLV type is mandatory
 func (t ValueType) IsMandatory() bool {
 	return t&0x80 != 0
@@ -91,7 +98,7 @@ func (t ValueType) IsSubType() bool {
 	case SubTypePad1, SubTypePadN, SubTypeDiversity, SubTypeTimestamp, SubTypeSourcePrefix:
 		return true
 	default:
-		return false
+		return lookupCodec(t, true) != nil
 	}
 }
 
@@ -135,3 +142,21 @@ func SeqnoAbsDistance(a, b SequenceNumber) int16 {
 func SeqnoLess(a, b SequenceNumber) bool {
 	return SeqnoDistance(a, b) > 0
 }
+
+// FormatRouterID formats a router ID as eight colon separated hexadecimal octets
+// like babeld.
+func FormatRouterID(rid RouterID) string {
+	return fmt.Sprintf("%02x:%02x:%02x:%02x:%02x:%02x:%02x:%02x",
+		rid[0], rid[1], rid[2], rid[3], rid[4], rid[5], rid[6], rid[7])
+}
+
+// ParseRouterID parses a router ID formatted by FormatRouterID.
+func ParseRouterID(s string) (rid RouterID, err error) {
+	var n int
+	if n, err = fmt.Sscanf(s, "%02x:%02x:%02x:%02x:%02x:%02x:%02x:%02x",
+		&rid[0], &rid[1], &rid[2], &rid[3], &rid[4], &rid[5], &rid[6], &rid[7]); err != nil || n != len(rid) || len(s) != 23 {
+		return RouterIDUnspecified, fmt.Errorf("%w: %s", ErrInvalidRouterID, s)
+	}
+
+	return rid, nil
+}
@@ -57,4 +57,26 @@ var _ = Context("Types", func() {
 		Expect(proto.SeqnoAbsDistance(0x0001, 0x0002)).To(Equal(int16(1)))
 		Expect(proto.SeqnoAbsDistance(0x0002, 0x0001)).To(Equal(int16(1)))
 	})
+
+	It("FormatRouterID", func() {
+		rid := proto.RouterID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}
+		Expect(proto.FormatRouterID(rid)).To(Equal("01:23:45:67:89:ab:cd:ef"))
+	})
+
+	DescribeTable("ParseRouterID",
+		func(s string, rid proto.RouterID, valid bool) {
+			r, err := proto.ParseRouterID(s)
+			if valid {
+				Expect(err).To(Succeed())
+				Expect(r).To(Equal(rid))
+			} else {
+				Expect(err).To(MatchError(proto.ErrInvalidRouterID))
+			}
+		},
+		Entry("valid", "01:23:45:67:89:ab:cd:ef", proto.RouterID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, true),
+		Entry("upper case", "01:23:45:67:89:AB:CD:EF", proto.RouterID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0xcd, 0xef}, true),
+		Entry("too short", "01:23:45:67:89:ab:cd", proto.RouterID{}, false),
+		Entry("too long", "01:23:45:67:89:ab:cd:ef:01", proto.RouterID{}, false),
+		Entry("invalid", "01:23:45:67:89:ab:cd:xx", proto.RouterID{}, false),
+	)
 })
@@ -62,3 +62,48 @@ func (a *Update) Less(b *Update) bool {
 
 	return false
 }
+
+// PrepareUpdate returns the values which are required to encode an
+// update TLV given the current parser state.
+//
+// The returned values include a copy of the update with flags set
+// such that subsequent updates can be compressed. It is preceded by
+// Router-Id and Next Hop TLVs only if they differ from the parser state.
+// Hence, updates should be appended in the order given by Update.Less.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.5
+func (p *Parser) PrepareUpdate(u *Update) []Value {
+	vs := []Value{}
+	v := *u
+
+	addr := v.Prefix.Addr()
+	ae := addressEncoding(&addr)
+
+	if isCompressible(ae) && v.Prefix.Bits() > 0 {
+		v.Flags |= FlagUpdatePrefix
+	}
+
+	if v.RouterID != RouterIDUnspecified {
+		if addr.Is6() && !addr.Is4In6() && !addr.IsLinkLocalUnicast() &&
+			v.Prefix.Bits() == 128 && RouterIDFromAddr(addr) == v.RouterID {
+			v.Flags |= FlagUpdateRouterID
+		} else if v.RouterID != p.CurrentRouterID {
+			vs = append(vs, &RouterIDValue{
+				RouterID: v.RouterID,
+			})
+		}
+	}
+
+	if v.NextHop.IsValid() {
+		nh := v.NextHop
+		af := addressFamilyFromAddressEncoding(ae)
+
+		if addressFamilyFromAddressEncoding(addressEncoding(&nh)) == af && p.CurrentNextHop[af] != nh {
+			vs = append(vs, &NextHop{
+				NextHop: nh,
+			})
+		}
+	}
+
+	return append(vs, &v)
+}
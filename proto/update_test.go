@@ -5,6 +5,7 @@ package proto
 
 import (
 	"net/netip"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -97,4 +98,100 @@ var _ = Context("Update", func() {
 			},
 		),
 	)
+
+	Describe("PrepareUpdate", func() {
+		var p *Parser
+
+		rid2 := RouterID{1, 2, 3, 4, 5, 6, 7, 8}
+
+		BeforeEach(func() {
+			p = NewParser()
+		})
+
+		encode := func(upds ...*Update) ([]byte, []Value) {
+			b := []byte{}
+			all := []Value{}
+
+			for _, u := range upds {
+				vs := p.PrepareUpdate(u)
+				all = append(all, vs...)
+
+				l := int(p.ValuesLength(vs))
+				o := len(b)
+
+				b = p.AppendValues(b, vs)
+				Expect(b[o:]).To(HaveLen(l))
+			}
+
+			return b, all
+		}
+
+		It("emits the router ID only if it changes", func() {
+			_, vs := encode(
+				&Update{RouterID: rid, Prefix: netip.MustParsePrefix("10.0.0.0/24")},
+				&Update{RouterID: rid, Prefix: netip.MustParsePrefix("10.0.1.0/24")},
+				&Update{RouterID: rid2, Prefix: netip.MustParsePrefix("10.0.2.0/24")},
+			)
+
+			Expect(vs).To(HaveLen(5))
+			Expect(vs[0]).To(Equal(&RouterIDValue{RouterID: rid}))
+			Expect(vs[3]).To(Equal(&RouterIDValue{RouterID: rid2}))
+		})
+
+		It("uses the router ID flag for host prefixes containing the router ID", func() {
+			_, vs := encode(&Update{RouterID: rid, Prefix: netip.MustParsePrefix("2001:db8::1234:5678:90ab:cdef/128")})
+
+			Expect(vs).To(HaveLen(1))
+			Expect(vs[0].(*Update).Flags & FlagUpdateRouterID).NotTo(BeZero())
+		})
+
+		It("emits the next hop only if it changes", func() {
+			nh := netip.MustParseAddr("fe80::1")
+
+			_, vs := encode(
+				&Update{NextHop: nh, Prefix: netip.MustParsePrefix("2001:db8:1::/48")},
+				&Update{NextHop: nh, Prefix: netip.MustParsePrefix("2001:db8:2::/48")},
+			)
+
+			Expect(vs).To(HaveLen(3))
+			Expect(vs[0]).To(Equal(&NextHop{NextHop: nh}))
+		})
+
+		It("does not modify the original update", func() {
+			u := &Update{RouterID: rid, Prefix: netip.MustParsePrefix("10.0.0.0/24")}
+			encode(u)
+
+			Expect(u.Flags).To(BeZero())
+		})
+
+		It("round-trips a compressed sequence of updates", func() {
+			nh := netip.MustParseAddr("fe80::1")
+			upds := []*Update{
+				{Interval: time.Second, Seqno: 1, Metric: 10, RouterID: rid, NextHop: nh, Prefix: netip.MustParsePrefix("2001:db8:1:1::/64")},
+				{Interval: time.Second, Seqno: 2, Metric: 20, RouterID: rid, NextHop: nh, Prefix: netip.MustParsePrefix("2001:db8:1:2::/64")},
+				{Interval: time.Second, Seqno: 3, Metric: 30, RouterID: rid2, NextHop: nh, Prefix: netip.MustParsePrefix("2001:db8:1:3::/64")},
+				{Interval: time.Second, Seqno: 4, Metric: 40, RouterID: rid2, Prefix: netip.MustParsePrefix("10.0.0.0/24")},
+			}
+
+			b, vs := encode(upds...)
+
+			// The IPv6 prefixes share seven octets with the default prefix
+			Expect(vs[2].(*Update).Flags & FlagUpdatePrefix).NotTo(BeZero())
+			Expect(NewParser().ValueLength(vs[2]) - p.ValueLength(vs[2])).To(Equal(7))
+
+			p.Reset()
+			_, vs, err := p.Values(b, false)
+			Expect(err).To(Succeed())
+
+			decoded := []*Update{}
+			for _, v := range vs {
+				if u, ok := v.(*Update); ok {
+					u.Flags = 0
+					decoded = append(decoded, u)
+				}
+			}
+
+			Expect(decoded).To(Equal(upds))
+		})
+	})
 })
@@ -0,0 +1,129 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"errors"
+	"time"
+
+	"cunicu.li/go-babel/proto"
+)
+
+var ErrRouterIDChanged = errors.New("router ID can not be changed at runtime")
+
+// UpdateConfig applies a new configuration without restarting the speaker.
+// Neighbours and routes are retained as far as possible:
+//   - Timers of interfaces and neighbours are retuned to the new intervals.
+//   - Interfaces are added or removed according to the new interface filter.
+//     Routes learned via removed interfaces are flushed.
+//   - The metrics of all routes are recomputed from the new link costs.
+//
+// Afterwards, an IHU is sent to all neighbours and a full update on all
+// interfaces so that neighbours learn about changed costs and routes.
+//
+// The router ID and the logger are kept.
+// Hence, the router ID must either be unspecified or unchanged.
+func (s *Speaker) UpdateConfig(cfg *SpeakerConfig) error {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	old := s.config()
+
+	c := *cfg
+	c.Logger = old.Logger
+
+	if c.RouterID == proto.RouterIDUnspecified {
+		c.RouterID = old.RouterID
+	} else if c.RouterID != old.RouterID {
+		return ErrRouterIDChanged
+	}
+
+	newCfg, err := cloneConfig(&c)
+	if err != nil {
+		return err
+	}
+
+	type intervals struct {
+		hello, update time.Duration
+	}
+
+	oldIntervals := map[*Interface]intervals{}
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		oldIntervals[i] = intervals{i.helloInterval(), i.updateInterval()}
+		return nil
+	})
+
+	s.cfg.Store(newCfg)
+
+	if err := s.syncInterfaces(); err != nil {
+		return err
+	}
+
+	intfs := []*Interface{}
+	neighbours := []*Neighbour{}
+
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		intfs = append(intfs, i)
+
+		i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+			neighbours = append(neighbours, n)
+			return nil
+		})
+
+		return nil
+	})
+
+	for _, i := range intfs {
+		// Newly added interfaces are already using the new intervals
+		if old, ok := oldIntervals[i]; ok {
+			i.retuneTimers(old.hello, old.update)
+		}
+	}
+
+	for _, n := range neighbours {
+		n.retuneTimers(old.Parameters)
+		s.updateNeighbourRoutes(n)
+
+		// Announce changed link costs right away
+		if err := n.sendIHU(); err != nil {
+			return err
+		}
+	}
+
+	for _, i := range intfs {
+		if err := i.sendUpdate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// retuneTimers resets the timers of the interface if their intervals have changed.
+func (i *Interface) retuneTimers(oldHello, oldUpdate time.Duration) {
+	if hello := i.helloInterval(); hello != oldHello {
+		i.helloMulticastTimer.Reset(hello)
+	}
+
+	if update := i.updateInterval(); update != oldUpdate {
+		i.periodicUpdateTimer.Reset(update)
+	}
+}
+
+// retuneTimers resets the timers of the neighbour if their intervals have changed.
+func (n *Neighbour) retuneTimers(old *Parameters) {
+	params := n.intf.speaker.config().Parameters
+
+	if params.IHUInterval != old.IHUInterval {
+		n.ihuTicker.Reset(params.IHUInterval)
+	}
+
+	if params.UnicastHelloInterval != old.UnicastHelloInterval {
+		if params.UnicastHelloInterval > 0 {
+			n.helloTicker.Reset(params.UnicastHelloInterval)
+		} else {
+			n.helloTicker.Stop()
+		}
+	}
+}
@@ -0,0 +1,112 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"time"
+
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+type removedNeighbourHandler struct {
+	removed []*Neighbour
+}
+
+func (h *removedNeighbourHandler) NeighbourAdded(*Neighbour) {}
+
+func (h *removedNeighbourHandler) NeighbourRemoved(n *Neighbour) {
+	h.removed = append(h.removed, n)
+}
+
+var _ = Describe("Reload", func() {
+	var sim *simulation
+	var node *simNode
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+		sim.attachNeighbours()
+	})
+
+	It("uses the link cost of the interface configuration", func() {
+		n := node.neighbours[0]
+		Expect(n.RxCost()).To(BeNumerically("==", DefaultWiredLinkCost))
+
+		cfg := *node.config()
+		cfg.InterfaceDefaults = InterfaceConfig{
+			RxCost: 200,
+		}
+		node.cfg.Store(&cfg)
+
+		Expect(n.RxCost()).To(BeNumerically("==", 200))
+
+		cfg.InterfaceDefaults = InterfaceConfig{
+			Type: InterfaceTypeWireless,
+		}
+
+		Expect(n.RxCost()).To(BeNumerically("==", DefaultWirelessLinkCost))
+	})
+
+	It("flushes the routes of removed neighbours", func() {
+		h := &removedNeighbourHandler{}
+		node.config().Handler = h
+
+		sim.update(0, 1, 100)
+		sim.update(2, 1, 95)
+
+		n := node.neighbours[0]
+		node.updateNeighbourRoutes(n)
+		Expect(sim.route(0).Selected).To(BeTrue())
+
+		sub := node.Subscribe(SubscriptionOptions{BufferSize: 16})
+		defer sub.Close()
+
+		node.removeNeighbour(n)
+
+		Expect(h.removed).To(Equal([]*Neighbour{n}))
+		Expect(node.intf.Neighbours.Lookup(n.Address)).Error().To(BeFalse())
+
+		_, ok := node.Routes.Lookup(sim.prefix, n)
+		Expect(ok).To(BeFalse())
+		Expect(sim.route(2).Selected).To(BeTrue())
+
+		types := []EventType{}
+		for len(sub.C) > 0 {
+			types = append(types, (<-sub.C).EventType())
+		}
+
+		Expect(types).To(Equal([]EventType{
+			EventNeighbourDown,
+			EventRouteRemoved,
+			EventRouteSelected,
+		}))
+	})
+
+	It("rejects changes of the router ID", func() {
+		cfg := *node.config()
+		cfg.RouterID = proto.RouterID{1, 2, 3, 4, 5, 6, 7, 8}
+
+		err := node.UpdateConfig(&cfg)
+		Expect(err).To(MatchError(ErrRouterIDChanged))
+	})
+
+	It("defaults each unset parameter", func() {
+		cfg := *node.config()
+		cfg.Parameters = &Parameters{
+			UpdateInterval: time.Second,
+		}
+
+		Expect(node.UpdateConfig(&cfg)).To(Succeed())
+
+		p := node.config().Parameters
+		Expect(p.UpdateInterval).To(Equal(time.Second))
+		Expect(p.IHUHoldTimeFactor).To(BeNumerically("==", DefaultIHUHoldTimeFactor))
+		Expect(p.RouteExpiryTime).To(Equal(DefaultRouteExpiryTime))
+		Expect(p.AcknowledgmentTimeout).To(Equal(DefaultAcknowledgmentTimeout))
+
+		// Zero disables metric smoothing
+		Expect(p.MetricSmoothingHalfLife).To(BeZero())
+	})
+})
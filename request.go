@@ -0,0 +1,246 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"log/slog"
+	"net/netip"
+	"time"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+)
+
+const (
+	// DefaultSeqnoRequestHopCount is the hop count of seqno requests
+	// originated by us.
+	DefaultSeqnoRequestHopCount = 64
+
+	// maxSeqnoRequestResends is the number of times we resend
+	// a pending seqno request before giving up.
+	maxSeqnoRequestResends = 2
+)
+
+// 3.8.1.1. Route Requests
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.8.1.1
+func (s *Speaker) onRouteRequest(n *Neighbour, rr *proto.RouteRequest) {
+	// Source-specific requests are ignored like source-specific Updates
+	if rr.SourcePrefix != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if isWildcardPrefix(rr.Prefix) {
+		n.sendValues(s.selectedUpdates(n.intf, n), queue.PriorityNormal, s.config().MulticastHelloInterval/2)
+		return
+	}
+
+	var upd *proto.Update
+	if x, ok := s.XRoutes.Lookup(rr.Prefix); ok {
+		upd = s.originatedUpdate(x)
+	} else if r := s.selectedRoute(rr.Prefix); r != nil {
+		upd = s.advertisedUpdate(r)
+	} else {
+		upd = s.retractionUpdate(rr.Prefix, s.config().RouterID)
+	}
+
+	n.sendValues([]proto.Value{upd}, queue.PriorityNormal, s.config().UrgentTimeout)
+}
+
+// 3.8.1.2. Seqno Requests
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.8.1.2
+func (s *Speaker) onSeqnoRequest(n *Neighbour, sr *proto.SeqnoRequest) {
+	// Source-specific requests are ignored like source-specific Updates
+	if sr.SourcePrefix != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if x, ok := s.XRoutes.Lookup(sr.Prefix); ok {
+		s.onOriginatedSeqnoRequest(n, x, sr)
+		return
+	}
+
+	// Requests for prefixes without a selected route are ignored
+	r := s.selectedRoute(sr.Prefix)
+	if r == nil {
+		return
+	}
+
+	// Reply if the request can be satisfied by our selected route
+	if r.Source.RouterID != sr.RouterID || !proto.SeqnoLess(r.SeqNo, sr.Seqno) {
+		n.sendReliableValues([]proto.Value{s.advertisedUpdate(r)}, s.config().UrgentTimeout)
+		return
+	}
+
+	// Otherwise forward the request to the next-hop
+	// unless its hop count is exhausted.
+	if sr.HopCount < 2 || r.Neighbour == n {
+		return
+	}
+
+	// Suppress duplicate requests
+	if req, ok := s.SeqnoRequests.Lookup(sr.Prefix, sr.RouterID); ok && !proto.SeqnoLess(req.Seqno, sr.Seqno) {
+		return
+	}
+
+	req := &PendingSeqNoRequest{
+		Prefix:    sr.Prefix,
+		RouterID:  sr.RouterID,
+		Seqno:     sr.Seqno,
+		HopCount:  sr.HopCount - 1,
+		Neighbour: n,
+		Target:    r.Neighbour,
+	}
+
+	s.sendSeqnoRequest(req)
+	s.emitSeqnoRequestEvent(EventSeqnoRequestForwarded, req)
+}
+
+// onOriginatedSeqnoRequest answers a seqno request for a prefix which
+// we originate ourself. If the request carries our router ID and a
+// seqno greater than ours, we increase our seqno and send a triggered
+// update to all neighbours. Otherwise, the request is answered by
+// an update sent to the requesting neighbour only.
+func (s *Speaker) onOriginatedSeqnoRequest(n *Neighbour, x *XRoute, sr *proto.SeqnoRequest) {
+	if sr.RouterID != s.config().RouterID || !proto.SeqnoLess(s.seqNo, sr.Seqno) {
+		n.sendReliableValues([]proto.Value{s.originatedUpdate(x)}, s.config().UrgentTimeout)
+		return
+	}
+
+	s.seqNo++
+
+	s.logger.Debug("Increased sequence number",
+		slog.Any("seqno", s.seqNo),
+		slog.Any("prefix", x.Prefix))
+
+	s.sendTriggeredUpdate(s.originatedUpdate(x), nil, queue.PriorityUrgent)
+}
+
+// satisfySeqnoRequest removes a pending seqno request after receiving an
+// update which satisfies it and propagates the update urgently.
+func (s *Speaker) satisfySeqnoRequest(upd *proto.Update) {
+	// Requests are also satisfied by updates with another router ID.
+	// Hence, all pending requests for the prefix are checked.
+	reqs := []*PendingSeqNoRequest{}
+	s.SeqnoRequests.Foreach(func(req *PendingSeqNoRequest) error { //nolint:errcheck
+		if req.IsSatisfiedBy(upd) {
+			reqs = append(reqs, req)
+		}
+		return nil
+	})
+
+	if len(reqs) == 0 {
+		return
+	}
+
+	for _, req := range reqs {
+		s.SeqnoRequests.Remove(req)
+		s.emitSeqnoRequestEvent(EventSeqnoRequestSatisfied, req)
+	}
+
+	if r := s.selectedRoute(upd.Prefix); r != nil {
+		s.sendTriggeredUpdate(s.advertisedUpdate(r), r, queue.PriorityUrgent)
+	}
+}
+
+// requestSeqno sends a seqno request for a source after we lost all
+// feasible routes to its prefix.
+//
+// 3.8.2.1. Avoiding Starvation
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.8.2.1
+func (s *Speaker) requestSeqno(src *Source) {
+	if _, ok := s.SeqnoRequests.Lookup(src.Prefix, src.RouterID); ok {
+		return
+	}
+
+	req := &PendingSeqNoRequest{
+		Prefix:   src.Prefix,
+		RouterID: src.RouterID,
+		Seqno:    src.Distance.SeqNo + 1,
+		HopCount: DefaultSeqnoRequestHopCount,
+	}
+
+	s.sendSeqnoRequest(req)
+	s.emitSeqnoRequestEvent(EventSeqnoRequestSent, req)
+}
+
+// sendSeqnoRequest sends a seqno request and tracks it in the
+// table of pending seqno requests.
+//
+// 3.8.2.4. Generating Requests
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.8.2.4
+func (s *Speaker) sendSeqnoRequest(req *PendingSeqNoRequest) {
+	req.Expires = time.Now().Add(s.config().InitialRequestTimeout << req.Resent)
+
+	s.SeqnoRequests.Insert(req)
+
+	sr := &proto.SeqnoRequest{
+		Seqno:    req.Seqno,
+		HopCount: req.HopCount,
+		RouterID: req.RouterID,
+		Prefix:   req.Prefix,
+	}
+
+	s.logger.Debug("Sending seqno request",
+		slog.Any("request", sr),
+		slog.Int("resent", req.Resent))
+
+	if req.Target != nil {
+		req.Target.sendValues([]proto.Value{sr}, queue.PriorityUrgent, s.config().UrgentTimeout)
+	} else {
+		s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+			i.sendValues([]proto.Value{sr}, queue.PriorityUrgent, s.config().UrgentTimeout)
+			return nil
+		})
+	}
+}
+
+// resendSeqnoRequests resends pending seqno requests which have not
+// been satisfied in time and drops them after a number of attempts.
+func (s *Speaker) resendSeqnoRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	expired := []*PendingSeqNoRequest{}
+
+	s.SeqnoRequests.Foreach(func(req *PendingSeqNoRequest) error { //nolint:errcheck
+		if now.After(req.Expires) {
+			expired = append(expired, req)
+		}
+		return nil
+	})
+
+	for _, req := range expired {
+		if req.Resent >= maxSeqnoRequestResends {
+			s.SeqnoRequests.Remove(req)
+			continue
+		}
+
+		req.Resent++
+		s.sendSeqnoRequest(req)
+	}
+}
+
+// selectedRoute returns the currently selected route for a prefix.
+func (s *Speaker) selectedRoute(pfx proto.Prefix) (sel *Route) {
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		if r.Selected && r.Source.Prefix == pfx {
+			sel = r
+		}
+		return nil
+	})
+
+	return sel
+}
+
+// isWildcardPrefix checks if a prefix has been encoded with the wildcard address encoding.
+func isWildcardPrefix(pfx proto.Prefix) bool {
+	return pfx.Bits() == 0 && pfx.Addr() == netip.IPv6Unspecified()
+}
@@ -0,0 +1,208 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"net/netip"
+	"time"
+
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Requests", func() {
+	var sim *simulation
+	var node *simNode
+	var ws map[int]*valueWriter
+
+	// sent returns the Updates and seqno requests sent to the neighbour j
+	sent := func(j int) func() []proto.Value {
+		return func() []proto.Value {
+			vs := []proto.Value{}
+			for _, v := range ws[j].Values() {
+				switch v.(type) {
+				case *proto.Update, *proto.SeqnoRequest:
+					vs = append(vs, v)
+				}
+			}
+			return vs
+		}
+	}
+
+	seqnoRequest := func(from int, seqno proto.SequenceNumber, hopCount uint8) {
+		node.onSeqnoRequest(node.neighbours[from], &proto.SeqnoRequest{
+			Seqno:    seqno,
+			HopCount: hopCount,
+			RouterID: sim.originID,
+			Prefix:   sim.prefix,
+		})
+	}
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+		ws = sim.attachNeighbours()
+
+		// Select a route via neighbour 0
+		sim.update(0, 1, 100)
+
+		next, ok := sim.selectedNextHop(1)
+		Expect(ok).To(BeTrue())
+		Expect(next).To(Equal(0))
+	})
+
+	Context("route requests", func() {
+		It("answers with the selected route", func() {
+			node.onRouteRequest(node.neighbours[2], &proto.RouteRequest{Prefix: sim.prefix})
+
+			Eventually(sent(2)).Should(ConsistOf(And(
+				BeAssignableToTypeOf(&proto.Update{}),
+				HaveField("Prefix", sim.prefix),
+				HaveField("RouterID", sim.originID),
+				HaveField("Seqno", BeNumerically("==", 1)),
+			)))
+		})
+
+		It("ignores source-specific requests", func() {
+			srcPfx := netip.MustParsePrefix("2001:db8:ff::/48")
+
+			node.onRouteRequest(node.neighbours[2], &proto.RouteRequest{
+				Prefix:       sim.prefix,
+				SourcePrefix: &srcPfx,
+			})
+
+			Consistently(sent(2), 2*node.config().UrgentTimeout).Should(BeEmpty())
+		})
+
+		It("answers wildcard requests with a full dump", func() {
+			node.onRouteRequest(node.neighbours[2], &proto.RouteRequest{
+				Prefix: netip.PrefixFrom(netip.IPv6Unspecified(), 0),
+			})
+
+			// Full dumps are sent within half a Hello interval
+			Eventually(sent(2), node.config().MulticastHelloInterval).Should(ContainElement(HaveField("Prefix", sim.prefix)))
+		})
+	})
+
+	Context("seqno requests", func() {
+		It("answers requests which can be satisfied by the selected route", func() {
+			seqnoRequest(2, 1, 16)
+
+			Eventually(sent(2)).Should(ConsistOf(And(
+				BeAssignableToTypeOf(&proto.Update{}),
+				HaveField("Seqno", BeNumerically("==", 1)),
+			)))
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+		})
+
+		It("forwards other requests to the next hop", func() {
+			seqnoRequest(2, 2, 16)
+
+			// Duplicate requests are suppressed
+			seqnoRequest(2, 2, 16)
+
+			Eventually(sent(0)).Should(ConsistOf(&proto.SeqnoRequest{
+				Seqno:    2,
+				HopCount: 15,
+				RouterID: sim.originID,
+				Prefix:   sim.prefix,
+			}))
+			Consistently(sent(0), 50*time.Millisecond).Should(HaveLen(1))
+
+			req, ok := node.SeqnoRequests.Lookup(sim.prefix, sim.originID)
+			Expect(ok).To(BeTrue())
+			Expect(req.Neighbour).To(BeIdenticalTo(node.neighbours[2]))
+			Expect(req.Target).To(BeIdenticalTo(node.neighbours[0]))
+		})
+
+		It("ignores source-specific requests", func() {
+			srcPfx := netip.MustParsePrefix("2001:db8:ff::/48")
+
+			node.onSeqnoRequest(node.neighbours[2], &proto.SeqnoRequest{
+				Seqno:        2,
+				HopCount:     16,
+				RouterID:     sim.originID,
+				Prefix:       sim.prefix,
+				SourcePrefix: &srcPfx,
+			})
+
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+			Consistently(sent(0), 50*time.Millisecond).Should(BeEmpty())
+		})
+
+		It("does not forward requests with an exhausted hop count", func() {
+			seqnoRequest(2, 2, 1)
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+		})
+
+		It("does not forward requests back to the next hop", func() {
+			seqnoRequest(0, 2, 16)
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+		})
+
+		It("removes pending requests once they are satisfied", func() {
+			seqnoRequest(2, 2, 16)
+			Expect(node.SeqnoRequests.Len()).To(Equal(1))
+
+			sim.update(0, 2, 100)
+
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+		})
+
+		It("removes pending requests satisfied by an update with another router ID", func() {
+			seqnoRequest(2, 2, 16)
+			Expect(node.SeqnoRequests.Len()).To(Equal(1))
+
+			node.onUpdate(node.neighbours[0], &proto.Update{
+				Interval: time.Second,
+				Seqno:    1,
+				Metric:   100,
+				Prefix:   sim.prefix,
+				RouterID: proto.RouterID{0xff},
+			})
+
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+		})
+
+		It("resends expired requests before giving up", func() {
+			seqnoRequest(2, 2, 16)
+
+			req, ok := node.SeqnoRequests.Lookup(sim.prefix, sim.originID)
+			Expect(ok).To(BeTrue())
+
+			for i := 1; i <= maxSeqnoRequestResends; i++ {
+				req.Expires = time.Now().Add(-time.Second)
+				node.resendSeqnoRequests()
+
+				Expect(req.Resent).To(Equal(i))
+				Expect(node.SeqnoRequests.Len()).To(Equal(1))
+			}
+
+			Eventually(sent(0)).Should(HaveLen(1 + maxSeqnoRequestResends))
+
+			req.Expires = time.Now().Add(-time.Second)
+			node.resendSeqnoRequests()
+
+			Expect(node.SeqnoRequests.Len()).To(BeZero())
+		})
+	})
+
+	DescribeTable("IsSatisfiedBy",
+		func(upd *proto.Update, satisfied bool) {
+			req := &PendingSeqNoRequest{
+				Prefix:   netip.MustParsePrefix("2001:db8::/64"),
+				RouterID: proto.RouterID{1},
+				Seqno:    10,
+			}
+
+			Expect(req.IsSatisfiedBy(upd)).To(Equal(satisfied))
+		},
+		Entry("greater seqno", &proto.Update{Prefix: netip.MustParsePrefix("2001:db8::/64"), RouterID: proto.RouterID{1}, Seqno: 11}, true),
+		Entry("equal seqno", &proto.Update{Prefix: netip.MustParsePrefix("2001:db8::/64"), RouterID: proto.RouterID{1}, Seqno: 10}, true),
+		Entry("smaller seqno", &proto.Update{Prefix: netip.MustParsePrefix("2001:db8::/64"), RouterID: proto.RouterID{1}, Seqno: 9}, false),
+		Entry("other router ID", &proto.Update{Prefix: netip.MustParsePrefix("2001:db8::/64"), RouterID: proto.RouterID{2}, Seqno: 1}, true),
+		Entry("other prefix", &proto.Update{Prefix: netip.MustParsePrefix("2001:db8:1::/64"), RouterID: proto.RouterID{1}, Seqno: 11}, false),
+		Entry("retraction", &proto.Update{Prefix: netip.MustParsePrefix("2001:db8::/64"), RouterID: proto.RouterID{1}, Seqno: 11, Metric: proto.Retraction}, false),
+	)
+})
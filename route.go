@@ -4,6 +4,9 @@
 package babel
 
 import (
+	"math"
+	"time"
+
 	"cunicu.li/go-babel/proto"
 )
 
@@ -13,13 +16,77 @@ type Route struct {
 	Source    *Source
 	Neighbour *Neighbour
 
-	Metric         uint16
-	SmoothedMetric uint16
+	Metric         uint16 // The metric of the route including the cost of the link to the neighbour.
+	RefMetric      uint16 // The metric with which the route has been advertised by the neighbour.
+	SmoothedMetric uint16 // The exponentially smoothed metric which is used for route selection.
 	SeqNo          proto.SequenceNumber
 	NextHop        proto.Address
+	Channels       []uint8 // The channels along the path of the route as advertised by the neighbour.
 	Selected       bool
+
+	Expires time.Time
+
+	// smoothed is the exact smoothed metric. It is kept as a float
+	// as rounding after each step would stall the smoothing if the
+	// metric is smoothed frequently.
+	smoothed   float64
+	smoothedAt time.Time
+}
+
+// SetMetric updates the metric of the route. The smoothed metric follows
+// the new metric exponentially with the provided half-life.
+// A half-life of zero disables smoothing.
+func (r *Route) SetMetric(metric uint16, halfLife time.Duration) {
+	fresh := r.smoothedAt.IsZero()
+
+	r.smooth(time.Now(), halfLife)
+	r.Metric = metric
+
+	// Changes to or from infinity are not smoothed
+	if fresh || halfLife <= 0 || metric == proto.Retraction || r.SmoothedMetric == proto.Retraction {
+		r.SmoothedMetric = metric
+		r.smoothed = float64(metric)
+	}
+}
+
+// smooth advances the smoothed metric towards the current metric.
+func (r *Route) smooth(now time.Time, halfLife time.Duration) {
+	if halfLife > 0 && !r.smoothedAt.IsZero() && now.After(r.smoothedAt) {
+		f := math.Exp2(-now.Sub(r.smoothedAt).Seconds() / halfLife.Seconds())
+
+		r.smoothed = float64(r.Metric) + (r.smoothed-float64(r.Metric))*f
+		r.SmoothedMetric = uint16(math.Round(r.smoothed))
+	}
+
+	r.smoothedAt = now
 }
 
-func (r *Route) SetMetric(metric uint16) {
-	// r.SmoothedMetric = (ALPHA * r.SmoothedMetric) + ((1 - ALPHA) * metric)
+// IsFeasible checks if the route satisfies the feasibility condition
+// of its source.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.1
+func (r *Route) IsFeasible() bool {
+	return r.Source.IsFeasible(r.SeqNo, r.RefMetric)
+}
+
+// IsRetracted checks if the route has been retracted.
+func (r *Route) IsRetracted() bool {
+	return r.Metric == proto.Retraction
+}
+
+// isSelectable checks if the route is a candidate for route selection.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.6
+func (r *Route) isSelectable() bool {
+	return !r.IsRetracted() && r.IsFeasible()
+}
+
+// 3.5.2. Metric Computation
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.2
+func addMetric(metric, cost proto.Metric) proto.Metric {
+	if sum := uint32(metric) + uint32(cost); sum < uint32(proto.Retraction) {
+		return proto.Metric(sum)
+	}
+
+	return proto.Retraction
 }
@@ -9,22 +9,43 @@ import (
 )
 
 type routeKey struct {
-	Prefix   proto.Prefix
-	RouterID proto.RouterID
+	Prefix    proto.Prefix
+	Neighbour *Neighbour
 }
 
 type RouteTable table.Table[routeKey, *Route]
 
-func (t *RouteTable) Lookup(pfx proto.Prefix, rid proto.RouterID) (*Route, bool) {
+func NewRouteTable() RouteTable {
+	return RouteTable(table.New[routeKey, *Route]())
+}
+
+func (t *RouteTable) Lookup(pfx proto.Prefix, n *Neighbour) (*Route, bool) {
 	return (*table.Table[routeKey, *Route])(t).Lookup(routeKey{
-		Prefix:   pfx,
-		RouterID: rid,
+		Prefix:    pfx,
+		Neighbour: n,
 	})
 }
 
 func (t *RouteTable) Insert(r *Route) {
 	(*table.Table[routeKey, *Route])(t).Insert(routeKey{
 		r.Source.Prefix,
-		r.Source.RouterID,
+		r.Neighbour,
 	}, r)
 }
+
+func (t *RouteTable) Remove(r *Route) {
+	(*table.Table[routeKey, *Route])(t).Remove(routeKey{
+		r.Source.Prefix,
+		r.Neighbour,
+	})
+}
+
+func (t *RouteTable) Foreach(cb func(*Route) error) error {
+	return (*table.Table[routeKey, *Route])(t).ForEach(func(k routeKey, v *Route) error {
+		return cb(v)
+	})
+}
+
+func (t *RouteTable) Len() int {
+	return (*table.Table[routeKey, *Route])(t).Len()
+}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"time"
+
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Context("Route", func() {
+	const halfLife = 4 * time.Second
+
+	Describe("Metric smoothing", func() {
+		var r *Route
+
+		BeforeEach(func() {
+			r = &Route{}
+			r.SetMetric(100, halfLife)
+		})
+
+		It("starts with the initial metric", func() {
+			Expect(r.Metric).To(BeNumerically("==", 100))
+			Expect(r.SmoothedMetric).To(BeNumerically("==", 100))
+		})
+
+		It("follows metric changes exponentially", func() {
+			r.SetMetric(300, halfLife)
+			Expect(r.Metric).To(BeNumerically("==", 300))
+			Expect(r.SmoothedMetric).To(BeNumerically("~", 100, 1))
+
+			t := r.smoothedAt
+
+			r.smooth(t.Add(halfLife), halfLife)
+			Expect(r.SmoothedMetric).To(BeNumerically("~", 200, 1))
+
+			r.smooth(t.Add(2*halfLife), halfLife)
+			Expect(r.SmoothedMetric).To(BeNumerically("~", 250, 1))
+
+			r.smooth(t.Add(20*halfLife), halfLife)
+			Expect(r.SmoothedMetric).To(BeNumerically("==", 300))
+		})
+
+		It("follows metric changes in many small steps", func() {
+			r.SetMetric(300, halfLife)
+
+			t := r.smoothedAt
+			step := 50 * time.Millisecond
+
+			for d := step; d <= halfLife; d += step {
+				r.smooth(t.Add(d), halfLife)
+			}
+
+			Expect(r.SmoothedMetric).To(BeNumerically("~", 200, 1))
+
+			for d := halfLife + step; d <= 20*halfLife; d += step {
+				r.smooth(t.Add(d), halfLife)
+			}
+
+			Expect(r.SmoothedMetric).To(BeNumerically("==", 300))
+		})
+
+		It("does not smooth retractions", func() {
+			r.SetMetric(proto.Retraction, halfLife)
+			Expect(r.SmoothedMetric).To(Equal(proto.Retraction))
+
+			r.SetMetric(100, halfLife)
+			Expect(r.SmoothedMetric).To(BeNumerically("==", 100))
+		})
+
+		It("can be disabled", func() {
+			r.SetMetric(300, 0)
+			Expect(r.SmoothedMetric).To(BeNumerically("==", 300))
+		})
+	})
+
+	Describe("Selection hysteresis", func() {
+		var sim *simulation
+		var node *simNode
+
+		update := func(from int, metric proto.Metric) {
+			node.onUpdate(node.neighbours[from], &proto.Update{
+				Seqno:    1,
+				Metric:   metric,
+				Prefix:   sim.prefix,
+				RouterID: sim.originID,
+			})
+		}
+
+		BeforeEach(func() {
+			sim = newSimulation(1, 3)
+			sim.link(1, 2)
+
+			node = sim.nodes[1]
+			node.neighbours[0].TxCost = 10
+			node.neighbours[2].TxCost = 10
+		})
+
+		It("does not switch routes on short metric fluctuations", func() {
+			update(0, 90)
+			next, _ := sim.selectedNextHop(1)
+			Expect(next).To(Equal(0))
+
+			// The metric of the selected route spikes
+			node.neighbours[0].TxCost = 310
+			node.updateNeighbourRoutes(node.neighbours[0])
+
+			// An alternative route is better in terms of the actual metric,
+			// but not in terms of the smoothed metric
+			update(2, 190)
+
+			next, _ = sim.selectedNextHop(1)
+			Expect(next).To(Equal(0))
+		})
+
+		It("switches routes if the alternative is better in both metrics", func() {
+			update(0, 190)
+			update(2, 90)
+
+			next, _ := sim.selectedNextHop(1)
+			Expect(next).To(Equal(2))
+		})
+
+		It("uses the actual metric for feasibility distances", func() {
+			update(0, 90)
+
+			node.neighbours[0].TxCost = 310
+			node.updateNeighbourRoutes(node.neighbours[0])
+
+			r, ok := node.Routes.Lookup(sim.prefix, node.neighbours[0])
+			Expect(ok).To(BeTrue())
+			Expect(r.Metric).To(BeNumerically("==", 400))
+			Expect(r.SmoothedMetric).To(BeNumerically("<", 400))
+
+			upds := node.selectedUpdates(nil, nil)
+			Expect(upds).To(HaveLen(1))
+			Expect(upds[0].(*proto.Update).Metric).To(BeNumerically("==", 400))
+		})
+	})
+
+	Describe("Housekeeping", func() {
+		It("stops once the speaker is closed", func() {
+			node := newSimulation(1, 2).nodes[1]
+			node.housekeepingTicker = time.NewTicker(time.Millisecond)
+			node.closed = make(chan struct{})
+			node.timersDone = make(chan struct{})
+
+			go node.runTimers()
+
+			close(node.closed)
+			node.housekeepingTicker.Stop()
+
+			Eventually(node.timersDone).Should(BeClosed())
+		})
+	})
+})
@@ -4,6 +4,8 @@
 package babel
 
 import (
+	"time"
+
 	"cunicu.li/go-babel/proto"
 )
 
@@ -13,7 +15,20 @@ import (
 type PendingSeqNoRequest struct {
 	Prefix   proto.Prefix
 	RouterID proto.RouterID
+	Seqno    proto.SequenceNumber
 
-	Neighbour *Neighbour
+	Neighbour *Neighbour // The neighbour on behalf of which we forwarded the request, or nil.
+	Target    *Neighbour // The neighbour to which the request has been sent, or nil if it has been multicasted.
+	HopCount  uint8
 	Resent    int
+	Expires   time.Time
+}
+
+// IsSatisfiedBy checks if an update satisfies the request.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.8.1.2
+func (r *PendingSeqNoRequest) IsSatisfiedBy(upd *proto.Update) bool {
+	return upd.Prefix == r.Prefix &&
+		upd.Metric != proto.Retraction &&
+		(upd.RouterID != r.RouterID || !proto.SeqnoLess(upd.Seqno, r.Seqno))
 }
@@ -15,6 +15,10 @@ type pendingSeqNoRequestKey struct {
 
 type PendingSeqNoRequestTable table.Table[pendingSeqNoRequestKey, *PendingSeqNoRequest]
 
+func NewPendingSeqNoRequestTable() PendingSeqNoRequestTable {
+	return PendingSeqNoRequestTable(table.New[pendingSeqNoRequestKey, *PendingSeqNoRequest]())
+}
+
 func (t *PendingSeqNoRequestTable) Lookup(pfx proto.Prefix, rid proto.RouterID) (*PendingSeqNoRequest, bool) {
 	return (*table.Table[pendingSeqNoRequestKey, *PendingSeqNoRequest])(t).Lookup(pendingSeqNoRequestKey{
 		Prefix:   pfx,
@@ -28,3 +32,20 @@ func (t *PendingSeqNoRequestTable) Insert(req *PendingSeqNoRequest) {
 		RouterID: req.RouterID,
 	}, req)
 }
+
+func (t *PendingSeqNoRequestTable) Remove(req *PendingSeqNoRequest) {
+	(*table.Table[pendingSeqNoRequestKey, *PendingSeqNoRequest])(t).Remove(pendingSeqNoRequestKey{
+		Prefix:   req.Prefix,
+		RouterID: req.RouterID,
+	})
+}
+
+func (t *PendingSeqNoRequestTable) Foreach(cb func(*PendingSeqNoRequest) error) error {
+	return (*table.Table[pendingSeqNoRequestKey, *PendingSeqNoRequest])(t).ForEach(func(k pendingSeqNoRequestKey, v *PendingSeqNoRequest) error {
+		return cb(v)
+	})
+}
+
+func (t *PendingSeqNoRequestTable) Len() int {
+	return (*table.Table[pendingSeqNoRequestKey, *PendingSeqNoRequest])(t).Len()
+}
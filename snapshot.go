@@ -0,0 +1,411 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"cmp"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"cunicu.li/go-babel/proto"
+)
+
+// Snapshot is a consistent copy of the state of a speaker.
+// It contains only values and can be safely accessed and
+// serialized to JSON while the speaker keeps running.
+//
+// All tables are sorted to allow for a stable comparison of snapshots.
+type Snapshot struct {
+	Time     time.Time `json:"time"`
+	RouterID string    `json:"router_id"`
+
+	Interfaces    []InterfaceSnapshot    `json:"interfaces"`
+	Neighbours    []NeighbourSnapshot    `json:"neighbours"`
+	Sources       []SourceSnapshot       `json:"sources"`
+	Routes        []RouteSnapshot        `json:"routes"`
+	XRoutes       []XRouteSnapshot       `json:"xroutes"`
+	SeqnoRequests []SeqnoRequestSnapshot `json:"seqno_requests"`
+}
+
+// 3.2.3. The Interface Table
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.3
+type InterfaceSnapshot struct {
+	Index       int    `json:"index"`
+	Name        string `json:"name"`
+	MTU         int    `json:"mtu"`
+	Up          bool   `json:"up"`
+	Multicast   bool   `json:"multicast"`
+	QueueLength int    `json:"queue_length"`
+
+	AddressLinkLocal proto.Address `json:"address_link_local"`
+	AddressIPv4      proto.Address `json:"address_ipv4"`
+}
+
+// HelloHistorySnapshot is the history of Hellos received from a neighbour.
+//
+// See: A.1. Maintaining Hello History
+// https://datatracker.ietf.org/doc/html/rfc8966#appendix-A.1
+type HelloHistorySnapshot struct {
+	Vector        uint16               `json:"vector"`
+	ExpectedSeqno proto.SequenceNumber `json:"expected_seqno"`
+}
+
+// 3.2.4. The Neighbour Table
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.4
+type NeighbourSnapshot struct {
+	Interface   string        `json:"interface"`
+	Address     proto.Address `json:"address"`
+	AddressIPv4 proto.Address `json:"address_ipv4"`
+
+	UnicastHellos   HelloHistorySnapshot `json:"unicast_hellos"`
+	MulticastHellos HelloHistorySnapshot `json:"multicast_hellos"`
+
+	RxCost uint16 `json:"rxcost"`
+	TxCost uint16 `json:"txcost"`
+	Cost   uint16 `json:"cost"`
+
+	// RTT is the smoothed round-trip time to the neighbour.
+	// It is zero until the first sample has been taken.
+	RTT time.Duration `json:"rtt"`
+
+	PendingAcknowledgments int `json:"pending_acknowledgments"`
+	QueueLength            int `json:"queue_length"`
+}
+
+// 3.2.5. The Source Table
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.5
+type SourceSnapshot struct {
+	Prefix      proto.Prefix         `json:"prefix"`
+	RouterID    string               `json:"router_id"`
+	HasDistance bool                 `json:"has_distance"`
+	Seqno       proto.SequenceNumber `json:"seqno"`
+	Metric      proto.Metric         `json:"metric"`
+	Expires     time.Time            `json:"expires"`
+}
+
+// 3.2.6. The Route Table
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.6
+type RouteSnapshot struct {
+	Prefix    proto.Prefix  `json:"prefix"`
+	RouterID  string        `json:"router_id"`
+	Interface string        `json:"interface"`
+	Neighbour proto.Address `json:"neighbour"`
+	NextHop   proto.Address `json:"next_hop"`
+
+	Seqno          proto.SequenceNumber `json:"seqno"`
+	Metric         uint16               `json:"metric"`
+	RefMetric      uint16               `json:"ref_metric"`
+	SmoothedMetric uint16               `json:"smoothed_metric"`
+
+	Feasible bool      `json:"feasible"`
+	Selected bool      `json:"selected"`
+	Expires  time.Time `json:"expires"`
+}
+
+// XRouteSnapshot is a route originated by the speaker.
+type XRouteSnapshot struct {
+	Prefix proto.Prefix `json:"prefix"`
+	Metric uint16       `json:"metric"`
+}
+
+// 3.2.7. The Table of Pending Seqno Requests
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.7
+type SeqnoRequestSnapshot struct {
+	Prefix    proto.Prefix         `json:"prefix"`
+	RouterID  string               `json:"router_id"`
+	Seqno     proto.SequenceNumber `json:"seqno"`
+	HopCount  uint8                `json:"hop_count"`
+	Neighbour proto.Address        `json:"neighbour"`
+	Resent    int                  `json:"resent"`
+	Expires   time.Time            `json:"expires"`
+}
+
+// Snapshot returns a consistent copy of the state of the speaker.
+func (s *Speaker) Snapshot() *Snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := &Snapshot{
+		Time:          time.Now().Round(0),
+		RouterID:      proto.FormatRouterID(s.config().RouterID),
+		Interfaces:    []InterfaceSnapshot{},
+		Neighbours:    []NeighbourSnapshot{},
+		Sources:       []SourceSnapshot{},
+		Routes:        []RouteSnapshot{},
+		XRoutes:       []XRouteSnapshot{},
+		SeqnoRequests: []SeqnoRequestSnapshot{},
+	}
+
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		snap.Interfaces = append(snap.Interfaces, i.snapshot())
+
+		i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+			snap.Neighbours = append(snap.Neighbours, n.snapshot())
+			return nil
+		})
+
+		return nil
+	})
+
+	s.Sources.Foreach(func(src *Source) error { //nolint:errcheck
+		snap.Sources = append(snap.Sources, src.snapshot())
+		return nil
+	})
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		snap.Routes = append(snap.Routes, r.snapshot())
+		return nil
+	})
+
+	s.XRoutes.Foreach(func(x *XRoute) error { //nolint:errcheck
+		snap.XRoutes = append(snap.XRoutes, XRouteSnapshot{
+			Prefix: x.Prefix,
+			Metric: x.Metric,
+		})
+		return nil
+	})
+
+	s.SeqnoRequests.Foreach(func(req *PendingSeqNoRequest) error { //nolint:errcheck
+		snap.SeqnoRequests = append(snap.SeqnoRequests, req.snapshot())
+		return nil
+	})
+
+	slices.SortFunc(snap.Interfaces, func(a, b InterfaceSnapshot) int {
+		return cmp.Compare(a.Index, b.Index)
+	})
+
+	slices.SortFunc(snap.Neighbours, func(a, b NeighbourSnapshot) int {
+		return cmp.Or(
+			cmp.Compare(a.Interface, b.Interface),
+			a.Address.Compare(b.Address))
+	})
+
+	slices.SortFunc(snap.Sources, func(a, b SourceSnapshot) int {
+		return cmp.Or(
+			comparePrefix(a.Prefix, b.Prefix),
+			cmp.Compare(a.RouterID, b.RouterID))
+	})
+
+	slices.SortFunc(snap.Routes, func(a, b RouteSnapshot) int {
+		return cmp.Or(
+			comparePrefix(a.Prefix, b.Prefix),
+			cmp.Compare(a.Interface, b.Interface),
+			a.Neighbour.Compare(b.Neighbour))
+	})
+
+	slices.SortFunc(snap.XRoutes, func(a, b XRouteSnapshot) int {
+		return comparePrefix(a.Prefix, b.Prefix)
+	})
+
+	slices.SortFunc(snap.SeqnoRequests, func(a, b SeqnoRequestSnapshot) int {
+		return cmp.Or(
+			comparePrefix(a.Prefix, b.Prefix),
+			cmp.Compare(a.RouterID, b.RouterID))
+	})
+
+	return snap
+}
+
+func (i *Interface) snapshot() InterfaceSnapshot {
+	is := InterfaceSnapshot{
+		Name:        i.name(),
+		Multicast:   i.multicast,
+		QueueLength: i.QueueLength(),
+	}
+
+	if i.Interface != nil {
+		is.Index = i.Index
+		is.MTU = i.MTU
+		is.Up = i.Flags&net.FlagUp != 0
+		is.AddressLinkLocal = i.addressLinkLocal()
+		is.AddressIPv4 = i.addressIPv4()
+	}
+
+	return is
+}
+
+func (n *Neighbour) snapshot() NeighbourSnapshot {
+	ns := NeighbourSnapshot{
+		Interface:              n.intf.name(),
+		Address:                n.Address,
+		AddressIPv4:            n.AddressIPv4,
+		RxCost:                 n.RxCost(),
+		TxCost:                 n.TxCost,
+		Cost:                   n.Cost(),
+		RTT:                    n.RTT,
+		PendingAcknowledgments: n.PendingAcknowledgments.Len(),
+		QueueLength:            n.QueueLength(),
+	}
+
+	ns.UnicastHellos.Vector, ns.UnicastHellos.ExpectedSeqno = n.helloUnicast.State()
+	ns.MulticastHellos.Vector, ns.MulticastHellos.ExpectedSeqno = n.helloMulticast.State()
+
+	return ns
+}
+
+func (s *Source) snapshot() SourceSnapshot {
+	return SourceSnapshot{
+		Prefix:      s.Prefix,
+		RouterID:    proto.FormatRouterID(s.RouterID),
+		HasDistance: s.HasDistance(),
+		Seqno:       s.Distance.SeqNo,
+		Metric:      s.Distance.Metric,
+		Expires:     s.Expires.Round(0),
+	}
+}
+
+func (r *Route) snapshot() RouteSnapshot {
+	rs := RouteSnapshot{
+		Prefix:         r.Source.Prefix,
+		RouterID:       proto.FormatRouterID(r.Source.RouterID),
+		NextHop:        r.NextHop,
+		Seqno:          r.SeqNo,
+		Metric:         r.Metric,
+		RefMetric:      r.RefMetric,
+		SmoothedMetric: r.SmoothedMetric,
+		Feasible:       r.IsFeasible(),
+		Selected:       r.Selected,
+		Expires:        r.Expires.Round(0),
+	}
+
+	if n := r.Neighbour; n != nil {
+		rs.Interface = n.intf.name()
+		rs.Neighbour = n.Address
+	}
+
+	return rs
+}
+
+func (r *PendingSeqNoRequest) snapshot() SeqnoRequestSnapshot {
+	rs := SeqnoRequestSnapshot{
+		Prefix:   r.Prefix,
+		RouterID: proto.FormatRouterID(r.RouterID),
+		Seqno:    r.Seqno,
+		HopCount: r.HopCount,
+		Resent:   r.Resent,
+		Expires:  r.Expires.Round(0),
+	}
+
+	if r.Neighbour != nil {
+		rs.Neighbour = r.Neighbour.Address
+	}
+
+	return rs
+}
+
+// SnapshotDiff contains the differences between two snapshots.
+// Changes of expiry timers are ignored.
+type SnapshotDiff struct {
+	Interfaces    TableDiff[InterfaceSnapshot]    `json:"interfaces"`
+	Neighbours    TableDiff[NeighbourSnapshot]    `json:"neighbours"`
+	Sources       TableDiff[SourceSnapshot]       `json:"sources"`
+	Routes        TableDiff[RouteSnapshot]        `json:"routes"`
+	XRoutes       TableDiff[XRouteSnapshot]       `json:"xroutes"`
+	SeqnoRequests TableDiff[SeqnoRequestSnapshot] `json:"seqno_requests"`
+}
+
+// Empty checks if both snapshots are equal.
+func (d *SnapshotDiff) Empty() bool {
+	return d.Interfaces.Empty() &&
+		d.Neighbours.Empty() &&
+		d.Sources.Empty() &&
+		d.Routes.Empty() &&
+		d.XRoutes.Empty() &&
+		d.SeqnoRequests.Empty()
+}
+
+// TableDiff contains the entries of a table which have been
+// added, removed or changed between two snapshots.
+type TableDiff[T any] struct {
+	Added   []T         `json:"added,omitempty"`
+	Removed []T         `json:"removed,omitempty"`
+	Changed []Change[T] `json:"changed,omitempty"`
+}
+
+// Change is an entry of a table which has been changed.
+type Change[T any] struct {
+	Old T `json:"old"`
+	New T `json:"new"`
+}
+
+func (d *TableDiff[T]) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// Diff returns the changes from the snapshot to a newer one.
+func (s *Snapshot) Diff(newer *Snapshot) *SnapshotDiff {
+	type neighbourKey struct {
+		Interface string
+		Address   proto.Address
+	}
+
+	type sourceKey struct {
+		Prefix   proto.Prefix
+		RouterID string
+	}
+
+	type routeKey struct {
+		Prefix    proto.Prefix
+		Interface string
+		Neighbour proto.Address
+	}
+
+	return &SnapshotDiff{
+		Interfaces: diffTable(s.Interfaces, newer.Interfaces,
+			func(i InterfaceSnapshot) int { return i.Index },
+			func(i InterfaceSnapshot) InterfaceSnapshot { return i }),
+		Neighbours: diffTable(s.Neighbours, newer.Neighbours,
+			func(n NeighbourSnapshot) neighbourKey { return neighbourKey{n.Interface, n.Address} },
+			func(n NeighbourSnapshot) NeighbourSnapshot { return n }),
+		Sources: diffTable(s.Sources, newer.Sources,
+			func(src SourceSnapshot) sourceKey { return sourceKey{src.Prefix, src.RouterID} },
+			func(src SourceSnapshot) SourceSnapshot { src.Expires = time.Time{}; return src }),
+		Routes: diffTable(s.Routes, newer.Routes,
+			func(r RouteSnapshot) routeKey { return routeKey{r.Prefix, r.Interface, r.Neighbour} },
+			func(r RouteSnapshot) RouteSnapshot { r.Expires = time.Time{}; return r }),
+		XRoutes: diffTable(s.XRoutes, newer.XRoutes,
+			func(x XRouteSnapshot) proto.Prefix { return x.Prefix },
+			func(x XRouteSnapshot) XRouteSnapshot { return x }),
+		SeqnoRequests: diffTable(s.SeqnoRequests, newer.SeqnoRequests,
+			func(r SeqnoRequestSnapshot) sourceKey { return sourceKey{r.Prefix, r.RouterID} },
+			func(r SeqnoRequestSnapshot) SeqnoRequestSnapshot { r.Expires = time.Time{}; return r }),
+	}
+}
+
+// diffTable compares two tables whose entries are identified by key.
+// Entries are compared after being normalized by norm.
+func diffTable[K, T comparable](older, newer []T, key func(T) K, norm func(T) T) (d TableDiff[T]) {
+	olderByKey := map[K]T{}
+	for _, o := range older {
+		olderByKey[key(o)] = o
+	}
+
+	newerKeys := map[K]any{}
+	for _, n := range newer {
+		k := key(n)
+		newerKeys[k] = nil
+
+		if o, ok := olderByKey[k]; !ok {
+			d.Added = append(d.Added, n)
+		} else if norm(o) != norm(n) {
+			d.Changed = append(d.Changed, Change[T]{Old: o, New: n})
+		}
+	}
+
+	for _, o := range older {
+		if _, ok := newerKeys[key(o)]; !ok {
+			d.Removed = append(d.Removed, o)
+		}
+	}
+
+	return d
+}
+
+func comparePrefix(a, b netip.Prefix) int {
+	return cmp.Or(
+		a.Addr().Compare(b.Addr()),
+		cmp.Compare(a.Bits(), b.Bits()))
+}
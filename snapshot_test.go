@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"time"
+
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Snapshot", func() {
+	var sim *simulation
+	var node *simNode
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+		sim.attachNeighbours()
+
+		node.intf.Interface = &net.Interface{Index: 1, Name: "eth0", MTU: 1500}
+		node.Interfaces.Insert(node.intf)
+	})
+
+	It("copies the tables", func() {
+		sim.update(0, 1, 100)
+		sim.update(2, 1, 100)
+
+		snap := node.Snapshot()
+
+		Expect(snap.RouterID).To(Equal("00:00:00:00:00:00:00:02"))
+		Expect(snap.Interfaces).To(HaveLen(1))
+
+		Expect(snap.Neighbours).To(HaveLen(2))
+		Expect(snap.Neighbours[0].Address).To(Equal(node.neighbours[0].Address))
+		Expect(snap.Neighbours[0].TxCost).To(BeNumerically("==", 10))
+		Expect(snap.Neighbours[0].Cost).To(BeNumerically("==", 10))
+		Expect(snap.Neighbours[0].MulticastHellos.Vector).To(Equal(uint16(0b11)))
+		Expect(snap.Neighbours[0].MulticastHellos.ExpectedSeqno).To(BeNumerically("==", 3))
+
+		Expect(snap.Sources).To(HaveLen(1))
+		Expect(snap.Sources[0].HasDistance).To(BeTrue())
+		Expect(snap.Sources[0].Metric).To(BeNumerically("==", 110))
+
+		Expect(snap.Routes).To(HaveLen(2))
+		Expect(snap.Routes[0].Neighbour).To(Equal(node.neighbours[0].Address))
+		Expect(snap.Routes[0].Selected).To(BeTrue())
+		Expect(snap.Routes[0].Metric).To(BeNumerically("==", 110))
+		Expect(snap.Routes[1].Selected).To(BeFalse())
+		Expect(snap.Routes[1].Metric).To(BeNumerically("==", 120))
+	})
+
+	It("is not affected by later changes", func() {
+		sim.update(0, 1, 100)
+
+		snap := node.Snapshot()
+
+		sim.update(0, 2, 200)
+
+		Expect(snap.Routes[0].Seqno).To(BeNumerically("==", 1))
+		Expect(snap.Routes[0].Metric).To(BeNumerically("==", 110))
+	})
+
+	It("can be serialized to JSON", func() {
+		sim.update(0, 1, 100)
+
+		snap := node.Snapshot()
+
+		b, err := json.Marshal(snap)
+		Expect(err).To(Succeed())
+
+		var snap2 Snapshot
+		Expect(json.Unmarshal(b, &snap2)).To(Succeed())
+		Expect(snap.Diff(&snap2).Empty()).To(BeTrue())
+		Expect(snap2.Routes[0].Prefix).To(Equal(sim.prefix))
+	})
+
+	It("can be compared", func() {
+		sim.update(0, 1, 100)
+
+		older := node.Snapshot()
+
+		sim.update(0, 2, 100)
+		sim.update(2, 2, 100)
+
+		newer := node.Snapshot()
+
+		Expect(older.Diff(older).Empty()).To(BeTrue())
+
+		diff := older.Diff(newer)
+		Expect(diff.Empty()).To(BeFalse())
+		Expect(diff.Interfaces.Empty()).To(BeTrue())
+		Expect(diff.Neighbours.Empty()).To(BeTrue())
+
+		Expect(diff.Routes.Added).To(HaveLen(1))
+		Expect(diff.Routes.Added[0].Neighbour).To(Equal(node.neighbours[2].Address))
+		Expect(diff.Routes.Removed).To(BeEmpty())
+		Expect(diff.Routes.Changed).To(HaveLen(1))
+		Expect(diff.Routes.Changed[0].Old.Seqno).To(BeNumerically("==", 1))
+		Expect(diff.Routes.Changed[0].New.Seqno).To(BeNumerically("==", 2))
+
+		reverse := newer.Diff(older)
+		Expect(reverse.Routes.Removed).To(HaveLen(1))
+		Expect(reverse.Routes.Added).To(BeEmpty())
+	})
+
+	It("ignores expiry timers when comparing", func() {
+		sim.update(0, 1, 100)
+		older := node.Snapshot()
+
+		r, ok := node.Routes.Lookup(sim.prefix, node.neighbours[0])
+		Expect(ok).To(BeTrue())
+		r.Expires = r.Expires.Add(time.Hour)
+
+		Expect(older.Diff(node.Snapshot()).Empty()).To(BeTrue())
+	})
+
+	It("sorts routes by prefix", func() {
+		for _, pfx := range []string{"2001:db8:2::/48", "10.0.0.0/8", "2001:db8:1::/48"} {
+			node.onUpdate(node.neighbours[0], &proto.Update{
+				Interval: time.Second,
+				Seqno:    1,
+				Metric:   100,
+				Prefix:   netip.MustParsePrefix(pfx),
+				RouterID: sim.originID,
+			})
+		}
+
+		pfxs := []string{}
+		for _, r := range node.Snapshot().Routes {
+			pfxs = append(pfxs, r.Prefix.String())
+		}
+
+		Expect(pfxs).To(Equal([]string{"10.0.0.0/8", "2001:db8:1::/48", "2001:db8:2::/48"}))
+	})
+})
@@ -5,6 +5,7 @@ package babel
 
 import (
 	"net/netip"
+	"time"
 
 	"cunicu.li/go-babel/proto"
 )
@@ -16,6 +17,59 @@ type Source struct {
 	Prefix   netip.Prefix
 	RouterID proto.RouterID
 
-	Metric int
-	SeqNo  uint16
+	// Distance is the feasibility distance of the source.
+	// It is only valid after we have advertised a route for the source
+	// and until the garbage-collection timer expires.
+	Distance FeasibilityDistance
+	Expires  time.Time
+}
+
+func newSource(pfx netip.Prefix, rid proto.RouterID) *Source {
+	return &Source{
+		Prefix:   pfx,
+		RouterID: rid,
+		Distance: FeasibilityDistance{
+			Metric: proto.Retraction,
+		},
+	}
+}
+
+// HasDistance checks if the source has a valid feasibility distance.
+func (s *Source) HasDistance() bool {
+	return s.Distance.Metric != proto.Retraction && time.Now().Before(s.Expires)
+}
+
+// IsFeasible checks if an update with the provided sequence number and metric
+// satisfies the feasibility condition for this source.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.1
+func (s *Source) IsFeasible(seqno proto.SequenceNumber, metric proto.Metric) bool {
+	if !s.HasDistance() {
+		return true
+	}
+
+	return s.Distance.IsFeasible(seqno, metric)
+}
+
+// advertise updates the feasibility distance after a route for this source
+// has been advertised with the provided sequence number and metric.
+//
+// 3.7.3. Maintaining Feasibility Distances
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7.3
+func (s *Source) advertise(seqno proto.SequenceNumber, metric proto.Metric, gcTime time.Duration) {
+	// Retractions do not change the feasibility distance
+	if metric == proto.Retraction {
+		return
+	}
+
+	d := FeasibilityDistance{
+		SeqNo:  seqno,
+		Metric: metric,
+	}
+
+	if !s.HasDistance() || d.IsBetter(s.Distance) {
+		s.Distance = d
+	}
+
+	s.Expires = time.Now().Add(gcTime)
 }
@@ -16,6 +16,10 @@ type sourceKey struct {
 
 type SourceTable table.Table[sourceKey, *Source]
 
+func NewSourceTable() SourceTable {
+	return SourceTable(table.New[sourceKey, *Source]())
+}
+
 func (t *SourceTable) Lookup(pfx netip.Prefix, rid proto.RouterID) (*Source, bool) {
 	return (*table.Table[sourceKey, *Source])(t).Lookup(sourceKey{
 		Prefix:   pfx,
@@ -29,3 +33,20 @@ func (t *SourceTable) Insert(s *Source) {
 		s.RouterID,
 	}, s)
 }
+
+func (t *SourceTable) Remove(s *Source) {
+	(*table.Table[sourceKey, *Source])(t).Remove(sourceKey{
+		s.Prefix,
+		s.RouterID,
+	})
+}
+
+func (t *SourceTable) Foreach(cb func(*Source) error) error {
+	return (*table.Table[sourceKey, *Source])(t).ForEach(func(k sourceKey, v *Source) error {
+		return cb(v)
+	})
+}
+
+func (t *SourceTable) Len() int {
+	return (*table.Table[sourceKey, *Source])(t).Len()
+}
@@ -10,7 +10,11 @@ import (
 	"log/slog"
 	"net"
 	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"cunicu.li/go-babel/filter"
 	"cunicu.li/go-babel/proto"
 	"golang.org/x/net/ipv6"
 )
@@ -18,16 +22,36 @@ import (
 // 3.2. Data Structures
 // https://datatracker.ietf.org/doc/html/rfc8966#section-3.2
 
+// housekeepingInterval is the interval in which expired
+// routes and sources are removed.
+const housekeepingInterval = time.Second
+
 type SpeakerConfig struct {
 	*Parameters
 
 	Handler         any
 	RouterID        proto.RouterID
 	InterfaceFilter func(string) bool
-	RouteFilter     func(*Route) proto.Metric
-	UnicastPeers    []net.UDPAddr
-	Multicast       bool
-	Logger          *slog.Logger
+
+	// Interfaces holds the settings of individual interfaces by name.
+	// Interfaces without an entry use InterfaceDefaults.
+	Interfaces        map[string]InterfaceConfig
+	InterfaceDefaults InterfaceConfig
+
+	// InputFilter is applied to the metric advertised by neighbours before
+	// adding the link cost. OutputFilter is applied to the Updates sent
+	// to neighbours. Rejected routes are treated as retracted on input
+	// and not announced on output.
+	InputFilter  filter.Filter
+	OutputFilter filter.Filter
+
+	UnicastPeers []net.UDPAddr
+	Multicast    bool
+	Logger       *slog.Logger
+
+	// StrictDecoding drops received packets which violate
+	// requirements of RFC 8966 tolerated otherwise (see proto.Parser.Strict).
+	StrictDecoding bool
 }
 
 func (c *SpeakerConfig) SetDefaults() error {
@@ -45,9 +69,7 @@ func (c *SpeakerConfig) SetDefaults() error {
 		dp := DefaultParameters
 		c.Parameters = &dp
 	} else {
-		if c.NominalLinkCost == 0 {
-			c.NominalLinkCost = DefaultWiredLinkCost
-		}
+		c.Parameters.setDefaults()
 	}
 
 	if c.Logger == nil {
@@ -57,48 +79,125 @@ func (c *SpeakerConfig) SetDefaults() error {
 	return nil
 }
 
+// cloneConfig returns a copy of the configuration with defaults applied.
+// The parameters are copied as well so that later modifications by
+// the caller do not affect the speaker.
+func cloneConfig(cfg *SpeakerConfig) (*SpeakerConfig, error) {
+	c := *cfg
+
+	if c.Parameters != nil {
+		p := *c.Parameters
+		c.Parameters = &p
+	}
+
+	if err := c.SetDefaults(); err != nil {
+		return nil, err
+	}
+
+	return &c, nil
+}
+
 type Speaker struct {
-	// TODO: Use field
-	//nolint:unused
-	seqNo uint16
+	// seqNo is our sequence number which is used for originated routes.
+	//
+	// 3.2.1. Sequence Number
+	// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.1
+	seqNo proto.SequenceNumber
 
 	Interfaces InterfaceTable
 	Sources    SourceTable
 	Routes     RouteTable
+	XRoutes    XRouteTable
+
+	SeqnoRequests PendingSeqNoRequestTable
+
+	// mu protects the route, source and xroute tables as well as our
+	// sequence number against concurrent modifications from the
+	// read loop and the timers.
+	mu sync.Mutex
+
+	housekeepingTicker *time.Ticker
+	closed             chan struct{}
+	closeOnce          sync.Once
+	timersDone         chan struct{}
+
+	events eventBus
 
 	conn *ipv6.PacketConn
 
-	config SpeakerConfig
-	logger *slog.Logger
+	// cfg holds the current configuration which
+	// is replaced atomically by UpdateConfig.
+	cfg      atomic.Pointer[SpeakerConfig]
+	reloadMu sync.Mutex
+	logger   *slog.Logger
 }
 
 func NewSpeaker(cfg *SpeakerConfig) (*Speaker, error) {
 	var err error
 
 	s := &Speaker{
-		config: *cfg,
-
 		Interfaces: NewInterfaceTable(),
-		Sources:    SourceTable{},
-		Routes:     RouteTable{},
+		Sources:    NewSourceTable(),
+		Routes:     NewRouteTable(),
+		XRoutes:    NewXRouteTable(),
+
+		SeqnoRequests: NewPendingSeqNoRequestTable(),
 	}
 
-	if err := s.config.SetDefaults(); err != nil {
+	c, err := cloneConfig(cfg)
+	if err != nil {
 		return nil, err
 	}
 
-	s.logger = s.config.Logger
+	s.cfg.Store(c)
+	s.logger = c.Logger
 
 	if s.conn, err = s.createConn(); err != nil {
 		return nil, fmt.Errorf("failed to create conn: %w", err)
 	}
 
-	// Find local interfaces
+	if err := s.syncInterfaces(); err != nil {
+		s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+			return i.Close()
+		})
+
+		s.conn.Close() //nolint:errcheck
+
+		return nil, err
+	}
+
+	s.housekeepingTicker = time.NewTicker(housekeepingInterval)
+	s.closed = make(chan struct{})
+	s.timersDone = make(chan struct{})
+
+	go s.runReadLoop()
+	go s.runTimers()
+
+	return s, nil
+}
+
+// config returns the current configuration of the speaker.
+// An empty configuration is returned for speakers which
+// have not been created by NewSpeaker.
+func (s *Speaker) config() *SpeakerConfig {
+	if c := s.cfg.Load(); c != nil {
+		return c
+	}
+
+	return &SpeakerConfig{}
+}
+
+// syncInterfaces adds all local interfaces selected by the interface
+// filter which are not used yet and removes all others.
+func (s *Speaker) syncInterfaces() error {
 	intfs, err := net.Interfaces()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interfaces: %w", err)
+		return fmt.Errorf("failed to get interfaces: %w", err)
 	}
 
+	cfg := s.config()
+	wanted := map[int]any{}
+
 	for _, intf := range intfs {
 		if intf.Flags&net.FlagLoopback != 0 {
 			continue
@@ -108,29 +207,148 @@ func NewSpeaker(cfg *SpeakerConfig) (*Speaker, error) {
 			continue
 		}
 
-		i, err := s.newInterface(intf.Index)
+		wanted[intf.Index] = nil
+	}
+
+	removed := []*Interface{}
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		if _, ok := wanted[i.Index]; !ok || i.multicast != cfg.Multicast {
+			removed = append(removed, i)
+		}
+		return nil
+	})
+
+	for _, i := range removed {
+		if err := s.removeInterface(i); err != nil {
+			return fmt.Errorf("failed to remove interface: %w", err)
+		}
+	}
+
+	for index := range wanted {
+		if _, ok := s.Interfaces.Lookup(index); ok {
+			continue
+		}
+
+		i, err := s.newInterface(index)
 		if err != nil {
-			return nil, fmt.Errorf("failed to create interface: %w", err)
+			return fmt.Errorf("failed to create interface: %w", err)
 		}
 
-		if h, ok := s.config.Handler.(InterfaceHandler); ok {
+		if h, ok := cfg.Handler.(InterfaceHandler); ok {
 			h.InterfaceAdded(i)
 		}
 
 		s.Interfaces.Insert(i)
 	}
 
-	go s.runReadLoop()
+	return nil
+}
 
-	return s, nil
+// removeInterface stops using an interface and
+// retracts all routes learned via its neighbours.
+func (s *Speaker) removeInterface(i *Interface) error {
+	s.Interfaces.Remove(i)
+
+	neighbours := []*Neighbour{}
+	i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+		neighbours = append(neighbours, n)
+		return nil
+	})
+
+	for _, n := range neighbours {
+		s.removeNeighbour(n)
+	}
+
+	if h, ok := s.config().Handler.(InterfaceHandler); ok {
+		h.InterfaceRemoved(i)
+	}
+
+	return i.Close()
 }
 
-func (s *Speaker) Close() error {
+// removeNeighbour forgets a neighbour and flushes all routes learned from it.
+func (s *Speaker) removeNeighbour(n *Neighbour) {
+	n.intf.Neighbours.Remove(n)
+
+	if err := n.Close(); err != nil {
+		n.logger.Error("Failed to close neighbour", slog.Any("error", err))
+	}
+
+	s.mu.Lock()
+
+	s.neighbourCostChanged(n, 0xFFFF)
+
+	flushed := []*Route{}
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		if r.Neighbour == n {
+			flushed = append(flushed, r)
+		}
+		return nil
+	})
+
+	pfxs := map[proto.Prefix]any{}
+	for _, r := range flushed {
+		s.Routes.Remove(r)
+		s.emitRouteEvent(EventRouteRemoved, r)
+
+		pfxs[r.Source.Prefix] = nil
+	}
+
+	for pfx := range pfxs {
+		s.selectRoute(pfx)
+	}
+
+	s.mu.Unlock()
+
+	if h, ok := s.config().Handler.(NeighbourHandler); ok {
+		h.NeighbourRemoved(n)
+	}
+}
+
+// Close retracts the originated routes, stops all
+// interfaces and neighbours and closes the socket.
+// Subsequent calls do nothing.
+func (s *Speaker) Close() (err error) {
+	s.closeOnce.Do(func() {
+		err = s.close()
+	})
+
+	return err
+}
+
+func (s *Speaker) close() error {
+	close(s.closed)
+	s.housekeepingTicker.Stop()
+
+	// Wait for running housekeeping tasks to complete
+	<-s.timersDone
+
+	s.retractXRoutes()
+
+	errs := []error{}
+
+	intfs := []*Interface{}
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		intfs = append(intfs, i)
+		return nil
+	})
+
+	for _, i := range intfs {
+		// Packets received for removed interfaces are ignored
+		s.Interfaces.Remove(i)
+
+		if err := i.shutdown(); err != nil {
+			errs = append(errs, fmt.Errorf("failed to close interface %s: %w", i.Name, err))
+		}
+	}
+
+	s.events.close()
+
 	if err := s.conn.Close(); err != nil {
-		return fmt.Errorf("failed to close interface: %w", err)
+		errs = append(errs, fmt.Errorf("failed to close conn: %w", err))
 	}
 
-	return nil
+	return errors.Join(errs...)
 }
 
 func (s *Speaker) runReadLoop() {
@@ -139,6 +357,10 @@ func (s *Speaker) runReadLoop() {
 	// TODO: Check for largest MTU of attached interfaces
 	buf := make([]byte, 1500)
 
+	// The decoder reuses its state and values across packets
+	// as packets are handled synchronously by this loop.
+	dec := proto.NewDecoder()
+
 	for {
 		n, cm, sAddr, err := s.conn.ReadFrom(buf)
 		if err != nil {
@@ -182,11 +404,17 @@ func (s *Speaker) runReadLoop() {
 			continue
 		}
 
-		p := proto.NewParser()
+		s.initParser(&dec.Parser, cm.IfIndex, srcAddr)
 
-		_, pkt, err := p.Packet(buf[:n])
+		_, pkt, err := dec.Packet(buf[:n])
 		if err != nil {
-			s.logger.Error("Failed to decode packet: %w", slog.Any("error", err))
+			s.logger.Error("Failed to decode packet", slog.Any("error", err))
+
+			if h, ok := s.config().Handler.(PacketHandler); ok {
+				i, _ := s.Interfaces.Lookup(cm.IfIndex)
+				h.PacketDecodingFailed(i, err)
+			}
+
 			continue
 		}
 
@@ -197,6 +425,27 @@ func (s *Speaker) runReadLoop() {
 	}
 }
 
+func (s *Speaker) runTimers() {
+	defer close(s.timersDone)
+
+	for {
+		select {
+		case <-s.closed:
+			return
+
+		case <-s.housekeepingTicker.C:
+			s.expireRoutes()
+			s.collectSources()
+			s.resendSeqnoRequests()
+			s.resendAcknowledgmentRequests()
+
+			if s.config().MetricSmoothingHalfLife > 0 {
+				s.reselectRoutes()
+			}
+		}
+	}
+}
+
 // createConn creates a single UDP socket for the speaker
 // See: Section 4. Protocol Encoding
 // https://datatracker.ietf.org/doc/html/rfc8966#section-4
@@ -233,6 +482,29 @@ func (s *Speaker) createConn() (*ipv6.PacketConn, error) {
 	return pktConn, nil
 }
 
+// initParser prepares a parser for a packet received on an interface.
+// Its parser state is seeded with the source address of the packet
+// and the learned IPv4 address of the sending neighbour, if any.
+//
+// See: 4.5. Parser State and Encoding of Updates
+// https://datatracker.ietf.org/doc/html/rfc8966#section-4.5
+func (s *Speaker) initParser(p *proto.Parser, ifIndex int, srcAddr proto.Address) {
+	p.Init(srcAddr)
+	p.Strict = s.config().StrictDecoding
+
+	if i, ok := s.Interfaces.Lookup(ifIndex); ok {
+		if n, ok := i.Neighbours.Lookup(srcAddr); ok {
+			s.mu.Lock()
+			nh := n.AddressIPv4
+			s.mu.Unlock()
+
+			if nh.IsValid() {
+				p.SetInitialNextHop(nh)
+			}
+		}
+	}
+}
+
 func (s *Speaker) onPacket(pkt *proto.Packet, ifIndex int, srcAddr, dstAddr proto.Address) error {
 	i, ok := s.Interfaces.Lookup(ifIndex)
 	if !ok {
@@ -16,13 +16,18 @@ import (
 
 type mockNeighbourHandler struct {
 	neighbours chan *babel.Neighbour
+	removed    chan *babel.Neighbour
 }
 
 func (h *mockNeighbourHandler) NeighbourAdded(n *babel.Neighbour) {
 	h.neighbours <- n
 }
 
-func (h *mockNeighbourHandler) NeighbourRemoved(n *babel.Neighbour) {}
+func (h *mockNeighbourHandler) NeighbourRemoved(n *babel.Neighbour) {
+	if h.removed != nil {
+		h.removed <- n
+	}
+}
 
 var _ = Context("Speaker", Label("integration"), func() {
 	var err error
@@ -95,4 +100,81 @@ var _ = Context("Speaker", Label("integration"), func() {
 		err = s2.Close()
 		Expect(err).To(Succeed())
 	})
+
+	It("applies configuration changes", func() {
+		sw, err := n.AddSwitch("sw1")
+		Expect(err).To(Succeed())
+
+		h1, err := n.AddHost("h1",
+			g.NewInterface("eth0", sw))
+		Expect(err).To(Succeed())
+
+		h2, err := n.AddHost("h2",
+			g.NewInterface("eth0", sw))
+		Expect(err).To(Succeed())
+
+		handler := &mockNeighbourHandler{
+			neighbours: make(chan *babel.Neighbour, 1),
+			removed:    make(chan *babel.Neighbour, 1),
+		}
+
+		speakerConfig := &babel.SpeakerConfig{
+			Multicast: true,
+		}
+
+		err = h1.RunFunc(func() (err error) {
+			cfg := *speakerConfig
+			cfg.Handler = handler
+			cfg.Logger = slog.Default().With(slog.String("speaker", "s1"))
+			s1, err = babel.NewSpeaker(&cfg)
+			return
+		})
+		Expect(err).To(Succeed())
+
+		err = h2.RunFunc(func() (err error) {
+			cfg := *speakerConfig
+			cfg.Logger = slog.Default().With(slog.String("speaker", "s2"))
+			s2, err = babel.NewSpeaker(&cfg)
+			return
+		})
+		Expect(err).To(Succeed())
+
+		n1 := <-handler.neighbours
+
+		Eventually(func() uint16 { return n1.Cost() }, 100*time.Second, time.Second).Should(BeNumerically("==", babel.DefaultWiredLinkCost))
+
+		By("Changing the link cost of s2")
+
+		params := babel.DefaultParameters
+		params.MulticastHelloInterval = time.Second
+
+		err = s2.UpdateConfig(&babel.SpeakerConfig{
+			Parameters: &params,
+			Multicast:  true,
+			InterfaceDefaults: babel.InterfaceConfig{
+				RxCost: 200,
+			},
+		})
+		Expect(err).To(Succeed())
+
+		Eventually(func() uint16 { return n1.Cost() }, 10*time.Second, 100*time.Millisecond).Should(BeNumerically("==", 200))
+
+		By("Removing the interface of s1")
+
+		err = s1.UpdateConfig(&babel.SpeakerConfig{
+			Multicast:       true,
+			Handler:         handler,
+			InterfaceFilter: func(string) bool { return false },
+		})
+		Expect(err).To(Succeed())
+
+		Expect(<-handler.removed).To(Equal(n1))
+		Expect(s1.Interfaces.Len()).To(Equal(0))
+
+		err = s1.Close()
+		Expect(err).To(Succeed())
+
+		err = s2.Close()
+		Expect(err).To(Succeed())
+	})
 })
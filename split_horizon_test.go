@@ -0,0 +1,94 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Split horizon", func() {
+	var sim *simulation
+	var node *simNode
+	var other *Interface
+	var writers map[int]*valueWriter
+
+	setInterfaceConfig := func(ic InterfaceConfig) {
+		cfg := *node.config()
+		cfg.InterfaceDefaults = ic
+		node.cfg.Store(&cfg)
+	}
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+		node.intf.Interface = &net.Interface{Index: 1, Name: "eth0"}
+
+		// Neighbour 2 is attached to a different interface
+		other = &Interface{
+			Interface:  &net.Interface{Index: 2, Name: "eth1"},
+			Neighbours: NewNeighbourTable(),
+			speaker:    node.Speaker,
+			logger:     node.logger,
+		}
+
+		node.Interfaces.Insert(node.intf)
+		node.Interfaces.Insert(other)
+
+		node.neighbours[2].intf = other
+
+		writers = sim.attachNeighbours()
+	})
+
+	enabled, disabled := true, false
+
+	DescribeTable("is chosen based on the interface type",
+		func(typ InterfaceType, wireless bool, splitHorizon *bool, expected bool) {
+			node.intf.wireless = wireless
+			setInterfaceConfig(InterfaceConfig{
+				Type:         typ,
+				SplitHorizon: splitHorizon,
+			})
+
+			Expect(node.intf.splitHorizon()).To(Equal(expected))
+		},
+		Entry("auto", InterfaceTypeAuto, false, nil, true),
+		Entry("auto wireless", InterfaceTypeAuto, true, nil, false),
+		Entry("wired", InterfaceTypeWired, true, nil, true),
+		Entry("tunnel", InterfaceTypeTunnel, false, nil, true),
+		Entry("wireless", InterfaceTypeWireless, false, nil, false),
+		Entry("enabled wireless", InterfaceTypeWireless, false, &enabled, true),
+		Entry("disabled wired", InterfaceTypeWired, false, &disabled, false),
+	)
+
+	It("does not advertise routes on the interface they are learned from", func() {
+		setInterfaceConfig(InterfaceConfig{
+			Type: InterfaceTypeWired,
+		})
+
+		sim.update(0, 1, 100)
+
+		Expect(node.selectedUpdates(node.intf, nil)).To(BeEmpty())
+		Expect(node.selectedUpdates(other, nil)).To(HaveLen(1))
+
+		Eventually(writers[2].Updates).Should(HaveLen(1))
+		Consistently(writers[0].Updates, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("advertises routes on the interface they are learned from on wireless interfaces", func() {
+		setInterfaceConfig(InterfaceConfig{
+			Type: InterfaceTypeWireless,
+		})
+
+		sim.update(0, 1, 100)
+
+		Expect(node.selectedUpdates(node.intf, nil)).To(HaveLen(1))
+		Expect(node.selectedUpdates(other, nil)).To(HaveLen(1))
+
+		Eventually(writers[0].Updates).Should(HaveLen(1))
+		Eventually(writers[2].Updates).Should(HaveLen(1))
+	})
+})
@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Unicast updates", func() {
+	var sim *simulation
+	var node *simNode
+	var multicast *valueWriter
+	var writers map[int]*valueWriter
+
+	// metrics returns the last metric sent for each prefix
+	metrics := func(w *valueWriter) func() map[proto.Prefix]proto.Metric {
+		return func() map[proto.Prefix]proto.Metric {
+			m := map[proto.Prefix]proto.Metric{}
+			for _, upd := range w.Updates() {
+				m[upd.Prefix] = upd.Metric
+			}
+
+			return m
+		}
+	}
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+
+		// Both neighbours are attached to a multicast capable
+		// point-to-multipoint tunnel interface
+		multicast = &valueWriter{}
+
+		node.intf.Interface = &net.Interface{Index: 1, Name: "tun0"}
+		node.intf.multicast = true
+		node.intf.queue = queue.NewQueue(1400, node.config().UrgentTimeout, multicast)
+
+		node.Interfaces.Insert(node.intf)
+
+		writers = sim.attachNeighbours()
+
+		// Periodic updates are delayed by half the hello interval
+		params := *node.config().Parameters
+		params.MulticastHelloInterval = 100 * time.Millisecond
+
+		cfg := *node.config()
+		cfg.Parameters = &params
+		cfg.InterfaceDefaults = InterfaceConfig{
+			Type:    InterfaceTypeTunnel,
+			Unicast: true,
+		}
+		node.cfg.Store(&cfg)
+
+		sim.update(0, 1, 100)
+
+		Expect(node.Originate(netip.MustParsePrefix("2001:db8:1::/64"), 0)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(node.intf.queue.Close()).To(Succeed())
+	})
+
+	It("sends triggered updates to each neighbour", func() {
+		r, ok := node.Routes.Lookup(sim.prefix, node.neighbours[0])
+		Expect(ok).To(BeTrue())
+
+		Eventually(metrics(writers[2])).Should(Equal(map[proto.Prefix]proto.Metric{
+			sim.prefix:                               r.Metric,
+			netip.MustParsePrefix("2001:db8:1::/64"): 0,
+		}))
+
+		// Routes are not advertised back to the neighbour they are learned from
+		Eventually(metrics(writers[0])).Should(Equal(map[proto.Prefix]proto.Metric{
+			netip.MustParsePrefix("2001:db8:1::/64"): 0,
+		}))
+
+		Consistently(multicast.Values, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("sends periodic updates to each neighbour", func() {
+		Expect(node.selectedUpdates(node.intf, node.neighbours[0])).To(HaveLen(1))
+		Expect(node.selectedUpdates(node.intf, node.neighbours[2])).To(HaveLen(2))
+
+		Eventually(metrics(writers[2])).Should(HaveLen(2))
+
+		// Wait for the triggered updates before sending the periodic ones
+		num := len(writers[2].Updates())
+
+		Expect(node.intf.sendUpdate()).To(Succeed())
+
+		Eventually(writers[2].Updates).Should(HaveLen(num + 2))
+		Consistently(multicast.Values, 50*time.Millisecond).Should(BeEmpty())
+	})
+
+	It("does not deadlock with concurrent triggered updates", func() {
+		var wg sync.WaitGroup
+
+		deadline := time.Now().Add(500 * time.Millisecond)
+
+		wg.Add(2)
+
+		go func() {
+			defer wg.Done()
+
+			for time.Now().Before(deadline) {
+				node.intf.sendUpdate() //nolint:errcheck
+			}
+		}()
+
+		// Alternating retractions and updates trigger updates
+		// to all neighbours while holding the speaker lock
+		go func() {
+			defer wg.Done()
+
+			for i := 0; time.Now().Before(deadline); i++ {
+				metric := proto.Metric(100)
+				if i%2 == 0 {
+					metric = proto.Retraction
+				}
+
+				sim.update(0, proto.SequenceNumber(2+i), metric)
+			}
+		}()
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+
+		Eventually(done, 5*time.Second).Should(BeClosed())
+	})
+
+	It("multicasts updates unless configured otherwise", func() {
+		cfg := *node.config()
+		cfg.InterfaceDefaults = InterfaceConfig{
+			Type: InterfaceTypeTunnel,
+		}
+		node.cfg.Store(&cfg)
+
+		Expect(node.intf.sendUpdate()).To(Succeed())
+
+		// Split horizon suppresses routes learned on the interface
+		Eventually(metrics(multicast)).Should(Equal(map[proto.Prefix]proto.Metric{
+			netip.MustParsePrefix("2001:db8:1::/64"): 0,
+		}))
+	})
+
+	It("multicasts retractions in addition to sending them reliably", func() {
+		cfg := *node.config()
+		cfg.InterfaceDefaults = InterfaceConfig{
+			Type: InterfaceTypeTunnel,
+		}
+		node.cfg.Store(&cfg)
+
+		pfx := netip.MustParsePrefix("2001:db8:1::/64")
+
+		// Wait for the triggered updates of the originated route
+		for _, w := range writers {
+			Eventually(metrics(w)).Should(HaveKey(pfx))
+		}
+
+		node.Withdraw(pfx)
+
+		// Neighbours which are not known yet receive the multicast retraction
+		Eventually(metrics(multicast)).Should(HaveKeyWithValue(pfx, proto.Retraction))
+
+		for idx, n := range node.neighbours {
+			Eventually(metrics(writers[idx])).Should(HaveKeyWithValue(pfx, proto.Retraction))
+			Expect(n.PendingAcknowledgments.Len()).To(Equal(1))
+		}
+	})
+})
@@ -0,0 +1,456 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+)
+
+// 3.5.3. Route Acquisition
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.3
+func (s *Speaker) onUpdate(n *Neighbour, upd *proto.Update) {
+	// Source-specific routing is not supported. As the Source Prefix
+	// sub-TLV is mandatory, source-specific Updates must be ignored.
+	//
+	// See: RFC 9079 Section 7.1. Source Prefix Sub-TLV
+	// https://datatracker.ietf.org/doc/html/rfc9079#section-7.1
+	if upd.SourcePrefix != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Ignore routes which have been originated by ourself
+	if upd.RouterID == s.config().RouterID {
+		return
+	}
+
+	feasible := true
+	src, hasSrc := s.Sources.Lookup(upd.Prefix, upd.RouterID)
+	if hasSrc {
+		feasible = src.IsFeasible(upd.Seqno, upd.Metric)
+	}
+
+	r, ok := s.Routes.Lookup(upd.Prefix, n)
+	if !ok {
+		// Unfeasible updates, retractions and updates rejected by
+		// the input filter are ignored for unknown routes
+		if !feasible || s.inputMetric(n, upd.Prefix, upd.RouterID, upd.Metric) == proto.Retraction {
+			return
+		}
+
+		r = &Route{
+			Neighbour: n,
+		}
+	}
+
+	old := *r
+
+	// Retractions keep the source of the route as
+	// they do not need to carry a meaningful router ID.
+	if upd.Metric != proto.Retraction {
+		if !hasSrc {
+			src = newSource(upd.Prefix, upd.RouterID)
+			s.Sources.Insert(src)
+		}
+
+		r.Source = src
+	}
+	r.SeqNo = upd.Seqno
+	r.RefMetric = upd.Metric
+	r.Channels = slices.Clone(upd.Channels) // Decoded values are reused
+	r.SetMetric(s.routeMetric(r, n.Cost()), s.config().MetricSmoothingHalfLife)
+	r.NextHop = upd.NextHop
+	r.Expires = time.Now().Add(s.routeExpiryTime(upd.Interval))
+
+	switch {
+	case !ok:
+		s.Routes.Insert(r)
+		s.emitRouteEvent(EventRouteAdded, r)
+
+	case r.IsRetracted() && !old.IsRetracted():
+		s.emitRouteEvent(EventRouteRetracted, r)
+
+	case r.SeqNo != old.SeqNo || r.Metric != old.Metric || r.NextHop != old.NextHop || r.Source != old.Source:
+		s.emitRouteEvent(EventRouteChanged, r)
+	}
+
+	if !feasible {
+		s.logger.Debug("Received unfeasible update",
+			slog.Any("update", upd))
+	}
+
+	s.selectRoute(upd.Prefix)
+	s.satisfySeqnoRequest(upd)
+}
+
+// updateNeighbourRoutes recomputes the metrics of all routes
+// learned from a neighbour after its cost has changed.
+func (s *Speaker) updateNeighbourRoutes(n *Neighbour) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cost := n.Cost()
+	pfxs := map[proto.Prefix]any{}
+
+	s.neighbourCostChanged(n, cost)
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		// Routes whose metric only became infinite due to the
+		// cost of the link or the input filter are recomputed as well
+		if r.Neighbour != n || r.RefMetric == proto.Retraction {
+			return nil
+		}
+
+		if metric := s.routeMetric(r, cost); metric != r.Metric {
+			r.SetMetric(metric, s.config().MetricSmoothingHalfLife)
+			pfxs[r.Source.Prefix] = nil
+
+			s.emitRouteEvent(EventRouteChanged, r)
+		}
+
+		return nil
+	})
+
+	for pfx := range pfxs {
+		s.selectRoute(pfx)
+	}
+}
+
+// reselectRoutes runs the route selection for all prefixes
+// to account for the decay of the smoothed metrics.
+func (s *Speaker) reselectRoutes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pfxs := map[proto.Prefix]any{}
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		pfxs[r.Source.Prefix] = nil
+		return nil
+	})
+
+	for pfx := range pfxs {
+		s.selectRoute(pfx)
+	}
+}
+
+// 3.6. Route Selection
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.6
+func (s *Speaker) selectRoute(pfx proto.Prefix) {
+	var best, current *Route
+
+	now := time.Now()
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		if r.Source.Prefix != pfx {
+			return nil
+		}
+
+		r.smooth(now, s.config().MetricSmoothingHalfLife)
+
+		if r.Selected {
+			current = r
+		}
+
+		if r.isSelectable() && (best == nil || r.SmoothedMetric < best.SmoothedMetric ||
+			(r.SmoothedMetric == best.SmoothedMetric && r.Metric < best.Metric)) {
+			best = r
+		}
+
+		return nil
+	})
+
+	// Avoid route flapping by only switching away from the currently
+	// selected route if the new route is better in terms of both
+	// its smoothed and its actual metric.
+	if current != nil && best != nil && current.isSelectable() &&
+		(best.Metric >= current.Metric || best.SmoothedMetric >= current.SmoothedMetric) {
+		best = current
+	}
+
+	if best == current {
+		return
+	}
+
+	if current != nil {
+		current.Selected = false
+		s.emitRouteEvent(EventRouteUnselected, current)
+	}
+
+	if h, ok := s.config().Handler.(RouteHandler); ok {
+		h.SelectedRouteChanged(pfx, best)
+	}
+
+	// Originated routes take precedence over learned ones.
+	// So we keep announcing them regardless of the selected route.
+	announce := !s.isOriginated(pfx)
+
+	if best != nil {
+		best.Selected = true
+		s.emitRouteEvent(EventRouteSelected, best)
+
+		s.logger.Debug("Selected route",
+			slog.Any("prefix", pfx),
+			slog.Any("nh", best.NextHop),
+			slog.Any("metric", best.Metric))
+
+		if announce {
+			s.sendTriggeredUpdate(s.advertisedUpdate(best), best, queue.PriorityNormal)
+		}
+	} else {
+		s.logger.Debug("Lost route", slog.Any("prefix", pfx))
+
+		if announce {
+			s.sendTriggeredUpdate(s.retractionUpdate(pfx, current.Source.RouterID), nil, queue.PriorityUrgent)
+		}
+
+		// Request a new seqno if we still have unfeasible routes
+		if unfeasible := s.unfeasibleRoute(pfx); unfeasible != nil {
+			s.requestSeqno(unfeasible.Source)
+		}
+	}
+}
+
+// unfeasibleRoute returns a non-retracted but unfeasible route for a prefix.
+func (s *Speaker) unfeasibleRoute(pfx proto.Prefix) (unfeasible *Route) {
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		if r.Source.Prefix == pfx && !r.IsRetracted() && !r.IsFeasible() {
+			unfeasible = r
+		}
+		return nil
+	})
+
+	return unfeasible
+}
+
+// expireRoutes retracts routes whose expiry timer has fired
+// and flushes routes which have already been retracted.
+//
+// 3.5.4. Hold Time
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.4
+func (s *Speaker) expireRoutes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	pfxs := map[proto.Prefix]any{}
+	flushed := []*Route{}
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		if now.Before(r.Expires) {
+			return nil
+		}
+
+		if r.IsRetracted() {
+			flushed = append(flushed, r)
+		} else {
+			r.SetMetric(proto.Retraction, s.config().MetricSmoothingHalfLife)
+			r.RefMetric = proto.Retraction
+			r.Expires = now.Add(s.config().RouteExpiryTime)
+
+			s.emitRouteEvent(EventRouteExpired, r)
+		}
+
+		pfxs[r.Source.Prefix] = nil
+
+		return nil
+	})
+
+	for _, r := range flushed {
+		s.Routes.Remove(r)
+		s.emitRouteEvent(EventRouteRemoved, r)
+	}
+
+	for pfx := range pfxs {
+		s.selectRoute(pfx)
+	}
+}
+
+// collectSources removes source table entries whose garbage-collection
+// timer has expired and which are not used by any route anymore.
+//
+// 3.2.5. The Source Table
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.2.5
+func (s *Speaker) collectSources() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	used := map[*Source]any{}
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		used[r.Source] = nil
+		return nil
+	})
+
+	collected := []*Source{}
+
+	s.Sources.Foreach(func(src *Source) error { //nolint:errcheck
+		if _, ok := used[src]; !ok && now.After(src.Expires) {
+			collected = append(collected, src)
+		}
+		return nil
+	})
+
+	for _, src := range collected {
+		s.Sources.Remove(src)
+		s.events.emit(&SourceEvent{
+			Type:     EventSourceCollected,
+			Prefix:   src.Prefix,
+			RouterID: src.RouterID,
+		})
+	}
+}
+
+// retractionUpdate returns an Update TLV retracting a prefix.
+// The router ID is included so that receivers do not attribute
+// the retraction to the router ID of a preceding Update.
+//
+// 3.5.4. Route Retractions
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.4
+func (s *Speaker) retractionUpdate(pfx proto.Prefix, rid proto.RouterID) *proto.Update {
+	return &proto.Update{
+		Interval: s.config().UpdateInterval,
+		Metric:   proto.Retraction,
+		Prefix:   pfx,
+		RouterID: rid,
+	}
+}
+
+// advertisedUpdate returns an Update TLV advertising the provided route.
+// The feasibility distance of the route's source is updated once the
+// Update is sent (see updateDistances).
+func (s *Speaker) advertisedUpdate(r *Route) *proto.Update {
+	upd := &proto.Update{
+		Interval: s.config().UpdateInterval,
+		Seqno:    r.SeqNo,
+		Metric:   r.Metric,
+		Prefix:   r.Source.Prefix,
+		RouterID: r.Source.RouterID,
+	}
+
+	if s.config().DiversityFactor > 0 {
+		upd.Channels = r.advertisedChannels()
+	}
+
+	return upd
+}
+
+// updateDistances updates the feasibility distances of the sources
+// of the routes advertised by the Updates. It is called after the output
+// filter has been applied as the distances must match the metrics which
+// are actually advertised. Originated routes do not have a source.
+//
+// 3.7.3. Maintaining Feasibility Distances
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7.3
+func (s *Speaker) updateDistances(vs []proto.Value) {
+	for _, v := range vs {
+		upd, ok := v.(*proto.Update)
+		if !ok {
+			continue
+		}
+
+		if src, ok := s.Sources.Lookup(upd.Prefix, upd.RouterID); ok {
+			src.advertise(upd.Seqno, upd.Metric, s.config().SourceGCTime)
+		}
+	}
+}
+
+// selectedUpdates returns Update TLVs for all originated and selected routes
+// which are advertised on the interface i. If the neighbour n is not nil,
+// the Updates are sent to it individually. Routes are omitted if they
+// have been learned on the interface and split horizon is used on it.
+// Updates for all routes are returned if the interface is nil.
+// It must be called with the speaker lock held.
+//
+// 3.7.1. Periodic Updates
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7.1
+func (s *Speaker) selectedUpdates(i *Interface, n *Neighbour) []proto.Value {
+	upds := []proto.Value{}
+
+	s.XRoutes.Foreach(func(x *XRoute) error { //nolint:errcheck
+		upds = append(upds, s.originatedUpdate(x))
+		return nil
+	})
+
+	s.Routes.Foreach(func(r *Route) error { //nolint:errcheck
+		if r.Selected && !s.isOriginated(r.Source.Prefix) && (i == nil || !i.isSplitHorizon(r, n)) {
+			upds = append(upds, s.advertisedUpdate(r))
+		}
+		return nil
+	})
+
+	return upds
+}
+
+// sendTriggeredUpdate sends an Update advertising the route r on all
+// interfaces. The route is nil for retractions and originated routes.
+//
+// 3.7.2. Triggered Updates
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7.2
+//
+// Retractions are sent reliably as their loss delays convergence.
+func (s *Speaker) sendTriggeredUpdate(upd *proto.Update, r *Route, prio queue.Priority) {
+	vs := []proto.Value{upd}
+
+	s.Interfaces.Foreach(func(_ int, i *Interface) error { //nolint:errcheck
+		switch {
+		case upd.Metric == proto.Retraction:
+			// Retractions are multicast as well to reach
+			// neighbours which we do not know about yet.
+			if !i.unicast() {
+				i.sendValues(vs, prio, s.config().UrgentTimeout)
+			}
+
+			i.sendReliableValues(vs, s.config().UrgentTimeout)
+
+		case i.unicast():
+			i.Neighbours.Foreach(func(n *Neighbour) error { //nolint:errcheck
+				if r == nil || !i.isSplitHorizon(r, n) {
+					n.sendValues(vs, prio, s.config().UrgentTimeout)
+				}
+				return nil
+			})
+
+		case r == nil || !i.isSplitHorizon(r, nil):
+			i.sendValues(vs, prio, s.config().UrgentTimeout)
+		}
+		return nil
+	})
+}
+
+// routeExpiryTime calculates the expiry time of a route based
+// on the interval advertised by the neighbour.
+//
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.5.4
+func (s *Speaker) routeExpiryTime(intv proto.Interval) time.Duration {
+	if intv == 0 {
+		return s.config().RouteExpiryTime
+	}
+
+	return intv * 7 / 2
+}
+
+// isSplitHorizon checks if the route must not be advertised on the
+// interface as it has been learned on it and split horizon is used.
+// Updates sent to the neighbour n individually are only suppressed if
+// the route has been learned from n as the neighbours of unicast
+// interfaces can not necessarily reach each other.
+//
+// 3.7.4. Split Horizon
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7.4
+func (i *Interface) isSplitHorizon(r *Route, n *Neighbour) bool {
+	if r.Neighbour == nil || r.Neighbour.intf != i || !i.splitHorizon() {
+		return false
+	}
+
+	return n == nil || r.Neighbour == n
+}
@@ -0,0 +1,125 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+)
+
+var (
+	ErrInvalidPrefix  = errors.New("invalid prefix")
+	ErrInfiniteMetric = errors.New("originated routes must have a finite metric")
+)
+
+// XRoute is a route which is originated by the speaker itself.
+// Originated routes take precedence over routes learned from
+// neighbours for the same prefix.
+//
+// 3.7. Sending Updates
+// https://datatracker.ietf.org/doc/html/rfc8966#section-3.7
+type XRoute struct {
+	Prefix proto.Prefix
+	Metric proto.Metric
+}
+
+// Originate announces a prefix with the provided metric.
+// The metric of already originated prefixes is updated.
+func (s *Speaker) Originate(pfx proto.Prefix, metric proto.Metric) error {
+	if !pfx.IsValid() {
+		return fmt.Errorf("%w: %s", ErrInvalidPrefix, pfx)
+	} else if metric == proto.Retraction {
+		return ErrInfiniteMetric
+	}
+
+	pfx = pfx.Masked()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	typ := EventXRouteChanged
+	x, ok := s.XRoutes.Lookup(pfx)
+	if !ok {
+		x = &XRoute{
+			Prefix: pfx,
+		}
+
+		s.XRoutes.Insert(x)
+		typ = EventXRouteAdded
+	} else if x.Metric == metric {
+		return nil
+	}
+
+	x.Metric = metric
+
+	s.logger.Debug("Originating route",
+		slog.Any("prefix", pfx),
+		slog.Any("metric", metric))
+
+	s.emitXRouteEvent(typ, x)
+	s.sendTriggeredUpdate(s.originatedUpdate(x), nil, queue.PriorityNormal)
+
+	return nil
+}
+
+// Withdraw stops the origination of a prefix.
+// The prefix is retracted unless we have learned a route for it
+// from one of our neighbours.
+func (s *Speaker) Withdraw(pfx proto.Prefix) {
+	pfx = pfx.Masked()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	x, ok := s.XRoutes.Lookup(pfx)
+	if !ok {
+		return
+	}
+
+	s.XRoutes.Remove(x)
+
+	s.logger.Debug("Withdrawing route", slog.Any("prefix", pfx))
+
+	s.emitXRouteEvent(EventXRouteRemoved, x)
+
+	if r := s.selectedRoute(pfx); r != nil {
+		s.sendTriggeredUpdate(s.advertisedUpdate(r), r, queue.PriorityUrgent)
+	} else {
+		s.sendTriggeredUpdate(s.retractionUpdate(pfx, s.config().RouterID), nil, queue.PriorityUrgent)
+	}
+}
+
+// retractXRoutes retracts all originated routes before shutting down.
+// The retractions are only queued and must be flushed by the caller.
+func (s *Speaker) retractXRoutes() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.XRoutes.Foreach(func(x *XRoute) error { //nolint:errcheck
+		s.sendTriggeredUpdate(s.retractionUpdate(x.Prefix, s.config().RouterID), nil, queue.PriorityUrgent)
+		return nil
+	})
+}
+
+// originatedUpdate returns an Update TLV advertising an originated route
+// with our router ID and current sequence number.
+func (s *Speaker) originatedUpdate(x *XRoute) *proto.Update {
+	return &proto.Update{
+		Interval: s.config().UpdateInterval,
+		Seqno:    s.seqNo,
+		Metric:   x.Metric,
+		Prefix:   x.Prefix,
+		RouterID: s.config().RouterID,
+	}
+}
+
+// isOriginated checks if a prefix is originated by the speaker.
+func (s *Speaker) isOriginated(pfx proto.Prefix) bool {
+	_, ok := s.XRoutes.Lookup(pfx)
+	return ok
+}
@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"cunicu.li/go-babel/internal/table"
+	"cunicu.li/go-babel/proto"
+)
+
+type XRouteTable table.Table[proto.Prefix, *XRoute]
+
+func NewXRouteTable() XRouteTable {
+	return XRouteTable(table.New[proto.Prefix, *XRoute]())
+}
+
+func (t *XRouteTable) Lookup(pfx proto.Prefix) (*XRoute, bool) {
+	return (*table.Table[proto.Prefix, *XRoute])(t).Lookup(pfx)
+}
+
+func (t *XRouteTable) Insert(x *XRoute) {
+	(*table.Table[proto.Prefix, *XRoute])(t).Insert(x.Prefix, x)
+}
+
+func (t *XRouteTable) Remove(x *XRoute) {
+	(*table.Table[proto.Prefix, *XRoute])(t).Remove(x.Prefix)
+}
+
+func (t *XRouteTable) Foreach(cb func(*XRoute) error) error {
+	return (*table.Table[proto.Prefix, *XRoute])(t).ForEach(func(k proto.Prefix, v *XRoute) error {
+		return cb(v)
+	})
+}
+
+func (t *XRouteTable) Len() int {
+	return (*table.Table[proto.Prefix, *XRoute])(t).Len()
+}
@@ -0,0 +1,199 @@
+// SPDX-FileCopyrightText: 2023-2024 Steffen Vogel <post@steffenvogel.de>
+// SPDX-License-Identifier: Apache-2.0
+
+package babel
+
+import (
+	"net"
+	"net/netip"
+	"time"
+
+	"cunicu.li/go-babel/internal/queue"
+	"cunicu.li/go-babel/proto"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"golang.org/x/net/ipv6"
+)
+
+var _ = Describe("Origination", func() {
+	var node *simNode
+	var n *Neighbour
+	var w *valueWriter
+
+	pfx := netip.MustParsePrefix("2001:db8:1::/48")
+
+	BeforeEach(func() {
+		sim := newSimulation(1, 2)
+		node = sim.nodes[1]
+
+		w = &valueWriter{}
+
+		n = node.neighbours[0]
+		n.PendingAcknowledgments = NewPendingAcknowledgmentTable()
+		n.queue = queue.NewQueue(1400, node.config().UrgentTimeout, w)
+	})
+
+	AfterEach(func() {
+		Expect(n.queue.Close()).To(Succeed())
+	})
+
+	It("originates and withdraws prefixes", func() {
+		sub := node.Subscribe(SubscriptionOptions{BufferSize: 16})
+		defer sub.Close()
+
+		Expect(node.Originate(netip.MustParsePrefix("2001:db8:1:2::/48"), 10)).To(Succeed())
+		Expect(node.Originate(pfx, 20)).To(Succeed())
+		Expect(node.Originate(pfx, 20)).To(Succeed())
+
+		x, ok := node.XRoutes.Lookup(pfx)
+		Expect(ok).To(BeTrue())
+		Expect(x.Metric).To(BeNumerically("==", 20))
+
+		upds := node.selectedUpdates(nil, nil)
+		Expect(upds).To(HaveLen(1))
+		Expect(upds[0].(*proto.Update).RouterID).To(Equal(node.config().RouterID))
+		Expect(upds[0].(*proto.Update).Metric).To(BeNumerically("==", 20))
+
+		node.Withdraw(pfx)
+		Expect(node.XRoutes.Len()).To(BeZero())
+
+		types := []EventType{}
+		for len(sub.C) > 0 {
+			types = append(types, (<-sub.C).EventType())
+		}
+
+		Expect(types).To(Equal([]EventType{
+			EventXRouteAdded,
+			EventXRouteChanged,
+			EventXRouteRemoved,
+		}))
+	})
+
+	It("rejects invalid routes", func() {
+		Expect(node.Originate(netip.Prefix{}, 0)).To(MatchError(ErrInvalidPrefix))
+		Expect(node.Originate(pfx, proto.Retraction)).To(MatchError(ErrInfiniteMetric))
+	})
+
+	It("prefers originated routes over learned ones", func() {
+		node.onUpdate(n, &proto.Update{
+			Interval: time.Second,
+			Seqno:    1,
+			Metric:   100,
+			Prefix:   pfx,
+			RouterID: proto.RouterID{0xff, 0, 0, 0, 0, 0, 0, 1},
+		})
+		node.updateNeighbourRoutes(n)
+
+		Expect(node.Originate(pfx, 0)).To(Succeed())
+
+		upds := node.selectedUpdates(nil, nil)
+		Expect(upds).To(HaveLen(1))
+		Expect(upds[0].(*proto.Update).RouterID).To(Equal(node.config().RouterID))
+	})
+
+	It("answers route requests", func() {
+		Expect(node.Originate(pfx, 10)).To(Succeed())
+
+		node.onRouteRequest(n, &proto.RouteRequest{Prefix: pfx})
+
+		Eventually(w.Updates).Should(ContainElement(And(
+			HaveField("Prefix", pfx),
+			HaveField("Metric", BeNumerically("==", 10)),
+		)))
+	})
+
+	It("retracts unknown prefixes with our router ID", func() {
+		node.onRouteRequest(n, &proto.RouteRequest{Prefix: pfx})
+
+		Eventually(w.Updates).Should(ContainElement(And(
+			HaveField("Prefix", pfx),
+			HaveField("Metric", proto.Retraction),
+			HaveField("RouterID", node.config().RouterID),
+		)))
+	})
+
+	It("increases its seqno on requests for our router ID", func() {
+		Expect(node.Originate(pfx, 10)).To(Succeed())
+
+		node.onSeqnoRequest(n, &proto.SeqnoRequest{
+			Seqno:    1,
+			HopCount: 2,
+			RouterID: node.config().RouterID,
+			Prefix:   pfx,
+		})
+		Expect(node.seqNo).To(BeNumerically("==", 1))
+
+		// Requests which are already satisfied do not increase our seqno
+		node.onSeqnoRequest(n, &proto.SeqnoRequest{
+			Seqno:    1,
+			HopCount: 2,
+			RouterID: node.config().RouterID,
+			Prefix:   pfx,
+		})
+		Expect(node.seqNo).To(BeNumerically("==", 1))
+
+		Eventually(w.Updates).Should(ContainElement(And(
+			HaveField("Prefix", pfx),
+			HaveField("Seqno", BeNumerically("==", 1)),
+		)))
+	})
+})
+
+var _ = Describe("Closing", func() {
+	var sim *simulation
+	var node *simNode
+	var ws map[int]*valueWriter
+
+	BeforeEach(func() {
+		sim, node = newTestSimulation()
+		ws = sim.attachNeighbours()
+
+		c, err := net.ListenPacket("udp6", "[::1]:0")
+		Expect(err).To(Succeed())
+
+		node.conn = ipv6.NewPacketConn(c)
+		node.closed = make(chan struct{})
+		node.timersDone = make(chan struct{})
+		node.housekeepingTicker = time.NewTicker(time.Hour)
+
+		node.intf.Interface = &net.Interface{Index: 1, Name: "eth0"}
+		node.intf.closed = make(chan struct{})
+		node.intf.helloMulticastTimer = time.NewTicker(time.Hour)
+		node.intf.periodicUpdateTimer = time.NewTicker(time.Hour)
+		node.Interfaces.Insert(node.intf)
+
+		// The housekeeping timers are not running
+		close(node.timersDone)
+	})
+
+	It("retracts originated routes and closes all neighbours", func() {
+		pfx := netip.MustParsePrefix("2001:db8:1::/48")
+		Expect(node.Originate(pfx, 0)).To(Succeed())
+
+		Expect(node.Close()).To(Succeed())
+
+		for j, n := range node.neighbours {
+			Expect(ws[j].Values()).To(ContainElement(And(
+				HaveField("Prefix", pfx),
+				HaveField("Metric", BeNumerically("==", proto.Retraction)),
+			)))
+
+			Expect(n.closed).To(BeClosed())
+		}
+
+		Expect(node.intf.closed).To(BeClosed())
+		Expect(node.intf.Neighbours.Len()).To(BeZero())
+		Expect(node.Interfaces.Len()).To(BeZero())
+	})
+
+	It("can be closed more than once", func() {
+		Expect(node.Close()).To(Succeed())
+		Expect(node.Close()).To(Succeed())
+
+		Expect(node.intf.Close()).To(Succeed())
+
+		for _, n := range node.neighbours {
+			Expect(n.Close()).To(Succeed())
+		}
+	})
+})